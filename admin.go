@@ -0,0 +1,122 @@
+package gpmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime/pprof"
+	"sync/atomic"
+)
+
+//AdminServiceName 内置的管理服务，通过 Server.EnableAdmin 开启
+const AdminServiceName = "_gpmd_.Admin"
+
+var errDraining = &sentinelError{"rpc server: draining", ErrUnavailable}
+
+//Drain draining 为 true 时服务端拒绝新的请求（内置服务除外），已经接受的请求继续处理，
+//客户端收到的错误满足 errors.Is(err, ErrUnavailable)。通常在注册中心把实例标记为 draining 之后调用
+func (s *Server) Drain(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&s.draining, v)
+}
+
+//Draining 是否正在拒绝新的请求
+func (s *Server) Draining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+//EnableAdmin 注册内置的管理服务 AdminServiceName，运维人员可以在运行时调整日志级别、追踪采样比例和连接速率限制，
+//让服务端进入 draining 状态，以及获取 goroutine、heap 等 profile，不需要重启。
+//无论是否设置了 Server.Authorizer，每次调用都先经过 auth 鉴权，auth 不能为空，例如只允许运维证书的身份
+func (s *Server) EnableAdmin(auth Authorizer) error {
+	if auth == nil {
+		return errors.New("rpc server: admin service requires an authorizer")
+	}
+	return s.register(newNamedService(&adminService{server: s, auth: auth}, AdminServiceName, false))
+}
+
+//AcceptRateArgs 连接速率限制，Rate 为 0 表示不限制
+type AcceptRateArgs struct {
+	Rate  float64 //每秒最多接受的连接数
+	Burst int     //允许的突发连接数
+}
+
+//ProfileArgs 需要获取的 profile
+type ProfileArgs struct {
+	Name  string //runtime/pprof 中的名字，例如 goroutine、heap、allocs、block、mutex
+	Debug int    //与 pprof.Profile.WriteTo 的 debug 相同，0 为 pprof 格式，1、2 为文本
+}
+
+type adminService struct {
+	server *Server
+	auth   Authorizer
+}
+
+func (a *adminService) authorize(ctx context.Context, method string) error {
+	return a.auth(ctx, AdminServiceName+"."+method)
+}
+
+//SetLogLevel 修改日志级别（debug、info、warn、error、off），返回原来的级别
+func (a *adminService) SetLogLevel(ctx context.Context, level string, reply *string) error {
+	if err := a.authorize(ctx, "SetLogLevel"); err != nil {
+		return err
+	}
+	l, err := ParseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	*reply = GetLogLevel().String()
+	SetLogLevel(l)
+	return nil
+}
+
+//SetTraceSampling 修改追踪的采样比例，返回原来的比例
+func (a *adminService) SetTraceSampling(ctx context.Context, rate float64, reply *float64) error {
+	if err := a.authorize(ctx, "SetTraceSampling"); err != nil {
+		return err
+	}
+	*reply = TraceSampling()
+	SetTraceSampling(rate)
+	return nil
+}
+
+//SetAcceptRate 修改连接速率限制，返回原来的限制
+func (a *adminService) SetAcceptRate(ctx context.Context, args AcceptRateArgs, reply *AcceptRateArgs) error {
+	if err := a.authorize(ctx, "SetAcceptRate"); err != nil {
+		return err
+	}
+	a.server.initLimits()
+	reply.Rate, reply.Burst = a.server.acceptLimiter.limits()
+	a.server.SetAcceptRate(args.Rate, args.Burst)
+	return nil
+}
+
+//Drain 开始或者停止拒绝新的请求，返回原来的状态，见 Server.Drain
+func (a *adminService) Drain(ctx context.Context, draining bool, reply *bool) error {
+	if err := a.authorize(ctx, "Drain"); err != nil {
+		return err
+	}
+	*reply = a.server.Draining()
+	a.server.Drain(draining)
+	return nil
+}
+
+//Profile 返回 runtime/pprof 中名为 args.Name 的 profile
+func (a *adminService) Profile(ctx context.Context, args ProfileArgs, reply *[]byte) error {
+	if err := a.authorize(ctx, "Profile"); err != nil {
+		return err
+	}
+	p := pprof.Lookup(args.Name)
+	if p == nil {
+		return &sentinelError{"rpc server: unknown profile " + args.Name, ErrNotFound}
+	}
+	var buf bytes.Buffer
+	if err := p.WriteTo(&buf, args.Debug); err != nil {
+		return err
+	}
+	*reply = buf.Bytes()
+	return nil
+}
@@ -0,0 +1,80 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"gpmd/metadata"
+	"strings"
+	"testing"
+)
+
+func TestServer_Admin(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_assert(server.EnableAdmin(nil) != nil, "expect admin service to require an authorizer")
+	_assert(server.EnableAdmin(func(ctx context.Context, _ string) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if md.Value(AuthorizationKey) != "Bearer ops" {
+			return errors.New("unauthorized")
+		}
+		return nil
+	}) == nil, "expect admin service registered")
+	var foo Foo
+	_ = server.Register(&foo)
+	opt, err := NewOption(WithAuth("ops"))
+	_assert(err == nil, "option error: %v", err)
+	client, _ := NewLocalPair(server, opt)
+	defer func() { _ = client.Close() }()
+	plain, _ := NewLocalPair(server)
+	defer func() { _ = plain.Close() }()
+	ctx := context.Background()
+
+	var level string
+	_assert(plain.Call(ctx, AdminServiceName+".SetLogLevel", "error", &level) != nil, "expect admin call without token rejected")
+	_assert(client.Call(ctx, AdminServiceName+".SetLogLevel", "warn", &level) == nil && level == "info", "expect previous level info, got %q", level)
+	_assert(GetLogLevel() == LogWarn, "expect log level changed, got %v", GetLogLevel())
+	_ = client.Call(ctx, AdminServiceName+".SetLogLevel", "info", &level)
+	_assert(client.Call(ctx, AdminServiceName+".SetLogLevel", "loud", &level) != nil, "expect unknown level rejected")
+
+	var rate float64
+	_assert(client.Call(ctx, AdminServiceName+".SetTraceSampling", 0.25, &rate) == nil && rate == 1, "expect previous sampling 1, got %v", rate)
+	_assert(client.Call(ctx, AdminServiceName+".SetTraceSampling", 1.0, &rate) == nil && rate == 0.25, "expect sampling changed, got %v", rate)
+
+	var limits AcceptRateArgs
+	_assert(client.Call(ctx, AdminServiceName+".SetAcceptRate", AcceptRateArgs{Rate: 100, Burst: 10}, &limits) == nil && limits.Rate == 0, "expect no previous limit, got %+v", limits)
+	_assert(client.Call(ctx, AdminServiceName+".SetAcceptRate", AcceptRateArgs{}, &limits) == nil && limits == AcceptRateArgs{Rate: 100, Burst: 10}, "expect limit changed, got %+v", limits)
+
+	var draining bool
+	_assert(client.Call(ctx, AdminServiceName+".Drain", true, &draining) == nil && !draining, "expect drain")
+	err = callSum(client)
+	_assert(errors.Is(err, ErrUnavailable) && strings.Contains(err.Error(), "draining"), "expect requests rejected while draining, got %v", err)
+	_assert(client.Ping(ctx) == nil, "expect builtin services while draining")
+	_assert(client.Call(ctx, AdminServiceName+".Drain", false, &draining) == nil && draining, "expect undrain")
+	_assert(callSum(client) == nil, "expect requests accepted after draining")
+
+	var profile []byte
+	err = client.Call(ctx, AdminServiceName+".Profile", ProfileArgs{Name: "goroutine", Debug: 1}, &profile)
+	_assert(err == nil && strings.Contains(string(profile), "goroutine profile"), "expect goroutine profile, got %v", err)
+	err = client.Call(ctx, AdminServiceName+".Profile", ProfileArgs{Name: "missing"}, &profile)
+	_assert(errors.Is(err, ErrNotFound), "expect unknown profile not found, got %v", err)
+}
+
+type countingTracer struct{ spans int }
+
+func (c *countingTracer) StartSpan(ctx context.Context, _ string, _ SpanKind) (context.Context, Span) {
+	c.spans++
+	return ctx, nopSpan{}
+}
+
+func TestSampledTracer(t *testing.T) {
+	t.Parallel()
+	counting := &countingTracer{}
+	never := sampledTracer{t: counting, rate: 0}
+	ctx, _ := never.StartSpan(context.Background(), "root", SpanClient)
+	_, _ = sampledTracer{t: counting, rate: 1e6}.StartSpan(ctx, "child", SpanClient)
+	_assert(counting.spans == 0, "expect child of an unsampled span skipped, got %d spans", counting.spans)
+	always := sampledTracer{t: counting, rate: 1e6}
+	ctx, _ = always.StartSpan(context.Background(), "root", SpanClient)
+	_, _ = never.StartSpan(ctx, "child", SpanClient)
+	_assert(counting.spans == 2, "expect child of a sampled span traced, got %d spans", counting.spans)
+}
@@ -0,0 +1,61 @@
+package gpmd
+
+import (
+	"errors"
+	"strings"
+)
+
+//RegisterName 使用 name 而不是结构体名字注册服务，同一个 rcvr 可以注册为多个带版本的服务名，
+//例如 Foo.v1 和 Foo.v2，客户端分别调用 "Foo.v1.Sum" 和 "Foo.v2.Sum"
+func (s *Server) RegisterName(name string, rcvr interface{}) error {
+	if err := checkServiceName(name); err != nil {
+		return err
+	}
+	return s.register(newNamedService(rcvr, name, s.ExtendedMethods))
+}
+
+//checkServiceName 检查自定义的服务名，内置服务使用的前缀是保留的
+func checkServiceName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return errors.New("rpc server: invalid service name " + name)
+	}
+	if strings.HasPrefix(name, builtinServicePrefix) {
+		return errors.New("rpc server: service name is reserved: " + name)
+	}
+	return nil
+}
+
+func RegisterName(name string, rcvr interface{}) error { return DefaultServer.RegisterName(name, rcvr) }
+
+//Alias 将 alias（"Service.Method" 格式）映射到已经注册的 target，方法或者服务改名后老的客户端仍然可以调用。
+//只有 alias 本身没有注册时才会使用映射，每次通过别名调用都会计入 gpmd_server_deprecated_calls_total，
+//第一次调用时记录一条日志，方便确认老的客户端是否已经全部升级
+func (s *Server) Alias(alias, target string) error {
+	if strings.LastIndex(alias, ".") <= 0 {
+		return errors.New("rpc server: alias request ill-formed:" + alias)
+	}
+	if _, _, err := s.lookupService(target); err != nil {
+		return err
+	}
+	if _, dup := s.aliases.LoadOrStore(alias, target); dup {
+		return errors.New("rpc: alias already defined:" + alias)
+	}
+	return nil
+}
+
+//resolveAlias 通过别名查找方法，没有别名时返回 false
+func (s *Server) resolveAlias(serviceMethod string) (*service, *methodType, bool) {
+	target, ok := s.aliases.Load(serviceMethod)
+	if !ok {
+		return nil, nil, false
+	}
+	svc, mType, err := s.lookupService(target.(string))
+	if err != nil {
+		return nil, nil, false
+	}
+	s.metrics().Inc("gpmd_server_deprecated_calls_total", "alias", serviceMethod, "target", target.(string))
+	if _, warned := s.warnedAliases.LoadOrStore(serviceMethod, true); !warned {
+		logf(LogWarn, "rpc server: %s is deprecated, use %s instead", serviceMethod, target)
+	}
+	return svc, mType, true
+}
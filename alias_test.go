@@ -0,0 +1,36 @@
+package gpmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestServer_RegisterNameAndAlias(t *testing.T) {
+	metrics := &countingMetrics{counters: make(map[string]int)}
+	SetMetrics(metrics)
+	defer SetMetrics(nil)
+	server := NewServer()
+	var foo Foo
+	_assert(server.RegisterName("Foo.v1", &foo) == nil, "expect Foo.v1 registered")
+	_assert(server.RegisterName("Foo.v2", &foo) == nil, "expect Foo.v2 registered")
+	_assert(server.RegisterName("Foo.v2", &foo) != nil, "expect duplicate name rejected")
+	_assert(server.RegisterName(ReflectionServiceName+"2", &foo) != nil, "expect reserved name rejected")
+	_assert(server.Alias("Foo.v1.Add", "Foo.v1.Sum") == nil, "expect alias registered")
+	_assert(server.Alias("Calc.Sum", "Foo.v2.Sum") == nil, "expect alias to another service registered")
+	_assert(server.Alias("Foo.v1.Add", "Foo.v1.Sum") != nil, "expect duplicate alias rejected")
+	_assert(server.Alias("Foo.v1.Minus", "Foo.v1.Missing") != nil, "expect alias to missing method rejected")
+	client, err := NewLocalPair(server)
+	_assert(err == nil, "local pair error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	for _, method := range []string{"Foo.v1.Sum", "Foo.v2.Sum", "Foo.v1.Add", "Calc.Sum"} {
+		var reply int
+		err := client.Call(context.Background(), method, &Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "expect %s to return 3, got %d, err: %v", method, reply, err)
+	}
+	_assert(metrics.get("gpmd_server_deprecated_calls_total") == 2, "expect alias calls counted")
+	var reply int
+	err = client.Call(context.Background(), "Foo.v3.Sum", &Args{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect unknown version rejected, got %v", err)
+}
@@ -0,0 +1,40 @@
+package gpmd
+
+import (
+	"reflect"
+	"strconv"
+)
+
+//multiArgsType 按顺序包含 types 的结构体，字段名为 Arg0、Arg1...，服务端和 MultiArgs 生成的类型一致，
+//gob 和 JSON 都按照字段名编解码
+func multiArgsType(types []reflect.Type) reflect.Type {
+	fields := make([]reflect.StructField, len(types))
+	for i, t := range types {
+		name := "Arg" + strconv.Itoa(i)
+		fields[i] = reflect.StructField{Name: name, Type: t, Tag: reflect.StructTag(`json:"` + name + `"`)}
+	}
+	return reflect.StructOf(fields)
+}
+
+//MultiArgs 把多个参数打包为一个值，用来调用有多个参数的方法（服务端需要开启 Server.ExtendedMethods），
+//参数的顺序和类型需要与方法一致：
+//
+//	func (c Calc) Add3(a, b, c int, reply *int) error
+//	client.Call(ctx, "Calc.Add3", gpmd.MultiArgs(1, 2, 3), &reply)
+func MultiArgs(args ...interface{}) interface{} {
+	types := make([]reflect.Type, len(args))
+	for i, arg := range args {
+		if arg == nil {
+			types[i] = reflect.TypeOf((*interface{})(nil)).Elem()
+		} else {
+			types[i] = reflect.TypeOf(arg)
+		}
+	}
+	v := reflect.New(multiArgsType(types)).Elem()
+	for i, arg := range args {
+		if arg != nil {
+			v.Field(i).Set(reflect.ValueOf(arg))
+		}
+	}
+	return v.Interface()
+}
@@ -0,0 +1,222 @@
+//Package benchmarks 提供 gpmd 的压测工具，在同一进程内启动服务端和客户端，
+//统计不同负载大小、编码方式、并发数以及传输协议下的吞吐量和延迟分布。
+package benchmarks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gpmd"
+	"gpmd/codec"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Echo 压测使用的服务，将入参原样返回
+type Echo int
+
+func (e *Echo) Echo(args []byte, reply *[]byte) error {
+	*reply = args
+	return nil
+}
+
+//Incr 返回 args+1，用于测量小的定长回复的开销
+func (e *Echo) Incr(args int, reply *int) error {
+	*reply = args + 1
+	return nil
+}
+
+//First 返回 args 的第一个字符，用于测量短字符串回复的开销
+func (e *Echo) First(args string, reply *string) error {
+	if len(args) > 0 {
+		*reply = args[:1]
+	}
+	return nil
+}
+
+//Config 一次压测的参数
+type Config struct {
+	Network     string        //传输协议：tcp、unix 或 http
+	CodeType    codec.Type    //编码方式
+	PayloadSize int           //每次请求的负载大小（字节）
+	Concurrency int           //并发的调用方数量
+	Duration    time.Duration //压测持续时间
+}
+
+//Result 一次压测的统计结果
+type Result struct {
+	Config
+	Calls   uint64        //成功的调用次数
+	Errors  uint64        //失败的调用次数
+	Elapsed time.Duration //实际耗时
+	QPS     float64       //每秒成功调用次数
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+func (r *Result) String() string {
+	return fmt.Sprintf("%-5s %-18s size=%-7d conc=%-4d calls=%-8d errors=%-4d qps=%-10.0f p50=%-10s p90=%-10s p99=%-10s max=%s",
+		r.Network, r.CodeType, r.PayloadSize, r.Concurrency, r.Calls, r.Errors, r.QPS, r.P50, r.P90, r.P99, r.Max)
+}
+
+//Codecs 返回当前已注册的所有编码方式
+func Codecs() []codec.Type {
+	types := make([]codec.Type, 0, len(codec.NewCodecFuncMap))
+	for t := range codec.NewCodecFuncMap {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+//Env 压测环境，包含一个已经启动的服务端以及连接到它的客户端
+type Env struct {
+	Client  *gpmd.Client
+	closers []func()
+}
+
+//Close 关闭客户端和服务端，清理临时文件
+func (e *Env) Close() {
+	for i := len(e.closers) - 1; i >= 0; i-- {
+		e.closers[i]()
+	}
+}
+
+//NewEnv 按照传输协议启动一个只注册了 Echo 服务的服务端，并建立客户端连接
+func NewEnv(network string, codeType codec.Type) (*Env, error) {
+	return NewServerEnv(network, codeType, gpmd.NewServer())
+}
+
+//NewServerEnv 与 NewEnv 相同，使用调用方配置好的 server，例如开启 FastPath 对比前后的开销
+func NewServerEnv(network string, codeType codec.Type, server *gpmd.Server) (*Env, error) {
+	var echo Echo
+	if err := server.Register(&echo); err != nil {
+		return nil, err
+	}
+	env := &Env{}
+	var l net.Listener
+	var err error
+	switch network {
+	case "tcp", "http":
+		l, err = net.Listen("tcp", "127.0.0.1:0")
+	case "unix":
+		dir, e := ioutil.TempDir("", "gpmdbench")
+		if e != nil {
+			return nil, e
+		}
+		env.closers = append(env.closers, func() { _ = os.RemoveAll(dir) })
+		l, err = net.Listen("unix", filepath.Join(dir, "gpmd.sock"))
+	default:
+		err = fmt.Errorf("benchmarks: unsupported network %s", network)
+	}
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	env.closers = append(env.closers, func() { _ = l.Close() })
+	opt := &gpmd.Option{CodeType: codeType, ConnectTimeout: 10 * time.Second}
+	var client *gpmd.Client
+	if network == "http" {
+		//Server 本身实现了 http.Handler，直接服务即可，无需注册到 DefaultServeMux
+		go func() { _ = http.Serve(l, server) }()
+		client, err = gpmd.DialHTTP("tcp", l.Addr().String(), opt)
+	} else {
+		go server.Accept(l)
+		client, err = gpmd.Dial(l.Addr().Network(), l.Addr().String(), opt)
+	}
+	if err != nil {
+		env.Close()
+		return nil, err
+	}
+	env.Client = client
+	env.closers = append(env.closers, func() { _ = client.Close() })
+	return env, nil
+}
+
+//Run 执行一次压测并返回统计结果
+func Run(cfg Config) (*Result, error) {
+	if cfg.Concurrency <= 0 {
+		return nil, errors.New("benchmarks: concurrency must be positive")
+	}
+	if cfg.Duration <= 0 {
+		return nil, errors.New("benchmarks: duration must be positive")
+	}
+	env, err := NewEnv(cfg.Network, cfg.CodeType)
+	if err != nil {
+		return nil, err
+	}
+	defer env.Close()
+
+	payload := make([]byte, cfg.PayloadSize)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	var calls, failed uint64
+	latencies := make([][]time.Duration, cfg.Concurrency)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Duration)
+	defer cancel()
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				var reply []byte
+				begin := time.Now()
+				if err := env.Client.Call(context.Background(), "Echo.Echo", payload, &reply); err != nil {
+					atomic.AddUint64(&failed, 1)
+					continue
+				}
+				latencies[i] = append(latencies[i], time.Since(begin))
+				atomic.AddUint64(&calls, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	result := &Result{
+		Config:  cfg,
+		Calls:   calls,
+		Errors:  failed,
+		Elapsed: elapsed,
+		QPS:     float64(calls) / elapsed.Seconds(),
+		P50:     percentile(all, 0.50),
+		P90:     percentile(all, 0.90),
+		P99:     percentile(all, 0.99),
+	}
+	if len(all) > 0 {
+		result.Max = all[len(all)-1]
+	}
+	return result, nil
+}
+
+//percentile 从已排序的延迟列表中取出对应分位数
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted))*p+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
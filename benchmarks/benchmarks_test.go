@@ -0,0 +1,101 @@
+package benchmarks
+
+import (
+	"context"
+	"fmt"
+	"gpmd"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	result, err := Run(Config{
+		Network:     "tcp",
+		CodeType:    Codecs()[0],
+		PayloadSize: 16,
+		Concurrency: 2,
+		Duration:    200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Calls == 0 || result.Errors != 0 || result.P50 > result.P99 {
+		t.Fatalf("unexpected result: %s", result)
+	}
+}
+
+func BenchmarkCall(b *testing.B) {
+	for _, network := range []string{"tcp", "unix", "http"} {
+		for _, codeType := range Codecs() {
+			for _, size := range []int{16, 1024, 64 * 1024} {
+				b.Run(fmt.Sprintf("%s/%s/%d", network, codeType, size), func(b *testing.B) {
+					env, err := NewEnv(network, codeType)
+					if err != nil {
+						b.Fatal(err)
+					}
+					defer env.Close()
+					payload := make([]byte, size)
+					b.SetBytes(int64(size))
+					b.ResetTimer()
+					b.RunParallel(func(pb *testing.PB) {
+						for pb.Next() {
+							var reply []byte
+							if err := env.Client.Call(context.Background(), "Echo.Echo", payload, &reply); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					})
+				})
+			}
+		}
+	}
+}
+
+//BenchmarkSmallReply 小的定长回复，对比 Server.FastPath 开启前后的开销。FastPath 只是复用参数和回复的实例，
+//编码仍然走 codec，每次调用少两次分配（参数和回复的 reflect.New），耗时主要是回环网络的往返，
+//前后的差别在多次运行的波动之内，不能据此认为开启之后更快。本机 tcp 上 -count 3 的中位数，allocs 包括客户端：
+//
+//	application/gob/int/default      17065 ns/op  1679 B/op  31 allocs/op
+//	application/gob/int/fast         16487 ns/op  1664 B/op  29 allocs/op
+//	application/gob/string/default   17663 ns/op  1712 B/op  32 allocs/op
+//	application/gob/string/fast      17874 ns/op  1680 B/op  30 allocs/op
+//	application/json/int/default     23993 ns/op  1536 B/op  26 allocs/op
+//	application/json/int/fast        24409 ns/op  1520 B/op  24 allocs/op
+//	application/json/string/default  24765 ns/op  1568 B/op  25 allocs/op
+//	application/json/string/fast     24773 ns/op  1536 B/op  23 allocs/op
+func BenchmarkSmallReply(b *testing.B) {
+	for _, codeType := range Codecs() {
+		for _, fast := range []bool{false, true} {
+			server := gpmd.NewServer()
+			server.FastPath = fast
+			env, err := NewServerEnv("tcp", codeType, server)
+			if err != nil {
+				b.Fatal(err)
+			}
+			mode := "default"
+			if fast {
+				mode = "fast"
+			}
+			b.Run(fmt.Sprintf("%s/int/%s", codeType, mode), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					var reply int
+					if err := env.Client.Call(context.Background(), "Echo.Incr", i, &reply); err != nil || reply != i+1 {
+						b.Fatal(reply, err)
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("%s/string/%s", codeType, mode), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					var reply string
+					if err := env.Client.Call(context.Background(), "Echo.First", "gpmd", &reply); err != nil || reply != "g" {
+						b.Fatal(reply, err)
+					}
+				}
+			})
+			env.Close()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"gpmd/metadata"
+	"time"
+)
+
+//CallOption 修改单次调用的设置，使一次调用可以不同于客户端的默认设置，而不需要另外创建客户端
+type CallOption func(o *CallOptions)
+
+//CallOptions 应用 CallOption 之后的单次调用设置，包装 Client 的实现（例如 XClient）通过 ApplyCallOptions 读取
+type CallOptions struct {
+	Timeout           time.Duration //这次调用的超时，0 表示只使用 ctx 的截止时间
+	Metadata          metadata.MD   //这次调用额外携带的元数据，同名的键优先于 ctx 和 Option 中的元数据
+	NoRetry           bool          //失败后不重试，例如非幂等的调用不希望 XClient 在连接断开后重新发送
+	Idempotent        bool          //调用可以安全地重复执行，XClient 只对这样的调用在请求发出之后连接断开时换实例重新发送
+	CompressThreshold int           //这次调用的压缩阈值，-1 表示沿用 Option.CompressThreshold
+}
+
+//ApplyCallOptions 依次应用 opts，返回最终的设置
+func ApplyCallOptions(opts ...CallOption) CallOptions {
+	o := CallOptions{CompressThreshold: -1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+//WithCallTimeout 这次调用最多等待 d，同时作为截止时间发送到服务端
+func WithCallTimeout(d time.Duration) CallOption {
+	return func(o *CallOptions) {
+		o.Timeout = d
+	}
+}
+
+//WithCallMetadata 这次调用额外携带 kv 组成的元数据，kv 的个数必须为偶数
+func WithCallMetadata(kv ...string) CallOption {
+	md := metadata.Pairs(kv...)
+	return func(o *CallOptions) {
+		o.Metadata = metadata.Join(o.Metadata, md)
+	}
+}
+
+//WithNoRetry 这次调用失败后不重试
+func WithNoRetry() CallOption {
+	return func(o *CallOptions) {
+		o.NoRetry = true
+	}
+}
+
+//WithIdempotent 标记这次调用是幂等的，请求发出之后连接断开时 XClient 可以换实例重新发送，
+//请求可能已经被原来的实例处理过，非幂等的调用不要设置
+func WithIdempotent() CallOption {
+	return func(o *CallOptions) {
+		o.Idempotent = true
+	}
+}
+
+//WithCallCompression 这次调用的请求编码后不小于 threshold 字节时压缩，0 表示不压缩
+func WithCallCompression(threshold int) CallOption {
+	return func(o *CallOptions) {
+		if threshold < 0 {
+			threshold = 0
+		}
+		o.CompressThreshold = threshold
+	}
+}
+
+//errCallTimeout Go 设置了 WithCallTimeout 时，超时后调用返回的错误
+var errCallTimeout = errors.New("rpc client: call failed:" + context.DeadlineExceeded.Error())
@@ -0,0 +1,157 @@
+package gpmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gpmd/codec"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Blob int
+
+func (b Blob) Echo(data []byte, reply *[]byte) error {
+	*reply = append(data, data...)
+	return nil
+}
+
+func TestClient_Chunking(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var b Blob
+	_ = server.Register(&b)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	data := bytes.Repeat([]byte("0123456789"), 20000)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		opt, err := NewOption(WithCodec(typ), WithChunking(16<<10), WithCompression(8<<10))
+		_assert(err == nil, "option error: %v", err)
+		client, err := Dial("tcp", l.Addr().String(), opt)
+		_assert(err == nil, "dial error: %v", err)
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				var reply []byte
+				err := client.Call(ctx, "Blob.Echo", data, &reply)
+				_assert(err == nil && len(reply) == 2*len(data), "%s: expect chunked echo, got %d bytes, %v", typ, len(reply), err)
+			}()
+		}
+		var small []byte
+		err = client.Call(context.Background(), "Blob.Echo", []byte("x"), &small)
+		_assert(err == nil && string(small) == "xx", "%s: expect small call alongside chunked calls, got %q, %v", typ, small, err)
+		wg.Wait()
+		_ = client.Close()
+	}
+}
+
+//yieldLock 第一次释放时写出另一条消息，模拟分块之间插入的回复
+type yieldLock struct {
+	sync.Mutex
+	once  sync.Once
+	yield func()
+}
+
+func (l *yieldLock) Unlock() {
+	l.Mutex.Unlock()
+	l.once.Do(l.yield)
+}
+
+func TestChunkCodec_Interleave(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	cc := codec.NewChunkCodec(codec.NewGobCodec(readWriteNopCloser{&buf}), codec.GobType, 1000)
+	lock := &yieldLock{}
+	lock.yield = func() {
+		lock.Lock()
+		defer lock.Mutex.Unlock()
+		_ = cc.WriteChunks(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 2}, 3, -1, lock)
+	}
+	big := bytes.Repeat([]byte{7}, 5000)
+	lock.Lock()
+	err := cc.WriteChunks(&codec.Header{ServiceMethod: "Blob.Echo", Seq: 1}, big, -1, lock)
+	lock.Mutex.Unlock()
+	_assert(err == nil, "write error: %v", err)
+
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil && h.Seq == 2, "expect the small message between chunks first, got seq %d", h.Seq)
+	var small int
+	_assert(cc.ReadBody(&small) == nil && small == 3, "expect small body, got %d", small)
+	_assert(cc.ReadHeader(&h) == nil && h.Seq == 1 && h.ServiceMethod == "Blob.Echo" && h.Chunk == 0, "expect reassembled header, got %+v", h)
+	var got []byte
+	_assert(cc.ReadBody(&got) == nil && bytes.Equal(got, big), "expect reassembled body, got %d bytes", len(got))
+}
+
+func TestChunkCodec_Limits(t *testing.T) {
+	t.Parallel()
+	//一条超过上限的拆分消息
+	var buf bytes.Buffer
+	w := codec.NewChunkCodec(codec.NewGobCodec(readWriteNopCloser{&buf}), codec.GobType, 16)
+	var lock sync.Mutex
+	lock.Lock()
+	_assert(w.WriteChunks(&codec.Header{ServiceMethod: "Blob.Echo", Seq: 1}, bytes.Repeat([]byte{7}, 200), -1, &lock) == nil, "write error")
+	lock.Unlock()
+	cc := codec.NewChunkCodec(codec.NewGobCodec(readWriteNopCloser{&buf}), codec.GobType, 0)
+	cc.SetLimits(100, 0)
+	var h codec.Header
+	err := cc.ReadHeader(&h)
+	_assert(err != nil && strings.Contains(err.Error(), "exceeds 100 bytes"), "expect the size limit enforced, got %v", err)
+
+	//大量没有结束的拆分消息
+	buf.Reset()
+	raw := codec.NewGobCodec(readWriteNopCloser{&buf})
+	for seq := uint64(1); seq <= 3; seq++ {
+		_ = raw.Write(&codec.Header{ServiceMethod: "Blob.Echo", Seq: seq, Chunk: 1, MoreChunks: true}, []byte("x"))
+	}
+	cc = codec.NewChunkCodec(codec.NewGobCodec(readWriteNopCloser{&buf}), codec.GobType, 0)
+	cc.SetLimits(0, 2)
+	err = cc.ReadHeader(&h)
+	_assert(err != nil && strings.Contains(err.Error(), "more than 2"), "expect the partial message limit enforced, got %v", err)
+}
+
+func TestServer_ChunkLimitClosesConn(t *testing.T) {
+	t.Parallel()
+	addr := startLimitedServer(NewServer())
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = conn.Close() }()
+	_ = json.NewEncoder(conn).Encode(&Option{MagicNumber: MagicNumber, CodeType: codec.GobType, ChunkSize: 16})
+	raw := codec.NewGobCodec(conn)
+	go func() {
+		for seq := uint64(1); seq <= codec.DefaultMaxPartialMessages+1; seq++ {
+			if raw.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: seq, Chunk: 1, MoreChunks: true}, []byte("x")) != nil {
+				return
+			}
+		}
+	}()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = ioutil.ReadAll(conn)
+	ne, ok := err.(net.Error)
+	_assert(!ok || !ne.Timeout(), "expect the server to close the connection, got %v", err)
+}
+
+func TestCompressCodec_Limit(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	w := codec.NewCompressCodec(codec.NewGobCodec(readWriteNopCloser{&buf}), codec.GobType, 1)
+	big := make([]byte, 10000) //压缩后只有几十字节
+	_ = w.Write(&codec.Header{ServiceMethod: "Blob.Echo", Seq: 1}, big)
+	_ = w.Write(&codec.Header{ServiceMethod: "Blob.Echo", Seq: 2}, big[:100])
+	cc := codec.NewCompressCodec(codec.NewGobCodec(readWriteNopCloser{&buf}), codec.GobType, 0).(*codec.CompressCodec)
+	cc.SetLimit(1000)
+	var h codec.Header
+	var got []byte
+	_assert(cc.ReadHeader(&h) == nil && h.Compressed, "expect a compressed message")
+	err := cc.ReadBody(&got)
+	_assert(err != nil && strings.Contains(err.Error(), "exceeds 1000 bytes"), "expect the decompressed size limit enforced, got %v", err)
+	_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(&got) == nil && len(got) == 100, "expect a message within the limit decoded, got %d bytes", len(got))
+}
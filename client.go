@@ -3,16 +3,19 @@ package gpmd
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"gpmd/codec"
+	"gpmd/metadata"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,28 +26,89 @@ type Call struct {
 	Reply        interface{} //调用方法的返回值
 	Error        error       //如果出错，记录错误信息
 	Done         chan *Call  //调用结束信号(为了支持异步调用)
+	RequestID    string      //请求编号，随 Header 发送到服务端
+	Metadata     metadata.MD //随 Header 发送到服务端的元数据，Call 使用 ctx 中的 metadata.FromOutgoingContext
+	Deadline     time.Time   //随 Header 发送到服务端的截止时间，Call 使用 ctx 的截止时间
+	Priority     Priority    //服务端排队时的优先级，Call 使用 ctx 中的 PriorityFromContext
+
+	compress *int      //不为空时覆盖 Option.CompressThreshold，由 WithCallCompression 设置
+	sentAt   time.Time //登记到 pending 的时间，用来计算等待时间
+
+	timerMu  sync.Mutex
+	timer    Timer //WithCallTimeout 的定时器，调用结束时停止
+	finished bool  //已经结束，之后设置的定时器立即停止
 }
 
 func (call *Call) done() {
+	call.timerMu.Lock()
+	call.finished = true
+	if call.timer != nil {
+		call.timer.Stop()
+	}
+	call.timerMu.Unlock()
 	call.Done <- call
 }
 
+//setTimer 记录调用的超时定时器，调用已经结束时直接停止它
+func (call *Call) setTimer(t Timer) {
+	call.timerMu.Lock()
+	defer call.timerMu.Unlock()
+	if call.finished {
+		t.Stop()
+		return
+	}
+	call.timer = t
+}
+
+//applyOptions 记录 CallOptions 中需要在发送时使用的设置
+func (call *Call) applyOptions(o *CallOptions) {
+	if o.CompressThreshold >= 0 {
+		threshold := o.CompressThreshold
+		call.compress = &threshold
+	}
+}
+
 type Client struct {
-	cc       codec.Codec      //cc 是消息的编解码器，和服务端类似，用来序列化将要发送出去的请求，以及反序列化接收到的响应
-	opt      *Option          //opt 编解码方式
-	sending  sync.Mutex       //sending 是一个互斥锁，和服务端类似，为了保证请求的有序发送，即防止出现多个请求报文混淆
-	header   codec.Header     // header 是每个请求的消息头，header 只有在请求发送时才需要，而请求发送是互斥的，因此每个客户端只需要一个，声明在 Client 结构体中可以复用
-	mu       sync.Mutex       //mu 互斥锁为了保证client的操作是线程安全的
-	seq      uint64           //seq 用于给发送的请求编号，每个请求拥有唯一编号
-	pending  map[uint64]*Call //pending 存储未处理完的请求，键是编号，值是 Call 实例
-	closing  bool             //closing 和 shutdown 任意一个值置为 true，则表示 Client 处于不可用的状态，但有些许的差别，closing 是用户主动关闭的，即调用 Close 方法，而 shutdown 置为 true 一般是有错误发生
-	shutdown bool             //shutdown 链接关闭
+	cc       codec.Codec              //cc 是消息的编解码器，和服务端类似，用来序列化将要发送出去的请求，以及反序列化接收到的响应
+	opt      *Option                  //opt 编解码方式
+	sending  sync.Mutex               //sending 是一个互斥锁，和服务端类似，为了保证请求的有序发送，即防止出现多个请求报文混淆
+	header   codec.Header             // header 是每个请求的消息头，header 只有在请求发送时才需要，而请求发送是互斥的，因此每个客户端只需要一个，声明在 Client 结构体中可以复用
+	mu       sync.Mutex               //mu 互斥锁为了保证client的操作是线程安全的
+	seq      uint64                   //seq 用于给发送的请求编号，每个请求拥有唯一编号
+	pending  map[uint64]*Call         //pending 存储未处理完的请求，键是编号，值是 Call 实例
+	closing  bool                     //closing 和 shutdown 任意一个值置为 true，则表示 Client 处于不可用的状态，但有些许的差别，closing 是用户主动关闭的，即调用 Close 方法，而 shutdown 置为 true 一般是有错误发生
+	shutdown bool                     //shutdown 链接关闭
+	subs     map[string]*Subscription //subs 记录订阅的主题，用来投递服务端推送的消息
+	drained  chan struct{}            //CloseContext 等待 pending 为空，为空时关闭
+
+	unexpected uint64         //收到的重复、未知或者已经超时的响应数量
+	rejected   error          //服务端拒绝握手时返回的 HandshakeError
+	handshake  *handshakeConn //识别服务端拒绝握手的错误帧，只在 receive 中读取
+	addr       string         //对端地址，连接事件回调的参数
+
+	sent     uint64 //成功写出的请求数
+	received uint64 //收到响应的请求数
+	failed   uint64 //以错误结束的请求数
+	statsMu  sync.Mutex
+	methods  map[string]*MethodStats //按方法的统计，EnableMethodStats 之后才不为空
 }
 
 var _ io.Closer = (*Client)(nil)
+
+//Caller 是 Client 的调用接口，业务代码依赖 Caller 而不是 *Client 时，可以在测试中替换为 gpmdtest.MockClient
+type Caller interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error
+	Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call
+}
+
+var _ Caller = (*Client)(nil)
 var ErrShutdown = errors.New("connection is shut down")
 
-// Close 关闭连接
+//ErrTooManyPending 等待响应的调用数达到了 Option.MaxPendingCalls，errors.Is(err, ErrResourceExhausted) 为 true。
+//调用没有发出，稍后可以重试
+var ErrTooManyPending error = &sentinelError{"rpc client: too many pending calls", ErrResourceExhausted}
+
+// Close 立即关闭连接，等待响应的调用以连接关闭的错误结束，需要等待它们完成时使用 CloseContext
 func (client *Client) Close() error {
 	client.mu.Lock()
 	defer client.mu.Unlock()
@@ -55,6 +119,59 @@ func (client *Client) Close() error {
 	return client.cc.Close()
 }
 
+//CloseContext 优雅地关闭连接：之后的调用立即返回 ErrShutdown，已经发出的调用继续等待响应，
+//全部完成或者 ctx 结束之后才关闭连接。ctx 结束时仍在等待的调用以 ErrShutdown 结束，并返回 ctx 的错误
+func (client *Client) CloseContext(ctx context.Context) error {
+	client.mu.Lock()
+	if client.closing {
+		client.mu.Unlock()
+		return ErrShutdown
+	}
+	client.closing = true
+	drained := make(chan struct{})
+	client.drained = drained
+	client.checkDrained()
+	client.mu.Unlock()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		client.abandonCalls()
+	}
+	if cerr := client.cc.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+//CloseGracefully 最多等待 timeout 让已经发出的调用完成，然后关闭连接，见 CloseContext
+func (client *Client) CloseGracefully(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return client.CloseContext(ctx)
+}
+
+//checkDrained 调用方持有 mu，CloseContext 等待的调用都已经完成时通知它
+func (client *Client) checkDrained() {
+	if client.drained != nil && len(client.pending) == 0 {
+		close(client.drained)
+		client.drained = nil
+	}
+}
+
+//abandonCalls 以 ErrShutdown 结束仍在等待响应的调用，之后收到的响应会被当作未知的响应跳过
+func (client *Client) abandonCalls() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
+		call.Error = ErrShutdown
+		client.complete(call)
+	}
+}
+
 // IsAvailable 返回当前客户端是否可用
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
@@ -65,20 +182,50 @@ func (client *Client) IsAvailable() bool {
 func (client *Client) registerCall(call *Call) (uint64, error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
+	if client.rejected != nil {
+		return 0, client.rejected
+	}
 	if client.closing || client.shutdown {
 		return 0, ErrShutdown
 	}
+	if client.opt != nil && client.opt.MaxPendingCalls > 0 && len(client.pending) >= client.opt.MaxPendingCalls {
+		GetMetrics().Inc("gpmd_client_pending_rejected_total")
+		return 0, ErrTooManyPending
+	}
+	//编号用完 [1, PushSeqBase) 后从 1 重新开始，跳过仍在等待响应的编号，避免与推送消息或者旧请求混淆
+	for client.seq == 0 || client.seq >= PushSeqBase || client.pending[client.seq] != nil {
+		if client.seq == 0 || client.seq >= PushSeqBase {
+			client.seq = 1
+			continue
+		}
+		client.seq++
+	}
 	call.Seq = client.seq
+	call.sentAt = client.clock().Now()
 	client.pending[call.Seq] = call
 	client.seq++
 	return call.Seq, nil
 }
 
+//clock 返回 Option.Clock，为空时返回 SystemClock
+func (client *Client) clock() Clock {
+	if client.opt == nil {
+		return SystemClock
+	}
+	return clockOr(client.opt.Clock)
+}
+
+//UnexpectedResponses 返回收到的重复、未知或者已经被取消的响应数量，这些响应会被跳过
+func (client *Client) UnexpectedResponses() uint64 {
+	return atomic.LoadUint64(&client.unexpected)
+}
+
 func (client *Client) removeCall(seq uint64) *Call {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	call := client.pending[seq]
 	delete(client.pending, seq)
+	client.checkDrained()
 	return call
 }
 
@@ -88,10 +235,13 @@ func (client *Client) terminateCalls(err error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown = true
-	for _, call := range client.pending {
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
 		call.Error = err
-		call.done()
+		client.complete(call)
 	}
+	client.checkDrained()
+	client.closeSubscriptions()
 }
 
 func (client *Client) receive() {
@@ -99,52 +249,142 @@ func (client *Client) receive() {
 	for err == nil {
 		var h codec.Header
 		if err = client.cc.ReadHeader(&h); err != nil {
+			//服务端拒绝了握手，连接上之后的调用都直接返回这个错误
+			if client.handshake != nil && client.handshake.err != nil {
+				err = client.handshake.err
+				client.mu.Lock()
+				client.rejected = err
+				client.mu.Unlock()
+			}
 			break
 		}
+		if h.Seq >= PushSeqBase {
+			if err = client.receivePush(&h); err != nil {
+				logf(LogError, "rpc client: read push %s error: %v", h.ServiceMethod, err)
+				if isTypeMismatch(err) {
+					err = nil
+				}
+			}
+			continue
+		}
 		call := client.removeCall(h.Seq)
 		switch {
 		case call == nil:
-			//通常来说，call为空表示写数据失败，并且call已经被移除
+			//call 为空表示写数据失败、请求已经超时被移除，或者收到了重复、未知的 Seq，
+			//只需要跳过这个响应的 body，不影响其他请求
+			atomic.AddUint64(&client.unexpected, 1)
+			GetMetrics().Inc("gpmd_client_unexpected_responses_total")
 			err = client.cc.ReadBody(nil)
 		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
-			err = client.cc.ReadBody(nil)
-			call.done()
+			//服务端发现请求数据损坏时，还原为 DataLossError 方便调用方区分
+			client.countReceived(call)
+			call.Error, err = readStatus(client.cc, &h)
+			client.complete(call)
 		default:
+			client.countReceived(call)
 			err = client.cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("reading body failed:" + err.Error())
+				call.Error = fmt.Errorf("reading body failed:%w", err)
+				if isTypeMismatch(err) {
+					err = nil
+				}
 			}
-			call.done()
+			client.complete(call)
 		}
 	}
 	//出错了。关闭所有请求
 	client.terminateCalls(err)
+	if on := client.opt.Events.OnDisconnect; on != nil {
+		client.mu.Lock()
+		closing := client.closing
+		client.mu.Unlock()
+		if closing {
+			err = nil
+		}
+		on(client.addr, err)
+	}
+}
+
+//isTypeMismatch body 与解码的类型不匹配，gob 和 json 都已经读完了这个 body，连接上之后的数据不受影响，
+//只需要让这一次调用或者推送失败；其他错误说明连接上的数据已经错位，需要断开
+func isTypeMismatch(err error) bool {
+	var ce *codec.CodecError
+	if !errors.As(err, &ce) || ce.Op != "decode" {
+		return false
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(ce.Err, &typeErr) {
+		return true
+	}
+	msg := ce.Err.Error()
+	return ce.Codec == codec.GobType && (strings.Contains(msg, "type mismatch") || strings.Contains(msg, "decoding into local type") || strings.Contains(msg, "wrong type"))
 }
 
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 	f := codec.NewCodecFuncMap[opt.CodeType]
 	if f == nil {
 		err := fmt.Errorf("invalid codec type %s", opt.CodeType)
-		log.Println("rpc client: codec error:", err)
+		logf(LogError, "rpc client: codec error: %v", err)
 		return nil, err
 	}
+	if opt.Encrypt && opt.Keyring == nil {
+		_ = conn.Close()
+		return nil, errors.New("rpc client: encrypt requires a keyring")
+	}
 	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: options error", err)
+		logf(LogError, "rpc client: options error %v", err)
 		_ = conn.Close()
 		return nil, err
 	}
-	return NewClientCodec(f(conn), opt), nil
+	//错误帧在加密和逐帧校验之外，需要直接从连接上识别
+	hs := &handshakeConn{ReadWriteCloser: conn}
+	var rwc io.ReadWriteCloser = hs
+	if opt.Encrypt {
+		var err error
+		if rwc, err = codec.NewEncryptConn(rwc, opt.Keyring); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if opt.Checksum {
+		rwc = codec.NewChecksumConn(rwc)
+	}
+	cc := codec.NewCompressCodec(f(rwc), opt.CodeType, opt.CompressThreshold)
+	if opt.ChunkSize > 0 {
+		cc = codec.NewChunkCodec(cc, opt.CodeType, opt.ChunkSize)
+	}
+	var addr string
+	if ra := conn.RemoteAddr(); ra != nil {
+		addr = ra.String()
+	}
+	return newClientCodec(cc, opt, hs, addr), nil
 }
 
+//NewClientCodec 在已经完成握手的 cc 上创建客户端，opt 为 nil 时使用 DefaultOption
 func NewClientCodec(cc codec.Codec, opt *Option) *Client {
+	if opt == nil {
+		opt = DefaultOption
+	}
+	return newClientCodec(cc, opt, nil, "")
+}
+
+func newClientCodec(cc codec.Codec, opt *Option, hs *handshakeConn, addr string) *Client {
 	client := &Client{
-		seq:     1,
-		cc:      cc,
-		opt:     opt,
-		pending: make(map[uint64]*Call),
+		seq:       1,
+		cc:        cc,
+		opt:       opt,
+		pending:   make(map[uint64]*Call),
+		subs:      make(map[string]*Subscription),
+		handshake: hs,
+		addr:      addr,
+	}
+	if on := opt.Events.OnConnect; on != nil {
+		on(addr)
 	}
 	go client.receive()
+	if opt.MaxCallAge > 0 {
+		client.startSweeper(opt.MaxCallAge)
+	}
 	return client
 }
 
@@ -157,17 +397,29 @@ func parseOptions(opts ...*Option) (*Option, error) {
 		return nil, errors.New("number of options is more than one")
 	}
 
-	opt := opts[0]
-	opt.MagicNumber = DefaultOption.MagicNumber
+	//复制一份，不修改调用方传入的 Option。为了兼容，没有设置的 MagicNumber 和 CodeType 使用默认值，
+	//其余为零值的项保持零值，例如 ConnectTimeout 为 0 表示不限制，需要默认值时使用 NewOption
+	opt := *opts[0]
+	if opt.MagicNumber == 0 {
+		opt.MagicNumber = DefaultOption.MagicNumber
+	}
 	if opt.CodeType == "" {
 		opt.CodeType = DefaultOption.CodeType
 	}
-	return opt, nil
+	if err := opt.validate(); err != nil {
+		return nil, err
+	}
+	return &opt, nil
 }
 
 // Dial 与服务器建立链接
 func Dial(network, address string, opts ...*Option) (client *Client, err error) {
-	return dialTimeout(NewClient, network, address, opts...)
+	return DialContext(context.Background(), network, address, opts...)
+}
+
+//DialContext 与 Dial 相同，ctx 同时限制建立连接和握手，ctx 结束时关闭连接并返回包装了 ctx.Err() 的错误
+func DialContext(ctx context.Context, network, address string, opts ...*Option) (*Client, error) {
+	return dialTimeout(ctx, NewClient, network, address, opts...)
 }
 
 func (client *Client) send(call *Call) {
@@ -178,36 +430,79 @@ func (client *Client) send(call *Call) {
 	seq, err := client.registerCall(call)
 	if err != nil {
 		call.Error = err
-		call.done()
+		client.complete(call)
 		return
 	}
 	//封装请求头
 	client.header.ServiceMethod = call.ServerMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.RequestID = call.RequestID
+	client.header.Metadata = outgoingMetadata(client.opt.Metadata, call.Metadata)
+	client.header.Priority = int8(call.Priority)
+	client.header.Timeout = 0
+	if !call.Deadline.IsZero() {
+		client.header.Timeout = headerTimeout(call.Deadline.Sub(client.clock().Now()))
+	}
 
-	if err := client.cc.Write(&client.header, call.Args); err != nil {
+	threshold := -1
+	if call.compress != nil {
+		threshold = *call.compress
+	}
+	switch cc := client.cc.(type) {
+	case *codec.ChunkCodec:
+		err = cc.WriteChunks(&client.header, call.Args, threshold, &client.sending)
+	case *codec.CompressCodec:
+		if threshold >= 0 {
+			err = cc.WriteThreshold(&client.header, call.Args, threshold)
+		} else {
+			err = cc.Write(&client.header, call.Args)
+		}
+	default:
+		err = client.cc.Write(&client.header, call.Args)
+	}
+	if err != nil {
 		call := client.removeCall(seq)
 		if call != nil {
 			call.Error = err
-			call.done()
+			client.complete(call)
 		}
+		return
 	}
+	client.countSent(call)
 }
 
-// Go 实现异步调用
-func (client *Client) Go(serverMethod string, args, reply interface{}, done chan *Call) *Call {
+// Go 实现异步调用，每次调用都会生成一个新的请求编号。
+// opts 中的 WithCallTimeout 同时作为截止时间发送到服务端，超时后 Done 收到的 Call 带有超时错误
+// done 必须带缓冲，不需要自己管理 channel 时使用 CallAsync
+func (client *Client) Go(serverMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	o := ApplyCallOptions(opts...)
+	call := &Call{ServerMethod: serverMethod, Args: args, Reply: reply, RequestID: newRequestID(), Metadata: o.Metadata}
+	call.applyOptions(&o)
+	if o.Timeout > 0 {
+		call.Deadline = client.clock().Now().Add(o.Timeout)
+	}
+	client.goCall(call, done)
+	if o.Timeout > 0 {
+		call.setTimer(client.clock().AfterFunc(o.Timeout, func() {
+			//已经完成的调用不在 pending 中，只有一方能够取到 call
+			if call := client.removeCall(call.Seq); call != nil {
+				call.Error = errCallTimeout
+				client.complete(call)
+			}
+		}))
+	}
+	return call
+}
+
+//goCall 以 done 作为完成通知发送 call，done 为空时创建一个带缓冲的 channel
+func (client *Client) goCall(call *Call, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 1)
 	} else if cap(done) == 0 {
 		log.Panic("rpc client: done channel is unbuffered")
 	}
-	call := &Call{
-		ServerMethod: serverMethod,
-		Args:         args,
-		Reply:        reply,
-		Done:         done,
-	}
+	call.Done = done
 	client.send(call)
 	return call
 }
@@ -217,52 +512,175 @@ func (client *Client) Go(serverMethod string, args, reply interface{}, done chan
 // ctx, _ := context.WithTimeout(context.Background(), time.Second)
 // var reply int
 // err := client.Call(ctx, "Foo.Sum", &Args{1, 2}, &reply)
-func (client *Client) Call(ctx context.Context, serverMethod string, args, reply interface{}) error {
-	call := client.Go(serverMethod, args, reply, make(chan *Call, 1))
+// ctx 中通过 ContextWithRequestID 携带了请求编号时（例如服务端 handler 收到的 ctx），沿用该编号，否则生成新的编号
+// opts 可以为这次调用单独设置超时、元数据和压缩，见 CallOption
+func (client *Client) Call(ctx context.Context, serverMethod string, args, reply interface{}, opts ...CallOption) error {
+	o := ApplyCallOptions(opts...)
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+	call := client.goCall(newContextCall(ctx, &o, serverMethod, args, reply), make(chan *Call, 1))
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
+		if call := client.removeCall(call.Seq); call != nil {
+			client.countFailed(call)
+		}
 		return errors.New("rpc client: call failed:" + ctx.Err().Error())
 	case call := <-call.Done:
 		return call.Error
 	}
 }
 
+//newContextCall 创建一次调用，请求编号、元数据、截止时间和优先级来自 ctx
+func newContextCall(ctx context.Context, o *CallOptions, serverMethod string, args, reply interface{}) *Call {
+	requestID, ok := RequestIDFromContext(ctx)
+	if !ok {
+		requestID = newRequestID()
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	deadline, _ := ctx.Deadline()
+	call := &Call{
+		ServerMethod: serverMethod,
+		Args:         args,
+		Reply:        reply,
+		RequestID:    requestID,
+		Metadata:     outgoingMetadata(md, o.Metadata),
+		Deadline:     deadline,
+		Priority:     PriorityFromContext(ctx),
+	}
+	call.applyOptions(o)
+	return call
+}
+
 type clientResult struct {
 	client *Client
 	err    error
 }
 type newClientFunc func(conn net.Conn, opt *Option) (client *Client, err error)
 
-func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
+//ErrConnectTimeout 建立连接和握手没有在 Option.ConnectTimeout 之内完成
+var ErrConnectTimeout = errors.New("rpc client: connect timeout")
+
+//dialTimeout 建立连接并通过 f 握手，ConnectTimeout 和 ctx 都限制从建立连接到握手完成的总时间，
+//超时或者 ctx 结束时关闭连接，握手的 goroutine 随之返回
+func dialTimeout(ctx context.Context, f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	//ConnectTimeout 从开始建立连接时计算，建立连接和握手共用同一个截止时间
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	expired := make(chan struct{})
+	if opt.ConnectTimeout > 0 {
+		//先关闭 expired 再取消 ctx，看到 ctx 结束时可以据此区分超时和调用方取消
+		timer := clockOr(opt.Clock).AfterFunc(opt.ConnectTimeout, func() {
+			close(expired)
+			cancel()
+		})
+		defer timer.Stop()
+	}
+	timeoutErr := func(err error) error {
+		select {
+		case <-expired:
+			return fmt.Errorf("%w: expect within %s", ErrConnectTimeout, opt.ConnectTimeout)
+		default:
+			return err
+		}
+	}
+	conn, err := dialConn(ctx, opt, network, address)
 	if err != nil {
-		return nil, err
+		return nil, timeoutErr(err)
+	}
+	if opt.TLSConfig != nil {
+		conn = tls.Client(conn, tlsConfigFor(opt.TLSConfig, address))
 	}
 	defer func() {
 		if err != nil {
 			_ = conn.Close()
 		}
 	}()
-	ch := make(chan clientResult)
+	//ch 带缓冲，放弃握手之后握手的 goroutine 发送结果时也不会阻塞
+	ch := make(chan clientResult, 1)
 	go func() {
 		client, err := f(conn, opt)
 		ch <- clientResult{client: client, err: err}
 	}()
-	if opt.ConnectTimeout == 0 {
-		result := <-ch
-		return result.client, result.err
-	}
 	select {
-	case <-time.After(opt.ConnectTimeout):
-		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
+	case <-ctx.Done():
+		err = timeoutErr(fmt.Errorf("rpc client: connect: %w", ctx.Err()))
 	case result := <-ch:
 		return result.client, result.err
 	}
+	//放弃握手时 defer 关闭连接，阻塞在连接上的握手随之失败；握手恰好已经完成时关闭它返回的客户端
+	go func() {
+		if result := <-ch; result.client != nil {
+			_ = result.client.Close()
+		}
+	}()
+	return nil, err
+}
+
+//serverError 将服务端返回的错误信息还原为对应的错误，方便调用方通过 errors.Is 或者类型断言区分
+func serverError(msg string) error {
+	if dataLoss, ok := codec.ParseDataLoss(msg); ok {
+		return dataLoss
+	}
+	if unavailable, ok := ParseUnavailable(msg); ok {
+		return unavailable
+	}
+	if herr, ok := ParseHandshakeError(msg); ok {
+		return herr
+	}
+	if msg == ErrDeadlineExceeded.Error() {
+		return ErrDeadlineExceeded
+	}
+	return errors.New(msg)
+}
+
+//ConnEvents 客户端连接状态变化时的回调，可以用来记录日志、清理本地状态或者切换健康检查的结果。
+//addr 为连接的对端地址，回调在客户端内部的 goroutine 中同步执行，不应该阻塞
+type ConnEvents struct {
+	OnConnect    func(addr string)            //握手写出之后、发出任何请求之前调用
+	OnDisconnect func(addr string, err error) //每个连接断开时调用一次，err 为断开的原因，主动关闭时为 nil
+	OnReconnect  func(addr string)            //XClient 在同一个实例的连接断开之后重新建立连接时，在 OnConnect 之后从新的 goroutine 中调用
+}
+
+//RemoteAddr 返回连接的对端地址，通过 NewClientCodec 创建时为空
+func (client *Client) RemoteAddr() string {
+	return client.addr
+}
+
+//DialFunc 自定义建立连接的方式，例如经过 SOCKS 代理、SSH 隧道，或者在测试中返回内存连接
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+//dialConn 使用 Option.Dialer 建立连接，ctx 同样限制自定义的 Dialer，ConnectTimeout 由调用方设置在 ctx 上。
+//没有设置 Dialer 时主机名解析出的多个地址并行尝试，见 dialHost
+func dialConn(ctx context.Context, opt *Option, network, address string) (net.Conn, error) {
+	dial := opt.Dialer
+	if dial == nil {
+		clock := clockOr(opt.Clock)
+		dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return dialHost(ctx, clock, network, address)
+		}
+	}
+	return dial(ctx, network, address)
+}
+
+//tlsConfigFor 没有指定 ServerName 时，使用地址中的主机名校验服务端证书
+func tlsConfigFor(cfg *tls.Config, address string) *tls.Config {
+	if cfg.ServerName != "" || cfg.InsecureSkipVerify {
+		return cfg
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return cfg
+	}
+	cfg = cfg.Clone()
+	cfg.ServerName = host
+	return cfg
 }
 
 func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
@@ -280,11 +698,20 @@ func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
 }
 
 func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
-	client, err := dialTimeout(NewHTTPClient, network, address, opts...)
-	return client, err
+	return DialHTTPContext(context.Background(), network, address, opts...)
+}
+
+//DialHTTPContext 与 DialHTTP 相同，ctx 同时限制建立连接和 CONNECT 握手
+func DialHTTPContext(ctx context.Context, network, address string, opts ...*Option) (*Client, error) {
+	return dialTimeout(ctx, NewHTTPClient, network, address, opts...)
 }
 
 func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
+	return XDialContext(context.Background(), rpcAddr, opts...)
+}
+
+//XDialContext 与 XDial 相同，ctx 限制建立连接和握手的总时间
+func XDialContext(ctx context.Context, rpcAddr string, opts ...*Option) (*Client, error) {
 	parts := strings.Split(rpcAddr, "@")
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("rpc client err: wrong format '%s', exptect protocal@address", rpcAddr)
@@ -292,9 +719,9 @@ func XDial(rpcAddr string, opts ...*Option) (*Client, error) {
 	protocol, addr := parts[0], parts[1]
 	switch protocol {
 	case "http":
-		return DialHTTP("tcp", addr, opts...)
+		return DialHTTPContext(ctx, "tcp", addr, opts...)
 	default:
 		// tcp, unix or other transport protocol
-		return Dial(protocol, addr, opts...)
+		return DialContext(ctx, protocol, addr, opts...)
 	}
 }
@@ -1,12 +1,20 @@
 package gpmd
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"gpmd/codec"
+	"gpmd/metadata"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -20,15 +28,150 @@ func TestClient_dialTimeout(t *testing.T) {
 		return nil, nil
 	}
 	t.Run("timeout", func(t *testing.T) {
-		_, err := dialTimeout(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
+		_, err := dialTimeout(context.Background(), f, "tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
 		_assert(err != nil && strings.Contains(err.Error(), "connect timeout"), "expect a timeout error")
 	})
 	t.Run("0", func(t *testing.T) {
-		_, err := dialTimeout(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
+		_, err := dialTimeout(context.Background(), f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
 		_assert(err == nil, "0 means no limit")
 	})
 }
 
+func TestClient_dialTimeoutShared(t *testing.T) {
+	t.Parallel()
+	//建立连接用掉大半个 ConnectTimeout，握手只剩下余下的时间
+	opt := &Option{ConnectTimeout: 200 * time.Millisecond, Dialer: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		time.Sleep(150 * time.Millisecond)
+		conn, _ := net.Pipe()
+		return conn, nil
+	}}
+	f := func(conn net.Conn, opt *Option) (*Client, error) {
+		_, err := conn.Read(make([]byte, 1))
+		return nil, err
+	}
+	start := time.Now()
+	_, err := dialTimeout(context.Background(), f, "tcp", "slow", opt)
+	_assert(errors.Is(err, ErrConnectTimeout) && time.Since(start) < 300*time.Millisecond, "expect one deadline for dial and handshake, got %v after %s", err, time.Since(start))
+
+	opt.ConnectTimeout = 100 * time.Millisecond
+	_, err = dialTimeout(context.Background(), f, "tcp", "slow", opt)
+	_assert(errors.Is(err, ErrConnectTimeout), "expect a dial past ConnectTimeout to report ErrConnectTimeout, got %v", err)
+}
+
+func TestClient_dialTimeoutAbandon(t *testing.T) {
+	t.Parallel()
+	//握手超时之后才完成：返回的客户端被关闭，连接同样被关闭
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(ioutil.Discard, conn) }()
+		}
+	}()
+	release := make(chan struct{})
+	late := make(chan *Client, 1)
+	var handshakeConn net.Conn
+	f := func(conn net.Conn, opt *Option) (*Client, error) {
+		handshakeConn = conn
+		<-release
+		pipe, _ := net.Pipe()
+		client := NewClientCodec(codec.NewGobCodec(pipe), opt)
+		late <- client
+		return client, nil
+	}
+	_, err := dialTimeout(context.Background(), f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 20 * time.Millisecond})
+	_assert(errors.Is(err, ErrConnectTimeout), "expect ErrConnectTimeout, got %v", err)
+	close(release)
+	client := <-late
+	deadline := time.Now().Add(time.Second)
+	for client.IsAvailable() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	_assert(!client.IsAvailable(), "expect the late client closed")
+	_, err = handshakeConn.Write([]byte("x"))
+	_assert(err != nil, "expect the abandoned connection closed")
+
+	//故意很慢的服务端：CONNECT 的回复晚于 ConnectTimeout，服务端随后发现连接已经被关闭
+	slow, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = slow.Close() }()
+	closed := make(chan struct{}, 1)
+	go func() {
+		conn, err := slow.Accept()
+		if err != nil {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		_, _ = io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
+		_, _ = io.Copy(ioutil.Discard, conn)
+		closed <- struct{}{}
+	}()
+	start := time.Now()
+	_, err = DialHTTP("tcp", slow.Addr().String(), &Option{ConnectTimeout: 20 * time.Millisecond})
+	_assert(errors.Is(err, ErrConnectTimeout) && time.Since(start) < 100*time.Millisecond, "expect ErrConnectTimeout before the slow reply, got %v", err)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expect the server side to see the abandoned connection closed")
+	}
+}
+
+func TestNewClientCodec_NilOption(t *testing.T) {
+	t.Parallel()
+	clientConn, serverConn := net.Pipe()
+	go func() { _, _ = io.Copy(ioutil.Discard, serverConn) }()
+	client := NewClientCodec(codec.NewGobCodec(clientConn), nil)
+	_assert(client.opt == DefaultOption, "expect DefaultOption for a nil opt")
+	call := client.Go("Foo.Sum", &Args{Num1: 1, Num2: 2}, new(int), make(chan *Call, 1))
+	_ = client.Close()
+	_assert((<-call.Done).Error != nil, "expect the pending call failed on close")
+}
+
+func TestClient_DialContext(t *testing.T) {
+	t.Parallel()
+	//服务端接受连接但是从不回复 CONNECT，握手一直阻塞
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	closed := make(chan struct{}, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(ioutil.Discard, conn)
+				closed <- struct{}{}
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := DialHTTPContext(ctx, "tcp", l.Addr().String())
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect ctx deadline during the handshake, got %v", err)
+	_assert(time.Since(start) < time.Second, "expect ctx to bound the handshake")
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expect the connection closed when ctx ends")
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = DialContext(canceled, "tcp", l.Addr().String())
+	_assert(err != nil, "expect canceled ctx to fail the dial")
+
+	server := NewServer()
+	client, err := DialContext(context.Background(), "tcp", startLimitedServer(server))
+	_assert(err == nil && callSum(client) == nil, "expect DialContext to connect, got %v", err)
+	_ = client.Close()
+}
+
 type Bar int
 
 func (b Bar) Timeout(argv int, reply *int) error {
@@ -71,17 +214,484 @@ func TestXDial(t *testing.T) {
 	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
 		ch := make(chan struct{})
 		addr := "/tmp/gpmd.sock"
+		_ = os.Remove(addr)
+		l, err := net.Listen("unix", addr)
+		if err != nil {
+			t.Fatal("failed to listen unix socket")
+		}
 		go func() {
-			_ = os.Remove(addr)
-			l, err := net.Listen("unix", addr)
-			if err != nil {
-				t.Fatal("failed to listen unix socket")
-			}
 			ch <- struct{}{}
 			Accept(l)
 		}()
 		<-ch
-		_, err := XDial("unix@" + addr)
+		_, err = XDial("unix@" + addr)
 		_assert(err == nil, "failed to connect unix socket")
 	}
 }
+
+type ContextEcho int
+
+func (t ContextEcho) RequestID(ctx context.Context, argv int, reply *string) error {
+	*reply, _ = RequestIDFromContext(ctx)
+	return nil
+}
+
+func (t ContextEcho) Tenant(ctx context.Context, argv int, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	*reply = md.Value("tenant")
+	return nil
+}
+
+func TestClient_Metadata(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var echo ContextEcho
+	_ = server.Register(&echo)
+	client, _ := NewLocalPair(server)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "Tenant", "acme")
+	err := client.Call(ctx, "ContextEcho.Tenant", 0, &reply)
+	_assert(err == nil && reply == "acme", "expect tenant acme, got %q %v", reply, err)
+	err = client.Call(context.Background(), "ContextEcho.Tenant", 0, &reply)
+	_assert(err == nil && reply == "", "expect no metadata on plain ctx, got %q %v", reply, err)
+}
+
+func TestClient_RequestID(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var echo ContextEcho
+	_ = server.Register(&echo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(ContextWithRequestID(context.Background(), "req-1"), "ContextEcho.RequestID", 0, &reply)
+	_assert(err == nil && reply == "req-1", "expect request id req-1, got %q %v", reply, err)
+	err = client.Call(context.Background(), "ContextEcho.RequestID", 0, &reply)
+	_assert(err == nil && len(reply) == 16 && reply != "req-1", "expect a generated request id, got %q %v", reply, err)
+	call := <-client.Go("ContextEcho.RequestID", 0, &reply, nil).Done
+	_assert(call.Error == nil && reply == call.RequestID, "Go should carry its request id, got %q want %q", reply, call.RequestID)
+}
+
+type Echo int
+
+func (e Echo) Echo(argv string, reply *string) error {
+	*reply = argv
+	return nil
+}
+
+//countingConn 统计客户端写出的字节数
+type countingConn struct {
+	net.Conn
+	written int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.written, int64(len(b)))
+	return c.Conn.Write(b)
+}
+
+func TestClient_Compress(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var echo Echo
+	_ = server.Register(&echo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	large := strings.Repeat("gpmd", 4096)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		raw, _ := net.Dial("tcp", l.Addr().String())
+		conn := &countingConn{Conn: raw}
+		client, err := NewClient(conn, &Option{MagicNumber: MagicNumber, CodeType: typ, CompressThreshold: 1024})
+		_assert(err == nil, "new client error: %v", err)
+		var reply string
+		err = client.Call(context.Background(), "Echo.Echo", "small", &reply)
+		_assert(err == nil && reply == "small", "%s: small echo failed: %q %v", typ, reply, err)
+		err = client.Call(context.Background(), "Echo.Echo", large, &reply)
+		_assert(err == nil && reply == large, "%s: large echo failed: %v", typ, err)
+		written := atomic.LoadInt64(&conn.written)
+		_assert(written < int64(len(large)), "%s: expect large body compressed, wrote %d bytes", typ, written)
+		_ = client.Close()
+	}
+}
+
+//corruptConn 在 corrupt 被置位后翻转读到的最后一个字节，模拟不可靠的网络
+type corruptConn struct {
+	net.Conn
+	corrupt int32
+}
+
+func (c *corruptConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 && atomic.LoadInt32(&c.corrupt) == 1 {
+		b[n-1] ^= 0xff
+	}
+	return n, err
+}
+
+func TestClient_Checksum(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var echo Echo
+	_ = server.Register(&echo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	raw, _ := net.Dial("tcp", l.Addr().String())
+	conn := &corruptConn{Conn: raw}
+	client, err := NewClient(conn, &Option{MagicNumber: MagicNumber, CodeType: codec.GobType, Checksum: true})
+	_assert(err == nil, "new client error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply string
+	err = client.Call(context.Background(), "Echo.Echo", "hello", &reply)
+	_assert(err == nil && reply == "hello", "echo failed: %q %v", reply, err)
+
+	atomic.StoreInt32(&conn.corrupt, 1)
+	err = client.Call(context.Background(), "Echo.Echo", "hello", &reply)
+	var dataLoss *codec.DataLossError
+	_assert(errors.As(err, &dataLoss), "expect DataLossError, got %v", err)
+}
+
+//recordConn 记录客户端写出的数据
+type recordConn struct {
+	net.Conn
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.written.Write(b)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func TestClient_Encrypt(t *testing.T) {
+	t.Parallel()
+	key1, key2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	serverKeys, _ := codec.NewKeyring(1, key1)
+	server := NewServer()
+	server.Keyring, server.RequireEncrypt = serverKeys, true
+	var echo Echo
+	_ = server.Register(&echo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	clientKeys, _ := codec.NewKeyring(1, key1)
+	raw, _ := net.Dial("tcp", l.Addr().String())
+	conn := &recordConn{Conn: raw}
+	client, err := NewClient(conn, &Option{MagicNumber: MagicNumber, CodeType: codec.GobType, Encrypt: true, Keyring: clientKeys})
+	_assert(err == nil, "new client error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply string
+	err = client.Call(context.Background(), "Echo.Echo", "top secret", &reply)
+	_assert(err == nil && reply == "top secret", "echo failed: %q %v", reply, err)
+	conn.mu.Lock()
+	leaked := bytes.Contains(conn.written.Bytes(), []byte("top secret"))
+	conn.mu.Unlock()
+	_assert(!leaked, "expect payload encrypted on the wire")
+
+	//轮换密钥：两端先添加新密钥，客户端切换后服务端删除旧密钥
+	_ = serverKeys.Add(2, key2)
+	_ = clientKeys.Add(2, key2)
+	_ = clientKeys.Rotate(2)
+	_ = serverKeys.Rotate(2)
+	_ = serverKeys.Remove(1)
+	err = client.Call(context.Background(), "Echo.Echo", "rotated", &reply)
+	_assert(err == nil && reply == "rotated", "echo after rotation failed: %q %v", reply, err)
+
+	plain, _ := Dial("tcp", l.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = plain.Call(ctx, "Echo.Echo", "plain", &reply)
+	_assert(err != nil, "expect plain connection rejected")
+}
+
+func TestClient_Dialer(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var echo Echo
+	_ = server.Register(&echo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+
+	var dialed []string
+	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, network+"@"+address)
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", l.Addr().String())
+	}
+	client, err := XDial("tcp@backend.internal:9999", &Option{Dialer: dialer})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply string
+	err = client.Call(context.Background(), "Echo.Echo", "via dialer", &reply)
+	_assert(err == nil && reply == "via dialer", "echo failed: %q %v", reply, err)
+	_assert(len(dialed) == 1 && dialed[0] == "tcp@backend.internal:9999", "expect custom dialer used, got %v", dialed)
+}
+
+func TestNewOption(t *testing.T) {
+	t.Parallel()
+	opt, err := NewOption(WithCodec(codec.JsonType), WithCompression(1024))
+	_assert(err == nil && opt.CodeType == codec.JsonType && opt.CompressThreshold == 1024, "unexpected option %+v %v", opt, err)
+	_assert(opt.ConnectTimeout == DefaultOption.ConnectTimeout && opt.MagicNumber == MagicNumber, "expect unset fields to keep defaults, got %+v", opt)
+	opt, err = NewOption(WithConnectTimeout(0))
+	_assert(err == nil && opt.ConnectTimeout == 0, "expect explicit zero connect timeout, got %+v %v", opt, err)
+
+	_, err = NewOption(WithCodec("application/unknown"))
+	_assert(err != nil, "expect unknown codec rejected")
+	_, err = NewOption(WithHandleTimeout(-time.Second))
+	_assert(err != nil, "expect negative timeout rejected")
+	_, err = NewOption(WithMetadata("odd"))
+	_assert(err != nil, "expect odd metadata rejected")
+
+	raw := &Option{CodeType: codec.JsonType}
+	opt, err = parseOptions(raw)
+	_assert(err == nil && opt != raw && raw.MagicNumber == 0, "expect parseOptions not to modify the caller's option")
+	_, err = parseOptions(&Option{MagicNumber: 1})
+	_assert(err != nil, "expect wrong magic number rejected")
+}
+
+func TestClient_WithAuth(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var echo ContextEcho
+	_ = server.Register(&echo)
+	server.Authorizer = func(ctx context.Context, _ string) error {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if md.Value(AuthorizationKey) != "Bearer secret" {
+			return errors.New("unauthorized")
+		}
+		return nil
+	}
+	opt, err := NewOption(WithAuth("secret"), WithMetadata("tenant", "default"))
+	_assert(err == nil, "option error: %v", err)
+	client, _ := NewLocalPair(server, opt)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call(context.Background(), "ContextEcho.Tenant", 0, &reply)
+	_assert(err == nil && reply == "default", "expect option metadata sent, got %q %v", reply, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "Tenant", "acme")
+	err = client.Call(ctx, "ContextEcho.Tenant", 0, &reply)
+	_assert(err == nil && reply == "acme", "expect ctx metadata to take precedence, got %q %v", reply, err)
+
+	plain, _ := NewLocalPair(server)
+	defer func() { _ = plain.Close() }()
+	_assert(plain.Call(context.Background(), "ContextEcho.Tenant", 0, &reply) != nil, "expect call without token rejected")
+}
+
+func TestClient_CallOptions(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var echo ContextEcho
+	var s Sleeper
+	_ = server.Register(&echo)
+	_ = server.Register(&s)
+	client, _ := NewLocalPair(server)
+	defer func() { _ = client.Close() }()
+
+	var tenant string
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "Tenant", "acme")
+	err := client.Call(ctx, "ContextEcho.Tenant", 0, &tenant, WithCallMetadata("tenant", "override"))
+	_assert(err == nil && tenant == "override", "expect call metadata to take precedence, got %q %v", tenant, err)
+
+	var reply int
+	err = client.Call(context.Background(), "Sleeper.Sleep", 200, &reply, WithCallTimeout(50*time.Millisecond))
+	_assert(err != nil && strings.Contains(err.Error(), "deadline exceeded"), "expect call timeout, got %v", err)
+	call := <-client.Go("Sleeper.Sleep", 200, &reply, nil, WithCallTimeout(50*time.Millisecond)).Done
+	_assert(call.Error == errCallTimeout, "expect Go timeout, got %v", call.Error)
+	call = <-client.Go("Sleeper.Sleep", 10, &reply, nil, WithCallTimeout(time.Second), WithCallCompression(1)).Done
+	_assert(call.Error == nil && reply == 10, "expect call within timeout to succeed, got %d %v", reply, call.Error)
+
+	o := ApplyCallOptions(WithNoRetry())
+	_assert(o.NoRetry && o.CompressThreshold == -1, "unexpected call options %+v", o)
+}
+
+//stopCountingClock 记录停止成功的定时器数量
+type stopCountingClock struct {
+	stopped int32
+}
+
+func (c *stopCountingClock) Now() time.Time { return time.Now() }
+
+func (c *stopCountingClock) AfterFunc(d time.Duration, f func()) Timer {
+	return &countedTimer{Timer: time.AfterFunc(d, f), clock: c}
+}
+
+type countedTimer struct {
+	*time.Timer
+	clock *stopCountingClock
+}
+
+func (t *countedTimer) Stop() bool {
+	ok := t.Timer.Stop()
+	if ok {
+		atomic.AddInt32(&t.clock.stopped, 1)
+	}
+	return ok
+}
+
+func TestClient_GoTimeoutStopped(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	clock := &stopCountingClock{}
+	opt, _ := NewOption(WithClock(clock))
+	client, _ := NewLocalPair(server, opt)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	for i := 0; i < 3; i++ {
+		call := <-client.Go("Sleeper.Sleep", 1, &reply, nil, WithCallTimeout(time.Hour)).Done
+		_assert(call.Error == nil, "expect call to succeed, got %v", call.Error)
+	}
+	//结束的调用停止自己的定时器，不会让一个小时的定时器留到触发
+	_assert(atomic.LoadInt32(&clock.stopped) == 3, "expect every timer stopped, got %d", atomic.LoadInt32(&clock.stopped))
+}
+
+func TestClient_Stats(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	client, _ := NewLocalPair(server)
+	defer func() { _ = client.Close() }()
+	client.EnableMethodStats()
+
+	var reply int
+	_assert(client.Call(context.Background(), "Sleeper.Sleep", 0, &reply) == nil, "call should succeed")
+	_assert(client.Call(context.Background(), "Sleeper.Missing", 0, &reply) != nil, "expect unknown method to fail")
+	slow := client.Go("Sleeper.Sleep", 200, &reply, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	stats := client.Stats()
+	_assert(stats.Sent == 3 && stats.Received == 2 && stats.Failed == 1, "unexpected counters %+v", stats)
+	_assert(stats.InFlight == 1 && stats.OldestPending >= 50*time.Millisecond, "expect one stuck call, got %+v", stats)
+	_assert(stats.Methods["Sleeper.Sleep"].Sent == 2 && stats.Methods["Sleeper.Missing"].Failed == 1, "unexpected method stats %+v", stats.Methods)
+	pending := client.Pending()
+	_assert(len(pending) == 1 && pending[0].ServiceMethod == "Sleeper.Sleep" && pending[0].Seq == slow.Seq, "unexpected pending calls %+v", pending)
+
+	<-slow.Done
+	stats = client.Stats()
+	_assert(stats.InFlight == 0 && stats.OldestPending == 0 && stats.Received == 3, "expect no pending calls, got %+v", stats)
+}
+
+func TestClient_CloseContext(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Sleeper))
+	addr := startLimitedServer(server)
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	slow := client.Go("Sleeper.Sleep", 100, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	closed := make(chan error, 1)
+	go func() { closed <- client.CloseGracefully(time.Second) }()
+	time.Sleep(20 * time.Millisecond)
+	err = client.Call(context.Background(), "Sleeper.Sleep", 1, new(int))
+	_assert(err == ErrShutdown, "expect new calls rejected while draining, got %v", err)
+	<-slow.Done
+	_assert(slow.Error == nil && *slow.Reply.(*int) == 100, "expect pending call to finish, got %v", slow.Error)
+	_assert(<-closed == nil, "expect graceful close without error")
+	_assert(client.Close() == ErrShutdown, "expect client closed")
+
+	client, err = Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	slow = client.Go("Sleeper.Sleep", 1000, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.CloseContext(ctx)
+	_assert(err == context.DeadlineExceeded, "expect deadline error, got %v", err)
+	<-slow.Done
+	_assert(slow.Error == ErrShutdown, "expect abandoned call to get ErrShutdown, got %v", slow.Error)
+	_assert(client.Stats().InFlight == 0, "expect no call in flight")
+}
+
+func TestClient_ConnEvents(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+			go server.ServeConn(conn)
+		}
+	}()
+
+	connected := make(chan string, 2)
+	disconnected := make(chan error, 2)
+	opt, _ := NewOption(WithConnEvents(ConnEvents{
+		OnConnect:    func(addr string) { connected <- addr },
+		OnDisconnect: func(addr string, err error) { disconnected <- err },
+	}))
+	client, err := Dial("tcp", l.Addr().String(), opt)
+	_assert(err == nil, "dial error: %v", err)
+	_assert(<-connected == l.Addr().String() && client.RemoteAddr() == l.Addr().String(), "expect OnConnect with the server address")
+	_assert(callSum(client) == nil, "call error")
+
+	//服务端断开连接时 OnDisconnect 收到断开的原因
+	_ = (<-accepted).Close()
+	select {
+	case err := <-disconnected:
+		_assert(err != nil, "expect the disconnect reason")
+	case <-time.After(time.Second):
+		t.Fatal("expect OnDisconnect after the server closed the connection")
+	}
+
+	//主动关闭时 err 为 nil
+	client, err = Dial("tcp", l.Addr().String(), opt)
+	_assert(err == nil, "dial error: %v", err)
+	<-connected
+	_ = client.Close()
+	select {
+	case err := <-disconnected:
+		_assert(err == nil, "expect nil error for Close, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("expect OnDisconnect after Close")
+	}
+}
+
+func TestClient_MaxPendingCalls(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Sleeper))
+	addr := startLimitedServer(server)
+	opt, _ := NewOption(WithMaxPendingCalls(2))
+	client, err := Dial("tcp", addr, opt)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	//服务端卡住时等待响应的调用达到上限，新的调用立即失败而不是继续排队
+	slow := []*Call{client.Go("Sleeper.Sleep", 200, new(int), nil), client.Go("Sleeper.Sleep", 200, new(int), nil)}
+	start := time.Now()
+	err = client.Call(context.Background(), "Sleeper.Sleep", 1, new(int))
+	_assert(err == ErrTooManyPending && errors.Is(err, ErrResourceExhausted), "expect ErrTooManyPending, got %v", err)
+	_assert(time.Since(start) < 100*time.Millisecond, "expect the call to fail fast")
+	call := <-client.Go("Sleeper.Sleep", 1, new(int), nil).Done
+	_assert(call.Error == ErrTooManyPending, "expect Go to fail fast too, got %v", call.Error)
+
+	for _, call := range slow {
+		<-call.Done
+		_assert(call.Error == nil, "slow call error: %v", call.Error)
+	}
+	_assert(client.Call(context.Background(), "Sleeper.Sleep", 1, new(int)) == nil, "expect calls accepted after the pending calls finish")
+	_, err = NewOption(WithMaxPendingCalls(-1))
+	_assert(err != nil, "expect negative limit rejected")
+}
@@ -0,0 +1,38 @@
+package gpmd
+
+import "time"
+
+//Clock 时间来源，客户端的调用超时和连接超时、服务端的处理超时和排队时间都通过它计时。
+//为空时使用系统时钟，测试中可以替换为 gpmdtest.FakeClock 手动推进时间，不需要真正等待
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer //d 之后在新的 goroutine 中调用 f
+}
+
+//Timer Clock.AfterFunc 返回的定时器
+type Timer interface {
+	Stop() bool //定时器已经触发或者已经被停止时返回 false
+}
+
+//SystemClock 使用 time 包的系统时钟
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+//clockOr 返回 c，为空时返回 SystemClock
+func clockOr(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+//after 与 time.After 相同，需要调用返回的 Timer 的 Stop 释放定时器
+func after(c Clock, d time.Duration) (<-chan struct{}, Timer) {
+	ch := make(chan struct{})
+	return ch, c.AfterFunc(d, func() { close(ch) })
+}
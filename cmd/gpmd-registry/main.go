@@ -0,0 +1,306 @@
+//gpmd-registry 独立运行的注册中心，参数的优先级为：命令行 > 环境变量 > 配置文件 > 默认值
+//
+//	gpmd-registry -addr :9999 -timeout 5m -persist /var/lib/gpmd/registry.json
+//	GPMD_REGISTRY_TOKEN=secret gpmd-registry -config /etc/gpmd/registry.json
+//
+//配置文件中的 tokens、acl 限制每个身份可以注册的服务，例如
+//
+//	{"tokens": {"s3cr3t": "order"}, "acl": {"order": ["Order", "Refund"], "ops": ["*"]}, "client_ca": "/etc/gpmd/ca.pem"}
+//
+//统计以 Prometheus 格式导出在 /metrics，设置 admin_token 后 /admin/servers、/admin/services、/admin/stats、/admin/events
+//可以查询实例，POST /admin/evict?addr=xxx 强制删除实例
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"gpmd/registry"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//config 注册中心的配置，json tag 对应配置文件中的字段
+type config struct {
+	Addr            string              `json:"addr"`             //监听地址
+	Path            string              `json:"path"`             //注册中心的 HTTP 路径
+	Timeout         time.Duration       `json:"timeout"`          //服务实例的过期时间，0 表示永不过期
+	PersistPath     string              `json:"persist_path"`     //持久化文件路径，为空表示不持久化
+	PersistInterval time.Duration       `json:"persist_interval"` //持久化的间隔
+	TLSCert         string              `json:"tls_cert"`         //TLS 证书路径
+	TLSKey          string              `json:"tls_key"`          //TLS 私钥路径
+	Token           string              `json:"token"`            //非空时，请求必须携带 Authorization: Bearer <token>
+	Tokens          map[string]string   `json:"tokens"`           //注册和注销使用的 token 及其对应的身份，与 token 不能同时使用
+	ClientCA        string              `json:"client_ca"`        //非空时，注册和注销也可以使用这个 CA 签发的客户端证书认证，身份为证书的 CN
+	ACL             map[string][]string `json:"acl"`              //每个身份可以注册的服务名，"*" 表示所有服务
+	EventLog        string              `json:"event_log"`        //非空时，注册、刷新、过期、注销等事件以 JSON 行追加写入这个文件
+	AdminToken      string              `json:"admin_token"`      //非空时开启 /admin 管理接口，请求必须携带 Authorization: Bearer <admin_token>
+	ShutdownTimeout time.Duration       `json:"shutdown_timeout"` //优雅退出的最长等待时间
+}
+
+var defaultConfig = config{
+	Addr:            ":9999",
+	Path:            "/_gpmd_/registry",
+	Timeout:         5 * time.Minute,
+	PersistInterval: 10 * time.Second,
+	ShutdownTimeout: 10 * time.Second,
+}
+
+//UnmarshalJSON 让配置文件中的时间可以写成 "5m" 这样的格式
+func (c *config) UnmarshalJSON(data []byte) error {
+	type plain config
+	aux := struct {
+		*plain
+		Timeout         string `json:"timeout"`
+		PersistInterval string `json:"persist_interval"`
+		ShutdownTimeout string `json:"shutdown_timeout"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	for _, d := range []struct {
+		s   string
+		dst *time.Duration
+	}{{aux.Timeout, &c.Timeout}, {aux.PersistInterval, &c.PersistInterval}, {aux.ShutdownTimeout, &c.ShutdownTimeout}} {
+		if d.s == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.s)
+		if err != nil {
+			return err
+		}
+		*d.dst = v
+	}
+	return nil
+}
+
+func loadFile(path string, cfg *config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, cfg)
+}
+
+func loadEnv(cfg *config) error {
+	strs := map[string]*string{
+		"GPMD_REGISTRY_ADDR":        &cfg.Addr,
+		"GPMD_REGISTRY_PATH":        &cfg.Path,
+		"GPMD_REGISTRY_PERSIST":     &cfg.PersistPath,
+		"GPMD_REGISTRY_TLS_CERT":    &cfg.TLSCert,
+		"GPMD_REGISTRY_TLS_KEY":     &cfg.TLSKey,
+		"GPMD_REGISTRY_TOKEN":       &cfg.Token,
+		"GPMD_REGISTRY_CLIENT_CA":   &cfg.ClientCA,
+		"GPMD_REGISTRY_ADMIN_TOKEN": &cfg.AdminToken,
+		"GPMD_REGISTRY_EVENT_LOG":   &cfg.EventLog,
+	}
+	for key, dst := range strs {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	durations := map[string]*time.Duration{
+		"GPMD_REGISTRY_TIMEOUT":          &cfg.Timeout,
+		"GPMD_REGISTRY_PERSIST_INTERVAL": &cfg.PersistInterval,
+		"GPMD_REGISTRY_SHUTDOWN_TIMEOUT": &cfg.ShutdownTimeout,
+	}
+	for key, dst := range durations {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return err
+			}
+			*dst = d
+		}
+	}
+	return nil
+}
+
+//authHandler 校验请求中的 token
+func authHandler(token string, next http.Handler) http.Handler {
+	expect := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expect) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func main() {
+	configPath := flag.String("config", os.Getenv("GPMD_REGISTRY_CONFIG"), "path of json config file")
+	flagCfg := defaultConfig
+	flag.StringVar(&flagCfg.Addr, "addr", defaultConfig.Addr, "listen address")
+	flag.StringVar(&flagCfg.Path, "path", defaultConfig.Path, "http path of the registry")
+	flag.DurationVar(&flagCfg.Timeout, "timeout", defaultConfig.Timeout, "expire time of servers, 0 means never expire")
+	flag.StringVar(&flagCfg.PersistPath, "persist", defaultConfig.PersistPath, "file used to persist registered servers")
+	flag.DurationVar(&flagCfg.PersistInterval, "persist-interval", defaultConfig.PersistInterval, "interval of persisting servers")
+	flag.StringVar(&flagCfg.TLSCert, "tls-cert", defaultConfig.TLSCert, "tls certificate file")
+	flag.StringVar(&flagCfg.TLSKey, "tls-key", defaultConfig.TLSKey, "tls key file")
+	flag.StringVar(&flagCfg.Token, "token", defaultConfig.Token, "bearer token required by every request")
+	flag.StringVar(&flagCfg.ClientCA, "client-ca", defaultConfig.ClientCA, "ca file of client certificates allowed to register")
+	flag.StringVar(&flagCfg.EventLog, "event-log", defaultConfig.EventLog, "file to append registry events to")
+	flag.StringVar(&flagCfg.AdminToken, "admin-token", defaultConfig.AdminToken, "bearer token of the /admin endpoints, empty disables them")
+	flag.DurationVar(&flagCfg.ShutdownTimeout, "shutdown-timeout", defaultConfig.ShutdownTimeout, "max time to wait for graceful shutdown")
+	flag.Parse()
+
+	cfg := defaultConfig
+	if *configPath != "" {
+		if err := loadFile(*configPath, &cfg); err != nil {
+			log.Fatalln("gpmd-registry: load config error:", err)
+		}
+	}
+	if err := loadEnv(&cfg); err != nil {
+		log.Fatalln("gpmd-registry: load env error:", err)
+	}
+	//只有显式指定的命令行参数才覆盖配置文件和环境变量
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = flagCfg.Addr
+		case "path":
+			cfg.Path = flagCfg.Path
+		case "timeout":
+			cfg.Timeout = flagCfg.Timeout
+		case "persist":
+			cfg.PersistPath = flagCfg.PersistPath
+		case "persist-interval":
+			cfg.PersistInterval = flagCfg.PersistInterval
+		case "tls-cert":
+			cfg.TLSCert = flagCfg.TLSCert
+		case "tls-key":
+			cfg.TLSKey = flagCfg.TLSKey
+		case "token":
+			cfg.Token = flagCfg.Token
+		case "client-ca":
+			cfg.ClientCA = flagCfg.ClientCA
+		case "event-log":
+			cfg.EventLog = flagCfg.EventLog
+		case "admin-token":
+			cfg.AdminToken = flagCfg.AdminToken
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = flagCfg.ShutdownTimeout
+		}
+	})
+
+	r := registry.New(cfg.Timeout)
+	if cfg.PersistPath != "" {
+		if err := r.Load(cfg.PersistPath); err != nil && !os.IsNotExist(err) {
+			log.Fatalln("gpmd-registry: load persisted servers error:", err)
+		}
+	}
+	if cfg.EventLog != "" {
+		f, err := os.OpenFile(cfg.EventLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalln("gpmd-registry: open event log error:", err)
+		}
+		defer f.Close()
+		r.EventSink = f
+	}
+	if cfg.Token != "" && len(cfg.Tokens) > 0 {
+		log.Fatalln("gpmd-registry: token and tokens can not be used together")
+	}
+	var auths []registry.Authenticator
+	if len(cfg.Tokens) > 0 {
+		auths = append(auths, registry.TokenAuth(cfg.Tokens))
+	}
+	var tlsConfig *tls.Config
+	if cfg.ClientCA != "" {
+		if cfg.TLSCert == "" {
+			log.Fatalln("gpmd-registry: client_ca requires tls_cert and tls_key")
+		}
+		pem, err := ioutil.ReadFile(cfg.ClientCA)
+		if err != nil {
+			log.Fatalln("gpmd-registry: load client ca error:", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalln("gpmd-registry: no certificate in client ca", cfg.ClientCA)
+		}
+		//查询不要求客户端证书，注册和注销由 MTLSAuth 检查
+		tlsConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+		auths = append(auths, registry.MTLSAuth())
+	}
+	if len(auths) > 0 {
+		r.Auth = registry.AnyAuth(auths...)
+	}
+	if len(cfg.ACL) > 0 {
+		if r.Auth == nil {
+			log.Fatalln("gpmd-registry: acl requires tokens or client_ca")
+		}
+		r.ACL = cfg.ACL
+	}
+	var handler, metrics http.Handler = r, r.MetricsHandler()
+	if cfg.Token != "" {
+		handler = authHandler(cfg.Token, r)
+		metrics = authHandler(cfg.Token, metrics)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, handler)
+	mux.Handle("/metrics", metrics)
+	if cfg.AdminToken != "" {
+		mux.Handle("/admin/", authHandler(cfg.AdminToken, r.AdminHandler("/admin")))
+	}
+	srv := &http.Server{Addr: cfg.Addr, Handler: mux, TLSConfig: tlsConfig}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if cfg.PersistPath == "" || cfg.PersistInterval <= 0 {
+			<-stop
+			return
+		}
+		t := time.NewTicker(cfg.PersistInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := r.Save(cfg.PersistPath); err != nil {
+					log.Println("gpmd-registry: persist error:", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		log.Println("gpmd-registry: received signal", <-sig)
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("gpmd-registry: shutdown error:", err)
+		}
+	}()
+
+	log.Printf("gpmd-registry: listen on %s, path %s", cfg.Addr, cfg.Path)
+	var err error
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		err = srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalln("gpmd-registry: serve error:", err)
+	}
+	close(stop)
+	<-done
+	if cfg.PersistPath != "" {
+		if err := r.Save(cfg.PersistPath); err != nil {
+			log.Fatalln("gpmd-registry: persist error:", err)
+		}
+	}
+	log.Println("gpmd-registry: stopped")
+}
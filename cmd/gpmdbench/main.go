@@ -0,0 +1,89 @@
+//gpmdbench 用来测量 gpmd 在不同负载大小、编码方式、并发数以及传输协议下的吞吐量和延迟分位数
+//
+//	gpmdbench -network tcp,unix,http -size 16,1024,65536 -concurrency 1,16,64 -duration 3s
+package main
+
+import (
+	"flag"
+	"fmt"
+	"gpmd/benchmarks"
+	"gpmd/codec"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func splitInts(s string) ([]int, error) {
+	var ints []int
+	for _, item := range splitList(s) {
+		n, err := strconv.Atoi(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", item)
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+func main() {
+	log.SetFlags(0)
+	networks := flag.String("network", "tcp", "comma separated transports: tcp, unix, http")
+	codecs := flag.String("codec", "", "comma separated codec types, empty means all registered codecs")
+	sizes := flag.String("size", "16,1024,65536", "comma separated payload sizes in bytes")
+	concurrency := flag.String("concurrency", "1,16,64", "comma separated number of concurrent callers")
+	duration := flag.Duration("duration", 3*time.Second, "duration of each run")
+	flag.Parse()
+
+	sizeList, err := splitInts(*sizes)
+	if err != nil {
+		log.Fatalln("gpmdbench: -size:", err)
+	}
+	concList, err := splitInts(*concurrency)
+	if err != nil {
+		log.Fatalln("gpmdbench: -concurrency:", err)
+	}
+	codecList := benchmarks.Codecs()
+	if *codecs != "" {
+		codecList = codecList[:0]
+		for _, c := range splitList(*codecs) {
+			codecList = append(codecList, codec.Type(c))
+		}
+	}
+
+	failed := false
+	for _, network := range splitList(*networks) {
+		for _, codeType := range codecList {
+			for _, size := range sizeList {
+				for _, conc := range concList {
+					result, err := benchmarks.Run(benchmarks.Config{
+						Network:     network,
+						CodeType:    codeType,
+						PayloadSize: size,
+						Concurrency: conc,
+						Duration:    *duration,
+					})
+					if err != nil {
+						log.Printf("%s %s size=%d conc=%d error: %v", network, codeType, size, conc, err)
+						failed = true
+						continue
+					}
+					log.Println(result)
+				}
+			}
+		}
+	}
+	if failed {
+		log.Fatalln("gpmdbench: some runs failed")
+	}
+}
@@ -0,0 +1,111 @@
+//gpmdctl 是一个命令行调试工具，连接服务端（或者通过注册中心选择一个服务端），
+//列出注册的服务，或者使用 JSON 编码的参数调用某个方法并打印 JSON 编码的返回值
+//
+//	gpmdctl -addr tcp@127.0.0.1:9999 list
+//	gpmdctl -registry http://127.0.0.1:9999/_gpmd_/registry call Foo.Sum '{"Num1":1,"Num2":2}'
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gpmd"
+	"gpmd/codec"
+	"gpmd/xclient"
+	"log"
+	"os"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `usage:
+  gpmdctl [flags] list
+  gpmdctl [flags] call <Service.Method> [json args]
+
+flags:
+`)
+	flag.PrintDefaults()
+}
+
+func resolve(addr, registry string) (string, error) {
+	if addr != "" {
+		return addr, nil
+	}
+	if registry == "" {
+		return "", errors.New("either -addr or -registry is required")
+	}
+	return xclient.NewGpmdRegistryDiscovery(registry, 0).Get(xclient.RandomSelect)
+}
+
+func run(client *gpmd.Client, ctx context.Context, args []string) error {
+	switch args[0] {
+	case "list":
+		var services []gpmd.ServiceInfo
+		if err := client.Call(ctx, gpmd.ReflectionServiceName+".ListServices", struct{}{}, &services); err != nil {
+			return err
+		}
+		for _, svc := range services {
+			for _, m := range svc.Methods {
+				fmt.Printf("%s.%s(%s, %s) error\n", svc.Name, m.Name, m.ArgType, m.ReplyType)
+			}
+		}
+		return nil
+	case "call":
+		if len(args) < 2 || len(args) > 3 {
+			return errors.New("call expects <Service.Method> [json args]")
+		}
+		var argv []byte
+		if len(args) == 3 {
+			if !json.Valid([]byte(args[2])) {
+				return fmt.Errorf("invalid json args: %s", args[2])
+			}
+			argv = []byte(args[2])
+		}
+		reply, err := client.CallRaw(ctx, args[1], argv)
+		if err != nil {
+			return err
+		}
+		var out bytes.Buffer
+		if err := json.Indent(&out, reply, "", "  "); err != nil {
+			return err
+		}
+		fmt.Println(out.String())
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+	addr := flag.String("addr", "", "server address, e.g. tcp@127.0.0.1:9999 or http@127.0.0.1:9999")
+	registry := flag.String("registry", "", "registry address used to pick a server when -addr is empty")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout of the whole command")
+	codecType := flag.String("codec", string(codec.JsonType), "codec used on the connection, application/json or application/gob")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	rpcAddr, err := resolve(*addr, *registry)
+	if err != nil {
+		log.Fatalln("gpmdctl:", err)
+	}
+	client, err := gpmd.XDial(rpcAddr, &gpmd.Option{CodeType: codec.Type(*codecType), ConnectTimeout: *timeout})
+	if err != nil {
+		log.Fatalln("gpmdctl: dial", rpcAddr, "error:", err)
+	}
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := run(client, ctx, flag.Args()); err != nil {
+		cancel()
+		_ = client.Close()
+		log.Fatalln("gpmdctl:", err)
+	}
+}
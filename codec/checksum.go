@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+//MaxFrameSize 校验帧的最大长度，更大的写入会被拆成多个帧
+const MaxFrameSize = 1 << 20
+
+const dataLossPrefix = "rpc codec: data loss: "
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//DataLossError 校验帧时发现数据损坏，之后连接上的数据都不再可信
+type DataLossError struct {
+	Reason string
+}
+
+func (e *DataLossError) Error() string {
+	return dataLossPrefix + e.Reason
+}
+
+//ParseDataLoss 还原对端以字符串形式返回的 DataLossError
+func ParseDataLoss(msg string) (*DataLossError, bool) {
+	if !strings.HasPrefix(msg, dataLossPrefix) {
+		return nil, false
+	}
+	return &DataLossError{Reason: strings.TrimPrefix(msg, dataLossPrefix)}, true
+}
+
+//checksumConn 将每次写入封装为一帧：| 长度 uint32 | CRC32 uint32 | 数据 |，读取时逐帧校验，
+//校验失败后所有的读取都返回同一个 DataLossError
+type checksumConn struct {
+	io.ReadWriteCloser
+	frame []byte //当前帧还没有被读取的数据
+	err   error
+}
+
+//NewChecksumConn 在 conn 上启用逐帧 CRC32 校验，是否启用由握手时的 Option.Checksum 协商
+func NewChecksumConn(conn io.ReadWriteCloser) io.ReadWriteCloser {
+	return &checksumConn{ReadWriteCloser: conn}
+}
+
+func (c *checksumConn) Read(p []byte) (int, error) {
+	for len(c.frame) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.readFrame()
+	}
+	n := copy(p, c.frame)
+	c.frame = c.frame[n:]
+	return n, nil
+}
+
+func (c *checksumConn) readFrame() error {
+	var head [8]byte
+	if _, err := io.ReadFull(c.ReadWriteCloser, head[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(head[:4])
+	if size > MaxFrameSize {
+		return &DataLossError{Reason: fmt.Sprintf("frame size %d exceeds %d", size, MaxFrameSize)}
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(c.ReadWriteCloser, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if want, got := binary.BigEndian.Uint32(head[4:]), crc32.Checksum(frame, crcTable); want != got {
+		return &DataLossError{Reason: fmt.Sprintf("checksum mismatch: expect %08x, got %08x", want, got)}
+	}
+	c.frame = frame
+	return nil
+}
+
+func (c *checksumConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > MaxFrameSize {
+			chunk = chunk[:MaxFrameSize]
+		}
+		frame := make([]byte, 8+len(chunk))
+		binary.BigEndian.PutUint32(frame[:4], uint32(len(chunk)))
+		binary.BigEndian.PutUint32(frame[4:8], crc32.Checksum(chunk, crcTable))
+		copy(frame[8:], chunk)
+		if _, err := c.ReadWriteCloser.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
@@ -0,0 +1,163 @@
+package codec
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+//组装拆分消息的默认上限，超过时 ReadHeader 返回错误，调用方随后关闭连接，
+//避免对端发送永远不结束的消息或者大量不结束的拆分消息耗尽内存
+const (
+	DefaultMaxChunkedSize     = 512 << 20 //组装后的 body 的最大字节数
+	DefaultMaxPartialMessages = 1024      //同时在组装的消息数
+)
+
+//ChunkCodec 在 Codec 之上实现大消息分块：WriteChunks 发送的 body 编码后超过 size 字节时拆分为多个帧，
+//每一帧以 []byte 的形式发送并在 Header.Chunk 和 Header.MoreChunks 中标记，帧之间其他消息可以插入，
+//一个几百 MB 的回复不会让同一个连接上其他调用的回复一直排在它后面。
+//读取时按照 Seq 重新组装，所有的帧到齐之后 ReadHeader 才返回这条消息，其他消息照常返回。
+//size 为 0 时不拆分发送的消息，但是仍然能够读取对端拆分过的消息
+type ChunkCodec struct {
+	Codec
+	typ        Type
+	size       int
+	maxSize    int                        //组装后的 body 的最大字节数，见 SetLimits
+	maxPartial int                        //同时在组装的消息数上限
+	partial    map[uint64]*chunkedMessage //正在组装的消息，键为 Seq
+	assembled  []byte                     //最近一次 ReadHeader 组装好的 body，nil 表示没有拆分
+}
+
+//chunkedMessage 收到了一部分帧的消息
+type chunkedMessage struct {
+	h    Header //第一帧的 Header
+	next int    //下一帧的序号
+	data []byte
+}
+
+var _ Codec = (*ChunkCodec)(nil)
+
+//NewChunkCodec 包装 cc，typ 决定拆分前 body 的编码方式
+func NewChunkCodec(cc Codec, typ Type, size int) *ChunkCodec {
+	return &ChunkCodec{
+		Codec:      cc,
+		typ:        typ,
+		size:       size,
+		maxSize:    DefaultMaxChunkedSize,
+		maxPartial: DefaultMaxPartialMessages,
+		partial:    make(map[uint64]*chunkedMessage),
+	}
+}
+
+//SetLimits 设置组装拆分消息的上限，maxSize 为组装后的 body 的最大字节数，maxPartial 为同时在组装的消息数，
+//不大于 0 时使用默认值
+func (c *ChunkCodec) SetLimits(maxSize, maxPartial int) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxChunkedSize
+	}
+	if maxPartial <= 0 {
+		maxPartial = DefaultMaxPartialMessages
+	}
+	c.maxSize, c.maxPartial = maxSize, maxPartial
+}
+
+func (c *ChunkCodec) ReadHeader(h *Header) error {
+	c.assembled = nil
+	for {
+		//gob 不会覆盖零值字段，每一帧都使用新的 Header
+		var fh Header
+		if err := c.Codec.ReadHeader(&fh); err != nil {
+			return err
+		}
+		if fh.Chunk == 0 {
+			*h = fh
+			return nil
+		}
+		var piece []byte
+		if err := c.Codec.ReadBody(&piece); err != nil {
+			return err
+		}
+		m := c.partial[fh.Seq]
+		switch {
+		case fh.Chunk == 1:
+			if m == nil && len(c.partial) >= c.maxPartial {
+				return fmt.Errorf("rpc codec: more than %d chunked messages in progress", c.maxPartial)
+			}
+			m = &chunkedMessage{h: fh}
+			c.partial[fh.Seq] = m
+		case m == nil || fh.Chunk != m.next:
+			return fmt.Errorf("rpc codec: unexpected chunk %d of seq %d", fh.Chunk, fh.Seq)
+		}
+		if len(m.data)+len(piece) > c.maxSize {
+			return fmt.Errorf("rpc codec: chunked message of seq %d exceeds %d bytes", fh.Seq, c.maxSize)
+		}
+		m.data = append(m.data, piece...)
+		m.next = fh.Chunk + 1
+		if fh.MoreChunks {
+			continue
+		}
+		delete(c.partial, fh.Seq)
+		*h = m.h
+		h.Chunk, h.MoreChunks, h.Compressed = 0, false, false
+		c.assembled = m.data
+		if c.assembled == nil {
+			c.assembled = []byte{}
+		}
+		return nil
+	}
+}
+
+func (c *ChunkCodec) ReadBody(body interface{}) error {
+	if c.assembled == nil {
+		return c.Codec.ReadBody(body)
+	}
+	data := c.assembled
+	c.assembled = nil
+	if body == nil {
+		return nil
+	}
+	return unmarshal(c.typ, data, body)
+}
+
+//WriteThreshold 不拆分地写出一条消息，threshold 为这条消息的压缩阈值，负数表示沿用 CompressCodec 创建时的阈值
+func (c *ChunkCodec) WriteThreshold(h *Header, body interface{}, threshold int) error {
+	if cc, ok := c.Codec.(*CompressCodec); ok && threshold >= 0 {
+		return cc.WriteThreshold(h, body, threshold)
+	}
+	return c.Codec.Write(h, body)
+}
+
+//WriteChunks 与 WriteThreshold 相同，body 编码后超过 size 字节时拆分为多个帧。
+//调用方持有 lock（保证一条消息完整写出的那把锁），拆分时每两帧之间释放一次 lock，让其他消息先写出，返回时仍然持有 lock。
+//每一帧单独按照压缩阈值决定是否压缩
+func (c *ChunkCodec) WriteChunks(h *Header, body interface{}, threshold int, lock sync.Locker) error {
+	if c.size <= 0 || body == nil {
+		return c.WriteThreshold(h, body, threshold)
+	}
+	data, err := marshal(c.typ, body)
+	if err != nil || len(data) <= c.size {
+		return c.WriteThreshold(h, body, threshold)
+	}
+	//lock 释放期间调用方可能修改 h，例如客户端复用同一个 Header
+	first := *h
+	first.Compressed = false
+	for off, n := 0, 1; off < len(data); off, n = off+c.size, n+1 {
+		end := off + c.size
+		if end > len(data) {
+			end = len(data)
+		}
+		fh := Header{Seq: first.Seq}
+		if n == 1 {
+			fh = first
+		} else {
+			lock.Unlock()
+			runtime.Gosched()
+			lock.Lock()
+		}
+		fh.Chunk, fh.MoreChunks = n, end < len(data)
+		if err = c.WriteThreshold(&fh, data[off:end], threshold); err != nil {
+			return err
+		}
+	}
+	return nil
+}
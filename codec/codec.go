@@ -3,9 +3,18 @@ package codec
 import "io"
 
 type Header struct {
-	ServiceMethod string //解析"Service.Method"，通常与 Go 语言中的结构体和方法相映射
-	Seq           uint64 //客户端提供的标志某一次请求的序列号
-	Error         string //错误信息，客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中
+	ServiceMethod string              //解析"Service.Method"，通常与 Go 语言中的结构体和方法相映射
+	Seq           uint64              //客户端提供的标志某一次请求的序列号
+	Error         string              //错误信息，客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中
+	RequestID     string              //请求编号，由客户端生成，服务端原样返回，用于串联两端的日志
+	Compressed    bool                //body 是否经过 gzip 压缩，见 CompressCodec
+	Metadata      map[string][]string //调用的元数据，见 metadata 包
+	Deadline      int64               //客户端 ctx 的截止时间（Unix 纳秒），0 表示没有截止时间。依赖两端的时钟一致，只为兼容旧版本的客户端保留
+	Priority      int8                //服务端排队时的优先级，正数为高优先级，负数为低优先级，0 为普通优先级
+	Status        bool                `json:",omitempty"` //错误响应的 body 是 gpmd.Status 的错误码和详情，而不是 {}
+	Chunk         int                 `json:",omitempty"` //大消息拆分后这一帧的序号，从 1 开始，0 表示没有拆分，见 ChunkCodec
+	MoreChunks    bool                `json:",omitempty"` //同一个 Seq 之后还有帧
+	Timeout       int64               `json:",omitempty"` //发送时距离客户端 ctx 截止时间的剩余纳秒，服务端从读到请求时开始计时，0 表示没有截止时间
 }
 
 //Codec 抽象出对消息体进行编解码的接口 Codec，抽象出接口是为了实现不同的 Codec 实例
@@ -21,11 +30,11 @@ type NewCodecFunc func(closer io.ReadWriteCloser) Codec
 
 type Type string
 
-//定义了 2 种 Codec，Gob 和 Json，但是实际代码中只实现了 Gob 一种，
-//事实上，2 者的实现非常接近，甚至只需要把 gob 换成 json 即可。
+//定义了 2 种 Codec，Gob 和 Json，2 者的实现非常接近，只需要把 gob 换成 json 即可。
+//Json 编码方便调试工具在不知道具体 Go 类型的情况下直接发送和查看报文。
 const (
 	GobType  Type = "application/gob"
-	JsonType Type = "application/json" //本项目中未实现
+	JsonType Type = "application/json"
 )
 
 var NewCodecFuncMap map[Type]NewCodecFunc
@@ -33,4 +42,5 @@ var NewCodecFuncMap map[Type]NewCodecFunc
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	NewCodecFuncMap[JsonType] = NewJsonCodec
 }
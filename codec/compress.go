@@ -0,0 +1,126 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+)
+
+//DefaultMaxDecompressedSize 解压后的 body 的默认上限，与 DefaultMaxChunkedSize 相同，
+//超过时 ReadBody 返回错误，避免很小的压缩数据解压出几个 GB 耗尽内存
+const DefaultMaxDecompressedSize = DefaultMaxChunkedSize
+
+//CompressCodec 在 Codec 之上实现按消息压缩：body 编码后不小于 threshold 字节时使用 gzip 压缩，
+//以 []byte 的形式发送并在 Header.Compressed 中标记，小消息原样发送，避免为大量小请求付出压缩的开销。
+//threshold 为 0 时不压缩发送的消息，但是仍然能够读取对端压缩过的消息
+type CompressCodec struct {
+	Codec
+	typ        Type
+	threshold  int
+	maxSize    int  //解压后的 body 的最大字节数，见 SetLimit
+	compressed bool //最近一次读取的 Header 是否标记了压缩
+}
+
+var _ Codec = (*CompressCodec)(nil)
+
+//NewCompressCodec 包装 cc，typ 决定压缩前 body 的编码方式
+func NewCompressCodec(cc Codec, typ Type, threshold int) Codec {
+	return &CompressCodec{Codec: cc, typ: typ, threshold: threshold, maxSize: DefaultMaxDecompressedSize}
+}
+
+//SetLimit 设置解压后的 body 的最大字节数，不大于 0 时使用 DefaultMaxDecompressedSize
+func (c *CompressCodec) SetLimit(maxSize int) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+	c.maxSize = maxSize
+}
+
+func (c *CompressCodec) ReadHeader(h *Header) error {
+	//gob 不会覆盖零值字段，需要先清除上一次的标记
+	h.Compressed = false
+	err := c.Codec.ReadHeader(h)
+	c.compressed = h.Compressed
+	return err
+}
+
+func (c *CompressCodec) ReadBody(body interface{}) error {
+	if !c.compressed {
+		return c.Codec.ReadBody(body)
+	}
+	c.compressed = false
+	var data []byte
+	if err := c.Codec.ReadBody(&data); err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if data, err = ioutil.ReadAll(io.LimitReader(zr, int64(c.maxSize)+1)); err != nil {
+		return err
+	}
+	if len(data) > c.maxSize {
+		return fmt.Errorf("rpc codec: decompressed body exceeds %d bytes", c.maxSize)
+	}
+	return unmarshal(c.typ, data, body)
+}
+
+func (c *CompressCodec) Write(h *Header, body interface{}) error {
+	return c.WriteThreshold(h, body, c.threshold)
+}
+
+//WriteThreshold 与 Write 相同，但是这一条消息使用 threshold 而不是创建时指定的阈值
+func (c *CompressCodec) WriteThreshold(h *Header, body interface{}, threshold int) error {
+	//服务端回复时沿用请求的 Header，请求压缩过而回复不压缩时需要清除标记
+	if h.Compressed {
+		plain := *h
+		plain.Compressed = false
+		h = &plain
+	}
+	if threshold <= 0 || body == nil {
+		return c.Codec.Write(h, body)
+	}
+	data, err := marshal(c.typ, body)
+	if err != nil || len(data) < threshold {
+		return c.Codec.Write(h, body)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write(data); err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		log.Println("rpc codec: gzip error compressing body:", err)
+		return c.Codec.Write(h, body)
+	}
+	compressed := *h
+	compressed.Compressed = true
+	return c.Codec.Write(&compressed, buf.Bytes())
+}
+
+//marshal 将 body 单独编码为 typ 格式的字节，压缩和分块都在编码后的字节上进行
+func marshal(typ Type, body interface{}) ([]byte, error) {
+	if typ == JsonType {
+		return json.Marshal(body)
+	}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(body)
+	return buf.Bytes(), err
+}
+
+//unmarshal 将 marshal 得到的字节解码到 body
+func unmarshal(typ Type, data []byte, body interface{}) error {
+	if typ == JsonType {
+		return json.Unmarshal(data, body)
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(body)
+}
@@ -0,0 +1,266 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+//Keyring 保存预共享的 AES 密钥，每个密钥有一个编号。发送时使用当前密钥加密，
+//接收时根据帧中的密钥编号选择密钥解密，轮换密钥时先在两端 Add 新密钥，再 Rotate，最后 Remove 旧密钥
+type Keyring struct {
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+//NewKeyring 创建 Keyring，key 的长度为 16、24 或 32 字节，分别对应 AES-128、AES-192 和 AES-256
+func NewKeyring(id uint32, key []byte) (*Keyring, error) {
+	k := &Keyring{keys: make(map[uint32][]byte)}
+	if err := k.Add(id, key); err != nil {
+		return nil, err
+	}
+	k.current = id
+	return k, nil
+}
+
+//Add 添加一个可以用来解密的密钥，已经存在的编号会被覆盖
+func (k *Keyring) Add(id uint32, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	key = append([]byte(nil), key...)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = key
+	return nil
+}
+
+//Rotate 之后发送的帧使用编号为 id 的密钥加密
+func (k *Keyring) Rotate(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.keys[id] == nil {
+		return fmt.Errorf("rpc codec: unknown key %d", id)
+	}
+	k.current = id
+	return nil
+}
+
+//Remove 删除不再使用的密钥，不能删除当前密钥
+func (k *Keyring) Remove(id uint32) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.current {
+		return errors.New("rpc codec: cannot remove the current key")
+	}
+	delete(k.keys, id)
+	return nil
+}
+
+//CurrentKey 返回当前用来加密的密钥编号
+func (k *Keyring) CurrentKey() uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *Keyring) sealer() (uint32, []byte) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, k.keys[k.current]
+}
+
+func (k *Keyring) opener(id uint32) []byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[id]
+}
+
+//encryptHeaderSize | 密文长度 uint32 | 密钥编号 uint32 | nonce 12 字节 |
+const encryptHeaderSize = 8 + 12
+
+//sessionSaltSize 每一端在第一帧之前以明文发送的随机盐的长度
+const sessionSaltSize = 16
+
+//encryptConn 将每次写入加密为一帧：| 密文长度 | 密钥编号 | nonce | 密文 |。
+//预共享密钥不直接用来加密：两端在第一帧之前各自发送一个随机盐，每个方向使用
+//HKDF-SHA256(预共享密钥, 发送方的盐 || 接收方的盐) 派生的会话密钥，因此每个连接、每个方向的密钥都不同，
+//计数器 nonce 在会话密钥下不会重复。帧头的前 8 个字节和按照发送方向排列的两个盐作为附加数据参与认证，
+//录下的帧无法在其他连接上重放，也无法反射回发送方
+type encryptConn struct {
+	io.ReadWriteCloser
+	keys *Keyring
+	salt [sessionSaltSize]byte //本端的盐
+	peer [sessionSaltSize]byte //对端的盐
+
+	once    sync.Once
+	initErr error
+	sendAAD []byte                 //本端的盐 || 对端的盐
+	recvAAD []byte                 //对端的盐 || 本端的盐
+	sending map[uint32]*sessionKey //只在 Write 中访问
+	reading map[uint32]*sessionKey //只在 Read 中访问
+
+	prefix [4]byte
+	count  uint64
+	frame  []byte //当前帧还没有被读取的明文
+	err    error
+}
+
+//sessionKey 从预共享密钥派生的会话密钥，psk 被 Keyring.Add 覆盖后重新派生
+type sessionKey struct {
+	psk  []byte
+	aead cipher.AEAD
+}
+
+//NewEncryptConn 使用 keys 加密 conn 上的数据，是否启用由握手时的 Option.Encrypt 协商。
+//第一次 Read 或者 Write 时先和对端交换会话的盐
+func NewEncryptConn(conn io.ReadWriteCloser, keys *Keyring) (io.ReadWriteCloser, error) {
+	c := &encryptConn{
+		ReadWriteCloser: conn,
+		keys:            keys,
+		sending:         make(map[uint32]*sessionKey),
+		reading:         make(map[uint32]*sessionKey),
+	}
+	if _, err := io.ReadFull(rand.Reader, c.salt[:]); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, c.prefix[:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//exchange 发送本端的盐并读取对端的盐。写和读同时进行，两端都没有缓冲时（例如 net.Pipe）也不会互相等待
+func (c *encryptConn) exchange() {
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.ReadWriteCloser.Write(c.salt[:])
+		written <- err
+	}()
+	_, err := io.ReadFull(c.ReadWriteCloser, c.peer[:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if werr := <-written; err == nil {
+		err = werr
+	}
+	if c.initErr = err; err != nil {
+		return
+	}
+	c.sendAAD = append(append([]byte(nil), c.salt[:]...), c.peer[:]...)
+	c.recvAAD = append(append([]byte(nil), c.peer[:]...), c.salt[:]...)
+}
+
+//session 返回编号为 id 的预共享密钥在 salts 方向上的会话密钥
+func (c *encryptConn) session(cache map[uint32]*sessionKey, id uint32, psk, salts []byte) (cipher.AEAD, error) {
+	if sk := cache[id]; sk != nil && &sk.psk[0] == &psk[0] {
+		return sk.aead, nil
+	}
+	block, err := aes.NewCipher(hkdf(psk, salts, []byte("gpmd session key"), len(psk)))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	cache[id] = &sessionKey{psk: psk, aead: aead}
+	return aead, nil
+}
+
+//hkdf RFC 5869 的 HKDF-SHA256，n 不超过 32
+func hkdf(secret, salt, info []byte, n int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:n]
+}
+
+func (c *encryptConn) Read(p []byte) (int, error) {
+	if c.once.Do(c.exchange); c.initErr != nil {
+		return 0, c.initErr
+	}
+	for len(c.frame) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.err = c.readFrame()
+	}
+	n := copy(p, c.frame)
+	c.frame = c.frame[n:]
+	return n, nil
+}
+
+func (c *encryptConn) readFrame() error {
+	var head [encryptHeaderSize]byte
+	if _, err := io.ReadFull(c.ReadWriteCloser, head[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(head[:4])
+	if size > MaxFrameSize+16 {
+		return &DataLossError{Reason: fmt.Sprintf("encrypted frame size %d exceeds %d", size, MaxFrameSize+16)}
+	}
+	id := binary.BigEndian.Uint32(head[4:8])
+	psk := c.keys.opener(id)
+	if psk == nil {
+		return fmt.Errorf("rpc codec: unknown key %d", id)
+	}
+	aead, err := c.session(c.reading, id, psk, c.recvAAD)
+	if err != nil {
+		return err
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(c.ReadWriteCloser, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	frame, err := aead.Open(sealed[:0], head[8:], sealed, append(head[:8:8], c.recvAAD...))
+	if err != nil {
+		return &DataLossError{Reason: "decrypt frame failed: " + err.Error()}
+	}
+	c.frame = frame
+	return nil
+}
+
+func (c *encryptConn) Write(p []byte) (int, error) {
+	if c.once.Do(c.exchange); c.initErr != nil {
+		return 0, c.initErr
+	}
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > MaxFrameSize {
+			chunk = chunk[:MaxFrameSize]
+		}
+		id, psk := c.keys.sealer()
+		aead, err := c.session(c.sending, id, psk, c.sendAAD)
+		if err != nil {
+			return written, err
+		}
+		frame := make([]byte, encryptHeaderSize, encryptHeaderSize+len(chunk)+aead.Overhead())
+		binary.BigEndian.PutUint32(frame[:4], uint32(len(chunk)+aead.Overhead()))
+		binary.BigEndian.PutUint32(frame[4:8], id)
+		copy(frame[8:12], c.prefix[:])
+		c.count++
+		binary.BigEndian.PutUint64(frame[12:20], c.count)
+		frame = aead.Seal(frame, frame[8:20], chunk, append(frame[:8:8], c.sendAAD...))
+		if _, err := c.ReadWriteCloser.Write(frame); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
@@ -0,0 +1,87 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+//recordedConn 记录写出的字节
+type recordedConn struct {
+	net.Conn
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordedConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.written.Write(p)
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func encryptPair(t *testing.T, keys *Keyring) (io.ReadWriteCloser, io.ReadWriteCloser, *recordedConn) {
+	a, b := net.Pipe()
+	t.Cleanup(func() { _ = a.Close(); _ = b.Close() })
+	rec := &recordedConn{Conn: a}
+	client, err := NewEncryptConn(rec, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := NewEncryptConn(b, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server, rec
+}
+
+func TestEncryptConn(t *testing.T) {
+	keys, err := NewKeyring(1, bytes.Repeat([]byte{0x42}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server, rec := encryptPair(t, keys)
+	go func() { _, _ = client.Write([]byte("top secret")) }()
+	got := make([]byte, 10)
+	if _, err := io.ReadFull(server, got); err != nil || string(got) != "top secret" {
+		t.Fatalf("expect the frame decrypted, got %q %v", got, err)
+	}
+	go func() { _, _ = server.Write([]byte("reply")) }()
+	got = make([]byte, 5)
+	if _, err := io.ReadFull(client, got); err != nil || string(got) != "reply" {
+		t.Fatalf("expect the reply decrypted, got %q %v", got, err)
+	}
+	rec.mu.Lock()
+	recorded := append([]byte(nil), rec.written.Bytes()...)
+	rec.mu.Unlock()
+	if bytes.Contains(recorded, []byte("top secret")) {
+		t.Fatal("expect the payload encrypted")
+	}
+	frame := recorded[sessionSaltSize:]
+
+	//同一个密钥下两个连接的会话密钥不同：录下的帧重放到新的连接上无法解密
+	peer, target := net.Pipe()
+	defer func() { _ = peer.Close(); _ = target.Close() }()
+	replayed, _ := NewEncryptConn(target, keys)
+	go func() {
+		_, _ = peer.Write(recorded[:sessionSaltSize])
+		_, _ = peer.Read(make([]byte, sessionSaltSize))
+		_, _ = peer.Write(frame)
+	}()
+	if _, err := replayed.Read(make([]byte, 10)); err == nil {
+		t.Fatal("expect a replayed frame rejected")
+	}
+
+	//帧反射回发送方同样无法解密：两个方向使用不同的会话密钥
+	c := client.(*encryptConn)
+	aead, err := c.session(c.reading, 1, keys.opener(1), c.recvAAD)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := frame[:encryptHeaderSize]
+	if _, err := aead.Open(nil, head[8:], frame[encryptHeaderSize:], append(head[:8:8], c.recvAAD...)); err == nil {
+		t.Fatal("expect a reflected frame rejected")
+	}
+}
@@ -0,0 +1,121 @@
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+//CodecError 编码或者解码 body 失败，代替 gob 和 encoding/json 难以定位的原始错误，Err 为原始错误
+type CodecError struct {
+	Codec Type
+	Op    string //encode 或者 decode
+	Type  string //body 的 Go 类型
+	Field string //出错的字段路径，例如 Filter.Value，无法确定时为空
+	Err   error
+}
+
+func (e *CodecError) Error() string {
+	msg := fmt.Sprintf("rpc codec: %s %s %s", e.Codec, e.Op, e.Type)
+	if e.Field != "" {
+		msg += " field " + e.Field
+	}
+	msg += ": " + e.Err.Error()
+	if strings.Contains(e.Err.Error(), "not registered for interface") {
+		msg += " (register the concrete type with gpmd.RegisterType)"
+	}
+	return msg
+}
+
+func (e *CodecError) Unwrap() error { return e.Err }
+
+//gobNotRegistered gob 编码 interface 字段时，具体类型没有注册的错误前缀
+const gobNotRegistered = "gob: type not registered for interface: "
+
+//gobError 只转换 gob 自身的错误，连接断开等错误原样返回
+func gobError(op string, body interface{}, err error) error {
+	if err == nil || !strings.HasPrefix(err.Error(), "gob: ") {
+		return err
+	}
+	e := &CodecError{Codec: GobType, Op: op, Type: fmt.Sprintf("%T", body), Err: err}
+	if name := strings.TrimPrefix(err.Error(), gobNotRegistered); name != err.Error() {
+		e.Field = interfaceField(reflect.ValueOf(body), name, "", 0)
+	}
+	return e
+}
+
+//jsonError 只转换 encoding/json 的类型和语法错误
+func jsonError(op string, body interface{}, err error) error {
+	if err == nil {
+		return nil
+	}
+	e := &CodecError{Codec: JsonType, Op: op, Type: fmt.Sprintf("%T", body), Err: err}
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var unsupportedType *json.UnsupportedTypeError
+	var unsupportedValue *json.UnsupportedValueError
+	var marshalerErr *json.MarshalerError
+	switch {
+	case errors.As(err, &typeErr):
+		e.Field = typeErr.Field
+	case errors.As(err, &syntaxErr), errors.As(err, &unsupportedType), errors.As(err, &unsupportedValue), errors.As(err, &marshalerErr):
+	default:
+		return err
+	}
+	return e
+}
+
+//interfaceField 查找值为 typeName 类型的 interface 字段，返回字段路径
+func interfaceField(v reflect.Value, typeName, path string, depth int) string {
+	if !v.IsValid() || depth > 16 {
+		return ""
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+		if v.Elem().Type().String() == typeName {
+			return path
+		}
+		return interfaceField(v.Elem(), typeName, path, depth+1)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return ""
+		}
+		return interfaceField(v.Elem(), typeName, path, depth+1)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if found := interfaceField(v.Field(i), typeName, joinField(path, f.Name), depth+1); found != "" {
+				return found
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if found := interfaceField(v.Index(i), typeName, fmt.Sprintf("%s[%d]", path, i), depth+1); found != "" {
+				return found
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if found := interfaceField(iter.Value(), typeName, fmt.Sprintf("%s[%v]", path, iter.Key()), depth+1); found != "" {
+				return found
+			}
+		}
+	}
+	return ""
+}
+
+func joinField(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
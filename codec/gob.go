@@ -35,12 +35,15 @@ func (c *GobCodec) ReadHeader(h *Header) error {
 }
 
 func (c *GobCodec) ReadBody(body interface{}) error {
-	return c.dec.Decode(body)
+	return gobError("decode", body, c.dec.Decode(body))
 }
 
 func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 	defer func() {
-		_ = c.buf.Flush()
+		//写出超时等错误在 Flush 时才出现，同样需要返回，否则这条消息会被悄悄丢弃
+		if ferr := c.buf.Flush(); err == nil {
+			err = ferr
+		}
 		if err != nil {
 			_ = c.Close()
 		}
@@ -50,6 +53,7 @@ func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 		return err
 	}
 	if err = c.enc.Encode(body); err != nil {
+		err = gobError("encode", body, err)
 		log.Println("rpc codec: gob error encoding body:", err)
 		return err
 	}
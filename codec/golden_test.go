@@ -0,0 +1,165 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+//update 为 true 时重新生成 testdata/golden 下的文件，协议有意改变时使用：go test ./codec -update
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+//GoldenArgs 请求的 body，字段名和类型名都会出现在 gob 的类型定义中
+type GoldenArgs struct {
+	Num1, Num2 int
+	Name       string
+}
+
+func init() {
+	//gob 的类型编号是进程内全局分配的，先按固定顺序编码一次所有类型，
+	//单独运行某一个子测试时写出的字节也与完整运行时相同
+	enc := gob.NewEncoder(ioutil.Discard)
+	for _, v := range []interface{}{&Header{}, &GoldenArgs{}, struct{}{}} {
+		if err := enc.Encode(v); err != nil {
+			panic(err)
+		}
+	}
+}
+
+//goldenFrame 一帧的 Header 和 body，body 为 nil 时写出 struct{}{}，读取时丢弃，与服务端的错误响应相同
+type goldenFrame struct {
+	h    Header
+	body interface{}
+}
+
+//goldenFrames 依次是一个请求、它的响应和一个错误响应
+var goldenFrames = []goldenFrame{
+	{Header{
+		ServiceMethod: "Foo.Sum",
+		Seq:           1,
+		RequestID:     "req-1",
+		Metadata:      map[string][]string{"tenant": {"acme"}},
+		Deadline:      1600000000000000000,
+		Priority:      1,
+	}, &GoldenArgs{Num1: 1, Num2: 2, Name: "golden request body"}},
+	{Header{ServiceMethod: "Foo.Sum", Seq: 1, RequestID: "req-1"}, func() *int { v := 3; return &v }()},
+	{Header{ServiceMethod: "Foo.Missing", Seq: 2, Error: "rpc server: can't find method Missing"}, nil},
+}
+
+//goldenConn 写入 w、从 r 读取的连接
+type goldenConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (goldenConn) Close() error { return nil }
+
+//goldenVariant 一种编码组合，wrap 和 newCodec 在连接之上构造和客户端相同的 Codec 栈，write 写出一帧。
+//decodeOnly 的组合依赖 gzip 的实现，只检查能否解码。
+//加密的连接使用每个连接交换的盐派生的会话密钥，录下的字节无法在另一个连接上解密，见 TestEncryptConn
+type goldenVariant struct {
+	name       string
+	decodeOnly bool
+	wrap       func(t *testing.T, rwc io.ReadWriteCloser, typ Type) io.ReadWriteCloser
+	newCodec   func(cc Codec, typ Type) Codec
+	write      func(cc Codec, h *Header, body interface{}) error
+}
+
+func plainCodec(cc Codec, typ Type) Codec { return NewCompressCodec(cc, typ, 0) }
+
+func writeFrame(cc Codec, h *Header, body interface{}) error { return cc.Write(h, body) }
+
+var goldenVariants = []goldenVariant{
+	{name: "plain", newCodec: plainCodec, write: writeFrame},
+	{name: "checksum", newCodec: plainCodec, write: writeFrame,
+		wrap: func(_ *testing.T, rwc io.ReadWriteCloser, _ Type) io.ReadWriteCloser { return NewChecksumConn(rwc) }},
+	{name: "chunk", write: func(cc Codec, h *Header, body interface{}) error {
+		var mu sync.Mutex
+		mu.Lock()
+		defer mu.Unlock()
+		return cc.(*ChunkCodec).WriteChunks(h, body, -1, &mu)
+	}, newCodec: func(cc Codec, typ Type) Codec { return NewChunkCodec(plainCodec(cc, typ), typ, 16) }},
+	{name: "compress", decodeOnly: true, write: writeFrame,
+		newCodec: func(cc Codec, typ Type) Codec { return NewCompressCodec(cc, typ, 1) }},
+}
+
+func (v goldenVariant) codec(t *testing.T, rwc io.ReadWriteCloser, typ Type) Codec {
+	if v.wrap != nil {
+		rwc = v.wrap(t, rwc, typ)
+	}
+	return v.newCodec(NewCodecFuncMap[typ](rwc), typ)
+}
+
+//TestGolden 以每种 codec 和编码组合写出固定的几帧，与 testdata/golden 下的文件逐字节比较，
+//字段改名、帧格式的改动都会导致失败；再从文件解码，确认旧的字节仍然能被读取
+func TestGolden(t *testing.T) {
+	for _, typ := range []Type{GobType, JsonType} {
+		for _, v := range goldenVariants {
+			name := map[Type]string{GobType: "gob", JsonType: "json"}[typ] + "_" + v.name
+			t.Run(name, func(t *testing.T) {
+				path := filepath.Join("testdata", "golden", name+".golden")
+				var buf bytes.Buffer
+				cc := v.codec(t, goldenConn{Writer: &buf}, typ)
+				for _, f := range goldenFrames {
+					h, body := f.h, f.body
+					if body == nil {
+						body = struct{}{}
+					}
+					if err := v.write(cc, &h, body); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if *update {
+					if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := ioutil.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !v.decodeOnly && !bytes.Equal(buf.Bytes(), want) {
+					t.Fatalf("wire bytes changed, run with -update if the change is intended\ngot:\n%swant:\n%s",
+						hex.Dump(buf.Bytes()), hex.Dump(want))
+				}
+				checkGolden(t, v.codec(t, goldenConn{Reader: bytes.NewReader(want)}, typ))
+			})
+		}
+	}
+}
+
+func checkGolden(t *testing.T, cc Codec) {
+	for i, f := range goldenFrames {
+		var h Header
+		if err := cc.ReadHeader(&h); err != nil {
+			t.Fatalf("frame %d: read header: %v", i, err)
+		}
+		h.Compressed = false
+		if !reflect.DeepEqual(h, f.h) {
+			t.Fatalf("frame %d: expect header %+v, got %+v", i, f.h, h)
+		}
+		if f.body == nil {
+			if err := cc.ReadBody(nil); err != nil {
+				t.Fatalf("frame %d: discard body: %v", i, err)
+			}
+			continue
+		}
+		got := reflect.New(reflect.TypeOf(f.body).Elem())
+		if err := cc.ReadBody(got.Interface()); err != nil {
+			t.Fatalf("frame %d: read body: %v", i, err)
+		}
+		if !reflect.DeepEqual(got.Interface(), f.body) {
+			t.Fatalf("frame %d: expect body %+v, got %+v", i, f.body, got.Interface())
+		}
+	}
+	if err := cc.ReadHeader(&Header{}); err != io.EOF {
+		t.Fatal("expect io.EOF after the last frame, got", err)
+	}
+}
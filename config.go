@@ -0,0 +1,497 @@
+package gpmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"gpmd/codec"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Config 汇总了服务端和客户端可调节的参数，可以从 JSON/YAML 文件和环境变量中加载，
+//避免在代码中写死这些参数
+type Config struct {
+	Addr           string        `json:"addr"`            //服务端监听地址，格式为 protocol@address，如 tcp@:9999
+	CodeType       codec.Type    `json:"codec"`           //客户端使用的编码方式
+	ConnectTimeout time.Duration `json:"connect_timeout"` //客户端连接超时
+	HandleTimeout  time.Duration `json:"handle_timeout"`  //请求处理超时，服务端作为默认值，客户端作为协商值
+
+	HandshakeTimeout  time.Duration `json:"handshake_timeout"`   //服务端等待客户端发送 Option 的时间
+	SlowCallThreshold time.Duration `json:"slow_call_threshold"` //服务端处理时间超过该值的调用会记录详细日志
+	MaxHandleTimeout  time.Duration `json:"max_handle_timeout"`  //服务端允许客户端协商的最大处理超时
+
+	AllowedCodecs []codec.Type `json:"allowed_codecs"` //服务端允许客户端使用的编码方式，为空表示不限制
+
+	CompressThreshold int           `json:"compress_threshold"` //客户端压缩消息的字节数阈值，0 表示不压缩
+	ChunkSize         int           `json:"chunk_size"`         //客户端拆分大消息的字节数，0 表示不拆分
+	Checksum          bool          `json:"checksum"`           //客户端是否开启逐帧 CRC32 校验
+	MaxPendingCalls   int           `json:"max_pending_calls"`  //客户端等待响应的调用数上限，0 表示不限制
+	MaxCallAge        time.Duration `json:"max_call_age"`       //客户端调用等待响应的最长时间，0 表示不限制
+	OrderedDelivery   bool          `json:"ordered_delivery"`   //客户端是否要求服务端按照请求的顺序回复
+
+	EncryptKey   string `json:"encrypt_key"`    //十六进制编码的 AES 预共享密钥，不为空时客户端开启加密，服务端用来解密
+	EncryptKeyID uint32 `json:"encrypt_key_id"` //EncryptKey 的编号
+
+	MaxConns        int     `json:"max_conns"`         //服务端最大并发连接数
+	MaxPendingConns int     `json:"max_pending_conns"` //服务端连接数达到上限后允许排队的连接数
+	AcceptRate      float64 `json:"accept_rate"`       //服务端每秒最多接受的连接数
+	AcceptBurst     int     `json:"accept_burst"`      //AcceptRate 允许的突发连接数
+
+	MaxConcurrentRequests int    `json:"max_concurrent_requests"` //服务端同时处理的请求数，超过的请求排队等待
+	Serial                string `json:"serial"`                  //服务端串行处理请求的方式：none、conn 或 service
+
+	WriteQueue     int           `json:"write_queue"`      //服务端每个连接的发送队列长度，0 表示在 handler 中直接写出
+	WriteQueueFull string        `json:"write_queue_full"` //发送队列满时的处理方式：block、drop 或 close
+	WriteTimeout   time.Duration `json:"write_timeout"`    //服务端写出一条消息的超时，需要同时设置 WriteQueue
+
+	TLSCert       string `json:"tls_cert"`        //证书路径，服务端必填，客户端可选
+	TLSKey        string `json:"tls_key"`         //私钥路径
+	TLSCA         string `json:"tls_ca"`          //用来校验对端证书的 CA 路径，服务端配置后要求客户端提供证书
+	TLSServerName string `json:"tls_server_name"` //客户端校验服务端证书时使用的主机名
+	TLSInsecure   bool   `json:"tls_insecure"`    //客户端不校验服务端证书，仅用于测试
+
+	Proxy string `json:"proxy"` //客户端经过的代理，格式为 socks5://[user:password@]host:port 或者 http://[user:password@]host:port
+
+	Registry         string        `json:"registry"`           //注册中心地址
+	RegistryRefresh  time.Duration `json:"registry_refresh"`   //从注册中心更新服务列表的间隔
+	RegistryMaxStale time.Duration `json:"registry_max_stale"` //注册中心不可用时，过期的服务列表最多继续使用的时间，负数表示不限制
+	Namespace        string        `json:"namespace"`          //注册中心的命名空间，例如 staging、prod，为空表示默认命名空间
+	Servers          []string      `json:"servers"`            //没有注册中心时，使用的静态服务列表
+	SelectMode       string        `json:"select_mode"`        //负载均衡策略：random 或 roundrobin
+
+	DebugPprof  bool   `json:"debug_pprof"`  //服务端 HandleHTTP 时挂载 /debug/pprof/
+	DebugExpvar bool   `json:"debug_expvar"` //服务端 HandleHTTP 时挂载 /debug/vars
+	DebugToken  string `json:"debug_token"`  //不为空时访问调试入口需要 Authorization: Bearer <token>
+}
+
+//DefaultConfig 返回与 DefaultOption 一致的默认配置
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:           "tcp@:9999",
+		CodeType:       DefaultOption.CodeType,
+		ConnectTimeout: DefaultOption.ConnectTimeout,
+		HandleTimeout:  DefaultOption.HandleTimeout,
+		SelectMode:     "random",
+	}
+}
+
+//LoadConfig 依次使用默认值、配置文件（path 为空时跳过）、环境变量构造配置，
+//配置文件根据扩展名区分 .json 和 .yaml/.yml
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			if data, err = yamlToJSON(data); err != nil {
+				return nil, fmt.Errorf("rpc config: parse %s error: %v", path, err)
+			}
+		case ".json":
+		default:
+			return nil, fmt.Errorf("rpc config: unsupported config file %s", path)
+		}
+		if err = json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("rpc config: parse %s error: %v", path, err)
+		}
+	}
+	if err := cfg.LoadEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+//LoadEnv 使用 GPMD_ 开头的环境变量覆盖配置，例如 GPMD_CONNECT_TIMEOUT=3s，GPMD_SERVERS=tcp@a:1,tcp@b:2
+func (c *Config) LoadEnv() error {
+	strs := map[string]*string{
+		"GPMD_ADDR":             &c.Addr,
+		"GPMD_CODEC":            (*string)(&c.CodeType),
+		"GPMD_TLS_CERT":         &c.TLSCert,
+		"GPMD_TLS_KEY":          &c.TLSKey,
+		"GPMD_TLS_CA":           &c.TLSCA,
+		"GPMD_TLS_SERVER_NAME":  &c.TLSServerName,
+		"GPMD_PROXY":            &c.Proxy,
+		"GPMD_REGISTRY":         &c.Registry,
+		"GPMD_NAMESPACE":        &c.Namespace,
+		"GPMD_SELECT_MODE":      &c.SelectMode,
+		"GPMD_ENCRYPT_KEY":      &c.EncryptKey,
+		"GPMD_SERIAL":           &c.Serial,
+		"GPMD_WRITE_QUEUE_FULL": &c.WriteQueueFull,
+		"GPMD_DEBUG_TOKEN":      &c.DebugToken,
+	}
+	for key, dst := range strs {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	durations := map[string]*time.Duration{
+		"GPMD_CONNECT_TIMEOUT":    &c.ConnectTimeout,
+		"GPMD_HANDLE_TIMEOUT":     &c.HandleTimeout,
+		"GPMD_REGISTRY_REFRESH":   &c.RegistryRefresh,
+		"GPMD_REGISTRY_MAX_STALE": &c.RegistryMaxStale,
+		"GPMD_HANDSHAKE_TIMEOUT":  &c.HandshakeTimeout,
+
+		"GPMD_SLOW_CALL_THRESHOLD": &c.SlowCallThreshold,
+		"GPMD_MAX_HANDLE_TIMEOUT":  &c.MaxHandleTimeout,
+		"GPMD_WRITE_TIMEOUT":       &c.WriteTimeout,
+		"GPMD_MAX_CALL_AGE":        &c.MaxCallAge,
+	}
+	for key, dst := range durations {
+		if v, ok := os.LookupEnv(key); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("rpc config: invalid %s: %v", key, err)
+			}
+			*dst = d
+		}
+	}
+	ints := map[string]*int{
+		"GPMD_MAX_CONNS":          &c.MaxConns,
+		"GPMD_MAX_PENDING_CONNS":  &c.MaxPendingConns,
+		"GPMD_ACCEPT_BURST":       &c.AcceptBurst,
+		"GPMD_COMPRESS_THRESHOLD": &c.CompressThreshold,
+		"GPMD_WRITE_QUEUE":        &c.WriteQueue,
+		"GPMD_CHUNK_SIZE":         &c.ChunkSize,
+		"GPMD_MAX_PENDING_CALLS":  &c.MaxPendingCalls,
+
+		"GPMD_MAX_CONCURRENT_REQUESTS": &c.MaxConcurrentRequests,
+	}
+	for key, dst := range ints {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("rpc config: invalid %s: %v", key, err)
+			}
+			*dst = n
+		}
+	}
+	if v, ok := os.LookupEnv("GPMD_ACCEPT_RATE"); ok {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("rpc config: invalid GPMD_ACCEPT_RATE: %v", err)
+		}
+		c.AcceptRate = rate
+	}
+	if v, ok := os.LookupEnv("GPMD_ENCRYPT_KEY_ID"); ok {
+		id, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return fmt.Errorf("rpc config: invalid GPMD_ENCRYPT_KEY_ID: %v", err)
+		}
+		c.EncryptKeyID = uint32(id)
+	}
+	bools := map[string]*bool{
+		"GPMD_TLS_INSECURE":     &c.TLSInsecure,
+		"GPMD_CHECKSUM":         &c.Checksum,
+		"GPMD_ORDERED_DELIVERY": &c.OrderedDelivery,
+		"GPMD_DEBUG_PPROF":      &c.DebugPprof,
+		"GPMD_DEBUG_EXPVAR":     &c.DebugExpvar,
+	}
+	for key, dst := range bools {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("rpc config: invalid %s: %v", key, err)
+			}
+			*dst = b
+		}
+	}
+	if v, ok := os.LookupEnv("GPMD_ALLOWED_CODECS"); ok {
+		c.AllowedCodecs = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				c.AllowedCodecs = append(c.AllowedCodecs, codec.Type(s))
+			}
+		}
+	}
+	if v, ok := os.LookupEnv("GPMD_SERVERS"); ok {
+		c.Servers = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				c.Servers = append(c.Servers, s)
+			}
+		}
+	}
+	return nil
+}
+
+//UnmarshalJSON 时间既可以写成 "3s" 这样的字符串，也可以写成纳秒数
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type plain Config
+	aux := struct {
+		*plain
+		ConnectTimeout   json.RawMessage `json:"connect_timeout"`
+		HandleTimeout    json.RawMessage `json:"handle_timeout"`
+		RegistryRefresh  json.RawMessage `json:"registry_refresh"`
+		RegistryMaxStale json.RawMessage `json:"registry_max_stale"`
+
+		HandshakeTimeout  json.RawMessage `json:"handshake_timeout"`
+		SlowCallThreshold json.RawMessage `json:"slow_call_threshold"`
+		MaxHandleTimeout  json.RawMessage `json:"max_handle_timeout"`
+		WriteTimeout      json.RawMessage `json:"write_timeout"`
+		MaxCallAge        json.RawMessage `json:"max_call_age"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	for _, d := range []struct {
+		raw json.RawMessage
+		dst *time.Duration
+	}{{aux.ConnectTimeout, &c.ConnectTimeout}, {aux.HandleTimeout, &c.HandleTimeout}, {aux.RegistryRefresh, &c.RegistryRefresh}, {aux.RegistryMaxStale, &c.RegistryMaxStale}, {aux.HandshakeTimeout, &c.HandshakeTimeout}, {aux.SlowCallThreshold, &c.SlowCallThreshold}, {aux.MaxHandleTimeout, &c.MaxHandleTimeout}, {aux.WriteTimeout, &c.WriteTimeout}, {aux.MaxCallAge, &c.MaxCallAge}} {
+		if len(d.raw) == 0 {
+			continue
+		}
+		var s string
+		if err := json.Unmarshal(d.raw, &s); err == nil {
+			v, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			*d.dst = v
+			continue
+		}
+		var n int64
+		if err := json.Unmarshal(d.raw, &n); err != nil {
+			return fmt.Errorf("invalid duration %s", d.raw)
+		}
+		*d.dst = time.Duration(n)
+	}
+	return nil
+}
+
+//Option 根据配置生成客户端使用的 Option
+func (c *Config) Option() (*Option, error) {
+	opt := &Option{
+		MagicNumber:    MagicNumber,
+		CodeType:       c.CodeType,
+		ConnectTimeout: c.ConnectTimeout,
+		HandleTimeout:  c.HandleTimeout,
+
+		CompressThreshold: c.CompressThreshold,
+		ChunkSize:         c.ChunkSize,
+		Checksum:          c.Checksum,
+		MaxPendingCalls:   c.MaxPendingCalls,
+		MaxCallAge:        c.MaxCallAge,
+		OrderedDelivery:   c.OrderedDelivery,
+	}
+	if c.TLSCA != "" || c.TLSCert != "" || c.TLSServerName != "" || c.TLSInsecure {
+		tlsConfig, err := c.clientTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		opt.TLSConfig = tlsConfig
+	}
+	if c.EncryptKey != "" {
+		keyring, err := c.keyring()
+		if err != nil {
+			return nil, err
+		}
+		opt.Encrypt, opt.Keyring = true, keyring
+	}
+	if c.Proxy != "" {
+		dial, err := ProxyDialer(c.Proxy, nil)
+		if err != nil {
+			return nil, err
+		}
+		opt.Dialer = dial
+	}
+	return opt, nil
+}
+
+func (c *Config) keyring() (*codec.Keyring, error) {
+	key, err := hex.DecodeString(c.EncryptKey)
+	if err != nil {
+		return nil, fmt.Errorf("rpc config: invalid encrypt_key: %v", err)
+	}
+	return codec.NewKeyring(c.EncryptKeyID, key)
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("rpc config: no certificate found in %s", path)
+	}
+	return pool, nil
+}
+
+func (c *Config) clientTLSConfig() (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: c.TLSServerName, InsecureSkipVerify: c.TLSInsecure}
+	if c.TLSCA != "" {
+		pool, err := loadCertPool(c.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if c.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+//Listen 按照 Addr 监听，配置了证书时返回 TLS 监听
+func (c *Config) Listen() (net.Listener, error) {
+	network, addr := "tcp", c.Addr
+	if parts := strings.SplitN(c.Addr, "@", 2); len(parts) == 2 {
+		network, addr = parts[0], parts[1]
+	}
+	if network == "http" {
+		network = "tcp"
+	}
+	if c.TLSCert == "" {
+		return net.Listen(network, addr)
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.TLSCA != "" {
+		pool, err := loadCertPool(c.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs, cfg.ClientAuth = pool, tls.RequireAndVerifyClientCert
+	}
+	return tls.Listen(network, addr, cfg)
+}
+
+//NewServerFromConfig 根据配置创建服务端
+func NewServerFromConfig(c *Config) (*Server, error) {
+	if c.CodeType != "" && codec.NewCodecFuncMap[c.CodeType] == nil {
+		return nil, fmt.Errorf("rpc config: invalid codec type %s", c.CodeType)
+	}
+	for _, typ := range c.AllowedCodecs {
+		if codec.NewCodecFuncMap[typ] == nil {
+			return nil, fmt.Errorf("rpc config: invalid allowed codec type %s", typ)
+		}
+	}
+	serial, err := ParseSerialMode(c.Serial)
+	if err != nil {
+		return nil, err
+	}
+	queueFull, err := ParseQueueFullPolicy(c.WriteQueueFull)
+	if err != nil {
+		return nil, err
+	}
+	s := NewServer()
+	s.Serial = serial
+	s.HandleTimeout = c.HandleTimeout
+	s.HandshakeTimeout = c.HandshakeTimeout
+	s.SlowCallThreshold = c.SlowCallThreshold
+	s.MaxConns = c.MaxConns
+	s.MaxPendingConns = c.MaxPendingConns
+	s.AcceptRate = c.AcceptRate
+	s.AcceptBurst = c.AcceptBurst
+	s.MaxConcurrentRequests = c.MaxConcurrentRequests
+	s.Defaults.Codecs = c.AllowedCodecs
+	s.Defaults.MaxHandleTimeout = c.MaxHandleTimeout
+	s.Defaults.WriteQueue = c.WriteQueue
+	s.Defaults.WriteQueueFull = queueFull
+	s.Defaults.WriteTimeout = c.WriteTimeout
+	if c.DebugPprof || c.DebugExpvar {
+		s.Debug = &DebugOptions{Pprof: c.DebugPprof, Expvar: c.DebugExpvar}
+		if c.DebugToken != "" {
+			s.Debug.Auth = TokenAuth(c.DebugToken)
+		}
+	}
+	if c.EncryptKey != "" {
+		keyring, err := c.keyring()
+		if err != nil {
+			return nil, err
+		}
+		s.Keyring, s.RequireEncrypt = keyring, true
+	}
+	return s, nil
+}
+
+//yamlToJSON 只支持配置文件用到的 YAML 子集：顶层的 key: value、字符串列表以及 # 注释
+func yamlToJSON(data []byte) ([]byte, error) {
+	m := make(map[string]interface{})
+	var listKey string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, " #"); i >= 0 {
+			text = text[:i]
+		}
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without key", line)
+			}
+			list, _ := m[listKey].([]interface{})
+			m[listKey] = append(list, yamlScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))))
+			continue
+		}
+		if text != trimmed && strings.HasPrefix(text, " ") {
+			return nil, fmt.Errorf("line %d: nested mapping is not supported", line)
+		}
+		i := strings.Index(trimmed, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: expect key: value", line)
+		}
+		key, value := strings.TrimSpace(trimmed[:i]), strings.TrimSpace(trimmed[i+1:])
+		if value == "" {
+			listKey = key
+			m[key] = []interface{}{}
+			continue
+		}
+		listKey = ""
+		if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+			list := []interface{}{}
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, yamlScalar(item))
+				}
+			}
+			m[key] = list
+			continue
+		}
+		m[key] = yamlScalar(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+func yamlScalar(s string) interface{} {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	switch s {
+	case "true", "yes", "on":
+		return true
+	case "false", "no", "off":
+		return false
+	case "null", "~":
+		return nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n
+	}
+	return s
+}
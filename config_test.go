@@ -0,0 +1,71 @@
+package gpmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gpmd")
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "gpmd.yaml")
+	_ = ioutil.WriteFile(path, []byte(`# gpmd config
+addr: tcp@:8888
+codec: application/json
+connect_timeout: 3s
+handle_timeout: "1m"
+servers:
+  - tcp@127.0.0.1:1
+  - tcp@127.0.0.1:2
+select_mode: roundrobin
+allowed_codecs: [application/json]
+max_handle_timeout: 5s
+serial: conn
+`), 0644)
+	_ = os.Setenv("GPMD_HANDLE_TIMEOUT", "2s")
+	defer func() { _ = os.Unsetenv("GPMD_HANDLE_TIMEOUT") }()
+
+	cfg, err := LoadConfig(path)
+	_assert(err == nil, "failed to load config: %v", err)
+	_assert(cfg.Addr == "tcp@:8888" && cfg.CodeType == "application/json", "wrong config %+v", cfg)
+	_assert(cfg.ConnectTimeout == 3*time.Second, "wrong connect timeout %s", cfg.ConnectTimeout)
+	_assert(cfg.HandleTimeout == 2*time.Second, "env should override file, got %s", cfg.HandleTimeout)
+	_assert(len(cfg.Servers) == 2 && cfg.Servers[1] == "tcp@127.0.0.1:2", "wrong servers %v", cfg.Servers)
+	server, err := NewServerFromConfig(cfg)
+	_assert(err == nil && len(server.Defaults.Codecs) == 1 && server.Defaults.MaxHandleTimeout == 5*time.Second, "server should use config codecs and max handle timeout, got %+v %v", server.Defaults, err)
+	_assert(server.Serial == SerialPerConn, "server should use config serial mode, got %v", server.Serial)
+
+	jsonPath := filepath.Join(dir, "gpmd.json")
+	_ = ioutil.WriteFile(jsonPath, []byte(`{"registry": "http://127.0.0.1:9999/_gpmd_/registry", "registry_refresh": 1000000000}`), 0644)
+	cfg, err = LoadConfig(jsonPath)
+	_assert(err == nil && cfg.RegistryRefresh == time.Second && cfg.CodeType == DefaultOption.CodeType, "wrong json config %+v %v", cfg, err)
+
+	server, err = NewServerFromConfig(cfg)
+	_assert(err == nil && server.HandleTimeout == 2*time.Second, "server should use config handle timeout")
+}
+
+func TestConfig_LoadEnvProxy(t *testing.T) {
+	addr := startLimitedServer(NewServer())
+	socks, tunnels := startSocks5Proxy(t, "", "")
+	_ = os.Setenv("GPMD_PROXY", "socks5://"+socks)
+	defer func() { _ = os.Unsetenv("GPMD_PROXY") }()
+
+	cfg := DefaultConfig()
+	_assert(cfg.LoadEnv() == nil && cfg.Proxy == "socks5://"+socks, "expect GPMD_PROXY loaded, got %q", cfg.Proxy)
+	opt, err := cfg.Option()
+	_assert(err == nil && opt.Dialer != nil, "expect the proxy dialer installed, got %v", err)
+	client, err := Dial("tcp", addr, opt)
+	_assert(err == nil && callSum(client) == nil, "dial through GPMD_PROXY failed: %v", err)
+	_ = client.Close()
+	_assert(atomic.LoadInt32(tunnels) == 1, "expect the connection to go through the proxy")
+
+	_ = os.Setenv("GPMD_PROXY", "ftp://"+socks)
+	cfg = DefaultConfig()
+	_assert(cfg.LoadEnv() == nil, "load env error")
+	_, err = cfg.Option()
+	_assert(err != nil, "expect an invalid GPMD_PROXY rejected")
+}
@@ -0,0 +1,107 @@
+package gpmd
+
+import (
+	"context"
+	"crypto/tls"
+	"gpmd/codec"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Conn 记录服务端一个客户端连接的信息，并提供连接级别的键值存储，
+//通过 Server.OnConnect 和 Server.OnDisconnect 获取，handler 中通过 ConnFromContext 获取。
+//Conn 可以作为会话使用：登录的 handler 把用户保存在连接上，之后同一个连接上的调用直接读取，
+//OnClose 注册的函数在连接关闭时清理会话
+type Conn struct {
+	ID          uint64               //服务端内唯一的连接编号
+	RemoteAddr  net.Addr             //客户端地址，底层连接不是 net.Conn 时为空
+	LocalAddr   net.Addr             //服务端地址，底层连接不是 net.Conn 时为空
+	TLS         *tls.ConnectionState //TLS 连接的状态，非 TLS 连接为空
+	Identity    *PeerIdentity        //经过校验的客户端证书身份，非双向 TLS 连接为空
+	Opt         Option               //客户端握手时发送的 Option，未指定的项已经用服务端的 ListenerOption 补全
+	ConnectedAt time.Time            //握手完成的时间
+
+	values  sync.Map
+	cc      codec.Codec
+	sending sync.Mutex //与响应共用，保证推送的消息和响应不会交错
+	pushSeq uint64
+	serial  serialQueue //SerialPerConn 时连接上的请求排队处理
+	order   *replyOrder //Opt.OrderedDelivery 时按照请求的顺序发送回复
+
+	closeMu  sync.Mutex
+	closed   bool     //连接已经关闭，之后注册的 OnClose 立即执行
+	onCloses []func() //OnClose 注册的函数
+}
+
+type connKey struct{}
+
+//ConnFromContext 在 handler 中获取请求所在的连接
+func ConnFromContext(ctx context.Context) (*Conn, bool) {
+	c, ok := ctx.Value(connKey{}).(*Conn)
+	return c, ok
+}
+
+//Set 在连接上保存一个值
+func (c *Conn) Set(key, value interface{}) {
+	c.values.Store(key, value)
+}
+
+//Get 读取连接上保存的值
+func (c *Conn) Get(key interface{}) (interface{}, bool) {
+	return c.values.Load(key)
+}
+
+//Delete 删除连接上保存的值
+func (c *Conn) Delete(key interface{}) {
+	c.values.Delete(key)
+}
+
+//OnClose 注册连接关闭时调用的函数，连接上所有请求处理完之后、Server.OnDisconnect 之前，
+//按照注册的相反顺序调用。连接已经关闭时立即调用
+func (c *Conn) OnClose(fn func()) {
+	c.closeMu.Lock()
+	if !c.closed {
+		c.onCloses = append(c.onCloses, fn)
+		c.closeMu.Unlock()
+		return
+	}
+	c.closeMu.Unlock()
+	fn()
+}
+
+//close 调用 OnClose 注册的函数
+func (c *Conn) close() {
+	c.closeMu.Lock()
+	c.closed = true
+	fns := c.onCloses
+	c.onCloses = nil
+	c.closeMu.Unlock()
+	for i := len(fns) - 1; i >= 0; i-- {
+		fns[i]()
+	}
+}
+
+func (s *Server) newConn(rwc io.ReadWriteCloser, opt *Option) *Conn {
+	c := &Conn{
+		ID:          atomic.AddUint64(&s.connSeq, 1),
+		Opt:         *opt,
+		ConnectedAt: time.Now(),
+	}
+	if nc, ok := rwc.(net.Conn); ok {
+		c.RemoteAddr = nc.RemoteAddr()
+		c.LocalAddr = nc.LocalAddr()
+	}
+	if sc, ok := rwc.(*sniffedConn); ok {
+		rwc = sc.Conn //Mux 分发的连接
+	}
+	//读取 Option 时已经完成了 TLS 握手，这里可以拿到完整的连接状态
+	if tc, ok := rwc.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		c.TLS = &state
+		c.Identity = newPeerIdentity(c)
+	}
+	return c
+}
@@ -0,0 +1,57 @@
+package gpmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type requestIDKey struct{}
+
+//ContextWithRequestID 返回携带请求编号的 ctx，客户端使用该 ctx 调用 Call 时会沿用这个编号
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+//RequestIDFromContext 读取 ctx 中的请求编号，服务端 handler 收到的 ctx 中总是带有请求编号
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey{}).(string)
+	return requestID, ok && requestID != ""
+}
+
+var fallbackRequestSeq uint64
+
+//newRequestID 生成 16 位十六进制的随机请求编号
+func newRequestID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		//随机数生成失败时，退化为时间戳加自增序号
+		return strconv.FormatInt(time.Now().UnixNano(), 16) + "-" + strconv.FormatUint(atomic.AddUint64(&fallbackRequestSeq, 1), 16)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+//Priority 调用在服务端排队时的优先级，服务端设置了 MaxConcurrentRequests 时按照优先级调度
+type Priority int8
+
+const (
+	PriorityLow    Priority = -1 //批量数据这类可以等待的调用
+	PriorityNormal Priority = 0
+	PriorityHigh   Priority = 1 //健康检查、控制面这类不能被饿死的调用
+)
+
+type priorityKey struct{}
+
+//ContextWithPriority 返回携带调用优先级的 ctx，客户端使用该 ctx 调用 Call 时设置 Header.Priority
+func ContextWithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+//PriorityFromContext 读取 ctx 中的调用优先级，没有设置时返回 PriorityNormal
+func PriorityFromContext(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
@@ -1,6 +1,7 @@
 package gpmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
@@ -14,11 +15,12 @@ const debugText = `<html>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th>
 		{{range $name, $mtype := .Method}}
 			<tr>
 			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
 			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=center>{{$mtype.NumErrors}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -52,3 +54,15 @@ func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
 }
+
+//statsHTTP 以 JSON 返回 Server.Stats
+type statsHTTP struct {
+	*Server
+}
+
+func (server statsHTTP) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(server.Stats()); err != nil {
+		_, _ = fmt.Fprintln(w, "rpc: error encoding stats:", err.Error())
+	}
+}
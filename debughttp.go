@@ -0,0 +1,168 @@
+package gpmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPprofPath  = "/debug/pprof/"
+	defaultExpvarPath = "/debug/vars"
+)
+
+//DebugOptions HandleHTTP 额外挂载的调试入口，见 Server.Debug。
+//这里不导入 net/http/pprof 和 expvar，它们会在 http.DefaultServeMux 上自动注册，无法放到其他 mux 或者加上鉴权
+type DebugOptions struct {
+	Mux    Router                     //挂载的位置，为空时与 HandleHTTPOn 的 mux 相同
+	Pprof  bool                       //挂载 /debug/pprof/，可以直接交给 go tool pprof
+	Expvar bool                       //挂载 /debug/vars，格式与 expvar 相同，包括 gpmd 的请求数、错误数和字节数
+	Auth   func(r *http.Request) bool //不为空时只有返回 true 的请求可以访问，其他请求返回 403
+}
+
+//TokenAuth 返回检查 Authorization: Bearer token 的 DebugOptions.Auth
+func TokenAuth(token string) func(r *http.Request) bool {
+	want := []byte("Bearer " + token)
+	return func(r *http.Request) bool {
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) == 1
+	}
+}
+
+func (s *Server) handleDebug(o *DebugOptions, mux Router) {
+	if o.Mux != nil {
+		mux = o.Mux
+	}
+	guard := func(h http.HandlerFunc) http.Handler {
+		if o.Auth == nil {
+			return h
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !o.Auth(r) {
+				http.Error(w, "403 forbidden", http.StatusForbidden)
+				return
+			}
+			h(w, r)
+		})
+	}
+	if o.Pprof {
+		mux.Handle(defaultPprofPath, guard(servePprof))
+	}
+	if o.Expvar {
+		mux.Handle(defaultExpvarPath, guard(s.serveExpvar))
+	}
+}
+
+//servePprof /debug/pprof/ 列出所有 profile，/debug/pprof/<name>?debug=N 返回 profile，
+///debug/pprof/profile?seconds=N 采集 N 秒（默认 30）的 CPU profile
+func servePprof(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, defaultPprofPath)
+	debug, _ := strconv.Atoi(r.FormValue("debug"))
+	switch name {
+	case "":
+		profiles := pprof.Profiles()
+		sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = io.WriteString(w, "<html><body><h1>gpmd pprof</h1>\n")
+		for _, p := range profiles {
+			_, _ = fmt.Fprintf(w, "<a href=\"%s?debug=1\">%s</a> (%d)<br>\n", p.Name(), p.Name(), p.Count())
+		}
+		_, _ = io.WriteString(w, "<a href=\"profile?seconds=30\">profile</a> (CPU)<br>\n</body></html>\n")
+	case "profile":
+		seconds, err := strconv.Atoi(r.FormValue("seconds"))
+		if err != nil || seconds <= 0 {
+			seconds = 30
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := pprof.StartCPUProfile(w); err != nil {
+			http.Error(w, "rpc debug: cpu profile: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		timer := time.NewTimer(time.Duration(seconds) * time.Second)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			timer.Stop()
+		}
+		pprof.StopCPUProfile()
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			http.Error(w, "rpc debug: unknown profile "+name, http.StatusNotFound)
+			return
+		}
+		if name == "heap" && r.FormValue("gc") != "" {
+			runtime.GC()
+		}
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		_ = p.WriteTo(w, debug)
+	}
+}
+
+//DebugVars /debug/vars 中 gpmd 的计数器
+type DebugVars struct {
+	Requests       uint64 `json:"requests"`      //handler 处理过的请求数
+	Errors         uint64 `json:"errors"`        //handler 返回错误的请求数
+	BytesRead      uint64 `json:"bytes_read"`    //原生连接握手之后读取的字节数
+	BytesWritten   uint64 `json:"bytes_written"` //原生连接握手之后写出的字节数
+	ActiveConns    int64  `json:"active_conns"`
+	ActiveHandlers int64  `json:"active_handlers"`
+}
+
+//DebugVars 返回当前的计数器
+func (s *Server) DebugVars() DebugVars {
+	stats := s.Stats()
+	vars := DebugVars{
+		BytesRead:      atomic.LoadUint64(&s.bytesRead),
+		BytesWritten:   atomic.LoadUint64(&s.bytesWritten),
+		ActiveConns:    stats.ActiveConns,
+		ActiveHandlers: stats.ActiveHandlers,
+	}
+	for _, svc := range stats.Services {
+		vars.Requests += svc.Calls
+		vars.Errors += svc.Errors
+	}
+	return vars
+}
+
+func (s *Server) serveExpvar(w http.ResponseWriter, _ *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"cmdline":  os.Args,
+		"memstats": mem,
+		"gpmd":     s.DebugVars(),
+	})
+}
+
+//byteCountingConn 统计连接读写的字节数
+type byteCountingConn struct {
+	io.ReadWriteCloser
+	s *Server
+}
+
+func (c *byteCountingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	atomic.AddUint64(&c.s.bytesRead, uint64(n))
+	return n, err
+}
+
+func (c *byteCountingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddUint64(&c.s.bytesWritten, uint64(n))
+	return n, err
+}
@@ -0,0 +1,48 @@
+package gpmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServer_Debug(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	mux := http.NewServeMux()
+	server.Debug = &DebugOptions{Pprof: true, Expvar: true, Auth: TokenAuth("ops")}
+	server.HandleHTTPOn(mux)
+	addr := startLimitedServer(server)
+	client, _ := Dial("tcp", addr)
+	defer func() { _ = client.Close() }()
+	_assert(callSum(client) == nil, "expect call served")
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+	_assert(get(defaultStatsPath, "").Code == http.StatusOK, "expect stats on the custom mux")
+	_assert(get("/debug/vars", "").Code == http.StatusForbidden, "expect debug vars to require a token")
+	_assert(get("/debug/pprof/", "wrong").Code == http.StatusForbidden, "expect pprof to reject a wrong token")
+
+	w := get("/debug/pprof/", "ops")
+	_assert(w.Code == http.StatusOK && strings.Contains(w.Body.String(), "goroutine"), "expect pprof index, got %d", w.Code)
+	w = get("/debug/pprof/goroutine?debug=1", "ops")
+	_assert(w.Code == http.StatusOK && strings.Contains(w.Body.String(), "goroutine profile"), "expect goroutine profile, got %d", w.Code)
+	_assert(get("/debug/pprof/missing", "ops").Code == http.StatusNotFound, "expect unknown profile not found")
+
+	w = get("/debug/vars", "ops")
+	var vars struct {
+		Gpmd DebugVars `json:"gpmd"`
+	}
+	_assert(json.Unmarshal(w.Body.Bytes(), &vars) == nil, "expect json debug vars")
+	_assert(vars.Gpmd.Requests >= 1, "expect requests counted, got %+v", vars.Gpmd)
+	_assert(vars.Gpmd.BytesRead > 0 && vars.Gpmd.BytesWritten > 0, "expect bytes counted, got %+v", vars.Gpmd)
+}
@@ -0,0 +1,163 @@
+package gpmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+//dialFallbackDelay 一个地址还没有连上时，间隔这么久开始尝试下一个地址，与 RFC 8305 的建议值相同
+const dialFallbackDelay = 250 * time.Millisecond
+
+//DialError 主机名解析出的所有地址都连接失败，Errors 按照尝试的顺序排列每个地址的错误
+type DialError struct {
+	Address string  //Dial 的地址，主机名没有解析
+	Errors  []error //每个地址的错误，形如 "ip:port: 原因"，与地址的顺序相同
+}
+
+func (e *DialError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("rpc client: dial %s: all %d addresses failed: %s", e.Address, len(e.Errors), strings.Join(msgs, "; "))
+}
+
+//Unwrap 返回第一个地址的错误，其他地址的错误见 Errors
+func (e *DialError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[0]
+}
+
+//dialHost 解析 address 中的主机名，多个地址时交错 IPv6 和 IPv4 后错开时间并行连接，使用第一个成功的连接，
+//一条失效的 DNS 记录不会让整个调用阻塞到超时。IP 地址和非 TCP 的网络直接使用 net.Dialer
+func dialHost(ctx context.Context, clock Clock, network, address string) (net.Conn, error) {
+	d := &net.Dialer{}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || host == "" || net.ParseIP(host) != nil || !strings.HasPrefix(network, "tcp") {
+		return d.DialContext(ctx, network, address)
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	addrs := interleaveFamilies(ips, network)
+	if len(addrs) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	for i, addr := range addrs {
+		addrs[i] = net.JoinHostPort(addr, port)
+	}
+	conn, err := dialAddrs(ctx, clock, d.DialContext, network, addrs, dialFallbackDelay)
+	if derr, ok := err.(*DialError); ok {
+		derr.Address = address
+	}
+	return conn, err
+}
+
+//interleaveFamilies 按照 network 过滤地址族，并从第一个地址的地址族开始交替排列 IPv6 和 IPv4 的地址
+func interleaveFamilies(ips []net.IPAddr, network string) []string {
+	var first, second []string
+	firstIs4 := len(ips) > 0 && ips[0].IP.To4() != nil
+	for _, ip := range ips {
+		is4 := ip.IP.To4() != nil
+		if (network == "tcp4" && !is4) || (network == "tcp6" && is4) {
+			continue
+		}
+		s := ip.String()
+		if is4 == firstIs4 {
+			first = append(first, s)
+		} else {
+			second = append(second, s)
+		}
+	}
+	addrs := make([]string, 0, len(first)+len(second))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			addrs = append(addrs, first[i])
+		}
+		if i < len(second) {
+			addrs = append(addrs, second[i])
+		}
+	}
+	return addrs
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+	addr string
+	i    int //地址在 addrs 中的位置
+}
+
+//dialAddrs 依次开始连接 addrs：前一个地址 delay 之内没有结果或者已经失败时开始下一个，第一个成功的连接胜出，
+//其他仍在进行的连接被取消，晚到的连接被关闭。全部失败时返回 DialError
+func dialAddrs(ctx context.Context, clock Clock, dial DialFunc, network string, addrs []string, delay time.Duration) (net.Conn, error) {
+	if len(addrs) == 1 {
+		return dial(ctx, network, addrs[0])
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(addrs))
+	next, running := 0, 0
+	startNext := func() {
+		i, addr := next, addrs[next]
+		next++
+		running++
+		go func() {
+			conn, err := dial(ctx, network, addr)
+			results <- dialResult{conn: conn, err: err, addr: addr, i: i}
+		}()
+	}
+	startNext()
+	errs := make([]error, len(addrs)) //按照地址的顺序，而不是失败的顺序
+	for running > 0 {
+		var fallback <-chan struct{}
+		var timer Timer
+		if next < len(addrs) {
+			fallback, timer = after(clock, delay)
+		}
+		select {
+		case r := <-results:
+			running--
+			if r.err == nil {
+				stopTimer(timer)
+				go closeLate(results, running)
+				return r.conn, nil
+			}
+			errs[r.i] = fmt.Errorf("%s: %w", r.addr, r.err)
+			if next < len(addrs) {
+				startNext()
+			}
+		case <-fallback:
+			startNext()
+		}
+		stopTimer(timer)
+	}
+	failed := errs[:0]
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return nil, &DialError{Errors: failed}
+}
+
+func stopTimer(timer Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+//closeLate 关闭胜出之后才连上的 n 个连接
+func closeLate(results <-chan dialResult, n int) {
+	for ; n > 0; n-- {
+		if r := <-results; r.conn != nil {
+			_ = r.conn.Close()
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDialAddrs(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	errRefused := errors.New("refused")
+	canceled := make(chan string, 4)
+	late := make(chan net.Conn, 1)
+	//stale 一直没有结果直到被取消，refused 立即失败，slow 在 good 之后才连上，good 连接真实的地址
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		switch addr {
+		case "stale":
+			<-ctx.Done()
+			canceled <- addr
+			return nil, ctx.Err()
+		case "refused":
+			return nil, errRefused
+		case "slow-refused":
+			time.Sleep(30 * time.Millisecond)
+			return nil, errRefused
+		case "slow":
+			time.Sleep(50 * time.Millisecond)
+			conn, _ := net.Pipe()
+			late <- conn
+			return conn, nil
+		}
+		return net.Dial(network, l.Addr().String())
+	}
+
+	start := time.Now()
+	conn, err := dialAddrs(context.Background(), SystemClock, dial, "tcp", []string{"stale", "good"}, 20*time.Millisecond)
+	_assert(err == nil && time.Since(start) < time.Second, "expect the fallback address after the delay, got %v", err)
+	_ = conn.Close()
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expect the stale attempt canceled after another address won")
+	}
+
+	//失败的地址不需要等待 delay，立即尝试下一个
+	start = time.Now()
+	conn, err = dialAddrs(context.Background(), SystemClock, dial, "tcp", []string{"refused", "good"}, time.Hour)
+	_assert(err == nil && time.Since(start) < time.Second, "expect a failed address to start the next one at once, got %v", err)
+	_ = conn.Close()
+
+	//胜出之后才连上的连接被关闭
+	conn, err = dialAddrs(context.Background(), SystemClock, dial, "tcp", []string{"slow", "good"}, time.Millisecond)
+	_assert(err == nil, "dial error: %v", err)
+	_ = conn.Close()
+	_, err = (<-late).Write([]byte("x"))
+	_assert(err != nil, "expect the late connection closed")
+
+	//全部失败时返回每个地址的错误
+	_, err = dialAddrs(context.Background(), SystemClock, dial, "tcp", []string{"refused", "refused"}, time.Hour)
+	var derr *DialError
+	_assert(errors.As(err, &derr) && len(derr.Errors) == 2 && errors.Is(err, errRefused), "expect all attempted errors, got %v", err)
+	_assert(strings.Contains(err.Error(), "refused: refused"), "expect the addresses in the message, got %v", err)
+
+	//错误按照地址的顺序排列，而不是失败的顺序
+	_, err = dialAddrs(context.Background(), SystemClock, dial, "tcp", []string{"slow-refused", "refused"}, time.Millisecond)
+	_assert(errors.As(err, &derr) && len(derr.Errors) == 2, "expect all attempted errors, got %v", err)
+	_assert(strings.HasPrefix(derr.Errors[0].Error(), "slow-refused:") && strings.HasPrefix(errors.Unwrap(err).Error(), "slow-refused:"),
+		"expect errors in address order, got %v", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, err = dialAddrs(ctx, SystemClock, dial, "tcp", []string{"stale", "stale"}, time.Millisecond)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect ctx to bound all attempts, got %v", err)
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	t.Parallel()
+	var ips []net.IPAddr
+	for _, s := range []string{"::1", "::2", "::3", "10.0.0.1", "10.0.0.2"} {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(s)})
+	}
+	got := interleaveFamilies(ips, "tcp")
+	want := []string{"::1", "10.0.0.1", "::2", "10.0.0.2", "::3"}
+	_assert(reflect.DeepEqual(got, want), "expect families interleaved, got %v", got)
+	got = interleaveFamilies(ips, "tcp4")
+	_assert(reflect.DeepEqual(got, []string{"10.0.0.1", "10.0.0.2"}), "expect only IPv4 for tcp4, got %v", got)
+}
+
+func TestDialHost(t *testing.T) {
+	t.Parallel()
+	//localhost 可能同时解析为 ::1 和 127.0.0.1，只在 127.0.0.1 上监听时仍然能够连上
+	addr := startLimitedServer(NewServer())
+	_, port, _ := net.SplitHostPort(addr)
+	client, err := Dial("tcp", "localhost:"+port)
+	_assert(err == nil && callSum(client) == nil, "expect localhost to connect, got %v", err)
+	_ = client.Close()
+
+	_, err = dialHost(context.Background(), SystemClock, "tcp", "localhost:1")
+	_assert(err != nil, "expect dial error for a closed port")
+}
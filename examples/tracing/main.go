@@ -0,0 +1,112 @@
+//tracing 演示通过 gpmd.SetTracer 接入分布式追踪：客户端把追踪编号写入请求的元数据，
+//服务端从元数据中取出，日志中同一个 trace 的 span 可以串起来，看出每次尝试由哪个实例处理
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"gpmd"
+	"gpmd/metadata"
+	"gpmd/xclient"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	traceIDKey = "trace-id"
+	parentKey  = "parent-span-id"
+)
+
+type spanKey struct{}
+
+//logSpan 结束时打印到日志的 span
+type logSpan struct {
+	traceID, id, parent string
+	name                string
+	kind                gpmd.SpanKind
+	start               time.Time
+	mu                  sync.Mutex
+	tags                []string
+}
+
+func (s *logSpan) SetTag(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags = append(s.tags, key+"="+value)
+}
+
+func (s *logSpan) Finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kinds := map[gpmd.SpanKind]string{gpmd.SpanClient: "client", gpmd.SpanServer: "server", gpmd.SpanInternal: "internal"}
+	log.Printf("trace=%s span=%s parent=%s %s %q %s [%s] err=%v",
+		s.traceID, s.id, s.parent, kinds[s.kind], s.name, time.Since(s.start), strings.Join(s.tags, " "), err)
+}
+
+//logTracer 一个简单的 Tracer，真实的系统中换成 OpenTelemetry 等实现
+type logTracer struct{}
+
+func (logTracer) StartSpan(ctx context.Context, name string, kind gpmd.SpanKind) (context.Context, gpmd.Span) {
+	s := &logSpan{id: newID(), name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*logSpan); ok {
+		s.traceID, s.parent = parent.traceID, parent.id
+	} else if md, ok := metadata.FromIncomingContext(ctx); ok && kind == gpmd.SpanServer {
+		//服务端的 span 从请求的元数据中取出调用方传递的追踪编号
+		s.traceID, s.parent = md.Value(traceIDKey), md.Value(parentKey)
+	}
+	if s.traceID == "" {
+		s.traceID = newID()
+	}
+	ctx = context.WithValue(ctx, spanKey{}, s)
+	if kind == gpmd.SpanClient {
+		ctx = metadata.AppendToOutgoingContext(ctx, traceIDKey, s.traceID, parentKey, s.id)
+	}
+	return ctx, s
+}
+
+func newID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func startServer() string {
+	var foo Foo
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := gpmd.NewServer()
+	_ = server.Register(&foo)
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func main() {
+	log.SetFlags(0)
+	gpmd.SetTracer(logTracer{})
+	d := xclient.NewMultiServerDiscovery([]string{startServer(), startServer()})
+	xc := xclient.NewXClient(d, xclient.RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	for i := 0; i < 2; i++ {
+		if err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: i * i}, &reply); err != nil {
+			log.Fatal("call Foo.Sum error:", err)
+		}
+	}
+	//广播的 span 是每个实例调用的父 span
+	if err := xc.Broadcast(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply); err != nil {
+		log.Fatal("broadcast Foo.Sum error:", err)
+	}
+}
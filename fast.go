@@ -0,0 +1,114 @@
+package gpmd
+
+import (
+	"gpmd/codec"
+	"reflect"
+	"sync"
+)
+
+//valuePool 复用布尔、数字、字符串这类小的值的实例，Server.FastPath 开启时参数和回复从这里获取，
+//省去每次调用的 reflect.New，编码和解码仍然由 codec 完成。取出的实例总是零值：gob 不会写入零值字段，复用之前必须重置
+type valuePool struct {
+	pool sync.Pool
+	zero reflect.Value
+	load func(p interface{}) interface{} //取出 *T 中的值，写出是异步的时候回复可以在写出之前放回池中
+}
+
+//newValuePool t 不是小的值类型时返回 nil
+func newValuePool(t reflect.Type) *valuePool {
+	if !isSmallValue(t) {
+		return nil
+	}
+	return &valuePool{
+		pool: sync.Pool{New: func() interface{} { return reflect.New(t).Interface() }},
+		zero: reflect.Zero(t),
+		load: compileLoad(t),
+	}
+}
+
+//isSmallValue 判断 t 是否是编码后长度很小、可以直接复制的值类型
+func isSmallValue(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+//compileLoad 为常用的内置类型生成不经过反射的取值函数，其他类型（包括以它们为底层类型的自定义类型）使用反射
+func compileLoad(t reflect.Type) func(p interface{}) interface{} {
+	switch t {
+	case reflect.TypeOf(false):
+		return func(p interface{}) interface{} { return *p.(*bool) }
+	case reflect.TypeOf(""):
+		return func(p interface{}) interface{} { return *p.(*string) }
+	case reflect.TypeOf(0):
+		return func(p interface{}) interface{} { return *p.(*int) }
+	case reflect.TypeOf(int32(0)):
+		return func(p interface{}) interface{} { return *p.(*int32) }
+	case reflect.TypeOf(int64(0)):
+		return func(p interface{}) interface{} { return *p.(*int64) }
+	case reflect.TypeOf(uint32(0)):
+		return func(p interface{}) interface{} { return *p.(*uint32) }
+	case reflect.TypeOf(uint64(0)):
+		return func(p interface{}) interface{} { return *p.(*uint64) }
+	case reflect.TypeOf(float64(0)):
+		return func(p interface{}) interface{} { return *p.(*float64) }
+	}
+	return func(p interface{}) interface{} { return reflect.ValueOf(p).Elem().Interface() }
+}
+
+//get 返回指向零值的 *T
+func (p *valuePool) get() reflect.Value {
+	v := reflect.ValueOf(p.pool.Get())
+	v.Elem().Set(p.zero)
+	return v
+}
+
+//put 放回 get 返回的 *T，之后不能再访问它
+func (p *valuePool) put(v reflect.Value) {
+	p.pool.Put(v.Interface())
+}
+
+//fastArgv 与 newArgv 相同，参数是小的值类型时从池中获取
+func (m *methodType) fastArgv() reflect.Value {
+	if m.argPool == nil {
+		return m.newArgv()
+	}
+	return m.argPool.get().Elem()
+}
+
+//fastReply 与 newReply 相同，回复是小的值类型时从池中获取
+func (m *methodType) fastReply() reflect.Value {
+	if m.replyPool == nil {
+		return m.newReply()
+	}
+	return m.replyPool.get()
+}
+
+//releaseArgv handler 返回之后放回池中的参数，参数按值传给 handler，之后不会再被访问
+func (req *request) releaseArgv() {
+	if req.pooled && req.mType.argPool != nil {
+		req.mType.argPool.put(req.argv.Addr())
+	}
+}
+
+//replyBody 返回写出的回复。写出是异步的时候（见 ListenerOption.WriteQueue 和 Option.OrderedDelivery）
+//复制出池中回复的值，回复随后就可以放回池中
+func (req *request) replyBody(cc codec.Codec, c *Conn) interface{} {
+	_, queued := cc.(*queuedCodec)
+	if (queued || c.order != nil) && req.pooled && req.mType.replyPool != nil {
+		return req.mType.replyPool.load(req.replyv.Interface())
+	}
+	return req.replyv.Interface()
+}
+
+//releaseReply 回复写出之后放回池中
+func (req *request) releaseReply() {
+	if req.pooled && req.mType.replyPool != nil {
+		req.mType.replyPool.put(req.replyv)
+	}
+}
@@ -0,0 +1,56 @@
+package gpmd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Scalars int
+
+func (s Scalars) Negate(args int, reply *int) error {
+	*reply = -args
+	return nil
+}
+
+func (s Scalars) Repeat(args string, reply *string) error {
+	*reply = args + args
+	return nil
+}
+
+func TestServer_FastPath(t *testing.T) {
+	t.Parallel()
+	for _, lo := range []ListenerOption{{}, {WriteQueue: 4}} {
+		server := NewServer()
+		server.FastPath = true
+		server.Defaults = lo
+		var scalars Scalars
+		_ = server.Register(&scalars)
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		go server.Accept(l)
+		client, err := Dial("tcp", l.Addr().String())
+		_assert(err == nil, "dial error: %v", err)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				//交替传入零值，池中复用的实例需要先重置，否则 gob 会留下上一次的值
+				for n := 0; n < 50; n++ {
+					args := (i + n) % 3 * i
+					var reply int
+					_assert(client.Call(ctx, "Scalars.Negate", args, &reply) == nil && reply == -args, "expect %d, got %d", -args, reply)
+					word, repeated := "ab"[:n%3], ""
+					_assert(client.Call(ctx, "Scalars.Repeat", word, &repeated) == nil && repeated == word+word, "expect %q, got %q", word+word, repeated)
+				}
+			}(i)
+		}
+		wg.Wait()
+		cancel()
+		_ = client.Close()
+		_ = l.Close()
+	}
+}
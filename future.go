@@ -0,0 +1,99 @@
+package gpmd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+//Future 一次异步调用的结果，由 CallAsync 返回。不需要调用方准备 done channel，可以多次 Await，
+//也可以通过 Then 注册回调。回复写入 CallAsync 传入的 reply
+type Future struct {
+	reply  interface{}
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu        sync.Mutex
+	finished  bool
+	err       error
+	callbacks []func(reply interface{}, err error)
+}
+
+//CallAsync 异步调用 serverMethod，立即返回 Future。ctx 和 CallOption 的作用与 Call 相同，
+//ctx 结束或者调用 Future.Cancel 时放弃这次调用
+func (client *Client) CallAsync(ctx context.Context, serverMethod string, args, reply interface{}, opts ...CallOption) *Future {
+	o := ApplyCallOptions(opts...)
+	var cancel context.CancelFunc
+	if o.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	f := &Future{reply: reply, cancel: cancel, done: make(chan struct{})}
+	call := client.goCall(newContextCall(ctx, &o, serverMethod, args, reply), make(chan *Call, 1))
+	go func() {
+		defer cancel()
+		select {
+		case <-ctx.Done():
+			if removed := client.removeCall(call.Seq); removed != nil {
+				client.countFailed(removed)
+				f.finish(fmt.Errorf("rpc client: call failed: %w", ctx.Err()))
+				return
+			}
+			//回复已经在写入 reply，等它完成
+			f.finish((<-call.Done).Error)
+		case call := <-call.Done:
+			f.finish(call.Error)
+		}
+	}()
+	return f
+}
+
+func (f *Future) finish(err error) {
+	f.mu.Lock()
+	f.finished = true
+	f.err = err
+	callbacks := f.callbacks
+	f.callbacks = nil
+	close(f.done)
+	f.mu.Unlock()
+	for _, cb := range callbacks {
+		cb(f.reply, err)
+	}
+}
+
+//Await 等待调用完成并返回调用的错误。ctx 结束时返回 ctx.Err()，只是停止等待，调用本身继续进行，
+//需要放弃调用时使用 Cancel
+func (f *Future) Await(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//Then 注册调用完成后的回调，按注册的顺序在同一个 goroutine 中执行，回调不应阻塞。
+//调用已经完成时在当前 goroutine 中立即执行
+func (f *Future) Then(cb func(reply interface{}, err error)) *Future {
+	f.mu.Lock()
+	if !f.finished {
+		f.callbacks = append(f.callbacks, cb)
+		f.mu.Unlock()
+		return f
+	}
+	err := f.err
+	f.mu.Unlock()
+	cb(f.reply, err)
+	return f
+}
+
+//Cancel 放弃调用，还在等待回复时调用以 context.Canceled 失败；已经完成时没有作用
+func (f *Future) Cancel() {
+	f.cancel()
+}
+
+//Done 调用完成时关闭
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
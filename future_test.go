@@ -0,0 +1,51 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_CallAsync(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	client, _ := NewLocalPair(server)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	results := make(chan int, 2)
+	f := client.CallAsync(context.Background(), "Sleeper.Sleep", 20, &reply).Then(func(r interface{}, err error) {
+		_assert(err == nil, "callback error: %v", err)
+		results <- *r.(*int)
+	})
+	_assert(f.Await(context.Background()) == nil && reply == 20, "expect the reply, got %d", reply)
+	_assert(<-results == 20, "expect the callback to see the reply")
+	//已经完成时立即执行
+	f.Then(func(r interface{}, err error) { results <- *r.(*int) })
+	_assert(len(results) == 1, "expect the late callback to run at once")
+	_assert(f.Await(context.Background()) == nil, "expect Await to be repeatable")
+
+	//Await 的 ctx 只限制等待
+	var slow int
+	f = client.CallAsync(context.Background(), "Sleeper.Sleep", 100, &slow)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_assert(errors.Is(f.Await(ctx), context.DeadlineExceeded), "expect Await bounded by its ctx")
+	_assert(f.Await(context.Background()) == nil && slow == 100, "expect the call to go on after Await gave up, got %d", slow)
+
+	f = client.CallAsync(context.Background(), "Sleeper.Sleep", 500, &slow)
+	start := time.Now()
+	f.Cancel()
+	err := f.Await(context.Background())
+	_assert(errors.Is(err, context.Canceled) && time.Since(start) < 200*time.Millisecond, "expect Cancel to abandon the call, got %v", err)
+	<-f.Done()
+
+	err = client.CallAsync(context.Background(), "Sleeper.Sleep", 200, &slow, WithCallTimeout(20*time.Millisecond)).Await(context.Background())
+	_assert(err != nil && strings.Contains(err.Error(), "deadline exceeded"), "expect call timeout, got %v", err)
+	err = client.CallAsync(context.Background(), "Sleeper.Missing", 0, &slow).Await(context.Background())
+	_assert(err != nil, "expect unknown method to fail")
+}
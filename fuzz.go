@@ -0,0 +1,83 @@
+package gpmd
+
+import (
+	"bytes"
+	"gpmd/codec"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+//fuzzConn 从 data 中读取对端发来的数据，丢弃写出的数据。start 关闭之前读取会阻塞，
+//保证客户端在读到响应之前已经登记了请求
+type fuzzConn struct {
+	r     io.Reader
+	start chan struct{}
+}
+
+func (c *fuzzConn) Read(p []byte) (int, error) {
+	<-c.start
+	return c.r.Read(p)
+}
+
+func (c *fuzzConn) Write(p []byte) (int, error) {
+	return ioutil.Discard.Write(p)
+}
+
+func (c *fuzzConn) Close() error {
+	return nil
+}
+
+//feedClient 将 data 作为服务端的响应流交给客户端，附带 Seq 为 1 到 3 的请求和主题 fuzz 的订阅，
+//客户端不应该 panic 或者卡住，返回结束后的请求和客户端供测试检查
+func feedClient(typ codec.Type, data []byte) ([]*Call, *Client) {
+	start := make(chan struct{})
+	conn := &fuzzConn{r: bytes.NewReader(data), start: start}
+	opt := &Option{MagicNumber: MagicNumber, CodeType: typ}
+	client := NewClientCodec(codec.NewCompressCodec(codec.NewCodecFuncMap[typ](conn), typ, 0), opt)
+	var calls []*Call
+	for i := 0; i < 3; i++ {
+		var reply string
+		calls = append(calls, client.Go("Fuzz.Call", i, &reply, make(chan *Call, 1)))
+	}
+	//直接登记订阅，覆盖推送消息的处理
+	ch := make(chan interface{}, 1)
+	client.mu.Lock()
+	client.subs["fuzz"] = &Subscription{Topic: "fuzz", C: ch, ch: ch, typ: reflect.TypeOf(""), client: client}
+	client.mu.Unlock()
+	close(start)
+	for _, call := range calls {
+		<-call.Done
+	}
+	_ = client.Close()
+	return calls, client
+}
+
+//feedServer 将 data 作为客户端发来的数据交给服务端，服务端不应该 panic 或者卡住
+func feedServer(data []byte) {
+	start := make(chan struct{})
+	close(start)
+	NewServer().ServeConn(&fuzzConn{r: bytes.NewReader(data), start: start})
+}
+
+//feedOption 解码握手的 Option
+func feedOption(data []byte) {
+	_, _, _ = readOption(bytes.NewReader(data), "")
+}
+
+//feedCodec 使用 typ 反复解码 Header 和 Body，直到出错
+func feedCodec(typ codec.Type, data []byte) {
+	start := make(chan struct{})
+	close(start)
+	cc := codec.NewCodecFuncMap[typ](&fuzzConn{r: bytes.NewReader(data), start: start})
+	for {
+		var h codec.Header
+		if cc.ReadHeader(&h) != nil {
+			return
+		}
+		var args Option
+		if cc.ReadBody(&args) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build gofuzz
+// +build gofuzz
+
+package gpmd
+
+import "gpmd/codec"
+
+//以下函数供 go-fuzz 使用，例如：go-fuzz-build -func FuzzServer && go-fuzz
+
+//FuzzClient 服务端的响应流
+func FuzzClient(data []byte) int {
+	feedClient(codec.GobType, data)
+	feedClient(codec.JsonType, data)
+	return 0
+}
+
+//FuzzServer 客户端发来的握手和请求流
+func FuzzServer(data []byte) int {
+	feedServer(data)
+	return 0
+}
+
+//FuzzOption 握手的 Option
+func FuzzOption(data []byte) int {
+	feedOption(data)
+	return 0
+}
+
+//FuzzCodec gob 和 json 的 Header 与 Body
+func FuzzCodec(data []byte) int {
+	feedCodec(codec.GobType, data)
+	feedCodec(codec.JsonType, data)
+	return 0
+}
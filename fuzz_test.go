@@ -0,0 +1,106 @@
+package gpmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"gpmd/codec"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+//clientSeed 一段包含重复、未知 Seq、推送和错误的响应流
+func clientSeed(typ codec.Type) []byte {
+	var buf bytes.Buffer
+	cc := codec.NewCodecFuncMap[typ](nopCloser{&buf})
+	_ = cc.Write(&codec.Header{Seq: 1}, "one")
+	_ = cc.Write(&codec.Header{Seq: 1}, "duplicate")
+	_ = cc.Write(&codec.Header{Seq: 99}, "unknown")
+	_ = cc.Write(&codec.Header{Seq: PushSeqBase + 1, ServiceMethod: "fuzz"}, "event")
+	_ = cc.Write(&codec.Header{Seq: 2, Error: "boom"}, struct{}{})
+	_ = cc.Write(&codec.Header{Seq: 3}, "three")
+	return buf.Bytes()
+}
+
+//serverSeed 一段握手加几个请求的请求流
+func serverSeed(typ codec.Type) []byte {
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(&Option{MagicNumber: MagicNumber, CodeType: typ})
+	cc := codec.NewCodecFuncMap[typ](nopCloser{&buf})
+	_ = cc.Write(&codec.Header{ServiceMethod: ReflectionServiceName + ".ListServices", Seq: 1}, struct{}{})
+	_ = cc.Write(&codec.Header{ServiceMethod: "Missing.Method", Seq: 2}, 1)
+	_ = cc.Write(&codec.Header{ServiceMethod: PushServiceName + ".Subscribe", Seq: 3}, "topic")
+	return buf.Bytes()
+}
+
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+//mutate 随机翻转、截断或者插入字节
+func mutate(r *rand.Rand, seed []byte) []byte {
+	data := append([]byte(nil), seed...)
+	for n := r.Intn(4) + 1; n > 0 && len(data) > 0; n-- {
+		switch r.Intn(3) {
+		case 0:
+			data[r.Intn(len(data))] ^= byte(r.Intn(255) + 1)
+		case 1:
+			data = data[:r.Intn(len(data))]
+		default:
+			i := r.Intn(len(data))
+			data = append(data[:i], append([]byte{byte(r.Intn(256))}, data[i:]...)...)
+		}
+	}
+	return data
+}
+
+//withinDeadline 确保 fn 不会卡住
+func withinDeadline(t *testing.T, data []byte, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("stuck on input %q", data)
+	}
+}
+
+func TestClient_UnexpectedResponses(t *testing.T) {
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		calls, client := feedClient(typ, clientSeed(typ))
+		one, three := *calls[0].Reply.(*string), *calls[2].Reply.(*string)
+		_assert(calls[0].Error == nil && one == "one", "%s: expect first reply kept, got %q %v", typ, one, calls[0].Error)
+		_assert(calls[1].Error != nil && calls[1].Error.Error() == "boom", "%s: expect boom, got %v", typ, calls[1].Error)
+		_assert(calls[2].Error == nil && three == "three", "%s: expect reply after skipped frames, got %q %v", typ, three, calls[2].Error)
+		_assert(client.UnexpectedResponses() == 2, "%s: expect 2 unexpected responses, got %d", typ, client.UnexpectedResponses())
+	}
+}
+
+func TestClient_SeqWraparound(t *testing.T) {
+	client := &Client{seq: PushSeqBase - 1, pending: map[uint64]*Call{1: {}}}
+	seqs := make([]uint64, 2)
+	for i := range seqs {
+		seqs[i], _ = client.registerCall(&Call{})
+	}
+	_assert(seqs[0] == PushSeqBase-1 && seqs[1] == 2, "expect seq wrap past PushSeqBase and pending 1, got %v", seqs)
+}
+
+func TestFuzz_MalformedStreams(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, server := clientSeed(typ), serverSeed(typ)
+		for i := 0; i < 200; i++ {
+			data := mutate(r, client)
+			withinDeadline(t, data, func() { feedClient(typ, data) })
+			data = mutate(r, server)
+			withinDeadline(t, data, func() { feedServer(data) })
+			withinDeadline(t, data, func() { feedOption(data) })
+			withinDeadline(t, data, func() { feedCodec(typ, data) })
+		}
+	}
+}
@@ -0,0 +1,248 @@
+//Package gateway 实现 gpmd 的转发网关：网关接受客户端连接，根据请求的服务名通过注册中心
+//（或者其他 xclient.Discovery）找到后端实例，按照负载均衡策略转发请求并复用后端连接，
+//客户端只需要连接网关，不再需要服务发现的逻辑。
+//网关不知道参数和返回值的具体类型，请求以 JSON 原始数据的形式转发，客户端和后端都需要使用 codec.JsonType。
+//请求编号、元数据、截止时间和优先级会随请求传递到后端
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gpmd"
+	"gpmd/codec"
+	"gpmd/metadata"
+	"gpmd/registry"
+	"gpmd/xclient"
+	"net"
+	"strings"
+	"sync"
+)
+
+//ServicesMetaKey 后端实例在注册中心的元数据中以逗号分隔列出提供的服务，
+//没有这一项的实例被认为提供所有服务
+const ServicesMetaKey = registry.ServicesMetaKey
+
+//Gateway 转发网关，Server 是面向客户端的服务端，可以调整它的限流、超时等设置
+type Gateway struct {
+	Server *gpmd.Server
+
+	d    xclient.Discovery
+	mode xclient.SelectMode
+	opt  *gpmd.Option
+
+	mu      sync.Mutex
+	clients map[string]*xclient.XClient //每个在发现中声明过的服务一个 XClient，加上没有声明服务的实例共用的一个，复用后端连接
+	closed  bool
+}
+
+//anyService 没有声明服务的实例共用的 XClient 在 clients 中的键
+const anyService = ""
+
+//New 创建网关，d 用来发现后端实例，opt 为连接后端的参数，编码方式总是使用 codec.JsonType
+func New(d xclient.Discovery, mode xclient.SelectMode, opt *gpmd.Option) *Gateway {
+	backend := *gpmd.DefaultOption
+	if opt != nil {
+		backend = *opt
+	}
+	backend.MagicNumber = gpmd.MagicNumber
+	backend.CodeType = codec.JsonType
+	g := &Gateway{
+		Server:  gpmd.NewServer(),
+		d:       d,
+		mode:    mode,
+		opt:     &backend,
+		clients: make(map[string]*xclient.XClient),
+	}
+	g.Server.SetUnknownServiceHandler(g.forward)
+	return g
+}
+
+//Accept 在 lis 上接受客户端连接
+func (g *Gateway) Accept(lis net.Listener) {
+	g.Server.Accept(lis)
+}
+
+//Close 关闭所有后端连接，之后转发的请求以 gpmd.ErrShutdown 失败
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	for service, xc := range g.clients {
+		_ = xc.Close()
+		delete(g.clients, service)
+	}
+	return nil
+}
+
+//client 返回转发 service 使用的 XClient。只为发现中有实例声明的服务创建 XClient，
+//客户端随意填写的服务名不会让网关无限地创建 XClient 和后端连接
+func (g *Gateway) client(service string) (*xclient.XClient, error) {
+	g.mu.Lock()
+	xc, closed := g.clients[service], g.closed
+	g.mu.Unlock()
+	if closed {
+		return nil, gpmd.ErrShutdown
+	}
+	if xc != nil {
+		return xc, nil
+	}
+	//查询发现可能很慢，不持有锁
+	key, err := g.clientKey(service)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return nil, gpmd.ErrShutdown
+	}
+	xc = g.clients[key]
+	if xc == nil {
+		xc = xclient.NewXClient(newServiceDiscovery(g.d, key), g.mode, g.opt)
+		g.clients[key] = xc
+	}
+	return xc, nil
+}
+
+//clientKey 有实例声明了 service 时返回 service，否则只有没有声明服务的实例可以处理它，返回 anyService
+func (g *Gateway) clientKey(service string) (string, error) {
+	servers, err := g.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	md, ok := g.d.(xclient.MetaDiscovery)
+	if !ok {
+		return anyService, nil
+	}
+	meta, err := md.GetMeta()
+	if err != nil {
+		return "", err
+	}
+	undeclared := false
+	for _, addr := range servers {
+		services := meta[addr][ServicesMetaKey]
+		if services == "" {
+			undeclared = true
+		} else if provides(services, service) {
+			return service, nil
+		}
+	}
+	if undeclared {
+		return anyService, nil
+	}
+	return "", errors.New("rpc gateway: no available servers for " + service)
+}
+
+//forward 将客户端的请求转发到提供该服务的后端实例
+func (g *Gateway) forward(ctx context.Context, serviceMethod string, dec func(interface{}) error) (interface{}, error) {
+	var args json.RawMessage
+	if err := dec(&args); err != nil {
+		return nil, fmt.Errorf("rpc gateway: %s is forwarded and requires the %s codec: %v", serviceMethod, codec.JsonType, err)
+	}
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, errors.New("rpc gateway: service/method request ill-formed: " + serviceMethod)
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	}
+	xc, err := g.client(serviceMethod[:dot])
+	if err != nil {
+		return nil, err
+	}
+	var reply json.RawMessage
+	if err := xc.Call(ctx, serviceMethod, args, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+//serviceDiscovery 只返回提供 service 的后端实例，service 为 anyService 时只返回没有声明服务的实例
+type serviceDiscovery struct {
+	xclient.Discovery
+	service   string
+	selectors map[xclient.SelectMode]xclient.Selector
+}
+
+func newServiceDiscovery(d xclient.Discovery, service string) *serviceDiscovery {
+	return &serviceDiscovery{
+		Discovery: d,
+		service:   service,
+		selectors: map[xclient.SelectMode]xclient.Selector{
+			xclient.RandomSelect:     xclient.NewRandomSelector(),
+			xclient.RoundRobinSelect: xclient.NewRoundRobinSelector(),
+		},
+	}
+}
+
+func (d *serviceDiscovery) GetAll() ([]string, error) {
+	servers, _, err := d.getAll()
+	return servers, err
+}
+
+//getAll 返回提供 service 的后端实例和所有实例的元数据，不支持元数据的 Discovery 返回的元数据为空
+func (d *serviceDiscovery) getAll() ([]string, map[string]map[string]string, error) {
+	servers, err := d.Discovery.GetAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	md, ok := d.Discovery.(xclient.MetaDiscovery)
+	if !ok {
+		return servers, nil, nil
+	}
+	meta, err := md.GetMeta()
+	if err != nil {
+		return nil, nil, err
+	}
+	filtered := make([]string, 0, len(servers))
+	for _, addr := range servers {
+		if d.provides(meta[addr][ServicesMetaKey]) {
+			filtered = append(filtered, addr)
+		}
+	}
+	return filtered, meta, nil
+}
+
+func (d *serviceDiscovery) Get(mode xclient.SelectMode) (string, error) {
+	servers, meta, err := d.getAll()
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", errors.New("rpc gateway: no available servers for " + d.service)
+	}
+	selector, ok := d.selectors[mode]
+	if !ok {
+		return "", errors.New("rpc discovery: not supported select mode")
+	}
+	instances := make([]xclient.Instance, len(servers))
+	for i, addr := range servers {
+		instances[i] = xclient.Instance{Addr: addr, Meta: meta[addr]}
+	}
+	if s := selector.Pick(instances, xclient.CallInfo{}); s.Addr != "" {
+		return s.Addr, nil
+	}
+	return "", errors.New("rpc gateway: no available servers for " + d.service)
+}
+
+func (d *serviceDiscovery) provides(services string) bool {
+	if d.service == anyService {
+		return services == ""
+	}
+	return provides(services, d.service)
+}
+
+//provides services 为空表示实例没有声明服务，认为提供所有服务
+func provides(services, service string) bool {
+	if services == "" {
+		return true
+	}
+	for _, s := range strings.Split(services, ",") {
+		if strings.TrimSpace(s) == service {
+			return true
+		}
+	}
+	return false
+}
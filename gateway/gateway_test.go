@@ -0,0 +1,141 @@
+package gateway
+
+import (
+	"context"
+	"gpmd"
+	"gpmd/codec"
+	"gpmd/metadata"
+	"gpmd/xclient"
+	"net"
+	"strings"
+	"testing"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Foo int
+
+func (f *Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+type Tenant int
+
+func (t *Tenant) Name(ctx context.Context, _ int, reply *string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID, _ := gpmd.RequestIDFromContext(ctx)
+	*reply = md.Value("tenant") + "/" + requestID
+	return nil
+}
+
+func startBackend(rcvr interface{}) string {
+	server := gpmd.NewServer()
+	_ = server.Register(rcvr)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func TestGateway(t *testing.T) {
+	fooAddr, tenantAddr := startBackend(new(Foo)), startBackend(new(Tenant))
+	d := xclient.NewMultiServerDiscovery([]string{fooAddr, tenantAddr})
+	d.UpdateMeta(map[string]map[string]string{
+		fooAddr:    {ServicesMetaKey: "Foo"},
+		tenantAddr: {ServicesMetaKey: "Tenant"},
+	})
+	g := New(d, xclient.RoundRobinSelect, nil)
+	defer func() { _ = g.Close() }()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go g.Accept(l)
+
+	client, err := gpmd.Dial("tcp", l.Addr().String(), &gpmd.Option{CodeType: codec.JsonType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	for i := 0; i < 3; i++ {
+		var sum int
+		if err := client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 2}, &sum); err != nil || sum != i+2 {
+			t.Fatalf("expect %d, got %d, err: %v", i+2, sum, err)
+		}
+	}
+	ctx := metadata.AppendToOutgoingContext(gpmd.ContextWithRequestID(context.Background(), "req-1"), "tenant", "acme")
+	var name string
+	if err := client.Call(ctx, "Tenant.Name", 0, &name); err != nil || name != "acme/req-1" {
+		t.Fatalf("expect metadata and request id forwarded, got %q, err: %v", name, err)
+	}
+	var sum int
+	if err := client.Call(context.Background(), "Foo.Missing", Args{}, &sum); err == nil || !strings.Contains(err.Error(), "can't find method") {
+		t.Fatalf("expect backend error forwarded, got %v", err)
+	}
+	if err := client.Call(context.Background(), "Bar.Sum", Args{}, &sum); err == nil || !strings.Contains(err.Error(), "no available servers") {
+		t.Fatalf("expect no backend for unknown service, got %v", err)
+	}
+
+	gobClient, err := gpmd.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = gobClient.Close() }()
+	if err := gobClient.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum); err == nil || !strings.Contains(err.Error(), "requires the application/json codec") {
+		t.Fatalf("expect gob client rejected, got %v", err)
+	}
+	if err := gobClient.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum); err == nil {
+		t.Fatal("expect gob connection to stay usable and keep rejecting")
+	}
+}
+
+func TestGateway_Clients(t *testing.T) {
+	fooAddr, anyAddr := startBackend(new(Foo)), startBackend(new(Tenant))
+	d := xclient.NewMultiServerDiscovery([]string{fooAddr})
+	d.UpdateMeta(map[string]map[string]string{fooAddr: {ServicesMetaKey: "Foo"}})
+	g := New(d, xclient.RoundRobinSelect, nil)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go g.Accept(l)
+	client, err := gpmd.Dial("tcp", l.Addr().String(), &gpmd.Option{CodeType: codec.JsonType})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+	count := func() int {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return len(g.clients)
+	}
+
+	var sum int
+	for _, service := range []string{"A", "B", "C"} {
+		if err := client.Call(context.Background(), service+".Sum", Args{}, &sum); err == nil || !strings.Contains(err.Error(), "no available servers") {
+			t.Fatalf("expect no backend for %s, got %v", service, err)
+		}
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("expect no XClient for services nobody declares, got %d", n)
+	}
+	if err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum); err != nil || sum != 3 {
+		t.Fatalf("expect 3, got %d, err: %v", sum, err)
+	}
+
+	//没有声明服务的实例处理所有服务，它们共用一个 XClient
+	d.Update([]string{fooAddr, anyAddr})
+	var name string
+	for _, service := range []string{"Tenant", "A", "B"} {
+		_ = client.Call(context.Background(), service+".Name", 0, &name)
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("expect Foo and the shared XClient, got %d", n)
+	}
+	if err := client.Call(context.Background(), "Tenant.Name", 0, &name); err != nil {
+		t.Fatalf("expect Tenant forwarded to the undeclared instance, got %v", err)
+	}
+
+	_ = g.Close()
+	if err := client.Call(context.Background(), "Foo.Sum", Args{}, &sum); err == nil || !strings.Contains(err.Error(), gpmd.ErrShutdown.Error()) {
+		t.Fatalf("expect ErrShutdown after Close, got %v", err)
+	}
+	if n := count(); n != 0 {
+		t.Fatalf("expect no XClient created after Close, got %d", n)
+	}
+}
@@ -0,0 +1,60 @@
+package gpmd
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"gpmd/codec"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//updateGolden 为 true 时重新生成 testdata/golden 下的握手文件：go test -run TestGolden_Handshake -update
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+//TestGolden_Handshake 比较 NewClient 写出的握手与 testdata/golden 下的文件，Option 的字段改名、
+//新的字段没有加 omitempty 或者 json:"-" 都会改变握手，旧版本的服务端可能无法识别。
+//握手之后的帧见 codec 包的 TestGolden
+func TestGolden_Handshake(t *testing.T) {
+	keys, _ := codec.NewKeyring(1, bytes.Repeat([]byte{0x42}, 32))
+	cases := []struct {
+		name string
+		opt  *Option
+	}{
+		{"default", DefaultOption},
+		{"json", &Option{MagicNumber: MagicNumber, CodeType: codec.JsonType, ConnectTimeout: 10 * time.Second}},
+		{"options", &Option{
+			MagicNumber:       MagicNumber,
+			CodeType:          codec.GobType,
+			HandleTimeout:     5 * time.Second,
+			CompressThreshold: 1024,
+			ChunkSize:         64 << 10,
+			Checksum:          true,
+		}},
+		{"encrypt", &Option{MagicNumber: MagicNumber, CodeType: codec.GobType, Encrypt: true, Keyring: keys}},
+	}
+	for _, c := range cases {
+		conn, peer := net.Pipe()
+		done := make(chan []byte)
+		go func() {
+			line, _ := bufio.NewReader(peer).ReadBytes('\n')
+			done <- line
+		}()
+		client, err := NewClient(conn, c.opt)
+		_assert(err == nil, "%s: new client error: %v", c.name, err)
+		got := <-done
+		_ = client.Close()
+		_ = peer.Close()
+
+		path := filepath.Join("testdata", "golden", "handshake_"+c.name+".golden")
+		if *updateGolden {
+			_assert(ioutil.WriteFile(path, got, 0644) == nil, "%s: write golden file", c.name)
+		}
+		want, err := ioutil.ReadFile(path)
+		_assert(err == nil, "%s: read golden file: %v", c.name, err)
+		_assert(bytes.Equal(got, want), "%s: handshake changed, run with -update if the change is intended\ngot:  %s\nwant: %s", c.name, got, want)
+	}
+}
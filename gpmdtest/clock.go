@@ -0,0 +1,113 @@
+package gpmdtest
+
+import (
+	"gpmd"
+	"sort"
+	"sync"
+	"time"
+)
+
+//FakeClock 手动推进的时钟，实现 gpmd.Clock，也可以交给 registry.Registry.Clock 和
+//xclient.GpmdRegistryDiscovery.SetClock。时间只在调用 Advance 时前进，到期的定时器按到期时间依次触发，
+//测试超时、过期等逻辑时不需要真正等待
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+var _ gpmd.Clock = (*FakeClock)(nil)
+
+//NewFakeClock 创建从 start 开始的时钟，start 为零值时从当前时间开始。
+//调用的截止时间会发送到服务端，只有客户端使用 FakeClock 时 start 应该接近当前时间
+func NewFakeClock(start time.Time) *FakeClock {
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+//AfterFunc 与 time.AfterFunc 相同，d 不大于 0 时立即在新的 goroutine 中调用 f
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) gpmd.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	if d <= 0 {
+		t.fired = true
+		go f()
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+//Advance 将时间推进 d，依次触发到期的定时器。定时器的函数在新的 goroutine 中执行，
+//Advance 返回时它们不一定已经执行完
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due, rest []*fakeTimer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			rest = append(rest, t)
+		} else {
+			t.fired = true
+			due = append(due, t)
+		}
+	}
+	c.timers = rest
+	c.mu.Unlock()
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at.Before(due[j].at) })
+	for _, t := range due {
+		go t.f()
+	}
+}
+
+//Timers 返回还没有触发和停止的定时器数量，用来等待被测代码设置好定时器之后再推进时间
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+//WaitTimers 等待至少有 n 个定时器，超过 timeout（真实时间）时返回 false
+func (c *FakeClock) WaitTimers(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.Timers() < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	f     func()
+	fired bool //已经触发或者已经停止，由 clock.mu 保护
+}
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.fired {
+		return false
+	}
+	t.fired = true
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
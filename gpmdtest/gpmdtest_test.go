@@ -0,0 +1,163 @@
+package gpmdtest
+
+import (
+	"context"
+	"errors"
+	"gpmd"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Args struct{ Num1, Num2 int }
+
+type Foo int
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func TestMockClient(t *testing.T) {
+	m := NewMockClient()
+	m.On("Foo.Sum").WithArgs(Args{1, 2}).Return(3, nil).Times(1)
+	m.On("Foo.Sum").Return(nil, errors.New("overflow"))
+
+	var reply int
+	if err := m.Call(context.Background(), "Foo.Sum", &Args{1, 2}, &reply); err != nil || reply != 3 {
+		t.Fatalf("expect 3, got %d %v", reply, err)
+	}
+	if err := m.Call(context.Background(), "Foo.Sum", &Args{1, 2}, &reply); err == nil || err.Error() != "overflow" {
+		t.Fatalf("expect fallback expectation after Times, got %v", err)
+	}
+	call := <-m.Go("Foo.Missing", 1, &reply, nil).Done
+	if _, ok := call.Error.(*UnexpectedCallError); !ok {
+		t.Fatalf("expect unexpected call error, got %v", call.Error)
+	}
+	if calls := m.Calls(); len(calls) != 3 {
+		t.Fatalf("expect 3 recorded calls, got %d", len(calls))
+	}
+	var rec recordingT
+	if m.AssertExpectations(&rec) || len(rec.errors) != 1 {
+		t.Fatalf("expect the unexpected call reported, got %v", rec.errors)
+	}
+}
+
+type recordingT struct {
+	errors []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, format)
+}
+
+func TestRecorder_Replay(t *testing.T) {
+	server := gpmd.NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, err := gpmd.NewLocalPair(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	rec := NewRecorder(client)
+	var reply int
+	if err = rec.Call(context.Background(), "Foo.Sum", Args{1, 2}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	call := <-rec.Go("Foo.Sum", Args{3, 4}, &reply, nil).Done
+	if call.Error != nil || reply != 7 {
+		t.Fatalf("expect 7, got %d %v", reply, call.Error)
+	}
+	path := filepath.Join(t.TempDir(), "calls.json")
+	if err = rec.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := LoadReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = replay.Call(context.Background(), "Foo.Sum", Args{3, 4}, &reply); err != nil || reply != 7 {
+		t.Fatalf("expect replayed 7, got %d %v", reply, err)
+	}
+	if err = replay.Call(context.Background(), "Foo.Sum", Args{5, 6}, &reply); err == nil {
+		t.Fatal("expect unrecorded args to fail")
+	}
+}
+
+//Block 一直阻塞到 release 被关闭
+type Block struct{ release chan struct{} }
+
+func (b *Block) Wait(args int, reply *int) error {
+	<-b.release
+	*reply = args
+	return nil
+}
+
+func TestFakeClock_Timeouts(t *testing.T) {
+	block := &Block{release: make(chan struct{})}
+	defer close(block.release)
+	clock := NewFakeClock(time.Time{})
+	server := gpmd.NewServer()
+	server.Clock = clock
+	server.HandleTimeout = time.Hour
+	_ = server.Register(block)
+
+	opt, _ := gpmd.NewOption(gpmd.WithClock(clock))
+	client, err := gpmd.NewLocalPair(server, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	//客户端的调用超时：推进时间之后立即返回，不需要真正等待一分钟
+	var reply int
+	call := client.Go("Block.Wait", 1, &reply, nil, gpmd.WithCallTimeout(time.Minute))
+	if !clock.WaitTimers(2, time.Second) {
+		t.Fatalf("expect client and server timers, got %d", clock.Timers())
+	}
+	clock.Advance(time.Minute)
+	select {
+	case call = <-call.Done:
+		if call.Error == nil || !strings.Contains(call.Error.Error(), context.DeadlineExceeded.Error()) {
+			t.Fatalf("expect call timeout, got %v", call.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect call timed out by the fake clock")
+	}
+
+	//服务端的处理超时
+	call = client.Go("Block.Wait", 2, &reply, nil)
+	if !clock.WaitTimers(2, time.Second) {
+		t.Fatalf("expect the new server timer, got %d", clock.Timers())
+	}
+	clock.Advance(time.Hour)
+	select {
+	case call = <-call.Done:
+		if call.Error == nil || !strings.Contains(call.Error.Error(), "handle timeout") {
+			t.Fatalf("expect handle timeout, got %v", call.Error)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect handle timeout by the fake clock")
+	}
+}
+
+func TestFakeClock_Stop(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	fired := make(chan time.Duration, 2)
+	clock.AfterFunc(time.Second, func() { fired <- time.Second })
+	stopped := clock.AfterFunc(2*time.Second, func() { fired <- 2 * time.Second })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatal("expect only the first Stop to succeed")
+	}
+	clock.Advance(time.Hour)
+	if d := <-fired; d != time.Second || clock.Timers() != 0 {
+		t.Fatalf("expect only the 1s timer, got %s with %d left", d, clock.Timers())
+	}
+	if !clock.Now().Equal(time.Date(2020, 1, 1, 1, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected now %s", clock.Now())
+	}
+}
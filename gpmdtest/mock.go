@@ -0,0 +1,191 @@
+//Package gpmdtest 提供测试 gpmd 调用方的工具：MockClient 按照 ServiceMethod 设置期望的返回值，
+//Recorder 录制真实的调用，之后通过 Replay 在测试中回放，FakeClock 替换超时和过期使用的时钟
+package gpmdtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gpmd"
+	"reflect"
+	"sync"
+)
+
+//Expectation 一个方法的期望，通过 MockClient.On 创建
+type Expectation struct {
+	serviceMethod string
+	matchArgs     bool
+	args          interface{}
+	argsJSON      []byte //回放录制的调用时，使用 json 编码比较参数
+	reply         interface{}
+	replyJSON     []byte //回放录制的调用时，reply 使用 json 解码
+	err           error
+	times         int //期望被调用的次数，0 表示不限制
+	called        int
+}
+
+//WithArgs 只匹配参数相等（reflect.DeepEqual，指针比较指向的值）的调用
+func (e *Expectation) WithArgs(args interface{}) *Expectation {
+	e.matchArgs, e.args = true, args
+	return e
+}
+
+//Return 设置返回值，reply 可以是值也可以是指针，err 不为空时不设置 reply
+func (e *Expectation) Return(reply interface{}, err error) *Expectation {
+	e.reply, e.err = reply, err
+	return e
+}
+
+//Times 期望被调用的次数，达到次数后不再匹配
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+func (e *Expectation) matches(args interface{}) bool {
+	if e.times > 0 && e.called >= e.times {
+		return false
+	}
+	if e.argsJSON != nil {
+		data, err := json.Marshal(args)
+		return err == nil && string(data) == string(e.argsJSON)
+	}
+	return !e.matchArgs || reflect.DeepEqual(indirect(e.args), indirect(args))
+}
+
+func (e *Expectation) fill(reply interface{}) error {
+	if e.err != nil || reply == nil {
+		return e.err
+	}
+	if e.replyJSON != nil {
+		return json.Unmarshal(e.replyJSON, reply)
+	}
+	if e.reply == nil {
+		return nil
+	}
+	dst := reflect.ValueOf(reply)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return fmt.Errorf("gpmdtest: reply of %s must be a non-nil pointer", e.serviceMethod)
+	}
+	src := reflect.ValueOf(e.reply)
+	if !src.Type().AssignableTo(dst.Elem().Type()) && src.Kind() == reflect.Ptr {
+		src = src.Elem()
+	}
+	if !src.Type().AssignableTo(dst.Elem().Type()) {
+		return fmt.Errorf("gpmdtest: reply of %s is %s, not assignable to %s", e.serviceMethod, src.Type(), dst.Elem().Type())
+	}
+	dst.Elem().Set(src)
+	return nil
+}
+
+func indirect(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+	return rv.Interface()
+}
+
+//Invocation 一次调用的记录
+type Invocation struct {
+	ServiceMethod string
+	Args          interface{}
+	Reply         interface{}
+	Err           error
+}
+
+//UnexpectedCallError 没有期望匹配这次调用
+type UnexpectedCallError struct {
+	ServiceMethod string
+	Args          interface{}
+}
+
+func (e *UnexpectedCallError) Error() string {
+	return fmt.Sprintf("gpmdtest: unexpected call %s(%v)", e.ServiceMethod, e.Args)
+}
+
+//MockClient 实现 gpmd.Caller，按照设置的期望返回结果，没有匹配的期望时返回错误
+type MockClient struct {
+	mu      sync.Mutex
+	expects []*Expectation
+	calls   []Invocation
+}
+
+var _ gpmd.Caller = (*MockClient)(nil)
+
+func NewMockClient() *MockClient {
+	return &MockClient{}
+}
+
+//On 为 serviceMethod 添加一个期望，多个期望按照添加的顺序匹配
+func (m *MockClient) On(serviceMethod string) *Expectation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := &Expectation{serviceMethod: serviceMethod}
+	m.expects = append(m.expects, e)
+	return e
+}
+
+//Call 按照期望返回结果，opts 会被忽略
+func (m *MockClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}, _ ...gpmd.CallOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error = &UnexpectedCallError{ServiceMethod: serviceMethod, Args: args}
+	for _, e := range m.expects {
+		if e.serviceMethod == serviceMethod && e.matches(args) {
+			e.called++
+			err = e.fill(reply)
+			break
+		}
+	}
+	m.calls = append(m.calls, Invocation{ServiceMethod: serviceMethod, Args: args, Reply: reply, Err: err})
+	return err
+}
+
+func (m *MockClient) Go(serviceMethod string, args, reply interface{}, done chan *gpmd.Call, _ ...gpmd.CallOption) *gpmd.Call {
+	if done == nil {
+		done = make(chan *gpmd.Call, 1)
+	}
+	call := &gpmd.Call{ServerMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	call.Error = m.Call(context.Background(), serviceMethod, args, reply)
+	done <- call
+	return call
+}
+
+//Calls 返回所有收到的调用，包括没有匹配期望的调用
+func (m *MockClient) Calls() []Invocation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Invocation(nil), m.calls...)
+}
+
+//TestingT testing.T 的子集
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+//AssertExpectations 检查设置了 Times 的期望是否被调用了足够的次数，以及是否存在没有匹配的调用
+func (m *MockClient) AssertExpectations(t TestingT) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ok := true
+	for _, e := range m.expects {
+		if e.times > 0 && e.called != e.times {
+			t.Errorf("gpmdtest: expect %s to be called %d times, called %d times", e.serviceMethod, e.times, e.called)
+			ok = false
+		}
+	}
+	for _, c := range m.calls {
+		if _, unexpected := c.Err.(*UnexpectedCallError); unexpected {
+			t.Errorf("%v", c.Err)
+			ok = false
+		}
+	}
+	return ok
+}
@@ -0,0 +1,124 @@
+package gpmdtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"gpmd"
+	"io/ioutil"
+	"sync"
+)
+
+//Record 一次录制的调用，参数和返回值使用 json 编码
+type Record struct {
+	ServiceMethod string          `json:"service_method"`
+	Args          json.RawMessage `json:"args"`
+	Reply         json.RawMessage `json:"reply,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+//Recorder 包装真实的 Caller，转发调用的同时录制参数和结果，之后通过 Save 保存并用 Replay 回放
+type Recorder struct {
+	caller  gpmd.Caller
+	mu      sync.Mutex
+	records []Record
+}
+
+var _ gpmd.Caller = (*Recorder)(nil)
+
+func NewRecorder(caller gpmd.Caller) *Recorder {
+	return &Recorder{caller: caller}
+}
+
+func (r *Recorder) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...gpmd.CallOption) error {
+	err := r.caller.Call(ctx, serviceMethod, args, reply, opts...)
+	r.record(serviceMethod, args, reply, err)
+	return err
+}
+
+func (r *Recorder) Go(serviceMethod string, args, reply interface{}, done chan *gpmd.Call, opts ...gpmd.CallOption) *gpmd.Call {
+	if done == nil {
+		done = make(chan *gpmd.Call, 1)
+	}
+	call := &gpmd.Call{ServerMethod: serviceMethod, Args: args, Reply: reply, Done: done}
+	inner := r.caller.Go(serviceMethod, args, reply, make(chan *gpmd.Call, 1), opts...)
+	call.Seq, call.RequestID = inner.Seq, inner.RequestID
+	go func() {
+		<-inner.Done
+		r.record(serviceMethod, args, reply, inner.Error)
+		call.Error = inner.Error
+		done <- call
+	}()
+	return call
+}
+
+func (r *Recorder) record(serviceMethod string, args, reply interface{}, err error) {
+	rec := Record{ServiceMethod: serviceMethod}
+	rec.Args, _ = json.Marshal(args)
+	if err != nil {
+		rec.Error = err.Error()
+	} else {
+		rec.Reply, _ = json.Marshal(reply)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, rec)
+}
+
+//Records 返回录制的调用
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Record(nil), r.records...)
+}
+
+//Save 将录制的调用保存为 json 文件
+func (r *Recorder) Save(path string) error {
+	data, err := json.MarshalIndent(r.Records(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+//Replay 根据录制的调用创建 MockClient，参数的 json 编码相同的调用返回录制的结果
+func Replay(records []Record) *MockClient {
+	m := NewMockClient()
+	for _, rec := range records {
+		e := m.On(rec.ServiceMethod)
+		//保存文件时 json 被重新缩进过，比较之前需要压缩成与 json.Marshal 相同的形式
+		var args bytes.Buffer
+		if err := json.Compact(&args, rec.Args); err != nil {
+			args.Write(rec.Args)
+		}
+		e.argsJSON = args.Bytes()
+		if rec.Error != "" {
+			e.err = &replayError{rec.Error}
+		} else {
+			e.replyJSON = []byte(rec.Reply)
+		}
+	}
+	return m
+}
+
+//LoadReplay 读取 Save 保存的文件并创建 MockClient
+func LoadReplay(path string) (*MockClient, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err = json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return Replay(records), nil
+}
+
+//replayError 录制时的错误，只保留了错误信息
+type replayError struct {
+	msg string
+}
+
+func (e *replayError) Error() string {
+	return e.msg
+}
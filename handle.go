@@ -0,0 +1,106 @@
+package gpmd
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"reflect"
+	"strings"
+)
+
+//Handle 以函数的形式注册单个方法，不需要定义接收者结构体：
+//
+//	server.Handle("Greeter.Hello", func(ctx context.Context, req *HelloReq) (*HelloResp, error) {...})
+//
+//handler 的签名为 func([ctx context.Context,] req T) (R, error)，T 和 R 可以是值或者指针，
+//内部会把它适配为 func(ctx, req, reply *R) error 交给已有的反射调用逻辑。
+//同一个服务的多个方法可以分别注册，但是不能和 Register 注册的服务重名
+func (s *Server) Handle(serviceMethod string, handler interface{}) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return errors.New("rpc server: service/method request ill-formed:" + serviceMethod)
+	}
+	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	if err := checkServiceName(serviceName); err != nil {
+		return err
+	}
+	if !ast.IsExported(methodName) {
+		return errors.New("rpc server: method name must be exported: " + methodName)
+	}
+	m, err := adaptHandler(reflect.ValueOf(handler))
+	if err != nil {
+		return fmt.Errorf("rpc server: %s: %v", serviceMethod, err)
+	}
+	//正在使用的 service 不能修改，每次都复制一份新的方法表再替换
+	s.handleMu.Lock()
+	defer s.handleMu.Unlock()
+	methods := map[string]*methodType{methodName: m}
+	if old, ok := s.serviceMap.Load(serviceName); ok {
+		svc := old.(*service)
+		if svc.typ != nil {
+			return errors.New("rpc: service already defined:" + serviceName)
+		}
+		if svc.method[methodName] != nil {
+			return errors.New("rpc: method already defined:" + serviceMethod)
+		}
+		for name, mType := range svc.method {
+			methods[name] = mType
+		}
+	}
+	if s.SchemaCheck != nil {
+		if err := s.SchemaCheck.check(serviceName, map[string]*methodType{methodName: m}); err != nil {
+			return err
+		}
+	}
+	s.serviceMap.Store(serviceName, &service{name: serviceName, method: methods})
+	s.methods.rebuild(&s.serviceMap)
+	logf(LogInfo, "rpc service: register %s", serviceMethod)
+	return nil
+}
+
+func Handle(serviceMethod string, handler interface{}) error {
+	return DefaultServer.Handle(serviceMethod, handler)
+}
+
+//adaptHandler 将 func([ctx,] req) (resp, error) 包装为 func(ctx, req, reply *resp) error
+func adaptHandler(fn reflect.Value) (*methodType, error) {
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return nil, fmt.Errorf("handler is %v, expect a func", fn.Kind())
+	}
+	fType := fn.Type()
+	hasCtx := fType.NumIn() == 2 && fType.In(0) == typeOfContext
+	if (fType.NumIn() != 1 && !hasCtx) || fType.NumOut() != 2 || fType.Out(1) != typeOfError {
+		return nil, fmt.Errorf("handler is %s, expect func([ctx,] req) (resp, error)", fType)
+	}
+	argType, respType := fType.In(fType.NumIn()-1), fType.Out(0)
+	//reply 总是指向一个值，handler 返回指针时 reply 指向它所指的类型
+	replyType := reflect.PtrTo(respType)
+	if respType.Kind() == reflect.Ptr {
+		replyType = respType
+	}
+	adapterType := reflect.FuncOf([]reflect.Type{typeOfContext, argType, replyType}, []reflect.Type{typeOfError}, false)
+	adapter := reflect.MakeFunc(adapterType, func(in []reflect.Value) []reflect.Value {
+		args := in[1:2]
+		if hasCtx {
+			args = in[:2]
+		}
+		out := fn.Call(args)
+		if !out[1].IsNil() {
+			return []reflect.Value{out[1]}
+		}
+		resp := out[0]
+		if respType.Kind() == reflect.Ptr {
+			if resp.IsNil() {
+				return []reflect.Value{reflect.Zero(typeOfError)}
+			}
+			resp = resp.Elem()
+		}
+		in[2].Elem().Set(resp)
+		return []reflect.Value{reflect.Zero(typeOfError)}
+	})
+	m, err := newMethodType(adapter, false)
+	if err != nil {
+		return nil, fmt.Errorf("handler %s: %v", fType, err)
+	}
+	return m, nil
+}
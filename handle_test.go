@@ -0,0 +1,48 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type HelloReq struct{ Name string }
+
+type HelloResp struct{ Greeting string }
+
+func TestServer_Handle(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	err := server.Handle("Greeter.Hello", func(ctx context.Context, req *HelloReq) (*HelloResp, error) {
+		if req.Name == "" {
+			return nil, errors.New("name is required")
+		}
+		return &HelloResp{Greeting: "hello, " + req.Name}, nil
+	})
+	_assert(err == nil, "expect handler registered: %v", err)
+	_assert(server.Handle("Greeter.Count", func(s string) (int, error) { return len(s), nil }) == nil, "expect second method registered")
+	_assert(server.Handle("Greeter.Nothing", func(HelloReq) (*HelloResp, error) { return nil, nil }) == nil, "expect nil response allowed")
+	_assert(server.Handle("Greeter.Count", func(s string) (int, error) { return 0, nil }) != nil, "expect duplicate method rejected")
+	_assert(server.Handle("Greeter.Bad", func(s string) error { return nil }) != nil, "expect bad signature rejected")
+	_assert(server.Handle("Greeter.bad", func(s string) (int, error) { return 0, nil }) != nil, "expect unexported method rejected")
+	_assert(server.Handle("Greeter", func(s string) (int, error) { return 0, nil }) != nil, "expect ill-formed name rejected")
+	var foo Foo
+	_ = server.Register(&foo)
+	_assert(server.Handle("Foo.Mul", func(a Args) (int, error) { return a.Num1 * a.Num2, nil }) != nil, "expect struct service not extended")
+	client, err := NewLocalPair(server)
+	_assert(err == nil, "local pair error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var resp HelloResp
+	err = client.Call(context.Background(), "Greeter.Hello", &HelloReq{Name: "gpmd"}, &resp)
+	_assert(err == nil && resp.Greeting == "hello, gpmd", "expect greeting, got %+v, err: %v", resp, err)
+	err = client.Call(context.Background(), "Greeter.Hello", &HelloReq{}, &resp)
+	_assert(err != nil && strings.Contains(err.Error(), "name is required"), "expect handler error, got %v", err)
+	var n int
+	err = client.Call(context.Background(), "Greeter.Count", "gpmd", &n)
+	_assert(err == nil && n == 4, "expect 4, got %d, err: %v", n, err)
+	resp = HelloResp{}
+	err = client.Call(context.Background(), "Greeter.Nothing", HelloReq{}, &resp)
+	_assert(err == nil && resp.Greeting == "", "expect empty response, got %+v, err: %v", resp, err)
+}
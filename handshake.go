@@ -0,0 +1,127 @@
+package gpmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+)
+
+//handshakeErrorMagic 服务端拒绝握手时在连接上写出的前缀，之后是一行 JSON 编码的 handshakeReply。
+//正常的响应不可能以它开头：gob 消息的长度不为 0，JSON 以 '{' 开头，逐帧校验和加密的帧长度也不为 0
+const handshakeErrorMagic = "\x00\x00\x00\x00GPMD"
+
+//handshakeLinger 拒绝握手后等待客户端读取错误并关闭连接的时间，
+//直接关闭还有未读数据的连接会发送 RST，客户端可能来不及读到错误
+const handshakeLinger = time.Second
+
+const handshakeRejectedPrefix = "rpc server: handshake rejected: "
+
+//HandshakeError 服务端不接受客户端在握手时发送的 Option，例如 MagicNumber 错误、
+//编码方式不支持或者不在允许的列表中、OnConnect 拒绝了连接，连接上所有的调用都会返回这个错误
+type HandshakeError struct {
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return handshakeRejectedPrefix + e.Reason
+}
+
+//ParseHandshakeError 还原服务端以字符串形式返回的 HandshakeError
+func ParseHandshakeError(msg string) (*HandshakeError, bool) {
+	if !strings.HasPrefix(msg, handshakeRejectedPrefix) {
+		return nil, false
+	}
+	return &HandshakeError{Reason: strings.TrimPrefix(msg, handshakeRejectedPrefix)}, true
+}
+
+//handshakeReply 拒绝握手时发送的错误帧
+type handshakeReply struct {
+	Error string `json:"error"`
+}
+
+//checkHandshake 按照 lo 检查客户端协商的 Option，不接受时返回拒绝的原因
+func (lo *ListenerOption) checkHandshake(opt *Option) *HandshakeError {
+	switch {
+	case !lo.allowCodec(opt.CodeType):
+		return &HandshakeError{Reason: "codec " + string(opt.CodeType) + " is not allowed"}
+	case !opt.Encrypt && lo.RequireEncrypt:
+		return &HandshakeError{Reason: "encryption is required"}
+	case !opt.Checksum && lo.RequireChecksum:
+		return &HandshakeError{Reason: "checksum is required"}
+	}
+	return nil
+}
+
+//rejectHandshake 在任何编码的数据之前写出错误帧，然后丢弃客户端已经发出的请求，
+//直到客户端关闭连接或者超过 handshakeLinger
+func (s *Server) rejectHandshake(conn io.ReadWriteCloser, remote net.Addr, herr *HandshakeError) {
+	logf(LogWarn, "rpc server: handshake from %v rejected: %s", remote, herr.Reason)
+	s.metrics().Inc("gpmd_server_handshake_rejections_total")
+	reply, _ := json.Marshal(handshakeReply{Error: herr.Reason})
+	if _, err := conn.Write(append(append([]byte(handshakeErrorMagic), reply...), '\n')); err != nil {
+		return
+	}
+	if nc, ok := conn.(net.Conn); ok {
+		_ = nc.SetReadDeadline(time.Now().Add(handshakeLinger))
+	} else {
+		timer := time.AfterFunc(handshakeLinger, func() { _ = conn.Close() })
+		defer timer.Stop()
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(conn, maxOptionSize))
+}
+
+//handshakeConn 客户端一侧识别服务端的错误帧，读到错误帧时 Read 返回 HandshakeError，
+//否则原样返回已经读出的数据
+type handshakeConn struct {
+	io.ReadWriteCloser
+	r   io.Reader //识别完成后从这里读取
+	err *HandshakeError
+}
+
+func (c *handshakeConn) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.r == nil {
+		if err := c.sniff(); err != nil {
+			return 0, err
+		}
+	}
+	return c.r.Read(p)
+}
+
+//sniff 逐字节比较前缀，一旦不匹配就停止，不会等待正常响应之外的数据
+func (c *handshakeConn) sniff() error {
+	var prefix []byte
+	var b [1]byte
+	for len(prefix) < len(handshakeErrorMagic) {
+		if _, err := io.ReadFull(c.ReadWriteCloser, b[:]); err != nil {
+			c.r = io.MultiReader(bytes.NewReader(prefix), c.ReadWriteCloser)
+			return err
+		}
+		prefix = append(prefix, b[0])
+		if b[0] != handshakeErrorMagic[len(prefix)-1] {
+			c.r = io.MultiReader(bytes.NewReader(prefix), c.ReadWriteCloser)
+			return nil
+		}
+	}
+	var reply handshakeReply
+	if err := json.NewDecoder(io.LimitReader(c.ReadWriteCloser, maxOptionSize)).Decode(&reply); err != nil {
+		c.err = &HandshakeError{Reason: "unreadable error from server: " + err.Error()}
+	} else {
+		c.err = &HandshakeError{Reason: reply.Error}
+	}
+	return c.err
+}
+
+//remoteAddr 返回连接的对端地址，不是 net.Conn 时返回空
+func remoteAddr(conn io.ReadWriteCloser) net.Addr {
+	if nc, ok := conn.(net.Conn); ok {
+		return nc.RemoteAddr()
+	}
+	return nil
+}
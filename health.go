@@ -0,0 +1,29 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+)
+
+//HealthServiceName 内置的健康检查服务，客户端通过 Client.Ping 确认连接和服务端仍然可用
+const HealthServiceName = "_gpmd_.Health"
+
+type healthService struct{}
+
+//Ping 原样返回 seq，客户端据此确认收到的是这一次 Ping 的响应
+func (healthService) Ping(seq int, reply *int) error {
+	*reply = seq
+	return nil
+}
+
+//Ping 调用服务端的健康检查服务，ctx 控制等待的时间
+func (client *Client) Ping(ctx context.Context) error {
+	var reply int
+	if err := client.Call(ctx, HealthServiceName+".Ping", 1, &reply); err != nil {
+		return err
+	}
+	if reply != 1 {
+		return errors.New("rpc client: unexpected ping reply")
+	}
+	return nil
+}
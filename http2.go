@@ -0,0 +1,317 @@
+package gpmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gpmd/codec"
+	"gpmd/metadata"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//HTTP2PathPrefix HTTP/2 传输中每个调用请求的路径为 HTTP2PathPrefix + "Service.Method"，
+//七层负载均衡可以按照路径把不同的服务路由到不同的后端
+const HTTP2PathPrefix = "/_gpmd_/"
+
+var errStreamClosed = errors.New("rpc server: stream closed")
+
+//HTTP2Handler 返回 HTTP/2 传输的 handler，每个调用是一个独立的 POST 请求（即一个 HTTP/2 流），
+//请求体和响应体各是一帧 Header + body，编码方式由 Content-Type 指定。
+//一个连接上的慢调用不会阻塞其他调用，推送和订阅在这个传输上不可用。
+//标准库只在 TLS 上启用 HTTP/2，可以通过 ServeHTTP2 或者自己配置了 TLS 的 http.Server 使用
+func (s *Server) HTTP2Handler() http.Handler {
+	return http2Handler{server: s}
+}
+
+//ServeHTTP2 在 lis 上以 TLS + HTTP/2 提供服务，cfg 中需要配置服务端证书
+func (s *Server) ServeHTTP2(lis net.Listener, cfg *tls.Config) error {
+	srv := &http.Server{Handler: s.HTTP2Handler(), TLSConfig: cfg}
+	return srv.ServeTLS(lis, "", "")
+}
+
+type http2Handler struct {
+	server *Server
+}
+
+func (h http2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = io.WriteString(w, "405 must POST\n")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, HTTP2PathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	typ := codec.Type(r.Header.Get("Content-Type"))
+	f := codec.NewCodecFuncMap[typ]
+	if f == nil {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", typ), http.StatusUnsupportedMediaType)
+		return
+	}
+	s := h.server
+	c := &Conn{
+		ID:          atomic.AddUint64(&s.connSeq, 1),
+		RemoteAddr:  httpAddr(r.RemoteAddr),
+		TLS:         r.TLS,
+		Opt:         Option{MagicNumber: MagicNumber, CodeType: typ},
+		ConnectedAt: time.Now(),
+	}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		c.LocalAddr = addr
+	}
+	c.Identity = newPeerIdentity(c)
+	//每个请求都相当于一次握手，与原生连接一样经过 Server.Defaults 的检查和 OnConnect
+	lo := s.listenerOption(nil)
+	lo.negotiate(&c.Opt)
+	if herr := s.checkHTTP2Handshake(lo, c); herr != nil {
+		logf(LogWarn, "rpc server: handshake from %v rejected: %s", c.RemoteAddr, herr.Reason)
+		s.metrics().Inc("gpmd_server_handshake_rejections_total")
+		http.Error(w, herr.Error(), http.StatusForbidden)
+		return
+	}
+	if s.OnDisconnect != nil {
+		defer s.OnDisconnect(c)
+	}
+	w.Header().Set("Content-Type", string(typ))
+	stream := &http2Stream{body: r.Body, w: w}
+	defer stream.finish()
+	defer c.close()
+	defer s.unsubscribeAll(c)
+	cc := codec.NewCompressCodec(f(stream), typ, 0)
+	req, err := s.readRequest(cc)
+	if err != nil {
+		if req == nil {
+			http.Error(w, "rpc server: read request error: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.sendResponse(cc, req.h, errorResponse(req.h, typ, err), &c.sending)
+		return
+	}
+	//负载均衡按照路径路由，路径和 Header 中的方法不一致时拒绝，避免绕过路由规则
+	//反射服务的 Invoke 读取时已经改写为目标方法，路径仍然是 Invoke
+	called := req.h.ServiceMethod
+	if req.rawArgs != nil {
+		called = reflectionInvoke
+	}
+	if method := strings.TrimPrefix(r.URL.Path, HTTP2PathPrefix); method != called {
+		req.h.Error = fmt.Sprintf("rpc server: path %s does not match method %s", r.URL.Path, called)
+		s.sendResponse(cc, req.h, invalidRequest, &c.sending)
+		return
+	}
+	wg := new(sync.WaitGroup)
+	s.serveRequest(cc, c, req, &c.sending, wg, c.Opt.HandleTimeout)
+	wg.Wait()
+}
+
+//checkHTTP2Handshake 按照 lo 检查请求的编码方式并调用 OnConnect。TLS 已经提供了加密和完整性校验，
+//满足 RequireEncrypt 和 RequireChecksum 的要求
+func (s *Server) checkHTTP2Handshake(lo *ListenerOption, c *Conn) *HandshakeError {
+	opt := c.Opt
+	if c.TLS != nil {
+		opt.Encrypt, opt.Checksum = true, true
+	}
+	if herr := lo.checkHandshake(&opt); herr != nil {
+		return herr
+	}
+	if s.OnConnect != nil {
+		if err := s.OnConnect(c); err != nil {
+			return &HandshakeError{Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+//http2Stream 将一个 HTTP 请求适配为 Codec 需要的 io.ReadWriteCloser，
+//handler 返回后不能再写入 ResponseWriter，处理超时后迟到的响应直接丢弃
+type http2Stream struct {
+	body io.ReadCloser
+	mu   sync.Mutex
+	w    http.ResponseWriter
+	done bool
+}
+
+func (s *http2Stream) Read(p []byte) (int, error) {
+	return s.body.Read(p)
+}
+
+func (s *http2Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		return 0, errStreamClosed
+	}
+	return s.w.Write(p)
+}
+
+func (s *http2Stream) Close() error {
+	return nil
+}
+
+func (s *http2Stream) finish() {
+	s.mu.Lock()
+	s.done = true
+	s.mu.Unlock()
+}
+
+//httpAddr 是 http.Request.RemoteAddr 的 net.Addr 表示
+type httpAddr string
+
+func (a httpAddr) Network() string { return "tcp" }
+func (a httpAddr) String() string  { return string(a) }
+
+//HTTP2Client 通过 HTTP/2 调用服务端，每个调用使用一个独立的流，底层的连接由 http.Transport 复用
+type HTTP2Client struct {
+	baseURL string
+	opt     *Option
+	hc      *http.Client
+	seq     uint64
+}
+
+var _ Caller = (*HTTP2Client)(nil)
+var _ io.Closer = (*HTTP2Client)(nil)
+
+//NewHTTP2Client 创建访问 address 的 HTTP/2 客户端，连接在第一次调用时建立。
+//opt.TLSConfig 为空时使用系统根证书校验服务端，opt.Dialer 和 opt.ConnectTimeout 用于建立连接，
+//opt.CompressThreshold 用于压缩请求，加密和逐帧校验由 TLS 提供，Option 中的对应设置会被忽略
+func NewHTTP2Client(address string, opts ...*Option) (*HTTP2Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	cfg := opt.TLSConfig
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	dial := opt.Dialer
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: opt.ConnectTimeout}).DialContext
+	}
+	transport := &http.Transport{
+		DialContext:       dial,
+		TLSClientConfig:   tlsConfigFor(cfg, address),
+		ForceAttemptHTTP2: true,
+	}
+	return &HTTP2Client{
+		baseURL: "https://" + address + HTTP2PathPrefix,
+		opt:     opt,
+		hc:      &http.Client{Transport: transport},
+	}, nil
+}
+
+//Close 关闭空闲的连接
+func (client *HTTP2Client) Close() error {
+	client.hc.CloseIdleConnections()
+	return nil
+}
+
+//Call 同步调用，ctx 中的请求编号、元数据、截止时间和优先级以及 opts 与 Client.Call 一样随 Header 发送
+func (client *HTTP2Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	o := ApplyCallOptions(opts...)
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+	requestID, ok := RequestIDFromContext(ctx)
+	if !ok {
+		requestID = newRequestID()
+	}
+	h := &codec.Header{
+		ServiceMethod: serviceMethod,
+		Seq:           atomic.AddUint64(&client.seq, 1),
+		RequestID:     requestID,
+		Priority:      int8(PriorityFromContext(ctx)),
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	h.Metadata = outgoingMetadata(client.opt.Metadata, outgoingMetadata(md, o.Metadata))
+	if deadline, ok := ctx.Deadline(); ok {
+		h.Timeout = headerTimeout(time.Until(deadline))
+	}
+	f := codec.NewCodecFuncMap[client.opt.CodeType]
+	threshold := client.opt.CompressThreshold
+	if o.CompressThreshold >= 0 {
+		threshold = o.CompressThreshold
+	}
+	var body bytes.Buffer
+	if err := codec.NewCompressCodec(f(readWriteNopCloser{&body}), client.opt.CodeType, threshold).Write(h, args); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.baseURL+serviceMethod, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", string(client.opt.CodeType))
+	resp, err := client.hc.Do(req)
+	if err != nil {
+		return errors.New("rpc client: call failed:" + err.Error())
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		if herr, ok := ParseHandshakeError(strings.TrimSpace(string(msg))); ok && resp.StatusCode == http.StatusForbidden {
+			return herr
+		}
+		return fmt.Errorf("rpc client: unexpected HTTP response: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	cc := codec.NewCompressCodec(f(readWriteNopCloser{resp.Body}), client.opt.CodeType, 0)
+	var rh codec.Header
+	if err := cc.ReadHeader(&rh); err != nil {
+		return errors.New("rpc client: read response header error: " + err.Error())
+	}
+	if rh.Error != "" {
+		callErr, _ := readStatus(cc, &rh)
+		return callErr
+	}
+	if err := cc.ReadBody(reply); err != nil {
+		return errors.New("rpc client: reading body " + err.Error())
+	}
+	return nil
+}
+
+//Go 异步调用，每个调用在独立的 goroutine 中完成
+func (client *HTTP2Client) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	if done == nil {
+		done = make(chan *Call, 1)
+	} else if cap(done) == 0 {
+		log.Panic("rpc client: done channel is unbuffered")
+	}
+	call := &Call{ServerMethod: serviceMethod, Args: args, Reply: reply, Done: done, RequestID: newRequestID()}
+	go func() {
+		call.Error = client.Call(ContextWithRequestID(context.Background(), call.RequestID), serviceMethod, args, reply, opts...)
+		call.done()
+	}()
+	return call
+}
+
+//readWriteNopCloser 把请求体或者响应体适配为 Codec 需要的 io.ReadWriteCloser，不支持的方向直接失败
+type readWriteNopCloser struct {
+	rw interface{}
+}
+
+func (c readWriteNopCloser) Read(p []byte) (int, error) {
+	if r, ok := c.rw.(io.Reader); ok {
+		return r.Read(p)
+	}
+	return 0, io.EOF
+}
+
+func (c readWriteNopCloser) Write(p []byte) (int, error) {
+	if w, ok := c.rw.(io.Writer); ok {
+		return w.Write(p)
+	}
+	return 0, io.ErrClosedPipe
+}
+
+func (c readWriteNopCloser) Close() error { return nil }
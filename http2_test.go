@@ -0,0 +1,130 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gpmd/codec"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startHTTP2Server(t *testing.T) (*HTTP2Client, chan int) {
+	server := NewServer()
+	var foo Foo
+	var s Sleeper
+	_ = server.Register(&foo)
+	_ = server.Register(&s)
+	return serveHTTP2(t, server, nil)
+}
+
+//serveHTTP2 在 TLS + HTTP/2 上提供 server 的服务，opt 为空时客户端使用默认的编码方式
+func serveHTTP2(t *testing.T, server *Server, opt *Option) (*HTTP2Client, chan int) {
+	protos := make(chan int, 16)
+	handler := server.HTTP2Handler()
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protos <- r.ProtoMajor
+		handler.ServeHTTP(w, r)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	if opt == nil {
+		opt = &Option{}
+	}
+	opt.TLSConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig
+	client, err := NewHTTP2Client(strings.TrimPrefix(ts.URL, "https://"), opt)
+	_assert(err == nil, "new http2 client error: %v", err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client, protos
+}
+
+func TestHTTP2Client_Call(t *testing.T) {
+	t.Parallel()
+	client, protos := startHTTP2Server(t)
+
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, got %d, err: %v", reply, err)
+	_assert(<-protos == 2, "expect HTTP/2 stream")
+
+	err = client.Call(context.Background(), "Foo.Missing", &Args{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect server error, got %v", err)
+	<-protos
+
+	call := <-client.Go("Foo.Sum", &Args{Num1: 2, Num2: 3}, &reply, make(chan *Call, 1)).Done
+	_assert(call.Error == nil && reply == 5, "expect 5, got %d, err: %v", reply, call.Error)
+
+	var raw []byte
+	err = client.Call(context.Background(), ReflectionServiceName+".Invoke", InvokeArgs{ServiceMethod: "Foo.Sum", Args: []byte(`{"Num1":1,"Num2":2}`)}, &raw)
+	_assert(err == nil && string(raw) == "3", "expect Invoke over HTTP/2, got %s %v", raw, err)
+}
+
+func TestHTTP2Client_NoHeadOfLineBlocking(t *testing.T) {
+	t.Parallel()
+	client, _ := startHTTP2Server(t)
+
+	var slow int
+	slowCall := client.Go("Sleeper.Sleep", 300, &slow, make(chan *Call, 1))
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	var reply int
+	_assert(client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 1}, &reply) == nil, "expect fast call to succeed")
+	_assert(time.Since(start) < 200*time.Millisecond, "expect fast call not blocked by slow call")
+	<-slowCall.Done
+	_assert(slowCall.Error == nil && slow == 300, "expect slow call to finish: %v", slowCall.Error)
+}
+
+func TestHTTP2Handler_PathMismatch(t *testing.T) {
+	t.Parallel()
+	client, _ := startHTTP2Server(t)
+	client.baseURL += "Sleeper.Sleep#"
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", &Args{}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "does not match"), "expect path mismatch rejected, got %v", err)
+}
+
+func TestHTTP2Handler_Handshake(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	var s Sleeper
+	_ = server.Register(&foo)
+	_ = server.Register(&s)
+	server.RequireEncrypt = true
+	server.Defaults.Codecs = []codec.Type{codec.GobType}
+	server.Defaults.MaxHandleTimeout = 20 * time.Millisecond
+	var connected, disconnected int32
+	server.OnConnect = func(c *Conn) error {
+		if c.Opt.HandleTimeout != 20*time.Millisecond {
+			return fmt.Errorf("unexpected handle timeout %s", c.Opt.HandleTimeout)
+		}
+		atomic.AddInt32(&connected, 1)
+		return nil
+	}
+	server.OnDisconnect = func(c *Conn) { atomic.AddInt32(&disconnected, 1) }
+
+	client, _ := serveHTTP2(t, server, nil)
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect TLS to satisfy RequireEncrypt, got %d %v", reply, err)
+	for i := 0; i < 100 && atomic.LoadInt32(&disconnected) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	_assert(atomic.LoadInt32(&connected) == 1 && atomic.LoadInt32(&disconnected) == 1, "expect OnConnect and OnDisconnect called once")
+	err = client.Call(context.Background(), "Sleeper.Sleep", 200, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect MaxHandleTimeout applied, got %v", err)
+
+	jsonClient, _ := serveHTTP2(t, server, &Option{CodeType: codec.JsonType})
+	err = jsonClient.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	var herr *HandshakeError
+	_assert(errors.As(err, &herr) && strings.Contains(herr.Reason, "not allowed"), "expect codec allow-list applied, got %v", err)
+
+	server.OnConnect = func(c *Conn) error { return errors.New("quota exceeded") }
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.As(err, &herr) && herr.Reason == "quota exceeded", "expect OnConnect rejection, got %v", err)
+}
@@ -0,0 +1,50 @@
+package gpmd
+
+import (
+	"context"
+	"crypto/x509"
+)
+
+//PeerIdentity 双向 TLS 中经过校验的客户端证书身份，用于服务之间的零信任鉴权
+type PeerIdentity struct {
+	CommonName     string            //证书主题的 CN
+	DNSNames       []string          //SAN 中的 DNS 名称
+	URIs           []string          //SAN 中的 URI，例如 SPIFFE ID
+	EmailAddresses []string          //SAN 中的邮箱
+	IPAddresses    []string          //SAN 中的 IP
+	Certificate    *x509.Certificate //客户端的叶子证书
+}
+
+//Authorizer 在调用 handler 之前鉴权，返回错误时请求被拒绝，错误信息返回给客户端。
+//ctx 与 handler 的 ctx 相同，可以通过 PeerIdentityFromContext、ConnFromContext 以及 Server.Middlewares 放入的值获取调用方的信息
+type Authorizer func(ctx context.Context, serviceMethod string) error
+
+//newPeerIdentity 只有经过校验的证书才被视为身份，没有校验的证书（比如 RequestClientCert）返回空
+func newPeerIdentity(c *Conn) *PeerIdentity {
+	if c.TLS == nil || len(c.TLS.VerifiedChains) == 0 || len(c.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := c.TLS.PeerCertificates[0]
+	id := &PeerIdentity{
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Certificate:    cert,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		id.IPAddresses = append(id.IPAddresses, ip.String())
+	}
+	return id
+}
+
+//PeerIdentityFromContext 在 handler 或者 Authorizer 中获取客户端证书的身份，非双向 TLS 连接返回 false
+func PeerIdentityFromContext(ctx context.Context) (*PeerIdentity, bool) {
+	c, ok := ConnFromContext(ctx)
+	if !ok || c.Identity == nil {
+		return nil, false
+	}
+	return c.Identity, true
+}
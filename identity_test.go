@@ -0,0 +1,99 @@
+package gpmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+//issueCert 签发证书，parent 为空时生成自签名的 CA
+func issueCert(t *testing.T, cn string, parent *tls.Certificate, isCA bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{cn},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	parentCert, parentKey := tmpl, interface{}(key)
+	if parent != nil {
+		parentCert, parentKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+type Whoami int
+
+func (w Whoami) Name(ctx context.Context, _ int, reply *string) error {
+	id, ok := PeerIdentityFromContext(ctx)
+	if !ok {
+		return errors.New("no identity")
+	}
+	*reply = id.CommonName
+	return nil
+}
+
+func TestServer_MutualTLS(t *testing.T) {
+	t.Parallel()
+	ca := issueCert(t, "test-ca", nil, true)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	serverCert := issueCert(t, "127.0.0.1", &ca, false)
+
+	server := NewServer()
+	var whoami Whoami
+	_ = server.Register(&whoami)
+	server.Authorizer = func(ctx context.Context, serviceMethod string) error {
+		if id, ok := PeerIdentityFromContext(ctx); ok && id.CommonName == "svc-a" {
+			return nil
+		}
+		return errors.New("permission denied")
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}))
+
+	call := func(cn string) (string, error) {
+		client, err := Dial("tcp", l.Addr().String(), &Option{
+			ConnectTimeout: time.Second,
+			TLSConfig:      &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{issueCert(t, cn, &ca, false)}},
+		})
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = client.Close() }()
+		var reply string
+		err = client.Call(context.Background(), "Whoami.Name", 0, &reply)
+		return reply, err
+	}
+	name, err := call("svc-a")
+	_assert(err == nil && name == "svc-a", "expect identity svc-a, got %q %v", name, err)
+	_, err = call("svc-b")
+	_assert(err != nil && err.Error() == "permission denied", "expect svc-b denied, got %v", err)
+}
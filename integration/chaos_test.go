@@ -0,0 +1,148 @@
+package integration
+
+import (
+	"context"
+	"gpmd"
+	"gpmd/xclient"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//callName 通过 xc 调用一次 Node.Name，返回处理请求的实例
+func callName(xc *xclient.XClient, opts ...gpmd.CallOption) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var name string
+	err := xc.Call(ctx, "Node.Name", 0, &name, opts...)
+	return name, err
+}
+
+func TestChaos_KillServer(t *testing.T) {
+	c := newCluster(t, 6*heartbeatInterval, 3)
+	xc, d := c.newXClient()
+	for i := 0; i < 20; i++ {
+		if _, err := callName(xc); err != nil {
+			t.Fatal("warm up:", err)
+		}
+	}
+
+	//实例崩溃之后注册中心还没有让它过期，连接失败的调用换实例重试，全部成功。
+	//缓存的连接可能还没有发现对端已经关闭，请求写出之后才断开，Node.Name 是幂等的，允许重新发送
+	victim := c.nodes[0]
+	c.kill(victim)
+	before := atomic.LoadInt64(&victim.service.calls)
+	for i := 0; i < 30; i++ {
+		name, err := callName(xc, gpmd.WithIdempotent())
+		if err != nil {
+			t.Fatal("call after kill should fail over:", err)
+		}
+		if name == victim.service.name {
+			t.Fatal("killed node should not serve calls")
+		}
+	}
+	if calls := atomic.LoadInt64(&victim.service.calls); calls != before {
+		t.Fatalf("killed node served %d calls", calls-before)
+	}
+
+	//心跳停止之后实例过期，客户端随后也不再发现它
+	eventually(t, 20*heartbeatInterval, func() bool { return len(c.registered()) == 2 }, "killed node should expire from the registry")
+	if events := c.registry.Events(victim.addr, time.Time{}); len(events) == 0 || events[len(events)-1].Type != "expire" {
+		t.Fatal("expect an expire event for the killed node, got", events)
+	}
+	eventually(t, 20*heartbeatInterval, func() bool {
+		servers, err := d.GetAll()
+		return err == nil && len(servers) == 2
+	}, "discovery should drop the expired node")
+}
+
+func TestChaos_RegistryPartition(t *testing.T) {
+	ttl := 6 * heartbeatInterval
+	c := newCluster(t, ttl, 2)
+	xc, d := c.newXClient()
+	if _, err := callName(xc); err != nil {
+		t.Fatal(err)
+	}
+
+	//注册中心不可达期间客户端继续使用缓存的服务列表，调用不受影响
+	c.partition(true)
+	for deadline := time.Now().Add(2 * ttl); time.Now().Before(deadline); time.Sleep(heartbeatInterval / 2) {
+		if _, err := callName(xc); err != nil {
+			t.Fatal("call during the registry partition should use the cached list:", err)
+		}
+	}
+	if err := d.Refresh(); err == nil {
+		t.Fatal("refresh during the partition should fail")
+	}
+	//心跳也到达不了注册中心，分区超过过期时间后所有实例都被当作过期
+	if servers := c.registered(); len(servers) != 0 {
+		t.Fatal("heartbeats should not reach the partitioned registry, got", servers)
+	}
+
+	//恢复之后心跳重新注册，客户端重新同步
+	c.partition(false)
+	eventually(t, 20*heartbeatInterval, func() bool { return len(c.registered()) == 2 }, "nodes should register again after the partition heals")
+	eventually(t, 20*heartbeatInterval, func() bool { return d.Refresh() == nil }, "discovery should refresh after the partition heals")
+	if _, err := callName(xc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChaos_DelayedResponses(t *testing.T) {
+	for _, mode := range []string{"idempotent", "default", "no retry"} {
+		c := newCluster(t, time.Minute, 2)
+		xc, _ := c.newXClient()
+		if _, err := callName(xc); err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range c.nodes {
+			n.proxy.setDelay(4 * heartbeatInterval)
+		}
+		var opts []gpmd.CallOption
+		switch mode {
+		case "idempotent":
+			opts = append(opts, gpmd.WithIdempotent())
+		case "no retry":
+			opts = append(opts, gpmd.WithIdempotent(), gpmd.WithNoRetry())
+		}
+		//回复在路上时杀掉一个实例：请求可能已经被处理，只有幂等并且允许重试的调用换实例重新发送
+		const calls = 16
+		victim, other := c.nodes[0].service, c.nodes[1].service
+		before := atomic.LoadInt64(&victim.calls) + atomic.LoadInt64(&other.calls)
+		victimBefore := atomic.LoadInt64(&victim.calls)
+		var wg sync.WaitGroup
+		var failed, servedByVictim int32
+		for i := 0; i < calls; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				name, err := callName(xc, opts...)
+				if err != nil {
+					atomic.AddInt32(&failed, 1)
+				} else if name == victim.name {
+					atomic.AddInt32(&servedByVictim, 1)
+				}
+			}()
+		}
+		eventually(t, 20*heartbeatInterval, func() bool {
+			return atomic.LoadInt64(&victim.calls)+atomic.LoadInt64(&other.calls)-before >= calls
+		}, "all calls should reach the servers")
+		inflight := atomic.LoadInt64(&victim.calls) - victimBefore
+		c.kill(c.nodes[0])
+		wg.Wait()
+		if servedByVictim != 0 {
+			t.Fatalf("no reply of the killed node should arrive, got %d", servedByVictim)
+		}
+		if inflight == 0 {
+			t.Log("random selection sent no call to the killed node")
+			continue
+		}
+		if mode == "idempotent" && failed != 0 {
+			t.Fatalf("broken idempotent calls should be retried on the other node, %d failed", failed)
+		}
+		if mode != "idempotent" && failed == 0 {
+			t.Fatalf("broken calls should fail in %s mode", mode)
+		}
+	}
+}
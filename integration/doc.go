@@ -0,0 +1,4 @@
+//Package integration 端到端的集成测试：在一个进程中启动注册中心、多个服务实例和 XClient，
+//通过注入故障（杀掉实例、注册中心不可达、回复变慢）验证换实例、心跳过期和重试的行为。
+//包中只有测试，通过 go test ./integration 运行
+package integration
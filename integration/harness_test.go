@@ -0,0 +1,244 @@
+package integration
+
+import (
+	"gpmd"
+	"gpmd/registry"
+	"gpmd/xclient"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//Node 每个实例注册的服务，返回实例的名字，方便判断请求由哪个实例处理
+type Node struct {
+	name  string
+	calls int64
+}
+
+func (n *Node) Name(_ int, reply *string) error {
+	atomic.AddInt64(&n.calls, 1)
+	*reply = n.name
+	return nil
+}
+
+//cluster 一个通过 HTTP 访问的注册中心和若干服务实例，客户端和心跳都经过可以注入故障的网络：
+//实例前面是 chaosProxy，注册中心前面的 handler 可以断开所有请求，被杀掉的实例的心跳不再到达注册中心
+type cluster struct {
+	t           *testing.T
+	registry    *registry.Registry
+	url         string //注册中心地址
+	partitioned int32  //为 1 时注册中心不可达
+	mu          sync.Mutex
+	dead        map[string]bool //被杀掉的实例地址
+	nodes       []*node
+}
+
+//node 一个服务实例
+type node struct {
+	service *Node
+	addr    string //客户端和注册中心看到的地址，指向 proxy
+	proxy   *chaosProxy
+	hb      *registry.HeartbeatHandle
+}
+
+//heartbeatInterval 心跳间隔，实例过期时间为它的几倍，测试中的等待都以它为单位
+const heartbeatInterval = 50 * time.Millisecond
+
+//newCluster 启动过期时间为 ttl 的注册中心和 n 个实例，测试结束时全部关闭
+func newCluster(t *testing.T, ttl time.Duration, n int) *cluster {
+	c := &cluster{t: t, registry: registry.New(ttl), dead: make(map[string]bool)}
+	front := httptest.NewServer(http.HandlerFunc(c.serveRegistry))
+	t.Cleanup(front.Close)
+	c.url = front.URL + "/_gpmd_/registry"
+	for i := 0; i < n; i++ {
+		c.addNode()
+	}
+	return c
+}
+
+//serveRegistry 注册中心前面的网络：分区时直接断开连接，被杀掉的实例的心跳丢弃
+func (c *cluster) serveRegistry(w http.ResponseWriter, req *http.Request) {
+	addr := req.Header.Get("X-GPMD-SERVERS")
+	c.mu.Lock()
+	dead := req.Method != "GET" && c.dead[addr]
+	c.mu.Unlock()
+	if atomic.LoadInt32(&c.partitioned) == 1 || dead {
+		if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+			_ = conn.Close()
+		}
+		return
+	}
+	c.registry.ServeHTTP(w, req)
+}
+
+//addNode 启动一个实例并开始发送心跳
+func (c *cluster) addNode() *node {
+	c.mu.Lock()
+	name := "node" + string(rune('0'+len(c.nodes)))
+	c.mu.Unlock()
+	n := &node{service: &Node{name: name}}
+	server := gpmd.NewServer()
+	if err := server.Register(n.service); err != nil {
+		c.t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	n.proxy = newChaosProxy(c.t, l.Addr().String())
+	n.addr = "tcp@" + n.proxy.addr()
+	n.hb, err = registry.StartHeartbeat(c.url, n.addr, registry.HeartbeatOption{Interval: heartbeatInterval, Jitter: -1})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.t.Cleanup(func() { _ = n.hb.Stop() })
+	c.mu.Lock()
+	c.nodes = append(c.nodes, n)
+	c.mu.Unlock()
+	return n
+}
+
+//kill 模拟实例崩溃：断开所有连接并拒绝新的连接，心跳不再到达注册中心，实例没有机会注销
+func (c *cluster) kill(n *node) {
+	c.mu.Lock()
+	c.dead[n.addr] = true
+	c.mu.Unlock()
+	n.proxy.kill()
+}
+
+//partition 让注册中心不可达或者恢复
+func (c *cluster) partition(down bool) {
+	v := int32(0)
+	if down {
+		v = 1
+	}
+	atomic.StoreInt32(&c.partitioned, v)
+}
+
+//registered 绕过注册中心前面的网络，返回注册中心中没有过期的实例
+func (c *cluster) registered() []string {
+	resp, err := c.registry.Client().Get("http://local/_gpmd_/registry")
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if servers := resp.Header.Get("X-GPMD-SERVERS"); servers != "" {
+		return strings.Split(servers, ",")
+	}
+	return nil
+}
+
+//newXClient 通过注册中心发现实例的客户端，服务列表每两个心跳间隔过期一次
+func (c *cluster) newXClient() (*xclient.XClient, *xclient.GpmdRegistryDiscovery) {
+	d := xclient.NewGpmdRegistryDiscovery(c.url, 2*heartbeatInterval)
+	xc := xclient.NewXClient(d, xclient.RandomSelect, nil)
+	c.t.Cleanup(func() { _ = xc.Close() })
+	return xc, d
+}
+
+//eventually 在 timeout 之内反复检查 cond，一直不满足时测试失败
+func eventually(t *testing.T, timeout time.Duration, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(heartbeatInterval / 5)
+	}
+}
+
+//chaosProxy 转发到实例的 TCP 代理，可以让实例回复变慢或者模拟实例崩溃
+type chaosProxy struct {
+	lis     net.Listener
+	backend string
+	delay   int64 //实例发出的数据延迟转发的时间，单位纳秒
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	killed  bool
+}
+
+func newChaosProxy(t *testing.T, backend string) *chaosProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &chaosProxy{lis: l, backend: backend, conns: make(map[net.Conn]struct{})}
+	t.Cleanup(p.kill)
+	go p.serve()
+	return p
+}
+
+func (p *chaosProxy) addr() string { return p.lis.Addr().String() }
+
+//setDelay 之后实例发出的每一段数据都晚 d 到达客户端
+func (p *chaosProxy) setDelay(d time.Duration) {
+	atomic.StoreInt64(&p.delay, int64(d))
+}
+
+//kill 关闭监听和所有连接，之后的连接请求被拒绝
+func (p *chaosProxy) kill() {
+	_ = p.lis.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.killed = true
+	for conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = make(map[net.Conn]struct{})
+}
+
+func (p *chaosProxy) serve() {
+	for {
+		conn, err := p.lis.Accept()
+		if err != nil {
+			return
+		}
+		backend, err := net.Dial("tcp", p.backend)
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+		p.mu.Lock()
+		if p.killed {
+			p.mu.Unlock()
+			_ = conn.Close()
+			_ = backend.Close()
+			return
+		}
+		p.conns[conn], p.conns[backend] = struct{}{}, struct{}{}
+		p.mu.Unlock()
+		go p.pipe(backend, conn, false)
+		go p.pipe(conn, backend, true)
+	}
+}
+
+//pipe 从 src 复制到 dst，delayed 为 true 时每一段数据按照 delay 延迟，任意一端出错时关闭两端
+func (p *chaosProxy) pipe(dst, src net.Conn, delayed bool) {
+	defer func() {
+		_ = dst.Close()
+		_ = src.Close()
+	}()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if d := time.Duration(atomic.LoadInt64(&p.delay)); delayed && d > 0 {
+				time.Sleep(d)
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,226 @@
+#!/usr/bin/env python3
+"""gpmd 的 Python 参考客户端，只依赖标准库，协议见 wire 包的文档。
+
+    python3 gpmd_client.py call 127.0.0.1:9999 Arith.Multiply '{"A": 3, "B": 4}'
+    python3 gpmd_client.py vectors ../../wire/testdata/vectors.json
+
+call 打印 JSON 编码的返回值，调用失败时把错误写到 stderr 并以 1 退出；
+vectors 逐个检查一致性测试用例，全部通过时打印 "ok N"。
+"""
+
+import base64
+import binascii
+import gzip
+import json
+import socket
+import struct
+import sys
+
+MAGIC_NUMBER = 0x1234567
+CODEC_JSON = "application/json"
+HANDSHAKE_ERROR_MAGIC = b"\x00\x00\x00\x00GPMD"
+PUSH_SEQ_BASE = 1 << 63
+MAX_CHECKSUM_FRAME = 1 << 20
+
+HEADER_FIELDS = ("ServiceMethod", "Seq", "Error", "RequestID", "Compressed", "Metadata", "Deadline", "Priority")
+HANDSHAKE_FIELDS = ("MagicNumber", "CodeType", "ConnectTimeout", "HandleTimeout", "CompressThreshold", "Encrypt", "Checksum")
+HEADER_DEFAULTS = {"ServiceMethod": "", "Seq": 0, "Error": "", "RequestID": "", "Compressed": False,
+                   "Metadata": None, "Deadline": 0, "Priority": 0}
+HANDSHAKE_DEFAULTS = {"MagicNumber": MAGIC_NUMBER, "CodeType": CODEC_JSON, "ConnectTimeout": 0, "HandleTimeout": 0,
+                      "CompressThreshold": 0, "Encrypt": False, "Checksum": False}
+
+
+class HandshakeError(Exception):
+    pass
+
+
+class RpcError(Exception):
+    """调用失败，服务端返回了带有详情的错误（Header.Status）时 code 和 details 不为空。"""
+
+    def __init__(self, message, code=None, details=None):
+        super().__init__(message)
+        self.code = code
+        self.details = details or []
+
+
+def dumps(value):
+    """与 Go 的 encoding/json.Encoder 相同的紧凑格式，以换行结尾。"""
+    return (json.dumps(value, separators=(",", ":")) + "\n").encode()
+
+
+def encode_handshake(handshake):
+    ordered = {k: handshake.get(k, HANDSHAKE_DEFAULTS[k]) for k in HANDSHAKE_FIELDS}
+    return dumps(ordered)
+
+
+def encode_frame(header, body):
+    ordered = {k: header.get(k, HEADER_DEFAULTS[k]) for k in HEADER_FIELDS}
+    if header.get("Status"):
+        ordered["Status"] = True  # 为 false 时不写出
+    return dumps(ordered) + dumps(body)
+
+
+def encode_checksum_frame(data):
+    return struct.pack(">II", len(data), crc32c(data)) + data
+
+
+def crc32c(data):
+    crc = 0xFFFFFFFF
+    for b in data:
+        crc ^= b
+        for _ in range(8):
+            crc = (crc >> 1) ^ (0x82F63B78 if crc & 1 else 0)
+    return crc ^ 0xFFFFFFFF
+
+
+def payload(header, body):
+    """压缩的 body 是 base64 编码的 gzip 数据。"""
+    if not header.get("Compressed"):
+        return body
+    return json.loads(gzip.decompress(base64.b64decode(body)))
+
+
+class Decoder:
+    """从字节流中逐个读取 JSON 值，接受任意空白。"""
+
+    def __init__(self, read):
+        self.read = read
+        self.buf = b""
+        self.checked = False
+        self.json = json.JSONDecoder()
+
+    def _fill(self):
+        chunk = self.read()
+        if not chunk:
+            raise EOFError("connection closed")
+        self.buf += chunk
+
+    def value(self):
+        while True:
+            text = self.buf.lstrip()
+            if text:
+                try:
+                    decoded = text.decode()
+                    value, end = self.json.raw_decode(decoded)
+                    # 数字可能还没有读完整，需要看到之后的分隔符
+                    if end < len(decoded):
+                        self.buf = decoded[end:].encode()
+                        return value
+                except ValueError:
+                    pass  # 数据还不完整，包括被截断的 UTF-8 字符
+            self._fill()
+
+    def frame(self):
+        if not self.checked:
+            self.checked = True
+            while len(self.buf) < len(HANDSHAKE_ERROR_MAGIC) and HANDSHAKE_ERROR_MAGIC.startswith(self.buf):
+                self._fill()
+            if self.buf.startswith(HANDSHAKE_ERROR_MAGIC):
+                self.buf = self.buf[len(HANDSHAKE_ERROR_MAGIC):]
+                raise HandshakeError(self.value()["error"])
+        header = self.value()
+        body = self.value()
+        return header, body
+
+
+class Client:
+    def __init__(self, address, timeout=5.0, **handshake):
+        host, port = address.rsplit(":", 1)
+        self.sock = socket.create_connection((host, int(port)), timeout=timeout)
+        self.sock.sendall(encode_handshake(handshake))
+        self.decoder = Decoder(lambda: self.sock.recv(65536))
+        self.seq = 0
+
+    def call(self, service_method, args, metadata=None):
+        self.seq += 1
+        header = {"ServiceMethod": service_method, "Seq": self.seq, "Metadata": metadata}
+        self.sock.sendall(encode_frame(header, args))
+        while True:
+            header, body = self.decoder.frame()
+            if header["Seq"] >= PUSH_SEQ_BASE:
+                continue  # 没有订阅的推送消息直接丢弃
+            if header["Seq"] != self.seq:
+                continue  # 之前超时放弃的响应
+            if header.get("Error"):
+                if header.get("Status"):
+                    raise RpcError(header["Error"], body.get("Code"), body.get("Details"))
+                raise RpcError(header["Error"])
+            return payload(header, body)
+
+    def close(self):
+        self.sock.close()
+
+
+def check_vectors(path):
+    with open(path) as f:
+        vectors = json.load(f)
+    for v in vectors:
+        wire = binascii.unhexlify(v["wireHex"]) if "wireHex" in v else v["wire"].encode("latin-1")
+        decoder = Decoder(_reader(wire))
+        kind = v["kind"]
+        if kind == "handshake":
+            got = decoder.value()
+            got = {k: got.get(k, HANDSHAKE_DEFAULTS[k]) for k in HANDSHAKE_FIELDS}
+            want = dict(HANDSHAKE_DEFAULTS, **v["handshake"])
+            _expect(v, got == want, got)
+            _expect(v, v.get("decodeOnly") or encode_handshake(want) == wire, encode_handshake(want))
+        elif kind == "handshakeError":
+            try:
+                decoder.frame()
+                _expect(v, False, "no error")
+            except HandshakeError as e:
+                _expect(v, str(e) == v["handshakeError"], str(e))
+        elif kind == "frame":
+            header, body = decoder.frame()
+            want = dict(HEADER_DEFAULTS, **v["header"])
+            got = {k: header.get(k, HEADER_DEFAULTS[k]) for k in HEADER_FIELDS}
+            if header.get("Status"):
+                got["Status"] = True
+            _expect(v, got == want, header)
+            _expect(v, body == v["body"], body)
+            _expect(v, payload(header, body) == v.get("payload", v["body"]), payload(header, body))
+            _expect(v, v.get("decodeOnly") or encode_frame(want, v["body"]) == wire, encode_frame(want, v["body"]))
+        elif kind == "checksum":
+            size, crc = struct.unpack(">II", wire[:8])
+            data = wire[8:]
+            _expect(v, size == len(data) <= MAX_CHECKSUM_FRAME and crc == crc32c(data), (size, crc))
+            _expect(v, data == v["data"].encode(), data)
+            _expect(v, encode_checksum_frame(data) == wire, encode_checksum_frame(data))
+        else:
+            _expect(v, False, "unknown kind " + kind)
+    print("ok", len(vectors))
+
+
+def _reader(data):
+    chunks = [data]
+
+    def read():
+        return chunks.pop() if chunks else b""
+    return read
+
+
+def _expect(vector, ok, got):
+    if not ok:
+        raise AssertionError("vector %s: unexpected %r" % (vector["name"], got))
+
+
+def main(argv):
+    if len(argv) == 3 and argv[1] == "vectors":
+        check_vectors(argv[2])
+        return 0
+    if len(argv) == 5 and argv[1] == "call":
+        client = Client(argv[2])
+        try:
+            print(json.dumps(client.call(argv[3], json.loads(argv[4])), separators=(",", ":")))
+        except (RpcError, HandshakeError) as e:
+            print(e, file=sys.stderr)
+            return 1
+        finally:
+            client.close()
+        return 0
+    print(__doc__, file=sys.stderr)
+    return 2
+
+
+if __name__ == "__main__":
+    sys.exit(main(sys.argv))
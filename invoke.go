@@ -0,0 +1,105 @@
+package gpmd
+
+import (
+	"context"
+	"reflect"
+)
+
+//invoker 调用一个方法，argv 的类型为 ArgType，replyv 的类型为 ReplyType
+type invoker func(ctx context.Context, argv, replyv reflect.Value) error
+
+//compileInvoker 在注册时为 fn 生成调用函数。参数和回复是内置类型的常见签名直接断言出具体的函数类型调用，
+//省去 reflect.Call 每次调用时构造参数和返回值切片的开销；其他签名（包括自定义的结构体、变长参数和多个参数）
+//使用 reflect.Call
+func compileInvoker(fn reflect.Value, hasCtx bool, multiArgs int) invoker {
+	if fn.CanInterface() {
+		if invoke := typedInvoker(fn.Interface()); invoke != nil {
+			return invoke
+		}
+	}
+	return reflectInvoker(fn, hasCtx, multiArgs)
+}
+
+func typedInvoker(fn interface{}) invoker {
+	switch f := fn.(type) {
+	case func(int, *int) error:
+		return func(_ context.Context, argv, replyv reflect.Value) error {
+			return f(int(argv.Int()), replyv.Interface().(*int))
+		}
+	case func(context.Context, int, *int) error:
+		return func(ctx context.Context, argv, replyv reflect.Value) error {
+			return f(ctx, int(argv.Int()), replyv.Interface().(*int))
+		}
+	case func(int64, *int64) error:
+		return func(_ context.Context, argv, replyv reflect.Value) error {
+			return f(argv.Int(), replyv.Interface().(*int64))
+		}
+	case func(context.Context, int64, *int64) error:
+		return func(ctx context.Context, argv, replyv reflect.Value) error {
+			return f(ctx, argv.Int(), replyv.Interface().(*int64))
+		}
+	case func(float64, *float64) error:
+		return func(_ context.Context, argv, replyv reflect.Value) error {
+			return f(argv.Float(), replyv.Interface().(*float64))
+		}
+	case func(context.Context, float64, *float64) error:
+		return func(ctx context.Context, argv, replyv reflect.Value) error {
+			return f(ctx, argv.Float(), replyv.Interface().(*float64))
+		}
+	case func(bool, *bool) error:
+		return func(_ context.Context, argv, replyv reflect.Value) error {
+			return f(argv.Bool(), replyv.Interface().(*bool))
+		}
+	case func(context.Context, bool, *bool) error:
+		return func(ctx context.Context, argv, replyv reflect.Value) error {
+			return f(ctx, argv.Bool(), replyv.Interface().(*bool))
+		}
+	case func(string, *string) error:
+		return func(_ context.Context, argv, replyv reflect.Value) error {
+			return f(argv.String(), replyv.Interface().(*string))
+		}
+	case func(context.Context, string, *string) error:
+		return func(ctx context.Context, argv, replyv reflect.Value) error {
+			return f(ctx, argv.String(), replyv.Interface().(*string))
+		}
+	case func(string, *int) error:
+		return func(_ context.Context, argv, replyv reflect.Value) error {
+			return f(argv.String(), replyv.Interface().(*int))
+		}
+	case func(context.Context, string, *int) error:
+		return func(ctx context.Context, argv, replyv reflect.Value) error {
+			return f(ctx, argv.String(), replyv.Interface().(*int))
+		}
+	case func([]byte, *[]byte) error:
+		return func(_ context.Context, argv, replyv reflect.Value) error {
+			return f(argv.Bytes(), replyv.Interface().(*[]byte))
+		}
+	case func(context.Context, []byte, *[]byte) error:
+		return func(ctx context.Context, argv, replyv reflect.Value) error {
+			return f(ctx, argv.Bytes(), replyv.Interface().(*[]byte))
+		}
+	}
+	return nil
+}
+
+//reflectInvoker 通过 reflect.Call 调用，多个参数时按顺序展开 MultiArgs 结构体的字段
+func reflectInvoker(fn reflect.Value, hasCtx bool, multiArgs int) invoker {
+	return func(ctx context.Context, argv, replyv reflect.Value) error {
+		in := make([]reflect.Value, 0, multiArgs+3)
+		if hasCtx {
+			in = append(in, reflect.ValueOf(ctx))
+		}
+		if multiArgs > 0 {
+			for i := 0; i < multiArgs; i++ {
+				in = append(in, argv.Field(i))
+			}
+		} else {
+			in = append(in, argv)
+		}
+		in = append(in, replyv)
+		if errInter := fn.Call(in)[0].Interface(); errInter != nil {
+			return errInter.(error)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompileInvoker(t *testing.T) {
+	errOdd := errors.New("odd")
+	funcs := map[string]interface{}{
+		"Half": func(ctx context.Context, n int, reply *int) error {
+			if n%2 != 0 {
+				return errOdd
+			}
+			*reply = n / 2
+			return nil
+		},
+		"Echo": func(b []byte, reply *[]byte) error {
+			*reply = b
+			return nil
+		},
+	}
+	svc, err := newFuncService("Calc", funcs, false)
+	_assert(err == nil, "register funcs error: %v", err)
+	for name, mType := range svc.method {
+		typed := reflect.ValueOf(mType.invoke).Pointer()
+		fallback := reflect.ValueOf(reflectInvoker(mType.fn, mType.hasCtx, mType.multiArgs)).Pointer()
+		_assert(typed != fallback, "expect %s compiled to a typed invoker", name)
+	}
+	half := svc.method["Half"]
+	argv, reply := half.newArgv(), half.newReply()
+	argv.SetInt(8)
+	_assert(svc.call(context.Background(), half, argv, reply) == nil && *reply.Interface().(*int) == 4, "expect 8/2 == 4")
+	argv.SetInt(3)
+	_assert(svc.call(context.Background(), half, argv, reply) == errOdd && half.NumErrors() == 1, "expect handler error returned and counted")
+	allocs := testing.AllocsPerRun(100, func() { _ = half.invoke(context.Background(), argv, reply) })
+	_assert(allocs == 0, "expect typed invoker without allocations, got %v", allocs)
+
+	//结构体参数没有对应的签名，使用 reflect.Call
+	var foo Foo
+	sum := newService(&foo, false).method["Sum"]
+	argv, reply = sum.newArgv(), sum.newReply()
+	argv.Set(reflect.ValueOf(Args{Num1: 2, Num2: 5}))
+	_assert(sum.invoke(context.Background(), argv, reply) == nil && *reply.Interface().(*int) == 7, "expect reflect invoker for Foo.Sum")
+}
@@ -0,0 +1,109 @@
+package gpmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//initLimits 在第一次 Accept 时根据 Server 的配置初始化连接限制，之后修改配置不再生效
+func (s *Server) initLimits() {
+	s.limitOnce.Do(func() {
+		if s.MaxConns > 0 {
+			s.connSlots = make(chan struct{}, s.MaxConns)
+		}
+		//速率为 0 时不限制，仍然创建令牌桶，SetAcceptRate 可以在运行时开启限制
+		s.acceptLimiter = newRateLimiter(s.AcceptRate, s.AcceptBurst)
+	})
+}
+
+//SetAcceptRate 在运行时修改每秒最多接受的连接数和允许的突发连接数，rate 为 0 表示不限制
+func (s *Server) SetAcceptRate(rate float64, burst int) {
+	s.initLimits()
+	s.acceptLimiter.set(rate, burst)
+}
+
+//serveLimited 获取到连接名额后才开始处理连接，
+//名额用完时，如果排队的连接数没有超过 MaxPendingConns 则等待，否则回复 HandshakeError 后关闭连接。
+//lo 为空时使用 Server.Defaults
+func (s *Server) serveLimited(conn io.ReadWriteCloser, lo *ListenerOption) {
+	if s.connSlots != nil {
+		select {
+		case s.connSlots <- struct{}{}:
+		default:
+			if int(atomic.AddInt32(&s.pendingConns, 1)) > s.MaxPendingConns {
+				atomic.AddInt32(&s.pendingConns, -1)
+				logf(LogWarn, "rpc server: too many connections, limit %d", s.MaxConns)
+				s.rejectHandshake(conn, remoteAddr(conn), &HandshakeError{Reason: fmt.Sprintf("too many connections, limit %d", s.MaxConns)})
+				_ = conn.Close()
+				return
+			}
+			s.connSlots <- struct{}{}
+			atomic.AddInt32(&s.pendingConns, -1)
+		}
+		defer func() { <-s.connSlots }()
+	}
+	if lo == nil {
+		lo = s.listenerOption(nil)
+	}
+	s.serveConn(conn, lo)
+}
+
+//rateLimiter 令牌桶，限制 Accept 的速率
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 //每秒产生的令牌数
+	burst  float64 //令牌桶容量
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+//set 修改速率和容量，令牌桶重新装满
+func (l *rateLimiter) set(rate float64, burst int) {
+	if burst <= 0 {
+		burst = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate, l.burst, l.tokens, l.last = rate, float64(burst), float64(burst), time.Now()
+}
+
+//limits 返回当前的速率和容量
+func (l *rateLimiter) limits() (float64, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate, int(l.burst)
+}
+
+//wait 取走一个令牌，令牌不足时阻塞到令牌产生为止
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
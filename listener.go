@@ -0,0 +1,144 @@
+package gpmd
+
+import (
+	"fmt"
+	"gpmd/codec"
+	"net"
+	"time"
+)
+
+//ListenerOption 服务端对连接的设置，客户端在握手的 Option 中没有指定的项使用这里的值，
+//客户端指定的项受这里的限制。Server.Defaults 对所有连接生效，ServeListener 传入的设置逐项覆盖它，
+//为零值的项表示沿用上一层的设置
+type ListenerOption struct {
+	CodeType          codec.Type    //客户端 Option 中 CodeType 为空时使用的编码方式
+	Codecs            []codec.Type  //允许客户端使用的编码方式，为空表示不限制
+	HandshakeTimeout  time.Duration //等待客户端发送 Option 的时间，为 0 时使用 Server.HandshakeTimeout
+	HandleTimeout     time.Duration //客户端没有指定 HandleTimeout 时的处理超时，为 0 时使用 Server.HandleTimeout
+	MaxHandleTimeout  time.Duration //客户端指定的 HandleTimeout 的上限，0 表示不限制
+	CompressThreshold int           //客户端没有指定 CompressThreshold 时服务端回复使用的压缩阈值
+	RequireEncrypt    bool          //拒绝没有开启加密的连接，与 Server.RequireEncrypt 任一开启即生效
+	RequireChecksum   bool          //拒绝没有开启逐帧校验的连接
+	MaxConns          int           //这个 listener 上的并发连接数上限，超过时直接关闭新连接，0 表示不限制
+	//WriteQueue 每个连接的发送队列长度，大于 0 时回复和推送先进入队列，由连接的写 goroutine 依次写出，
+	//读得慢的客户端不会让 handler 阻塞在写连接上；0 表示在 handler 中直接写出
+	WriteQueue     int
+	WriteQueueFull QueueFullPolicy //发送队列满时的处理方式，默认等待
+	WriteTimeout   time.Duration   //写 goroutine 写出一条消息的超时，超时后关闭连接，0 表示不限制，WriteQueue 为 0 时不生效
+}
+
+//merge 用 o 中不为零值的项覆盖 base
+func (base ListenerOption) merge(o *ListenerOption) ListenerOption {
+	if o == nil {
+		return base
+	}
+	if o.CodeType != "" {
+		base.CodeType = o.CodeType
+	}
+	if len(o.Codecs) > 0 {
+		base.Codecs = o.Codecs
+	}
+	if o.HandshakeTimeout != 0 {
+		base.HandshakeTimeout = o.HandshakeTimeout
+	}
+	if o.HandleTimeout != 0 {
+		base.HandleTimeout = o.HandleTimeout
+	}
+	if o.MaxHandleTimeout != 0 {
+		base.MaxHandleTimeout = o.MaxHandleTimeout
+	}
+	if o.CompressThreshold != 0 {
+		base.CompressThreshold = o.CompressThreshold
+	}
+	base.RequireEncrypt = base.RequireEncrypt || o.RequireEncrypt
+	base.RequireChecksum = base.RequireChecksum || o.RequireChecksum
+	if o.MaxConns != 0 {
+		base.MaxConns = o.MaxConns
+	}
+	if o.WriteQueue != 0 {
+		base.WriteQueue = o.WriteQueue
+	}
+	if o.WriteQueueFull != QueueBlock {
+		base.WriteQueueFull = o.WriteQueueFull
+	}
+	if o.WriteTimeout != 0 {
+		base.WriteTimeout = o.WriteTimeout
+	}
+	return base
+}
+
+//listenerOption 按 Server 的字段、Server.Defaults、o 的顺序合并出连接最终的设置
+func (s *Server) listenerOption(o *ListenerOption) *ListenerOption {
+	lo := ListenerOption{
+		HandshakeTimeout: s.HandshakeTimeout,
+		HandleTimeout:    s.HandleTimeout,
+		RequireEncrypt:   s.RequireEncrypt,
+	}.merge(&s.Defaults).merge(o)
+	return &lo
+}
+
+//allowCodec 判断客户端协商的编码方式是否被允许
+func (lo *ListenerOption) allowCodec(typ codec.Type) bool {
+	if len(lo.Codecs) == 0 {
+		return true
+	}
+	for _, t := range lo.Codecs {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+//negotiate 用服务端的设置补全、限制客户端发送的 Option
+func (lo *ListenerOption) negotiate(opt *Option) {
+	if opt.HandleTimeout == 0 {
+		opt.HandleTimeout = lo.HandleTimeout
+	}
+	if lo.MaxHandleTimeout > 0 && (opt.HandleTimeout <= 0 || opt.HandleTimeout > lo.MaxHandleTimeout) {
+		opt.HandleTimeout = lo.MaxHandleTimeout
+	}
+	if opt.CompressThreshold == 0 {
+		opt.CompressThreshold = lo.CompressThreshold
+	}
+}
+
+//ServeListener 与 Accept 一样在 lis 上接受连接，连接使用 o 覆盖 Server.Defaults 之后的设置，
+//一个服务端可以在不同的 listener 上以不同的设置提供服务，例如内网端口放宽超时、公网端口要求加密。
+//Server 的 MaxConns、AcceptRate 等全局限制对所有 listener 共同生效
+func (s *Server) ServeListener(lis net.Listener, o *ListenerOption) {
+	s.initLimits()
+	lo := s.listenerOption(o)
+	var slots chan struct{}
+	if lo.MaxConns > 0 {
+		slots = make(chan struct{}, lo.MaxConns)
+	}
+	for {
+		if s.acceptLimiter != nil {
+			s.acceptLimiter.wait()
+		}
+		conn, err := lis.Accept()
+		if err != nil {
+			logf(LogError, "rpc server: accept error: %v", err)
+			return
+		}
+		if slots == nil {
+			go s.serveLimited(conn, lo)
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+			go func() {
+				defer func() { <-slots }()
+				s.serveLimited(conn, lo)
+			}()
+		default:
+			logf(LogWarn, "rpc server: too many connections on %s, limit %d", lis.Addr(), lo.MaxConns)
+			//在单独的 goroutine 中回复错误，等待客户端读取期间不阻塞 Accept
+			go func(limit int) {
+				s.rejectHandshake(conn, conn.RemoteAddr(), &HandshakeError{Reason: fmt.Sprintf("too many connections, limit %d", limit)})
+				_ = conn.Close()
+			}(lo.MaxConns)
+		}
+	}
+}
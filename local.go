@@ -0,0 +1,79 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+//NewLocalPair 通过 net.Pipe 在内存中连接 server 和返回的客户端，不需要监听端口，
+//适合在单元测试中端到端地测试 handler
+func NewLocalPair(server *Server, opts ...*Option) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	clientConn, serverConn := net.Pipe()
+	go server.ServeConn(serverConn)
+	client, err := NewClient(clientConn, opt)
+	if err != nil {
+		_ = serverConn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+//localAddr 内存连接的地址
+type localAddr string
+
+func (a localAddr) Network() string { return "local" }
+func (a localAddr) String() string  { return string(a) }
+
+var errLocalListenerClosed = errors.New("rpc local: listener closed")
+
+//LocalListener 内存中的 net.Listener，可以交给 Server.Accept，
+//客户端通过 Option{Dialer: l.Dial} 连接，XClient 也可以这样使用，地址可以任意填写
+type LocalListener struct {
+	conns     chan net.Conn
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+var _ net.Listener = (*LocalListener)(nil)
+
+//NewLocalListener 创建内存中的监听
+func NewLocalListener() *LocalListener {
+	return &LocalListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *LocalListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errLocalListenerClosed
+	}
+}
+
+func (l *LocalListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *LocalListener) Addr() net.Addr {
+	return localAddr("local")
+}
+
+//Dial 满足 DialFunc，network 和 address 会被忽略
+func (l *LocalListener) Dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	clientConn, serverConn := net.Pipe()
+	select {
+	case l.conns <- serverConn:
+		return clientConn, nil
+	case <-l.done:
+		return nil, errLocalListenerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
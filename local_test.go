@@ -0,0 +1,34 @@
+package gpmd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewLocalPair(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, err := NewLocalPair(server)
+	_assert(err == nil, "local pair error: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(callSum(client) == nil, "expect call over pipe to succeed")
+}
+
+func TestLocalListener(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var echo Echo
+	_ = server.Register(&echo)
+	l := NewLocalListener()
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := XDial("tcp@anywhere:1", &Option{Dialer: l.Dial})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply string
+	err = client.Call(context.Background(), "Echo.Echo", "in memory", &reply)
+	_assert(err == nil && reply == "in memory", "echo failed: %q %v", reply, err)
+}
@@ -0,0 +1,58 @@
+package gpmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+//LogLevel gpmd 自身日志的级别，低于当前级别的日志不输出，可以通过 SetLogLevel 或者 Admin 服务在运行时修改
+type LogLevel int32
+
+const (
+	LogDebug LogLevel = iota //调试信息
+	LogInfo                  //注册服务等启动信息，默认级别
+	LogWarn                  //慢调用、拒绝连接、丢弃消息等需要关注的情况
+	LogError                 //读写失败等错误
+	LogOff                   //不输出日志
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogOff {
+		return fmt.Sprintf("LogLevel(%d)", int32(l))
+	}
+	return logLevelNames[l]
+}
+
+//ParseLogLevel 将 debug、info、warn、error、off 转换为 LogLevel
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, errors.New("rpc: unknown log level " + s)
+}
+
+var logLevel = int32(LogInfo)
+
+//SetLogLevel 设置 gpmd 日志的级别
+func SetLogLevel(l LogLevel) {
+	atomic.StoreInt32(&logLevel, int32(l))
+}
+
+//GetLogLevel 返回当前的日志级别
+func GetLogLevel() LogLevel {
+	return LogLevel(atomic.LoadInt32(&logLevel))
+}
+
+//logf 级别不低于当前级别时通过标准库的 log 输出
+func logf(l LogLevel, format string, args ...interface{}) {
+	if l >= GetLogLevel() {
+		_ = log.Output(2, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,124 @@
+//Package metadata 在调用之间传递元数据，用法与 gRPC 的 metadata 相同：
+//客户端通过 NewOutgoingContext 附加元数据，服务端 handler 通过 FromIncomingContext 读取。
+//元数据的键不区分大小写，统一转换为小写
+package metadata
+
+import (
+	"context"
+	"strings"
+)
+
+//MD 一次调用的元数据，键为小写，一个键可以有多个值
+type MD map[string][]string
+
+//New 使用 map 创建 MD
+func New(m map[string]string) MD {
+	md := make(MD, len(m))
+	for k, v := range m {
+		md.Set(k, v)
+	}
+	return md
+}
+
+//Pairs 使用成对的键和值创建 MD，kv 的个数必须为偶数
+func Pairs(kv ...string) MD {
+	if len(kv)%2 == 1 {
+		panic("metadata: Pairs got an odd number of input pairs")
+	}
+	md := make(MD, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		md.Append(kv[i], kv[i+1])
+	}
+	return md
+}
+
+//Len 返回键的个数
+func (md MD) Len() int {
+	return len(md)
+}
+
+//Copy 返回 md 的副本
+func (md MD) Copy() MD {
+	out := make(MD, len(md))
+	for k, v := range md {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+//Get 返回键对应的所有值
+func (md MD) Get(key string) []string {
+	return md[strings.ToLower(key)]
+}
+
+//Value 返回键对应的第一个值，没有时返回空字符串
+func (md MD) Value(key string) string {
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+//Set 设置键的值，覆盖已有的值
+func (md MD) Set(key string, vals ...string) {
+	if len(vals) == 0 {
+		return
+	}
+	md[strings.ToLower(key)] = vals
+}
+
+//Append 在键已有的值之后追加
+func (md MD) Append(key string, vals ...string) {
+	if len(vals) == 0 {
+		return
+	}
+	key = strings.ToLower(key)
+	md[key] = append(md[key], vals...)
+}
+
+//Delete 删除键
+func (md MD) Delete(key string) {
+	delete(md, strings.ToLower(key))
+}
+
+//Join 合并多个 MD，相同键的值依次追加
+func Join(mds ...MD) MD {
+	out := MD{}
+	for _, md := range mds {
+		for k, v := range md {
+			out[k] = append(out[k], v...)
+		}
+	}
+	return out
+}
+
+type outgoingKey struct{}
+type incomingKey struct{}
+
+//NewOutgoingContext 返回附加了 md 的 ctx，使用这个 ctx 发起的调用会把 md 发送到服务端
+func NewOutgoingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, outgoingKey{}, md)
+}
+
+//AppendToOutgoingContext 在 ctx 已有的待发送元数据之后追加成对的键和值
+func AppendToOutgoingContext(ctx context.Context, kv ...string) context.Context {
+	md, _ := FromOutgoingContext(ctx)
+	return NewOutgoingContext(ctx, Join(md, Pairs(kv...)))
+}
+
+//FromOutgoingContext 返回 ctx 中待发送的元数据
+func FromOutgoingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(outgoingKey{}).(MD)
+	return md, ok
+}
+
+//NewIncomingContext 由服务端调用，将收到的元数据放入 handler 的 ctx
+func NewIncomingContext(ctx context.Context, md MD) context.Context {
+	return context.WithValue(ctx, incomingKey{}, md)
+}
+
+//FromIncomingContext 在 handler 中读取客户端发送的元数据
+func FromIncomingContext(ctx context.Context) (MD, bool) {
+	md, ok := ctx.Value(incomingKey{}).(MD)
+	return md, ok
+}
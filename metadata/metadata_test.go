@@ -0,0 +1,35 @@
+package metadata
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestMD(t *testing.T) {
+	md := Pairs("Tenant", "a", "tenant", "b", "trace", "1")
+	if got := md.Get("TENANT"); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("expect case-insensitive keys, got %v", got)
+	}
+	md.Set("trace", "2")
+	if md.Value("trace") != "2" || md.Len() != 2 {
+		t.Fatalf("expect trace overwritten, got %v", md)
+	}
+	cp := md.Copy()
+	cp.Append("tenant", "c")
+	if len(md.Get("tenant")) != 2 {
+		t.Fatal("expect Copy to be independent")
+	}
+}
+
+func TestOutgoingContext(t *testing.T) {
+	ctx := NewOutgoingContext(context.Background(), New(map[string]string{"user": "u1"}))
+	ctx = AppendToOutgoingContext(ctx, "user", "u2")
+	md, ok := FromOutgoingContext(ctx)
+	if !ok || !reflect.DeepEqual(md.Get("user"), []string{"u1", "u2"}) {
+		t.Fatalf("expect appended metadata, got %v", md)
+	}
+	if _, ok = FromIncomingContext(ctx); ok {
+		t.Fatal("outgoing metadata must not be visible as incoming")
+	}
+}
@@ -0,0 +1,44 @@
+package gpmd
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//methodEntry 方法表快照中的一项
+type methodEntry struct {
+	svc   *service
+	mType *methodType
+}
+
+//methodSnapshot 以 "Service.Method" 为键的方法表快照，创建之后只读，注册变化时整体替换。
+//每个请求只需要一次不加锁的 map 查找，不再分割字符串、查找两次
+type methodSnapshot map[string]methodEntry
+
+//methodCache 保存最新的快照，注册服务和 Handle 之后调用 rebuild
+type methodCache struct {
+	mu       sync.Mutex   //串行重建，后完成的重建总能看到之前所有的注册
+	snapshot atomic.Value //methodSnapshot
+}
+
+//lookup 在快照中查找，快照还没有建立或者没有这个方法时返回 false，由调用方走完整的查找得到错误信息
+func (c *methodCache) lookup(serviceMethod string) (methodEntry, bool) {
+	snapshot, _ := c.snapshot.Load().(methodSnapshot)
+	e, ok := snapshot[serviceMethod]
+	return e, ok
+}
+
+//rebuild 根据 serviceMap 重新生成快照
+func (c *methodCache) rebuild(serviceMap *sync.Map) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := make(methodSnapshot)
+	serviceMap.Range(func(_, svci interface{}) bool {
+		svc := svci.(*service)
+		for name, mType := range svc.method {
+			snapshot[svc.name+"."+name] = methodEntry{svc: svc, mType: mType}
+		}
+		return true
+	})
+	c.snapshot.Store(snapshot)
+}
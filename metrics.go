@@ -0,0 +1,40 @@
+package gpmd
+
+import "sync/atomic"
+
+//Metrics 指标上报的钩子，通过 SetMetrics 接入 Prometheus、expvar 等监控系统。
+//labels 为成对出现的标签名和标签值，例如 Inc("gpmd_server_calls_total", "method", "Foo.Sum")
+type Metrics interface {
+	Inc(name string, labels ...string)                    //计数器加一
+	Observe(name string, value float64, labels ...string) //记录一次观测值，例如耗时，单位由指标名决定
+	Set(name string, value float64, labels ...string)     //设置瞬时值
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Inc(string, ...string)              {}
+func (nopMetrics) Observe(string, float64, ...string) {}
+func (nopMetrics) Set(string, float64, ...string)     {}
+
+type metricsHolder struct {
+	m Metrics
+}
+
+var globalMetrics atomic.Value
+
+func init() {
+	globalMetrics.Store(metricsHolder{nopMetrics{}})
+}
+
+//SetMetrics 设置全局的指标钩子，m 为 nil 时恢复为不上报
+func SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+	globalMetrics.Store(metricsHolder{m})
+}
+
+//GetMetrics 返回当前的指标钩子，供其他包上报指标
+func GetMetrics() Metrics {
+	return globalMetrics.Load().(metricsHolder).m
+}
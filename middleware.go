@@ -0,0 +1,120 @@
+package gpmd
+
+import (
+	"context"
+	"gpmd/metadata"
+)
+
+//Middleware 服务端中间件，按照 Server.Middlewares 的顺序在 Authorizer 和 handler 之前调用。
+//返回的 ctx 交给之后的中间件、Authorizer 和 handler，可以通过 ContextKey 放入调用方的身份、租户、语言等；
+//返回 nil 的 ctx 表示不修改，返回错误时请求被拒绝，错误信息返回给客户端
+type Middleware func(ctx context.Context, serviceMethod string) (context.Context, error)
+
+//ContextKey 中间件向 handler 传递值时使用的键，不同的 ContextKey 互不冲突，即使名字相同。
+//通常定义为包级别的变量，例如 var UserKey = gpmd.NewContextKey("user")
+type ContextKey struct {
+	name string
+}
+
+//NewContextKey 创建新的键，name 只用于调试输出
+func NewContextKey(name string) *ContextKey {
+	return &ContextKey{name: name}
+}
+
+func (k *ContextKey) String() string {
+	return "gpmd context key " + k.name
+}
+
+//WithValue 返回携带 v 的 ctx
+func (k *ContextKey) WithValue(ctx context.Context, v interface{}) context.Context {
+	return context.WithValue(ctx, k, v)
+}
+
+//Value 读取 ctx 中的值，没有设置时返回 false
+func (k *ContextKey) Value(ctx context.Context) (interface{}, bool) {
+	v := ctx.Value(k)
+	return v, v != nil
+}
+
+//StringValue 读取 ctx 中的字符串值，没有设置或者不是字符串时返回空字符串
+func (k *ContextKey) StringValue(ctx context.Context) string {
+	s, _ := ctx.Value(k).(string)
+	return s
+}
+
+var (
+	identityKey = NewContextKey("identity")
+	tenantKey   = NewContextKey("tenant")
+	localeKey   = NewContextKey("locale")
+)
+
+//ContextWithIdentity 返回携带调用方身份的 ctx，一般由认证的中间件设置，例如校验 token 之后得到的用户名
+func ContextWithIdentity(ctx context.Context, identity string) context.Context {
+	return identityKey.WithValue(ctx, identity)
+}
+
+//IdentityFromContext 读取中间件设置的调用方身份，双向 TLS 的证书身份见 PeerIdentityFromContext
+func IdentityFromContext(ctx context.Context) (string, bool) {
+	identity := identityKey.StringValue(ctx)
+	return identity, identity != ""
+}
+
+//ContextWithTenant 返回携带租户的 ctx
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return tenantKey.WithValue(ctx, tenant)
+}
+
+//TenantFromContext 读取中间件设置的租户
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant := tenantKey.StringValue(ctx)
+	return tenant, tenant != ""
+}
+
+//ContextWithLocale 返回携带语言区域的 ctx，例如 "zh-CN"
+func ContextWithLocale(ctx context.Context, locale string) context.Context {
+	return localeKey.WithValue(ctx, locale)
+}
+
+//LocaleFromContext 读取中间件设置的语言区域
+func LocaleFromContext(ctx context.Context) (string, bool) {
+	locale := localeKey.StringValue(ctx)
+	return locale, locale != ""
+}
+
+//MetadataToContext 返回把请求元数据中 mdKey 的值放入 key 的中间件，元数据中没有这一项时不修改 ctx，
+//例如 MetadataToContext("x-tenant", TenantKey()) 之后 handler 可以通过 TenantFromContext 读取客户端携带的租户
+func MetadataToContext(mdKey string, key *ContextKey) Middleware {
+	return func(ctx context.Context, _ string) (context.Context, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if v := md.Value(mdKey); v != "" {
+			return key.WithValue(ctx, v), nil
+		}
+		return nil, nil
+	}
+}
+
+//IdentityKey 返回 ContextWithIdentity 使用的键，用于 MetadataToContext
+func IdentityKey() *ContextKey { return identityKey }
+
+//TenantKey 返回 ContextWithTenant 使用的键，用于 MetadataToContext
+func TenantKey() *ContextKey { return tenantKey }
+
+//LocaleKey 返回 ContextWithLocale 使用的键，用于 MetadataToContext
+func LocaleKey() *ContextKey { return localeKey }
+
+//intercept 依次调用中间件和 Authorizer，返回交给 handler 的 ctx
+func (s *Server) intercept(ctx context.Context, serviceMethod string) (context.Context, error) {
+	for _, mw := range s.Middlewares {
+		next, err := mw(ctx, serviceMethod)
+		if err != nil {
+			return ctx, err
+		}
+		if next != nil {
+			ctx = next
+		}
+	}
+	if s.Authorizer != nil {
+		return ctx, s.Authorizer(ctx, serviceMethod)
+	}
+	return ctx, nil
+}
@@ -0,0 +1,70 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"gpmd/metadata"
+	"strings"
+	"testing"
+)
+
+type RequestInfo int
+
+func (w RequestInfo) Get(ctx context.Context, _ int, reply *string) error {
+	identity, _ := IdentityFromContext(ctx)
+	tenant, _ := TenantFromContext(ctx)
+	locale, _ := LocaleFromContext(ctx)
+	*reply = identity + "/" + tenant + "/" + locale
+	return nil
+}
+
+func TestServer_Middlewares(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var w RequestInfo
+	_ = server.Register(&w)
+	server.Middlewares = []Middleware{
+		MetadataToContext("x-tenant", TenantKey()),
+		MetadataToContext("x-locale", LocaleKey()),
+		func(ctx context.Context, _ string) (context.Context, error) {
+			md, _ := metadata.FromIncomingContext(ctx)
+			token := strings.TrimPrefix(md.Value(AuthorizationKey), "Bearer ")
+			if token == "" {
+				return nil, errors.New("unauthenticated")
+			}
+			return ContextWithIdentity(ctx, "user-"+token), nil
+		},
+	}
+	server.Authorizer = func(ctx context.Context, _ string) error {
+		if identity, _ := IdentityFromContext(ctx); identity != "user-x" {
+			return errors.New("forbidden")
+		}
+		return nil
+	}
+	opt, _ := NewOption(WithAuth("x"))
+	client, _ := NewLocalPair(server, opt)
+	defer func() { _ = client.Close() }()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme", "x-locale", "zh-CN")
+	var reply string
+	err := client.Call(ctx, "RequestInfo.Get", 0, &reply)
+	_assert(err == nil && reply == "user-x/acme/zh-CN", "expect values from middlewares, got %q, %v", reply, err)
+	err = client.Call(context.Background(), "RequestInfo.Get", 0, &reply)
+	_assert(err == nil && reply == "user-x//", "expect missing metadata left unset, got %q, %v", reply, err)
+
+	plain, _ := NewLocalPair(server)
+	defer func() { _ = plain.Close() }()
+	err = plain.Call(context.Background(), "RequestInfo.Get", 0, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "unauthenticated"), "expect middleware error returned, got %v", err)
+}
+
+func TestContextKey(t *testing.T) {
+	t.Parallel()
+	a, b := NewContextKey("user"), NewContextKey("user")
+	ctx := a.WithValue(context.Background(), "alice")
+	_, ok := b.Value(ctx)
+	_assert(!ok, "expect keys with the same name to be distinct")
+	v, ok := a.Value(ctx)
+	_assert(ok && v == "alice" && a.StringValue(ctx) == "alice", "expect value, got %v", v)
+	_assert(a.StringValue(a.WithValue(ctx, 1)) == "", "expect non-string value ignored")
+}
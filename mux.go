@@ -0,0 +1,177 @@
+package gpmd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//maxSniffSize 识别协议时最多读取的字节数，只有空白字符时继续读取
+const maxSniffSize = 1 << 10
+
+var errMuxClosed = errors.New("rpc mux: listener closed")
+
+//Mux 在一个端口上同时提供原生 RPC 和 HTTP（CONNECT 方式的 RPC、网关、调试页面等），
+//与 cmux 类似：根据连接最开始的数据识别协议，原生握手的 Option 是以 '{' 开头的 JSON，
+//其他数据都交给 HTTP。lis 是 TLS 监听时在解密之后识别，RPC 连接仍然可以拿到 TLS 状态，
+//但是 HTTP 连接被包装之后 http.Server 不再认为它是 TLS 连接，不能协商 HTTP/2
+type Mux struct {
+	SniffTimeout time.Duration //等待客户端发送第一段数据的时间，为 0 时使用 DefaultHandshakeTimeout
+
+	root      net.Listener
+	rpc       *muxListener
+	http      *muxListener
+	closeOnce sync.Once
+}
+
+//NewMux 创建 Mux，需要调用 Serve 开始接受连接
+func NewMux(lis net.Listener) *Mux {
+	return &Mux{
+		root: lis,
+		rpc:  newMuxListener(lis.Addr()),
+		http: newMuxListener(lis.Addr()),
+	}
+}
+
+//RPC 返回原生 RPC 连接的 listener，通常交给 Server.Accept 或者 Server.ServeListener
+func (m *Mux) RPC() net.Listener { return m.rpc }
+
+//HTTP 返回 HTTP 连接的 listener，通常交给 http.Serve
+func (m *Mux) HTTP() net.Listener { return m.http }
+
+//Serve 接受连接并分发到 RPC 和 HTTP，lis 出错时关闭两个 listener 并返回错误
+func (m *Mux) Serve() error {
+	defer m.closeListeners()
+	for {
+		conn, err := m.root.Accept()
+		if err != nil {
+			return err
+		}
+		go m.dispatch(conn)
+	}
+}
+
+//Close 关闭 lis，Serve 随之返回
+func (m *Mux) Close() error {
+	err := m.root.Close()
+	m.closeListeners()
+	return err
+}
+
+func (m *Mux) closeListeners() {
+	m.closeOnce.Do(func() {
+		m.rpc.close()
+		m.http.close()
+	})
+}
+
+func (m *Mux) dispatch(conn net.Conn) {
+	timeout := m.SniffTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	rpc, prefix, err := sniffRPC(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		logf(LogWarn, "rpc mux: sniff %v error: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	sniffed := &sniffedConn{Conn: conn, r: io.MultiReader(bytes.NewReader(prefix), conn)}
+	lis, protocol := m.http, "http"
+	if rpc {
+		lis, protocol = m.rpc, "rpc"
+	}
+	GetMetrics().Inc("gpmd_server_mux_connections_total", "protocol", protocol)
+	if !lis.deliver(sniffed) {
+		_ = conn.Close()
+	}
+}
+
+//sniffRPC 跳过开头的空白字符，第一个字符为 '{' 时是原生握手，返回已经读出的数据
+func sniffRPC(r io.Reader) (bool, []byte, error) {
+	var buf [64]byte
+	var prefix []byte
+	for len(prefix) < maxSniffSize {
+		n, err := r.Read(buf[:])
+		prefix = append(prefix, buf[:n]...)
+		for _, b := range buf[:n] {
+			switch b {
+			case ' ', '\t', '\r', '\n':
+				continue
+			case '{':
+				return true, prefix, nil
+			default:
+				return false, prefix, nil
+			}
+		}
+		if err != nil {
+			return false, prefix, err
+		}
+	}
+	return false, prefix, nil
+}
+
+//sniffedConn 先读出识别协议时读到的数据
+type sniffedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+//muxListener 接受 Mux 分发过来的连接
+type muxListener struct {
+	addr   net.Addr
+	conns  chan net.Conn
+	done   chan struct{}
+	closed sync.Once
+}
+
+func newMuxListener(addr net.Addr) *muxListener {
+	return &muxListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, errMuxClosed
+	}
+}
+
+//Close 只停止分发，已经接受的连接不受影响
+func (l *muxListener) Close() error {
+	l.close()
+	return nil
+}
+
+func (l *muxListener) close() {
+	l.closed.Do(func() { close(l.done) })
+}
+
+func (l *muxListener) Addr() net.Addr { return l.addr }
+
+func (l *muxListener) deliver(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+//ServeMux 在 lis 上同时提供原生 RPC 和 handler 中的 HTTP 服务，handler 为空时使用 http.DefaultServeMux，
+//即 HandleHTTP 注册的 CONNECT 入口和调试页面，使用 HandleHTTPOn 时传入对应的 mux。lis 出错或者被关闭时返回
+func (s *Server) ServeMux(lis net.Listener, handler http.Handler) error {
+	m := NewMux(lis)
+	go s.Accept(m.RPC())
+	go func() { _ = http.Serve(m.HTTP(), handler) }()
+	return m.Serve()
+}
@@ -0,0 +1,77 @@
+package gpmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServer_ServeMux(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	handler := http.NewServeMux()
+	handler.Handle(defaultRPCPath, server)
+	handler.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello")
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go func() { _ = server.ServeMux(l, handler) }()
+	addr := l.Addr().String()
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	_assert(callSum(client) == nil, "expect native rpc on the shared port")
+	_ = client.Close()
+
+	client, err = DialHTTP("tcp", addr)
+	_assert(err == nil, "dial http error: %v", err)
+	_assert(callSum(client) == nil, "expect rpc over http connect on the shared port")
+	_ = client.Close()
+
+	resp, err := http.Get("http://" + addr + "/hello")
+	_assert(err == nil, "http get error: %v", err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	_assert(string(body) == "hello", "expect http handler on the shared port, got %q", body)
+}
+
+func TestMux_TLS(t *testing.T) {
+	t.Parallel()
+	ca := issueCert(t, "test-ca", nil, true)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Leaf)
+	tlsConns := make(chan bool, 1)
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	server.OnConnect = func(c *Conn) error {
+		tlsConns <- c.TLS != nil
+		return nil
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	m := NewMux(tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{issueCert(t, "127.0.0.1", &ca, false)}}))
+	m.SniffTimeout = time.Second
+	go server.Accept(m.RPC())
+	go func() { _ = m.Serve() }()
+	defer func() { _ = m.Close() }()
+
+	//一直不发送数据的连接在 SniffTimeout 之后被关闭，不影响其他连接
+	idle, _ := net.Dial("tcp", l.Addr().String())
+	defer func() { _ = idle.Close() }()
+	client, err := Dial("tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second, TLSConfig: &tls.Config{RootCAs: pool}})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var reply int
+	_assert(client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "expect tls rpc through mux")
+	_assert(<-tlsConns, "expect tls state on muxed connection")
+}
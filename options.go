@@ -0,0 +1,235 @@
+package gpmd
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gpmd/codec"
+	"gpmd/metadata"
+	"time"
+)
+
+//ClientOption 修改客户端的 Option，参数不合法时返回错误
+type ClientOption func(opt *Option) error
+
+//NewOption 以 DefaultOption 为基础依次应用 opts 并校验结果，得到的 Option 传给 Dial、XDial 等函数。
+//与直接构造 Option 不同，没有通过 ClientOption 设置的项保留默认值，
+//例如 WithConnectTimeout(0) 明确表示不限制连接超时，而不设置时仍然使用默认的 10s
+func NewOption(opts ...ClientOption) (*Option, error) {
+	opt := *DefaultOption
+	for _, o := range opts {
+		if err := o(&opt); err != nil {
+			return nil, err
+		}
+	}
+	if err := opt.validate(); err != nil {
+		return nil, err
+	}
+	return &opt, nil
+}
+
+//WithCodec 设置编码方式，typ 需要已经注册在 codec.NewCodecFuncMap 中
+func WithCodec(typ codec.Type) ClientOption {
+	return func(opt *Option) error {
+		if codec.NewCodecFuncMap[typ] == nil {
+			return fmt.Errorf("rpc client: invalid codec type %s", typ)
+		}
+		opt.CodeType = typ
+		return nil
+	}
+}
+
+//WithConnectTimeout 设置建立连接和握手的超时，0 表示不限制
+func WithConnectTimeout(d time.Duration) ClientOption {
+	return func(opt *Option) error {
+		if d < 0 {
+			return fmt.Errorf("rpc client: negative connect timeout %s", d)
+		}
+		opt.ConnectTimeout = d
+		return nil
+	}
+}
+
+//WithHandleTimeout 设置希望服务端使用的处理超时，0 表示使用服务端的默认值
+func WithHandleTimeout(d time.Duration) ClientOption {
+	return func(opt *Option) error {
+		if d < 0 {
+			return fmt.Errorf("rpc client: negative handle timeout %s", d)
+		}
+		opt.HandleTimeout = d
+		return nil
+	}
+}
+
+//WithTLS 使用 TLS 建立连接，cfg 中没有 ServerName 时使用地址中的主机名校验服务端证书
+func WithTLS(cfg *tls.Config) ClientOption {
+	return func(opt *Option) error {
+		if cfg == nil {
+			return errors.New("rpc client: nil tls config")
+		}
+		opt.TLSConfig = cfg
+		return nil
+	}
+}
+
+//WithCompression 编码后不小于 threshold 字节的请求会被压缩，0 表示不压缩
+func WithCompression(threshold int) ClientOption {
+	return func(opt *Option) error {
+		if threshold < 0 {
+			return fmt.Errorf("rpc client: negative compress threshold %d", threshold)
+		}
+		opt.CompressThreshold = threshold
+		return nil
+	}
+}
+
+//WithChunking 编码后超过 size 字节的请求和回复拆分为多个帧发送，帧之间同一个连接上的其他消息可以插入，
+//避免一个很大的回复阻塞其他调用的回复。需要服务端同样支持分块
+func WithChunking(size int) ClientOption {
+	return func(opt *Option) error {
+		if size < 0 {
+			return fmt.Errorf("rpc client: negative chunk size %d", size)
+		}
+		opt.ChunkSize = size
+		return nil
+	}
+}
+
+//WithChecksum 开启逐帧 CRC32 校验
+func WithChecksum() ClientOption {
+	return func(opt *Option) error {
+		opt.Checksum = true
+		return nil
+	}
+}
+
+//WithEncryption 使用 keyring 中的预共享密钥加密连接
+func WithEncryption(keyring *codec.Keyring) ClientOption {
+	return func(opt *Option) error {
+		if keyring == nil {
+			return errors.New("rpc client: encrypt requires a keyring")
+		}
+		opt.Encrypt, opt.Keyring = true, keyring
+		return nil
+	}
+}
+
+//WithDialer 使用 dial 建立连接
+func WithDialer(dial DialFunc) ClientOption {
+	return func(opt *Option) error {
+		if dial == nil {
+			return errors.New("rpc client: nil dialer")
+		}
+		opt.Dialer = dial
+		return nil
+	}
+}
+
+//WithMetadata 每个请求都携带 kv 组成的元数据，ctx 中同名的元数据优先
+func WithMetadata(kv ...string) ClientOption {
+	return func(opt *Option) error {
+		if len(kv)%2 == 1 {
+			return fmt.Errorf("rpc client: odd number of metadata kv: %d", len(kv))
+		}
+		opt.Metadata = metadata.Join(opt.Metadata, metadata.Pairs(kv...))
+		return nil
+	}
+}
+
+//WithClock 使用 c 计算调用超时和连接超时，测试中通常传入 gpmdtest.FakeClock
+func WithClock(c Clock) ClientOption {
+	return func(opt *Option) error {
+		if c == nil {
+			return errors.New("rpc client: nil clock")
+		}
+		opt.Clock = c
+		return nil
+	}
+}
+
+//WithOrderedDelivery 要求服务端按照请求的顺序发送回复，见 Option.OrderedDelivery
+func WithOrderedDelivery() ClientOption {
+	return func(opt *Option) error {
+		opt.OrderedDelivery = true
+		return nil
+	}
+}
+
+//WithMaxPendingCalls 等待响应的调用数达到 n 时新的调用立即以 ErrTooManyPending 失败，0 表示不限制
+func WithMaxPendingCalls(n int) ClientOption {
+	return func(opt *Option) error {
+		if n < 0 {
+			return fmt.Errorf("rpc client: negative max pending calls %d", n)
+		}
+		opt.MaxPendingCalls = n
+		return nil
+	}
+}
+
+//WithMaxCallAge 等待响应超过 d 的调用以包装了 ErrDeadlineExceeded 的错误结束，0 表示不检查
+func WithMaxCallAge(d time.Duration) ClientOption {
+	return func(opt *Option) error {
+		if d < 0 {
+			return fmt.Errorf("rpc client: negative max call age %s", d)
+		}
+		opt.MaxCallAge = d
+		return nil
+	}
+}
+
+//WithConnEvents 在连接建立、断开和 XClient 重新连接时调用 ev 中的回调
+func WithConnEvents(ev ConnEvents) ClientOption {
+	return func(opt *Option) error {
+		opt.Events = ev
+		return nil
+	}
+}
+
+//AuthorizationKey WithAuth 携带凭证时使用的元数据键，服务端的 Authorizer 可以通过
+//metadata.FromIncomingContext 读取
+const AuthorizationKey = "authorization"
+
+//WithAuth 每个请求都以 "Bearer <token>" 的形式携带凭证
+func WithAuth(token string) ClientOption {
+	return func(opt *Option) error {
+		if token == "" {
+			return errors.New("rpc client: empty auth token")
+		}
+		opt.Metadata = opt.Metadata.Copy()
+		opt.Metadata.Set(AuthorizationKey, "Bearer "+token)
+		return nil
+	}
+}
+
+//validate 检查 Option 中各项的取值，MagicNumber 为 0 表示使用默认值
+func (opt *Option) validate() error {
+	switch {
+	case opt.MagicNumber != 0 && opt.MagicNumber != MagicNumber:
+		return fmt.Errorf("rpc client: invalid magic number %x", opt.MagicNumber)
+	case codec.NewCodecFuncMap[opt.CodeType] == nil:
+		return fmt.Errorf("rpc client: invalid codec type %s", opt.CodeType)
+	case opt.ConnectTimeout < 0:
+		return fmt.Errorf("rpc client: negative connect timeout %s", opt.ConnectTimeout)
+	case opt.HandleTimeout < 0:
+		return fmt.Errorf("rpc client: negative handle timeout %s", opt.HandleTimeout)
+	case opt.CompressThreshold < 0:
+		return fmt.Errorf("rpc client: negative compress threshold %d", opt.CompressThreshold)
+	case opt.ChunkSize < 0:
+		return fmt.Errorf("rpc client: negative chunk size %d", opt.ChunkSize)
+	case opt.Encrypt && opt.Keyring == nil:
+		return errors.New("rpc client: encrypt requires a keyring")
+	}
+	return nil
+}
+
+//outgoingMetadata 合并 Option 中的默认元数据和本次调用的元数据，同名的键以本次调用为准
+func outgoingMetadata(defaults, md metadata.MD) metadata.MD {
+	if defaults.Len() == 0 {
+		return md
+	}
+	out := defaults.Copy()
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
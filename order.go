@@ -0,0 +1,49 @@
+package gpmd
+
+import "sync"
+
+//replyOrder 按照请求读取的顺序发送回复，先完成的回复暂存起来，直到前面的请求都已经回复。
+//每个序号只发送第一个回复，处理超时之后 handler 返回的回复被丢弃
+type replyOrder struct {
+	mu      sync.Mutex
+	read    uint64            //已经读取的请求数，下一个请求的序号
+	sent    uint64            //已经回复的请求数，下一个可以写出的序号
+	pending map[uint64]func() //已经完成、等待前面的请求回复的写出函数
+}
+
+func newReplyOrder() *replyOrder {
+	return &replyOrder{pending: make(map[uint64]func())}
+}
+
+//next 分配下一个请求的序号，只在读取请求的 goroutine 中调用
+func (o *replyOrder) next() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	n := o.read
+	o.read++
+	return n
+}
+
+//deliver 轮到 n 时调用 write，并依次写出之后已经完成的回复。持有锁写出，保证写出的顺序
+func (o *replyOrder) deliver(n uint64, write func()) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, dup := o.pending[n]; dup || n < o.sent {
+		return
+	}
+	if n > o.sent {
+		o.pending[n] = write
+		return
+	}
+	write()
+	o.sent++
+	for {
+		write, ok := o.pending[o.sent]
+		if !ok {
+			return
+		}
+		delete(o.pending, o.sent)
+		write()
+		o.sent++
+	}
+}
@@ -0,0 +1,65 @@
+package gpmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServer_OrderedDelivery(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Sleeper))
+	addr := startLimitedServer(server)
+	//回复到达的顺序就是 done 收到的顺序
+	arrivals := func(opt *Option) []int {
+		client, err := Dial("tcp", addr, opt)
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = client.Close() }()
+		done := make(chan *Call, 3)
+		index := make(map[*Call]int)
+		for i, ms := range []int{60, 30, 0} {
+			index[client.Go("Sleeper.Sleep", ms, new(int), done)] = i
+		}
+		var got []int
+		for range index {
+			call := <-done
+			_assert(call.Error == nil, "sleep error: %v", call.Error)
+			got = append(got, index[call])
+		}
+		return got
+	}
+	opt, _ := NewOption(WithOrderedDelivery())
+	got := arrivals(opt)
+	_assert(got[0] == 0 && got[1] == 1 && got[2] == 2, "expect replies in request order, got %v", got)
+	got = arrivals(DefaultOption)
+	_assert(got[0] == 2, "expect the fastest reply first without ordered delivery, got %v", got)
+
+	//处理超时的回复占据它的位置，handler 之后返回的回复被丢弃
+	opt, _ = NewOption(WithOrderedDelivery(), WithHandleTimeout(20*time.Millisecond))
+	client, err := Dial("tcp", addr, opt)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	slow := client.Go("Sleeper.Sleep", 100, new(int), make(chan *Call, 1))
+	_assert(callSum(client) == nil, "expect the following call to succeed")
+	_assert((<-slow.Done).Error != nil, "expect the slow call to time out")
+	time.Sleep(120 * time.Millisecond)
+	_assert(callSum(client) == nil, "expect the late reply dropped")
+}
+
+func TestReplyOrder(t *testing.T) {
+	t.Parallel()
+	o := newReplyOrder()
+	var sent []int
+	write := func(n int) func() { return func() { sent = append(sent, n) } }
+	for i := 0; i < 4; i++ {
+		_assert(o.next() == uint64(i), "expect sequential numbers")
+	}
+	o.deliver(2, write(2))
+	o.deliver(2, write(-2))
+	o.deliver(1, write(1))
+	_assert(len(sent) == 0, "expect replies held until the first one, got %v", sent)
+	o.deliver(0, write(0))
+	o.deliver(1, write(-1))
+	o.deliver(3, write(3))
+	_assert(len(sent) == 4 && sent[0] == 0 && sent[1] == 1 && sent[2] == 2 && sent[3] == 3, "expect replies in order once each, got %v", sent)
+}
@@ -0,0 +1,136 @@
+package gpmd
+
+import (
+	"math/rand"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//DefaultRetryAfter OverloadController 没有设置 RetryAfter 时建议客户端等待的时间
+const DefaultRetryAfter = 100 * time.Millisecond
+
+//gcSampleInterval 采样 GC 停顿的最小间隔，ReadMemStats 会短暂地暂停所有 goroutine，不能每个请求都调用
+const gcSampleInterval = 100 * time.Millisecond
+
+//builtinServicePrefix 内置服务名的前缀，这些服务不受过载保护的限制
+const builtinServicePrefix = "_gpmd_."
+
+const unavailablePrefix = "rpc server: unavailable, retry after "
+
+//UnavailableError 服务端过载时拒绝请求返回的错误，RetryAfter 为建议客户端等待的时间
+type UnavailableError struct {
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return unavailablePrefix + e.RetryAfter.String()
+}
+
+//Is 与 ErrUnavailable 匹配
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+//ParseUnavailable 还原服务端以字符串形式返回的 UnavailableError
+func ParseUnavailable(msg string) (*UnavailableError, bool) {
+	if !strings.HasPrefix(msg, unavailablePrefix) {
+		return nil, false
+	}
+	d, err := time.ParseDuration(strings.TrimPrefix(msg, unavailablePrefix))
+	if err != nil {
+		return nil, false
+	}
+	return &UnavailableError{RetryAfter: d}, true
+}
+
+//OverloadController 根据正在处理的请求数、平均排队时间和最近一次 GC 停顿计算服务端的负载，
+//负载超过 1 时按照 1-1/负载 的比例随机拒绝新请求，保护已经接受的请求的尾延迟。
+//拒绝的比例始终小于 1，保证仍然有请求进入，排队时间能够随着负载的下降而更新。
+//各项上限为 0 表示不检查这一项，内置的 _gpmd_ 服务（健康检查、反射等）不受限制
+type OverloadController struct {
+	MaxInFlight     int           //正在处理和排队的请求数上限
+	MaxQueueLatency time.Duration //请求平均排队时间的上限
+	MaxGCPause      time.Duration //最近一次 GC 停顿的上限
+	RetryAfter      time.Duration //返回给客户端的重试间隔，0 表示使用 DefaultRetryAfter
+
+	inFlight   int64
+	queueNanos int64 //排队时间的指数移动平均值
+	gcPause    int64 //最近一次采样到的 GC 停顿
+	gcSampled  int64 //最近一次采样 GC 停顿的时间（Unix 纳秒）
+	rejected   uint64
+}
+
+//Load 返回当前的负载，即各项指标与上限之比的最大值，超过 1 表示过载
+func (o *OverloadController) Load() float64 {
+	return o.load(atomic.LoadInt64(&o.inFlight))
+}
+
+func (o *OverloadController) load(inFlight int64) float64 {
+	var load float64
+	ratio := func(v, max int64) {
+		if max > 0 && float64(v)/float64(max) > load {
+			load = float64(v) / float64(max)
+		}
+	}
+	ratio(inFlight, int64(o.MaxInFlight))
+	ratio(atomic.LoadInt64(&o.queueNanos), int64(o.MaxQueueLatency))
+	if o.MaxGCPause > 0 {
+		o.sampleGC()
+		ratio(atomic.LoadInt64(&o.gcPause), int64(o.MaxGCPause))
+	}
+	return load
+}
+
+//sampleGC 距离上一次采样超过 gcSampleInterval 时读取最近一次 GC 的停顿时间
+func (o *OverloadController) sampleGC() {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&o.gcSampled)
+	if now-last < int64(gcSampleInterval) || !atomic.CompareAndSwapInt64(&o.gcSampled, last, now) {
+		return
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	var pause uint64
+	if ms.NumGC > 0 {
+		pause = ms.PauseNs[(ms.NumGC+255)%256]
+	}
+	atomic.StoreInt64(&o.gcPause, int64(pause))
+}
+
+//Rejected 返回因为过载被拒绝的请求数
+func (o *OverloadController) Rejected() uint64 {
+	return atomic.LoadUint64(&o.rejected)
+}
+
+//admit 判断是否接受新请求，接受时计入正在处理的请求，处理完后需要调用 done
+func (o *OverloadController) admit() bool {
+	load := o.load(atomic.LoadInt64(&o.inFlight) + 1)
+	if load > 1 && rand.Float64() < 1-1/load {
+		atomic.AddUint64(&o.rejected, 1)
+		return false
+	}
+	atomic.AddInt64(&o.inFlight, 1)
+	return true
+}
+
+//done 请求处理完成，queued 为请求的排队时间，新的样本占 1/8 的权重
+func (o *OverloadController) done(queued time.Duration) {
+	atomic.AddInt64(&o.inFlight, -1)
+	for {
+		old := atomic.LoadInt64(&o.queueNanos)
+		avg := old - old/8 + int64(queued)/8
+		if atomic.CompareAndSwapInt64(&o.queueNanos, old, avg) {
+			return
+		}
+	}
+}
+
+func (o *OverloadController) unavailable() *UnavailableError {
+	retryAfter := o.RetryAfter
+	if retryAfter == 0 {
+		retryAfter = DefaultRetryAfter
+	}
+	return &UnavailableError{RetryAfter: retryAfter}
+}
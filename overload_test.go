@@ -0,0 +1,67 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseUnavailable(t *testing.T) {
+	t.Parallel()
+	err := &UnavailableError{RetryAfter: 250 * time.Millisecond}
+	parsed, ok := ParseUnavailable(err.Error())
+	_assert(ok && parsed.RetryAfter == err.RetryAfter, "expect retry-after restored, got %v", parsed)
+	_, ok = ParseUnavailable("rpc server: unavailable, retry after soon")
+	_assert(!ok, "expect invalid duration rejected")
+}
+
+func TestOverloadController_Load(t *testing.T) {
+	t.Parallel()
+	o := &OverloadController{MaxInFlight: 2, MaxQueueLatency: 10 * time.Millisecond}
+	_assert(o.admit() && o.admit(), "expect requests admitted under the limit")
+	_assert(o.Load() == 1, "expect load 1, got %v", o.Load())
+	o.done(0)
+	o.done(0)
+	for i := 0; i < 20; i++ {
+		o.inFlight++
+		o.done(40 * time.Millisecond)
+	}
+	_assert(o.Load() > 1, "expect queue latency to overload, got %v", o.Load())
+}
+
+func TestServer_Overload(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.Overload = &OverloadController{MaxInFlight: 1, RetryAfter: 50 * time.Millisecond}
+	var s Sleeper
+	_ = server.Register(&s)
+	addr := startLimitedServer(server)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	busy := client.Go("Sleeper.Sleep", 200, &reply, make(chan *Call, 1))
+	time.Sleep(20 * time.Millisecond)
+	calls := make([]*Call, 20)
+	for i := range calls {
+		calls[i] = client.Go("Sleeper.Sleep", 0, new(int), make(chan *Call, 1))
+	}
+	var rejected int
+	for _, call := range calls {
+		<-call.Done
+		var unavailable *UnavailableError
+		if errors.As(call.Error, &unavailable) {
+			_assert(unavailable.RetryAfter == 50*time.Millisecond, "expect retry-after hint, got %v", unavailable.RetryAfter)
+			d, ok := RetryAfter(call.Error)
+			_assert(ok && d == 50*time.Millisecond && errors.Is(call.Error, ErrUnavailable), "expect retry-after in status details, got %v", call.Error)
+			rejected++
+		}
+	}
+	_assert(rejected > 0 && uint64(rejected) == server.Overload.Rejected(), "expect some requests rejected, got %d", rejected)
+	var ok int
+	_assert(client.Call(context.Background(), HealthServiceName+".Ping", 1, &ok) == nil, "expect builtin services not limited")
+	<-busy.Done
+	_assert(busy.Error == nil, "expect accepted request to finish: %v", busy.Error)
+}
@@ -0,0 +1,216 @@
+package gpmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+//ProxyDialer 返回经过 proxyURL 指定的代理建立连接的 DialFunc，用于后端只能通过跳板机访问的环境。
+//proxyURL 的格式为 socks5://[user:password@]host:port 或者 http://[user:password@]host:port，
+//后者使用 HTTP CONNECT。forward 为到代理自身的连接方式，为空时使用 net.Dialer
+func ProxyDialer(proxyURL string, forward DialFunc) (DialFunc, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("rpc client: invalid proxy %q: %v", proxyURL, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("rpc client: invalid proxy %q: missing host", proxyURL)
+	}
+	if forward == nil {
+		forward = (&net.Dialer{}).DialContext
+	}
+	var handshake func(conn net.Conn, address string) (net.Conn, error)
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		handshake = func(conn net.Conn, address string) (net.Conn, error) {
+			return conn, socks5Handshake(conn, address, u.User)
+		}
+	case "http":
+		handshake = func(conn net.Conn, address string) (net.Conn, error) {
+			return httpConnectHandshake(conn, address, u.User)
+		}
+	default:
+		return nil, fmt.Errorf("rpc client: unsupported proxy scheme %q", u.Scheme)
+	}
+	proxyAddr := u.Host
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if network != "tcp" && network != "tcp4" && network != "tcp6" {
+			return nil, fmt.Errorf("rpc client: proxy does not support network %s", network)
+		}
+		conn, err := forward(ctx, "tcp", proxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		//ctx 同时限制和代理之间的握手，握手完成后清除截止时间
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = conn.SetDeadline(deadline)
+		}
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				_ = conn.SetDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+		proxied, err := handshake(conn, address)
+		close(stop)
+		<-stopped
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("rpc client: proxy %s: %w", proxyAddr, err)
+		}
+		_ = conn.SetDeadline(time.Time{})
+		return proxied, nil
+	}, nil
+}
+
+//WithProxy 经过 proxyURL 指定的 SOCKS5 或者 HTTP CONNECT 代理建立连接，见 ProxyDialer。
+//之前通过 WithDialer 设置的 DialFunc 用来连接代理自身
+func WithProxy(proxyURL string) ClientOption {
+	return func(opt *Option) error {
+		dial, err := ProxyDialer(proxyURL, opt.Dialer)
+		if err != nil {
+			return err
+		}
+		opt.Dialer = dial
+		return nil
+	}
+}
+
+//socks5Handshake 按照 RFC 1928 请求代理连接 address，user 不为空时使用 RFC 1929 的用户名密码认证。
+//主机名交给代理解析
+func socks5Handshake(conn net.Conn, address string, user *url.Userinfo) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 0xffff {
+		return fmt.Errorf("socks5: invalid port %q", portStr)
+	}
+	method := byte(0x00) //不需要认证
+	if user != nil {
+		method = 0x02 //用户名密码
+	}
+	if _, err = conn.Write([]byte{0x05, 0x01, method}); err != nil {
+		return err
+	}
+	var reply [2]byte
+	if _, err = io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("socks5: unexpected version %d", reply[0])
+	}
+	if reply[1] != method {
+		return errors.New("socks5: no acceptable authentication method")
+	}
+	if user != nil {
+		password, _ := user.Password()
+		name := user.Username()
+		if len(name) > 255 || len(password) > 255 {
+			return errors.New("socks5: username or password too long")
+		}
+		auth := []byte{0x01, byte(len(name))}
+		auth = append(auth, name...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err = conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, reply[:]); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("socks5: authentication failed")
+		}
+	}
+
+	req := []byte{0x05, 0x01, 0x00} //CONNECT
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("socks5: host name too long")
+		}
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 0x01)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+	var head [4]byte
+	if _, err = io.ReadFull(conn, head[:]); err != nil {
+		return err
+	}
+	if head[1] != 0x00 {
+		return fmt.Errorf("socks5: connect %s failed with code %d", address, head[1])
+	}
+	//跳过代理绑定的地址和端口
+	var skip int
+	switch head[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		var n [1]byte
+		if _, err = io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return fmt.Errorf("socks5: unexpected address type %d", head[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+//httpConnectHandshake 通过 HTTP CONNECT 请求代理连接 address，user 不为空时携带 Basic 认证
+func httpConnectHandshake(conn net.Conn, address string, user *url.Userinfo) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http connect %s: %s", address, resp.Status)
+	}
+	if br.Buffered() > 0 {
+		//代理在回复之后紧接着转发了后端的数据
+		return &prefixConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,186 @@
+package gpmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//startSocks5Proxy 只支持 CONNECT 的 SOCKS5 代理，user 不为空时要求用户名密码认证
+func startSocks5Proxy(t *testing.T, user, password string) (string, *int32) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { _ = l.Close() })
+	var tunnels int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				r := bufio.NewReader(conn)
+				head := make([]byte, 2)
+				_, _ = io.ReadFull(r, head)
+				methods := make([]byte, head[1])
+				_, _ = io.ReadFull(r, methods)
+				if user == "" {
+					_, _ = conn.Write([]byte{0x05, 0x00})
+				} else {
+					_, _ = conn.Write([]byte{0x05, 0x02})
+					_, _ = io.ReadFull(r, head)
+					name := make([]byte, head[1])
+					_, _ = io.ReadFull(r, name)
+					n, _ := r.ReadByte()
+					pass := make([]byte, n)
+					_, _ = io.ReadFull(r, pass)
+					if string(name) != user || string(pass) != password {
+						_, _ = conn.Write([]byte{0x01, 0x01})
+						return
+					}
+					_, _ = conn.Write([]byte{0x01, 0x00})
+				}
+				req := make([]byte, 4)
+				_, _ = io.ReadFull(r, req)
+				var host string
+				switch req[3] {
+				case 0x01:
+					ip := make([]byte, 4)
+					_, _ = io.ReadFull(r, ip)
+					host = net.IP(ip).String()
+				case 0x03:
+					n, _ := r.ReadByte()
+					name := make([]byte, n)
+					_, _ = io.ReadFull(r, name)
+					host = string(name)
+				}
+				port := make([]byte, 2)
+				_, _ = io.ReadFull(r, port)
+				backend, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))))
+				if err != nil {
+					_, _ = conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer func() { _ = backend.Close() }()
+				atomic.AddInt32(&tunnels, 1)
+				_, _ = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+				go func() { _, _ = io.Copy(backend, r) }()
+				_, _ = io.Copy(conn, backend)
+			}()
+		}
+	}()
+	return l.Addr().String(), &tunnels
+}
+
+//startConnectProxy HTTP CONNECT 代理，auth 不为空时要求 Proxy-Authorization
+func startConnectProxy(t *testing.T, auth string) (string, *int32) {
+	var tunnels int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodConnect {
+			http.Error(w, "connect only", http.StatusMethodNotAllowed)
+			return
+		}
+		if auth != "" && req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)) {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		backend, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, rw, _ := w.(http.Hijacker).Hijack()
+		atomic.AddInt32(&tunnels, 1)
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			_, _ = io.Copy(backend, rw)
+			_ = backend.Close()
+		}()
+		_, _ = io.Copy(conn, backend)
+		_ = conn.Close()
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy.Listener.Addr().String(), &tunnels
+}
+
+func TestProxyDialer(t *testing.T) {
+	t.Parallel()
+	addr := startLimitedServer(NewServer())
+	socks, socksTunnels := startSocks5Proxy(t, "", "")
+	socksAuth, socksAuthTunnels := startSocks5Proxy(t, "alice", "secret")
+	connect, connectTunnels := startConnectProxy(t, "")
+	connectAuth, connectAuthTunnels := startConnectProxy(t, "alice:secret")
+	cases := []struct {
+		url     string
+		tunnels *int32
+		ok      bool
+	}{
+		{"socks5://" + socks, socksTunnels, true},
+		{"socks5://alice:secret@" + socksAuth, socksAuthTunnels, true},
+		{"socks5://alice:wrong@" + socksAuth, socksAuthTunnels, false},
+		{"http://" + connect, connectTunnels, true},
+		{"http://alice:secret@" + connectAuth, connectAuthTunnels, true},
+		{"http://" + connectAuth, connectAuthTunnels, false},
+	}
+	for _, c := range cases {
+		before := atomic.LoadInt32(c.tunnels)
+		opt, err := NewOption(WithProxy(c.url))
+		_assert(err == nil, "%s: option error: %v", c.url, err)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		client, err := DialContext(ctx, "tcp", addr, opt)
+		cancel()
+		if !c.ok {
+			_assert(err != nil, "%s: expect the proxy to reject the credentials", c.url)
+			continue
+		}
+		_assert(err == nil, "%s: dial error: %v", c.url, err)
+		_assert(callSum(client) == nil, "%s: call through the proxy failed", c.url)
+		_assert(atomic.LoadInt32(c.tunnels) == before+1, "%s: expect the connection to go through the proxy", c.url)
+		_ = client.Close()
+	}
+
+	//主机名交给代理解析
+	opt, _ := NewOption(WithProxy("socks5://" + socks))
+	client, err := Dial("tcp", "localhost:"+addr[len("127.0.0.1:"):], opt)
+	_assert(err == nil && callSum(client) == nil, "expect host names resolved by the proxy, got %v", err)
+	_ = client.Close()
+
+	for _, bad := range []string{"ftp://" + socks, "socks5://", "://"} {
+		_, err := ProxyDialer(bad, nil)
+		_assert(err != nil, "expect invalid proxy %q rejected", bad)
+	}
+	_, err = (&Config{Proxy: "ftp://" + socks}).Option()
+	_assert(err != nil, "expect Config.Proxy validated")
+}
+
+func TestProxyDialer_ContextDeadline(t *testing.T) {
+	t.Parallel()
+	//代理接受连接之后不回复，握手只受 ctx 限制
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(ioutil.Discard, conn) }()
+		}
+	}()
+	dial, err := ProxyDialer("socks5://"+l.Addr().String(), nil)
+	_assert(err == nil, "proxy dialer error: %v", err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = dial(ctx, "tcp", "127.0.0.1:1")
+	_assert(err != nil && time.Since(start) < time.Second, "expect ctx to bound the proxy handshake, got %v", err)
+}
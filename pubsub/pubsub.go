@@ -0,0 +1,199 @@
+//Package pubsub 在 gpmd 的推送能力之上实现发布订阅：
+//服务端通过 Broker 公开主题并发布消息，客户端订阅后从 channel 中接收消息。
+//消息最多投递一次，连接断开或者客户端缓冲区满时消息会被丢弃，
+//公开主题时可以保留最近的 N 条消息，新的订阅者可以选择先收到这些历史消息。
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gpmd"
+	"sort"
+	"sync"
+)
+
+//ServiceName Broker 注册到服务端的服务名
+const ServiceName = "PubSub"
+
+//SubscribeArgs 订阅请求
+type SubscribeArgs struct {
+	Topic  string
+	Replay int //希望补发的历史消息条数，不超过主题保留的条数
+}
+
+type topic struct {
+	name    string
+	keep    int           //保留的历史消息条数
+	history []interface{} //最近的消息，按照发布顺序排列
+	seq     uint64        //最后分配的推送序号，在 Broker.mu 下分配
+
+	//推送在 Broker.mu 之外进行，按照序号依次执行，保证补发的历史消息和新消息之间不会乱序
+	turnMu sync.Mutex
+	turn   *sync.Cond
+	sent   uint64 //已经推送完的序号
+}
+
+func newTopic(name string) *topic {
+	t := &topic{name: name}
+	t.turn = sync.NewCond(&t.turnMu)
+	return t
+}
+
+//next 分配下一个推送序号，调用方持有 Broker.mu
+func (t *topic) next() uint64 {
+	t.seq++
+	return t.seq
+}
+
+//wait 等待序号在 seq 之前的推送全部完成
+func (t *topic) wait(seq uint64) {
+	t.turnMu.Lock()
+	for t.sent != seq-1 {
+		t.turn.Wait()
+	}
+	t.turnMu.Unlock()
+}
+
+//done 标记序号为 seq 的推送完成，轮到下一个
+func (t *topic) done(seq uint64) {
+	t.turnMu.Lock()
+	t.sent = seq
+	t.turnMu.Unlock()
+	t.turn.Broadcast()
+}
+
+//Broker 服务端的发布订阅中心
+type Broker struct {
+	server *gpmd.Server
+	mu     sync.Mutex
+	topics map[string]*topic
+}
+
+//PubSub 是 Broker 注册到服务端的服务，客户端通过 pubsub.Subscribe 调用
+type PubSub struct {
+	broker *Broker
+}
+
+//NewBroker 创建 Broker 并将 PubSub 服务注册到 server
+func NewBroker(server *gpmd.Server) (*Broker, error) {
+	b := &Broker{server: server, topics: make(map[string]*topic)}
+	if err := server.Register(&PubSub{broker: b}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+//Expose 公开一个主题，keep 为保留的历史消息条数，不大于 0 表示不保留。重复公开时更新 keep
+func (b *Broker) Expose(name string, keep int) {
+	if keep < 0 {
+		keep = 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topics[name]
+	if t == nil {
+		t = newTopic(name)
+		b.topics[name] = t
+	}
+	t.keep = keep
+	if len(t.history) > keep {
+		t.history = t.history[len(t.history)-keep:]
+	}
+}
+
+//Topics 返回所有公开的主题
+func (b *Broker) Topics() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := make([]string, 0, len(b.topics))
+	for name := range b.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Publish 向公开的主题发布消息，返回推送到的订阅者数量
+func (b *Broker) Publish(name string, msg interface{}) (int, error) {
+	b.mu.Lock()
+	t := b.topics[name]
+	if t == nil {
+		b.mu.Unlock()
+		return 0, errors.New("pubsub: topic not exposed: " + name)
+	}
+	if t.keep > 0 {
+		t.history = append(t.history, msg)
+		if len(t.history) > t.keep {
+			t.history = t.history[len(t.history)-t.keep:]
+		}
+	}
+	seq := t.next()
+	b.mu.Unlock()
+	//慢的订阅者只阻塞同一个主题的推送，不阻塞 Broker 的其他操作
+	t.wait(seq)
+	defer t.done(seq)
+	return b.server.Publish(name, msg), nil
+}
+
+//Subscribe 订阅主题，reply 为实际补发的历史消息条数
+func (p *PubSub) Subscribe(ctx context.Context, args SubscribeArgs, reply *int) error {
+	c, ok := gpmd.ConnFromContext(ctx)
+	if !ok {
+		return errors.New("pubsub: subscribe requires a connection")
+	}
+	if args.Replay < 0 {
+		return fmt.Errorf("pubsub: invalid replay count %d", args.Replay)
+	}
+	b := p.broker
+	b.mu.Lock()
+	t := b.topics[args.Topic]
+	if t == nil {
+		b.mu.Unlock()
+		return errors.New("pubsub: topic not exposed: " + args.Topic)
+	}
+	replay := t.history
+	if args.Replay < len(replay) {
+		replay = replay[len(replay)-args.Replay:]
+	}
+	//history 只会追加或者截掉开头，快照在释放锁之后仍然有效
+	seq := t.next()
+	b.mu.Unlock()
+	//轮到这个序号时再订阅：序号在前的消息已经在快照中，序号在后的消息会在补发之后推送
+	t.wait(seq)
+	defer t.done(seq)
+	b.server.SubscribeConn(c, args.Topic)
+	for _, msg := range replay {
+		if err := c.Push(args.Topic, msg); err != nil {
+			return err
+		}
+		*reply++
+	}
+	return nil
+}
+
+//Topics 返回所有公开的主题
+func (p *PubSub) Topics(_ struct{}, reply *[]string) error {
+	*reply = p.broker.Topics()
+	return nil
+}
+
+//Option 客户端订阅的参数
+type Option struct {
+	Buffer int //接收消息的 channel 缓冲区大小，缓冲区满时新消息会被丢弃
+	Replay int //希望补发的历史消息条数
+}
+
+//Subscribe 通过 client 订阅服务端 Broker 公开的主题，msgType 为消息类型的示例，
+//返回的 Subscription.C 中收到的是指向该类型的指针，通过 Subscription.Unsubscribe 取消订阅
+func Subscribe(ctx context.Context, client *gpmd.Client, topic string, msgType interface{}, opt Option) (*gpmd.Subscription, error) {
+	var replayed int
+	return client.SubscribeVia(ctx, ServiceName+".Subscribe", SubscribeArgs{Topic: topic, Replay: opt.Replay}, &replayed, topic, msgType, opt.Buffer)
+}
+
+//Topics 查询服务端公开的主题
+func Topics(ctx context.Context, client *gpmd.Client) ([]string, error) {
+	var topics []string
+	err := client.Call(ctx, ServiceName+".Topics", struct{}{}, &topics)
+	return topics, err
+}
@@ -0,0 +1,196 @@
+package pubsub
+
+import (
+	"context"
+	"gpmd"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBroker(t *testing.T) {
+	server := gpmd.NewServer()
+	b, err := NewBroker(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Expose("events", 2)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, err := gpmd.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if _, err := b.Publish("events", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.Publish("unknown", "x"); err == nil {
+		t.Fatal("expect error publishing to an unexposed topic")
+	}
+	if _, err := Subscribe(context.Background(), client, "unknown", "", Option{}); err == nil {
+		t.Fatal("expect error subscribing to an unexposed topic")
+	}
+
+	sub, err := Subscribe(context.Background(), client, "events", "", Option{Buffer: 8, Replay: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Publish("events", "d"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"b", "c", "d"} {
+		select {
+		case msg := <-sub.C:
+			if got := *msg.(*string); got != want {
+				t.Fatalf("expect %s, got %s", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect message %s", want)
+		}
+	}
+
+	topics, err := Topics(context.Background(), client)
+	if err != nil || len(topics) != 1 || topics[0] != "events" {
+		t.Fatalf("unexpected topics %v %v", topics, err)
+	}
+	if err := sub.Unsubscribe(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := b.Publish("events", "e"); n != 0 {
+		t.Fatalf("expect no subscriber after unsubscribe, got %d", n)
+	}
+}
+
+func TestBroker_InvalidCounts(t *testing.T) {
+	server := gpmd.NewServer()
+	b, err := NewBroker(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Expose("events", 2)
+	_, _ = b.Publish("events", "a")
+	//负数的 keep 等同于不保留
+	b.Expose("events", -1)
+	b.Expose("other", -1)
+	if _, err := b.Publish("other", "x"); err != nil {
+		t.Fatal(err)
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, err := gpmd.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var replayed int
+	if err := client.Call(context.Background(), ServiceName+".Subscribe", SubscribeArgs{Topic: "events", Replay: -1}, &replayed); err == nil {
+		t.Fatal("expect a negative replay count rejected")
+	}
+	if err := client.Call(context.Background(), ServiceName+".Subscribe", SubscribeArgs{Topic: "events", Replay: 5}, &replayed); err != nil || replayed != 0 {
+		t.Fatalf("expect no history kept after a negative keep, got %d %v", replayed, err)
+	}
+}
+
+//stallConn 在 hold 之后停止读取，使服务端向它推送时阻塞
+type stallConn struct {
+	net.Conn
+	held    int32
+	release chan struct{}
+}
+
+func (c *stallConn) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.held) == 1 {
+		<-c.release
+	}
+	return c.Conn.Read(p)
+}
+
+func TestBroker_SlowSubscriber(t *testing.T) {
+	server := gpmd.NewServer()
+	b, err := NewBroker(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Expose("slow", 0)
+	b.Expose("fast", 0)
+	l := gpmd.NewLocalListener()
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	stall := &stallConn{release: make(chan struct{})}
+	slowOpt := *gpmd.DefaultOption
+	slowOpt.Dialer = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := l.Dial(ctx, network, address)
+		stall.Conn = conn
+		return stall, err
+	}
+	slow, err := gpmd.Dial("local", "broker", &slowOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = slow.Close() }()
+	fastOpt := *gpmd.DefaultOption
+	fastOpt.Dialer = l.Dial
+	fast, err := gpmd.Dial("local", "broker", &fastOpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = fast.Close() }()
+	if _, err := Subscribe(context.Background(), slow, "slow", "", Option{Buffer: 8}); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := Subscribe(context.Background(), fast, "fast", "", Option{Buffer: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&stall.held, 1)
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		//正在进行的读取会收下第一条，第二条推送阻塞
+		_, _ = b.Publish("slow", "x")
+		_, _ = b.Publish("slow", "x")
+	}()
+	//等推送阻塞在慢的连接上
+	time.Sleep(50 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Expose("other", 1)
+		_ = b.Topics()
+		_, _ = b.Publish("fast", "y")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expect a stalled subscriber not to block other topics")
+	}
+	select {
+	case msg := <-sub.C:
+		if got := *msg.(*string); got != "y" {
+			t.Fatalf("expect y, got %s", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expect the fast subscriber to receive its message")
+	}
+	select {
+	case <-published:
+		t.Fatal("expect the publish to the stalled subscriber to block")
+	default:
+	}
+	close(stall.release)
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("expect the stalled publish to finish after the subscriber reads again")
+	}
+}
@@ -0,0 +1,211 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"gpmd/codec"
+	"reflect"
+	"sync/atomic"
+)
+
+//PushSeqBase 服务端主动推送的消息使用 [PushSeqBase, MaxUint64] 范围内的 Seq，
+//客户端的请求编号从 1 开始递增，不会进入这个范围。推送消息的 Header.ServiceMethod 为主题名
+const PushSeqBase uint64 = 1 << 63
+
+//PushServiceName 内置的订阅服务，客户端通过它在连接上订阅和取消订阅主题
+const PushServiceName = "_gpmd_.Push"
+
+var errConnNotSupported = errors.New("rpc server: push requires a connection context")
+
+type pushService struct {
+	server *Server
+}
+
+//Subscribe 订阅主题，之后服务端 Publish 到该主题的消息会推送到这个连接
+func (p *pushService) Subscribe(ctx context.Context, topic string, reply *bool) error {
+	c, ok := ConnFromContext(ctx)
+	if !ok {
+		return errConnNotSupported
+	}
+	p.server.SubscribeConn(c, topic)
+	*reply = true
+	return nil
+}
+
+//Unsubscribe 取消订阅主题
+func (p *pushService) Unsubscribe(ctx context.Context, topic string, reply *bool) error {
+	c, ok := ConnFromContext(ctx)
+	if !ok {
+		return errConnNotSupported
+	}
+	p.server.UnsubscribeConn(c, topic)
+	*reply = true
+	return nil
+}
+
+//SubscribeConn 让连接订阅主题，用于在推送之上实现自定义的订阅服务
+func (s *Server) SubscribeConn(c *Conn, topic string) {
+	s.topicMu.Lock()
+	defer s.topicMu.Unlock()
+	conns := s.topics[topic]
+	if conns == nil {
+		conns = make(map[*Conn]struct{})
+		s.topics[topic] = conns
+	}
+	conns[c] = struct{}{}
+}
+
+//UnsubscribeConn 取消连接对主题的订阅
+func (s *Server) UnsubscribeConn(c *Conn, topic string) {
+	s.topicMu.Lock()
+	defer s.topicMu.Unlock()
+	if conns := s.topics[topic]; conns != nil {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(s.topics, topic)
+		}
+	}
+}
+
+//unsubscribeAll 连接关闭时取消它的所有订阅
+func (s *Server) unsubscribeAll(c *Conn) {
+	s.topicMu.Lock()
+	defer s.topicMu.Unlock()
+	for topic, conns := range s.topics {
+		delete(conns, c)
+		if len(conns) == 0 {
+			delete(s.topics, topic)
+		}
+	}
+}
+
+//Publish 将消息推送给所有订阅了该主题的连接，返回推送成功的连接数。
+//推送不等待客户端确认，连接断开或者客户端处理不过来时消息会被丢弃
+func (s *Server) Publish(topic string, msg interface{}) int {
+	s.topicMu.Lock()
+	conns := make([]*Conn, 0, len(s.topics[topic]))
+	for c := range s.topics[topic] {
+		conns = append(conns, c)
+	}
+	s.topicMu.Unlock()
+	n := 0
+	for _, c := range conns {
+		if err := c.Push(topic, msg); err != nil {
+			logf(LogWarn, "rpc server: push %s to connection %d error: %v", topic, c.ID, err)
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+//Push 向这个连接推送一条消息，不要求客户端订阅了该主题，没有订阅的客户端会丢弃消息
+func (c *Conn) Push(topic string, msg interface{}) error {
+	if c.cc == nil {
+		return errConnNotSupported
+	}
+	h := &codec.Header{
+		ServiceMethod: topic,
+		Seq:           PushSeqBase + atomic.AddUint64(&c.pushSeq, 1)%PushSeqBase,
+	}
+	c.sending.Lock()
+	defer c.sending.Unlock()
+	return c.cc.Write(h, msg)
+}
+
+//Subscription 客户端的一个订阅，C 中收到的是指向订阅时指定类型的指针
+type Subscription struct {
+	Topic  string
+	C      <-chan interface{}
+	ch     chan interface{}
+	typ    reflect.Type
+	client *Client
+}
+
+//Subscribe 订阅主题，msgType 是消息的类型示例（比如 "" 或者 CacheEvent{}），C 中收到的是 *string 或者 *CacheEvent。
+//buffer 是 C 的缓冲区大小，缓冲区满时新消息会被丢弃，客户端关闭时 C 会被关闭
+func (client *Client) Subscribe(ctx context.Context, topic string, msgType interface{}, buffer int) (*Subscription, error) {
+	var ok bool
+	return client.SubscribeVia(ctx, PushServiceName+".Subscribe", topic, &ok, topic, msgType, buffer)
+}
+
+//SubscribeVia 与 Subscribe 相同，但是通过调用 serviceMethod(args, reply) 完成服务端的订阅，
+//服务端的实现需要调用 Server.SubscribeConn，用于在推送之上实现自定义的订阅服务
+func (client *Client) SubscribeVia(ctx context.Context, serviceMethod string, args, reply interface{}, topic string, msgType interface{}, buffer int) (*Subscription, error) {
+	if msgType == nil {
+		return nil, errors.New("rpc client: subscribe requires a message type")
+	}
+	ch := make(chan interface{}, buffer)
+	sub := &Subscription{Topic: topic, C: ch, ch: ch, typ: reflect.TypeOf(msgType), client: client}
+	client.mu.Lock()
+	if client.closing || client.shutdown {
+		client.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	if client.subs[topic] != nil {
+		client.mu.Unlock()
+		return nil, errors.New("rpc client: already subscribed to " + topic)
+	}
+	//先在本地登记订阅，避免错过服务端在订阅成功后立刻推送的消息
+	client.subs[topic] = sub
+	client.mu.Unlock()
+	if err := client.Call(ctx, serviceMethod, args, reply); err != nil {
+		client.removeSubscription(sub)
+		return nil, err
+	}
+	return sub, nil
+}
+
+//Unsubscribe 取消订阅并关闭 C
+func (sub *Subscription) Unsubscribe(ctx context.Context) error {
+	if !sub.client.removeSubscription(sub) {
+		return nil
+	}
+	var ok bool
+	return sub.client.Call(ctx, PushServiceName+".Unsubscribe", sub.Topic, &ok)
+}
+
+func (client *Client) removeSubscription(sub *Subscription) bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.subs[sub.Topic] != sub {
+		return false
+	}
+	delete(client.subs, sub.Topic)
+	close(sub.ch)
+	return true
+}
+
+//receivePush 读取一条推送消息并投递给对应的订阅
+func (client *Client) receivePush(h *codec.Header) error {
+	client.mu.Lock()
+	sub := client.subs[h.ServiceMethod]
+	client.mu.Unlock()
+	if sub == nil {
+		return client.cc.ReadBody(nil)
+	}
+	msg := reflect.New(sub.typ)
+	if err := client.cc.ReadBody(msg.Interface()); err != nil {
+		return err
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	//读取消息期间订阅可能已经取消
+	if client.subs[h.ServiceMethod] != sub {
+		return nil
+	}
+	select {
+	case sub.ch <- msg.Interface():
+	default:
+		logf(LogWarn, "rpc client: subscription %s is full, message dropped", sub.Topic)
+	}
+	return nil
+}
+
+//closeSubscriptions 连接断开时关闭所有订阅
+func (client *Client) closeSubscriptions() {
+	for topic, sub := range client.subs {
+		delete(client.subs, topic)
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,81 @@
+package gpmd
+
+import (
+	"context"
+	"gpmd/codec"
+	"net"
+	"testing"
+	"time"
+)
+
+type CacheEvent struct {
+	Key string
+}
+
+func TestServer_Publish(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	client, _ := Dial("tcp", l.Addr().String())
+
+	sub, err := client.Subscribe(context.Background(), "cache", CacheEvent{}, 4)
+	_assert(err == nil, "failed to subscribe: %v", err)
+	_, err = client.Subscribe(context.Background(), "cache", CacheEvent{}, 4)
+	_assert(err != nil, "duplicate subscription should fail")
+
+	_assert(server.Publish("cache", &CacheEvent{Key: "user:1"}) == 1, "expect 1 subscriber")
+	_assert(server.Publish("other", &CacheEvent{Key: "user:2"}) == 0, "expect no subscriber")
+	select {
+	case msg := <-sub.C:
+		_assert(msg.(*CacheEvent).Key == "user:1", "wrong message %v", msg)
+	case <-time.After(time.Second):
+		t.Fatal("expect a pushed message")
+	}
+
+	//推送和普通调用共用一个连接
+	var services []ServiceInfo
+	err = client.Call(context.Background(), ReflectionServiceName+".ListServices", struct{}{}, &services)
+	_assert(err == nil && len(services) > 0, "call should work after push: %v", err)
+
+	_assert(sub.Unsubscribe(context.Background()) == nil, "failed to unsubscribe")
+	_, ok := <-sub.C
+	_assert(!ok, "channel should be closed after unsubscribe")
+	_assert(server.Publish("cache", &CacheEvent{Key: "user:3"}) == 0, "expect no subscriber after unsubscribe")
+
+	sub, _ = client.Subscribe(context.Background(), "cache", CacheEvent{}, 4)
+	_ = client.Close()
+	_, ok = <-sub.C
+	_assert(!ok, "channel should be closed after client closed")
+}
+
+func TestClient_PushTypeMismatch(t *testing.T) {
+	t.Parallel()
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		server := NewServer()
+		var foo Foo
+		_ = server.Register(&foo)
+		client, err := NewLocalPair(server, &Option{CodeType: typ})
+		_assert(err == nil, "local pair error: %v", err)
+		sub, err := client.Subscribe(context.Background(), "cache", CacheEvent{}, 4)
+		_assert(err == nil, "failed to subscribe: %v", err)
+
+		//推送的类型与订阅的类型不一致时只丢弃这条消息，连接继续可用
+		_assert(server.Publish("cache", []int{1, 2}) == 1, "expect 1 subscriber")
+		_assert(server.Publish("cache", &CacheEvent{Key: "user:1"}) == 1, "expect 1 subscriber")
+		select {
+		case msg := <-sub.C:
+			_assert(msg.(*CacheEvent).Key == "user:1", "%s: wrong message %v", typ, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expect the matching message after a mismatched one", typ)
+		}
+		_assert(callSum(client) == nil, "%s: expect the connection usable after a mismatched push", typ)
+
+		//回复的类型不匹配时这次调用失败，连接同样继续可用
+		var wrong string
+		err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &wrong)
+		_assert(err != nil && isTypeMismatch(err), "%s: expect a type mismatch, got %v", typ, err)
+		_assert(callSum(client) == nil, "%s: expect the connection usable after a mismatched reply", typ)
+		_ = client.Close()
+	}
+}
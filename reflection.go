@@ -0,0 +1,137 @@
+package gpmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gpmd/codec"
+	"reflect"
+	"sort"
+)
+
+//ReflectionServiceName 内置反射服务的名称，每个 Server 创建时都会自动注册，
+//调试工具可以通过它查询服务端注册的所有服务和方法，例如:
+//client.Call(ctx, ReflectionServiceName+".ListServices", struct{}{}, &services)
+const ReflectionServiceName = "_gpmd_.Reflection"
+
+const reflectionInvoke = ReflectionServiceName + ".Invoke"
+
+//MethodInfo 描述一个可以被远程调用的方法
+type MethodInfo struct {
+	Name      string //方法名
+	ArgType   string //入参类型
+	ReplyType string //返回值类型
+}
+
+//ServiceInfo 描述一个注册到服务端的服务
+type ServiceInfo struct {
+	Name    string
+	Methods []MethodInfo
+}
+
+type reflection struct {
+	server *Server
+}
+
+//ListServices 返回服务端注册的所有服务，按照服务名和方法名排序
+func (r *reflection) ListServices(_ struct{}, reply *[]ServiceInfo) error {
+	var services []ServiceInfo
+	r.server.serviceMap.Range(func(_, svci interface{}) bool {
+		svc := svci.(*service)
+		info := ServiceInfo{Name: svc.name}
+		for name, mType := range svc.method {
+			info.Methods = append(info.Methods, MethodInfo{
+				Name:      name,
+				ArgType:   mType.ArgType.String(),
+				ReplyType: mType.ReplyType.String(),
+			})
+		}
+		sort.Slice(info.Methods, func(i, j int) bool { return info.Methods[i].Name < info.Methods[j].Name })
+		services = append(services, info)
+		return true
+	})
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	*reply = services
+	return nil
+}
+
+//InvokeArgs 通过反射服务动态调用 ServiceMethod，参数和返回值使用 JSON 编码，与连接的编码方式无关
+type InvokeArgs struct {
+	ServiceMethod string
+	Args          []byte //JSON 编码的参数，为空时等同于 null
+}
+
+//Invoke 只用于在 ListServices 中列出，请求在读取时就被改写为对目标方法的调用，见 Server.readInvokeRequest
+func (r *reflection) Invoke(_ context.Context, _ InvokeArgs, _ *[]byte) error {
+	return errors.New("rpc server: Invoke must be dispatched by the server")
+}
+
+//readInvokeRequest 读取 Invoke 请求并改写为对 args.ServiceMethod 的调用，之后与直接调用目标方法一样
+//经过 Draining、Overload、串行队列、优先级、中间件和 Server.Authorizer，只是参数和返回值使用 JSON 编码，
+//未注册的方法交给 UnknownServiceHandler
+func (s *Server) readInvokeRequest(cc codec.Codec, req *request) (*request, error) {
+	var args InvokeArgs
+	if err := cc.ReadBody(&args); err != nil {
+		return req, err
+	}
+	if args.ServiceMethod == reflectionInvoke {
+		return req, errors.New("rpc server: Invoke can not invoke itself")
+	}
+	req.h.ServiceMethod = args.ServiceMethod
+	req.rawArgs = args.Args
+	if len(req.rawArgs) == 0 {
+		req.rawArgs = []byte("null")
+	}
+	var err error
+	req.svc, req.mType, err = s.findService(args.ServiceMethod)
+	if err != nil {
+		if req.unknown = s.unknownServiceHandler(); req.unknown != nil {
+			return req, nil
+		}
+		return req, err
+	}
+	req.argv, req.replyv = req.mType.newArgv(), req.mType.newReply()
+	argvInterface := req.argv.Interface()
+	if req.argv.Type().Kind() != reflect.Ptr {
+		argvInterface = req.argv.Addr().Interface()
+	}
+	if err = json.Unmarshal(req.rawArgs, argvInterface); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+//marshalReply 把 Invoke 请求的返回值编码为 JSON
+func (req *request) marshalReply() error {
+	var reply interface{}
+	if req.replyv.Interface() != invalidRequest {
+		reply = req.replyv.Interface()
+	}
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	req.replyv = reflect.ValueOf(data)
+	return nil
+}
+
+//CallRaw 使用 JSON 编码的参数调用 serviceMethod 并返回 JSON 编码的返回值，调用方不需要知道参数和返回值的 Go 类型，
+//适合调试工具和网关。连接使用 codec.JsonType 时直接发送，其他编码方式通过反射服务的 Invoke 转换
+func (client *Client) CallRaw(ctx context.Context, serviceMethod string, argsJSON []byte) ([]byte, error) {
+	if client.opt.CodeType == codec.JsonType {
+		args := json.RawMessage(argsJSON)
+		if len(args) == 0 {
+			args = json.RawMessage("null")
+		}
+		var reply json.RawMessage
+		if err := client.Call(ctx, serviceMethod, args, &reply); err != nil {
+			return nil, err
+		}
+		return reply, nil
+	}
+	var reply []byte
+	if err := client.Call(ctx, ReflectionServiceName+".Invoke", InvokeArgs{ServiceMethod: serviceMethod, Args: argsJSON}, &reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
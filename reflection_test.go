@@ -0,0 +1,95 @@
+package gpmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gpmd/codec"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestReflection_ListServices(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{CodeType: codec.JsonType})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var services []ServiceInfo
+	err = client.Call(context.Background(), ReflectionServiceName+".ListServices", struct{}{}, &services)
+	_assert(err == nil && len(services) == 4, "expect 4 services, got %v %v", services, err)
+	_assert(services[0].Name == "Foo" && services[0].Methods[0].Name == "Sum", "expect Foo.Sum, got %v", services[0])
+	_assert(services[0].Methods[0].ArgType == "gpmd.Args", "wrong arg type %s", services[0].Methods[0].ArgType)
+
+	var reply json.RawMessage
+	err = client.Call(context.Background(), "Foo.Sum", json.RawMessage(`{"Num1":1,"Num2":2}`), &reply)
+	_assert(err == nil && string(reply) == "3", "expect 3, got %s %v", reply, err)
+}
+
+func TestClient_CallRaw(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	for _, typ := range []codec.Type{codec.JsonType, codec.GobType} {
+		client, err := NewLocalPair(server, &Option{CodeType: typ})
+		_assert(err == nil, "local pair error: %v", err)
+		reply, err := client.CallRaw(context.Background(), "Foo.Sum", []byte(`{"Num1":1,"Num2":2}`))
+		_assert(err == nil && string(reply) == "3", "%s: expect 3, got %s %v", typ, reply, err)
+		_, err = client.CallRaw(context.Background(), "Foo.Sum", []byte(`{"Num1":"x"}`))
+		_assert(err != nil, "%s: expect invalid args rejected", typ)
+		_, err = client.CallRaw(context.Background(), "Foo.Missing", nil)
+		_assert(err != nil, "%s: expect unknown method rejected", typ)
+		_ = client.Close()
+	}
+}
+
+func TestReflection_InvokeAdmission(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	var mu sync.Mutex
+	var seen []string
+	server.Middlewares = append(server.Middlewares, func(ctx context.Context, serviceMethod string) (context.Context, error) {
+		mu.Lock()
+		seen = append(seen, serviceMethod)
+		mu.Unlock()
+		return ctx, nil
+	})
+	server.Authorizer = func(_ context.Context, serviceMethod string) error {
+		if serviceMethod == "Foo.Sum" {
+			return errors.New("denied")
+		}
+		return nil
+	}
+	client, err := NewLocalPair(server, &Option{CodeType: codec.GobType})
+	_assert(err == nil, "local pair error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	_, err = client.CallRaw(context.Background(), "Foo.Sum", []byte(`{"Num1":1,"Num2":2}`))
+	_assert(err != nil && strings.Contains(err.Error(), "denied"), "expect Authorizer to see the target method, got %v", err)
+	mu.Lock()
+	_assert(len(seen) == 1 && seen[0] == "Foo.Sum", "expect middlewares to see the target method, got %v", seen)
+	mu.Unlock()
+
+	server.Authorizer = nil
+	server.Drain(true)
+	_, err = client.CallRaw(context.Background(), "Foo.Sum", []byte(`{"Num1":1,"Num2":2}`))
+	_assert(errors.Is(err, ErrUnavailable), "expect Invoke rejected while draining, got %v", err)
+	server.Drain(false)
+	reply, err := client.CallRaw(context.Background(), "Foo.Sum", []byte(`{"Num1":1,"Num2":2}`))
+	_assert(err == nil && string(reply) == "3", "expect 3, got %s %v", reply, err)
+
+	var nested []byte
+	err = client.Call(context.Background(), ReflectionServiceName+".Invoke", InvokeArgs{ServiceMethod: ReflectionServiceName + ".Invoke"}, &nested)
+	_assert(err != nil, "expect nested Invoke rejected")
+}
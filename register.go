@@ -0,0 +1,33 @@
+package gpmd
+
+import (
+	"encoding/gob"
+	"fmt"
+)
+
+//RegisterType 注册参数或者返回值中通过 interface 字段传递的具体类型，客户端和服务端都需要注册。
+//gob 编码 interface 字段时必须知道具体类型，JSON 不需要注册，解码到 interface{} 时总是得到 map 等基础类型。
+//同一个名字注册了不同的类型时返回错误，不会像 gob.Register 一样 panic
+func RegisterType(values ...interface{}) (err error) {
+	for _, value := range values {
+		if err = register(func() { gob.Register(value) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//RegisterTypeName 以指定的名字注册类型，两端的包路径不同时用来保持名字一致
+func RegisterTypeName(name string, value interface{}) error {
+	return register(func() { gob.RegisterName(name, value) })
+}
+
+func register(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("rpc codec: register type: %v", r)
+		}
+	}()
+	fn()
+	return nil
+}
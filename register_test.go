@@ -0,0 +1,72 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"gpmd/codec"
+	"strings"
+	"testing"
+)
+
+type Point struct{ X, Y int }
+
+type Envelope struct {
+	Name    string
+	Payload interface{}
+}
+
+type Count struct{ N int }
+
+type Mailbox int
+
+func (m Mailbox) Open(args Envelope, reply *Envelope) error {
+	*reply = args
+	return nil
+}
+
+func (m Mailbox) Size(args Count, reply *int) error {
+	*reply = args.N
+	return nil
+}
+
+func TestRegisterType(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Mailbox))
+	addr := startLimitedServer(server)
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	var reply Envelope
+	err = client.Call(context.Background(), "Mailbox.Open", Envelope{Name: "a", Payload: Point{1, 2}}, &reply)
+	var codecErr *codec.CodecError
+	_assert(errors.As(err, &codecErr), "expect codec error, got %v", err)
+	_assert(codecErr.Op == "encode" && codecErr.Type == "gpmd.Envelope" && codecErr.Field == "Payload",
+		"expect error naming the field, got %+v", codecErr)
+	_assert(strings.Contains(err.Error(), "gpmd.Point") && strings.Contains(err.Error(), "RegisterType"),
+		"expect error naming the type, got %v", err)
+	_ = client.Close()
+
+	_assert(RegisterType(Point{}) == nil, "register error")
+	_assert(RegisterType(Point{}) == nil, "expect registering the same type twice to succeed")
+	_assert(RegisterTypeName("gpmd.Point", Count{}) != nil, "expect conflicting name to return error")
+	client, err = Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	err = client.Call(context.Background(), "Mailbox.Open", Envelope{Name: "a", Payload: Point{1, 2}}, &reply)
+	_assert(err == nil && reply.Payload == Point{1, 2}, "expect registered type round trip, got %+v %v", reply, err)
+}
+
+func TestCodecError_JSONField(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Mailbox))
+	client, err := Dial("tcp", startLimitedServer(server), &Option{MagicNumber: MagicNumber, CodeType: codec.JsonType})
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var n int
+	err = client.Call(context.Background(), "Mailbox.Size", struct{ N string }{"x"}, &n)
+	_assert(err != nil && strings.Contains(err.Error(), "field N"), "expect error naming field N, got %v", err)
+	err = client.Call(context.Background(), "Mailbox.Size", Count{3}, &n)
+	_assert(err == nil && n == 3, "expect connection still usable, got %d %v", n, err)
+}
@@ -0,0 +1,181 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//Stats 注册中心的统计，计数器从创建 Registry 开始累计
+type Stats struct {
+	Servers       int    `json:"servers"`       //当前可用的实例数
+	Registrations uint64 `json:"registrations"` //新注册的实例数
+	Heartbeats    uint64 `json:"heartbeats"`    //已注册的实例刷新心跳的次数
+	Expirations   uint64 `json:"expirations"`   //超时被删除的实例数
+	Evictions     uint64 `json:"evictions"`     //通过 Evict 强制删除的实例数
+}
+
+//Stats 返回当前的统计
+func (r *Registry) Stats() Stats {
+	return Stats{
+		Servers:       len(r.aliveItems()),
+		Registrations: atomic.LoadUint64(&r.registrations),
+		Heartbeats:    atomic.LoadUint64(&r.heartbeats),
+		Expirations:   atomic.LoadUint64(&r.expirations),
+		Evictions:     atomic.LoadUint64(&r.evictions),
+	}
+}
+
+//Evict 强制删除命名空间中的 addr，不检查租约，用于下线有问题的实例。addr 不存在时返回 false。
+//实例如果仍然在发送心跳，会以新的租约重新注册，需要同时停掉实例或者通过 ACL 拒绝它
+func (r *Registry) Evict(namespace, addr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := serverKey{namespace, addr}
+	s, ok := r.servers[key]
+	if !ok {
+		return false
+	}
+	delete(r.servers, key)
+	atomic.AddUint64(&r.evictions, 1)
+	r.record(EventEvict, namespace, addr, "", s.Meta)
+	return true
+}
+
+//Services 返回命名空间中每个服务对应的实例地址，没有声明 ServicesMetaKey 的实例列在 "*" 下
+func (r *Registry) Services(namespace string) map[string][]string {
+	return servicesOf(r.aliveIn(namespace))
+}
+
+func servicesOf(items []ServerItem) map[string][]string {
+	services := make(map[string][]string)
+	for _, s := range items {
+		names := s.Meta[ServicesMetaKey]
+		if names == "" {
+			services["*"] = append(services["*"], s.Addr)
+			continue
+		}
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				services[name] = append(services[name], s.Addr)
+			}
+		}
+	}
+	return services
+}
+
+//MetricsHandler 以 Prometheus 文本格式导出统计
+func (r *Registry) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		stats := r.Stats()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetric(w, "gpmd_registry_servers", "gauge", "Number of alive servers.", float64(stats.Servers))
+		writeMetric(w, "gpmd_registry_registrations_total", "counter", "Number of new registrations.", float64(stats.Registrations))
+		writeMetric(w, "gpmd_registry_heartbeats_total", "counter", "Number of heartbeats from registered servers.", float64(stats.Heartbeats))
+		writeMetric(w, "gpmd_registry_expirations_total", "counter", "Number of servers removed after timeout.", float64(stats.Expirations))
+		writeMetric(w, "gpmd_registry_evictions_total", "counter", "Number of servers evicted by admin.", float64(stats.Evictions))
+		byNamespace := make(map[string][]ServerItem)
+		for _, s := range r.aliveItems() {
+			byNamespace[s.Namespace] = append(byNamespace[s.Namespace], s)
+		}
+		namespaces := make([]string, 0, len(byNamespace))
+		for ns := range byNamespace {
+			namespaces = append(namespaces, ns)
+		}
+		sort.Strings(namespaces)
+		_, _ = fmt.Fprintln(w, "# HELP gpmd_registry_service_servers Number of alive servers per service.")
+		_, _ = fmt.Fprintln(w, "# TYPE gpmd_registry_service_servers gauge")
+		for _, ns := range namespaces {
+			services := servicesOf(byNamespace[ns])
+			names := make([]string, 0, len(services))
+			for name := range services {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				_, _ = fmt.Fprintf(w, "gpmd_registry_service_servers{namespace=%q,service=%q} %d\n", ns, name, len(services[name]))
+			}
+		}
+	})
+}
+
+func writeMetric(w http.ResponseWriter, name, typ, help string, value float64) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, value)
+}
+
+//adminServer 管理接口返回的实例信息
+type adminServer struct {
+	Namespace     string            `json:"namespace,omitempty"`
+	Addr          string            `json:"addr"`
+	Meta          map[string]string `json:"meta,omitempty"`
+	LastHeartbeat time.Time         `json:"last_heartbeat"`
+}
+
+//AdminHandler 返回管理接口，路径都以 prefix 开头，调用方负责认证。namespace 参数表示命名空间，
+//省略时 servers 返回所有命名空间的实例，services 和 evict 使用默认命名空间：
+//
+//	GET  prefix/servers?namespace=xxx    可用的实例
+//	GET  prefix/services?namespace=xxx   每个服务对应的实例地址
+//	GET  prefix/stats                    Stats
+//	GET  prefix/events?addr=xxx&since=2006-01-02T15:04:05Z   Events，参数都可以省略
+//	POST prefix/evict?namespace=xxx&addr=xxx   强制删除实例
+func (r *Registry) AdminHandler(prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/servers", func(w http.ResponseWriter, req *http.Request) {
+		items := r.aliveItems()
+		if _, ok := req.URL.Query()["namespace"]; ok {
+			items = r.aliveIn(req.URL.Query().Get("namespace"))
+		}
+		servers := make([]adminServer, 0, len(items))
+		for _, s := range items {
+			servers = append(servers, adminServer{Namespace: s.Namespace, Addr: s.Addr, Meta: s.Meta, LastHeartbeat: s.start})
+		}
+		writeJSON(w, servers)
+	})
+	mux.HandleFunc(prefix+"/services", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.Services(req.URL.Query().Get("namespace")))
+	})
+	mux.HandleFunc(prefix+"/stats", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, r.Stats())
+	})
+	mux.HandleFunc(prefix+"/events", func(w http.ResponseWriter, req *http.Request) {
+		var since time.Time
+		if v := req.URL.Query().Get("since"); v != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		writeJSON(w, r.Events(req.URL.Query().Get("addr"), since))
+	})
+	mux.HandleFunc(prefix+"/evict", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		addr := req.URL.Query().Get("addr")
+		if addr == "" {
+			http.Error(w, "missing addr", http.StatusBadRequest)
+			return
+		}
+		if !r.Evict(req.URL.Query().Get("namespace"), addr) {
+			http.Error(w, "no such server: "+addr, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
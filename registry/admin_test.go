@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryAdmin(t *testing.T) {
+	r := New(time.Minute)
+	if _, err := r.putServer("", "tcp@127.0.0.1:1", map[string]string{ServicesMetaKey: "Foo,Bar"}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.putServer("", "tcp@127.0.0.1:2", nil, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(r.AdminHandler("/admin"))
+	defer srv.Close()
+
+	services := r.Services("")
+	if len(services["Foo"]) != 1 || len(services["Bar"]) != 1 || len(services["*"]) != 1 {
+		t.Fatal("unexpected services:", services)
+	}
+	resp, err := http.Post(srv.URL+"/admin/evict?addr=tcp@127.0.0.1:2", "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("evict failed:", err, resp)
+	}
+	if resp, _ = http.Post(srv.URL+"/admin/evict?addr=tcp@127.0.0.1:2", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Fatal("evict an unknown addr should return 404, got", resp.Status)
+	}
+	if stats := r.Stats(); stats.Servers != 1 || stats.Registrations != 2 || stats.Evictions != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	events := r.Events("tcp@127.0.0.1:2", time.Time{})
+	if len(events) != 2 || events[0].Type != EventRegister || events[1].Type != EventEvict {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	w := httptest.NewRecorder()
+	r.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(w.Body)
+	for _, line := range []string{
+		"gpmd_registry_servers 1",
+		"gpmd_registry_evictions_total 1",
+		`gpmd_registry_service_servers{namespace="",service="Foo"} 1`,
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Fatalf("metrics should contain %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestRegistryEvents(t *testing.T) {
+	r := New(time.Minute)
+	r.EventHistory = 3
+	var sink strings.Builder
+	r.EventSink = &sink
+	for i := 0; i < 4; i++ {
+		if _, err := r.putServer("", "tcp@127.0.0.1:1", nil, "lease", "foo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := r.Events("", time.Time{})
+	if len(events) != 3 {
+		t.Fatal("ring buffer should keep 3 events, got", len(events))
+	}
+	for i, e := range events {
+		if e.Type != EventRefresh || e.Identity != "foo" || (i > 0 && e.Time.Before(events[i-1].Time)) {
+			t.Fatalf("unexpected events: %+v", events)
+		}
+	}
+	if n := strings.Count(sink.String(), "\n"); n != 4 {
+		t.Fatal("sink should receive every event, got", n)
+	}
+}
@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+//Authenticator 识别注册和注销请求的身份，ok 为 false 表示认证失败
+type Authenticator func(req *http.Request) (identity string, ok bool)
+
+//TokenAuth 以 Authorization: Bearer <token> 认证，tokens 中 token 对应的值为身份
+func TokenAuth(tokens map[string]string) Authenticator {
+	return func(req *http.Request) (string, bool) {
+		auth := req.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return "", false
+		}
+		token := []byte(strings.TrimPrefix(auth, "Bearer "))
+		for t, identity := range tokens {
+			if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				return identity, true
+			}
+		}
+		return "", false
+	}
+}
+
+//MTLSAuth 以经过校验的客户端证书的 CN 作为身份，需要 http.Server 的 TLSConfig 设置 ClientCAs 和 ClientAuth
+func MTLSAuth() Authenticator {
+	return func(req *http.Request) (string, bool) {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.PeerCertificates) == 0 {
+			return "", false
+		}
+		return req.TLS.PeerCertificates[0].Subject.CommonName, true
+	}
+}
+
+//AnyAuth 依次尝试 auths，任意一个认证成功即可，例如同时接受 token 和客户端证书
+func AnyAuth(auths ...Authenticator) Authenticator {
+	return func(req *http.Request) (string, bool) {
+		for _, auth := range auths {
+			if identity, ok := auth(req); ok {
+				return identity, true
+			}
+		}
+		return "", false
+	}
+}
+
+//ACL 每个身份可以注册的服务名，"*" 表示所有服务，包括没有声明 ServicesMetaKey 的实例
+type ACL map[string][]string
+
+//errForbidden 身份不允许注册实例声明的服务
+var errForbidden = errors.New("rpc registry: forbidden")
+
+//allow 判断 identity 是否可以注册提供 services 的实例，services 为空表示提供所有服务
+func (acl ACL) allow(identity, services string) bool {
+	granted := make(map[string]bool, len(acl[identity]))
+	for _, s := range acl[identity] {
+		granted[s] = true
+	}
+	if granted["*"] {
+		return true
+	}
+	if services == "" {
+		return false
+	}
+	for _, s := range strings.Split(services, ",") {
+		if s = strings.TrimSpace(s); s != "" && !granted[s] {
+			return false
+		}
+	}
+	return true
+}
+
+//authorize 检查注册请求，meta 为空的心跳沿用已经注册的元数据
+func (r *Registry) authorize(identity, namespace, addr string, meta map[string]string) error {
+	if r.ACL == nil {
+		return nil
+	}
+	if meta == nil {
+		r.mu.Lock()
+		if s := r.servers[serverKey{namespace, addr}]; s != nil {
+			meta = s.Meta
+		}
+		r.mu.Unlock()
+	}
+	if !r.ACL.allow(identity, meta[ServicesMetaKey]) {
+		return errForbidden
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package registry
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+//DefaultEventHistory Registry.EventHistory 为 0 时内存中保留的事件数
+const DefaultEventHistory = 1024
+
+//EventType 注册中心事件的类型
+type EventType string
+
+const (
+	EventRegister   EventType = "register"   //新注册的实例，包括过期后重新注册
+	EventRefresh    EventType = "refresh"    //已注册的实例刷新心跳
+	EventExpire     EventType = "expire"     //实例超时被删除
+	EventDeregister EventType = "deregister" //实例主动注销
+	EventEvict      EventType = "evict"      //通过 Evict 强制删除
+)
+
+//Event 注册中心的一条事件记录
+type Event struct {
+	Time      time.Time         `json:"time"`
+	Type      EventType         `json:"type"`
+	Namespace string            `json:"namespace,omitempty"`
+	Addr      string            `json:"addr"`
+	Identity  string            `json:"identity,omitempty"` //设置了 Auth 时为请求方的身份
+	Meta      map[string]string `json:"meta,omitempty"`
+}
+
+//eventLog 保存最近的事件的环形缓冲区
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+	next   int  //下一条事件写入的位置
+	full   bool //缓冲区已经写满，最旧的事件在 next
+}
+
+//record 记录一条事件，设置了 EventSink 时同时以一行 JSON 写出
+func (r *Registry) record(typ EventType, namespace, addr, identity string, meta map[string]string) {
+	e := Event{Time: r.now(), Type: typ, Namespace: namespace, Addr: addr, Identity: identity, Meta: meta}
+	l := &r.events
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.events == nil {
+		size := r.EventHistory
+		if size <= 0 {
+			size = DefaultEventHistory
+		}
+		l.events = make([]Event, size)
+	}
+	l.events[l.next] = e
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+	if r.EventSink != nil {
+		data, _ := json.Marshal(e)
+		if _, err := r.EventSink.Write(append(data, '\n')); err != nil {
+			log.Println("rpc registry: write event error:", err)
+		}
+	}
+}
+
+//Events 按时间顺序返回内存中保留的所有命名空间的事件，addr 不为空时只返回这个地址的事件，since 不为零时只返回之后的事件
+func (r *Registry) Events(addr string, since time.Time) []Event {
+	l := &r.events
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ordered := l.events[:l.next]
+	if l.full {
+		ordered = append(append([]Event(nil), l.events[l.next:]...), l.events[:l.next]...)
+	}
+	events := make([]Event, 0)
+	for _, e := range ordered {
+		if (addr == "" || e.Addr == addr) && e.Time.After(since) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
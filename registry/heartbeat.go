@@ -0,0 +1,258 @@
+package registry
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//DefaultHeartbeatJitter 心跳间隔默认的随机浮动比例，避免所有服务实例同时发送心跳
+const DefaultHeartbeatJitter = 0.1
+
+//HeartbeatOption 心跳的设置
+type HeartbeatOption struct {
+	Interval  time.Duration     //心跳间隔，0 表示使用注册中心建议的间隔（TTL 的一半），失败一次后仍然来得及在过期之前重试
+	Jitter    float64           //间隔随机浮动的比例，0 表示使用 DefaultHeartbeatJitter，负数表示不浮动
+	Meta      map[string]string //心跳中携带的元数据，客户端可以据此路由，例如按照 shard 分片
+	Namespace string            //注册到的命名空间，为空时使用 registry 地址中的 namespace 参数或者默认命名空间
+	Token     string            //非空时以 Authorization: Bearer <token> 携带，对应注册中心的 TokenAuth
+	TLSConfig *tls.Config       //访问 https 注册中心的设置，设置客户端证书对应注册中心的 MTLSAuth
+}
+
+//HeartbeatHandle 正在运行的心跳，Stop 停止心跳并从注册中心注销
+type HeartbeatHandle struct {
+	registry string
+	addr     string
+	opt      HeartbeatOption
+	lease    string //注册中心分配的租约，之后的心跳和注销都携带它
+	client   *http.Client
+	mu       sync.Mutex //保护 opt.Meta 和 lease，SetWeight 和 Drain 会修改 opt.Meta，后台心跳和它们都会更新 lease
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+//StartHeartbeat 立即向注册中心发送一次心跳，失败时返回错误，成功后在后台定期发送。
+//后台的心跳失败时记录日志，按照间隔的 1/4 重试，直到 Stop
+func StartHeartbeat(registry, addr string, opt HeartbeatOption) (*HeartbeatHandle, error) {
+	client := http.DefaultClient
+	if opt.TLSConfig != nil {
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: opt.TLSConfig}}
+	}
+	return startHeartbeat(registry, addr, opt, client)
+}
+
+//StartHeartbeat 与包级别的 StartHeartbeat 相同，心跳直接发给同一个进程中的 r，不经过网络，
+//用于测试以及在一个进程中运行多个注册中心的场景。opt.TLSConfig 被忽略
+func (r *Registry) StartHeartbeat(addr string, opt HeartbeatOption) (*HeartbeatHandle, error) {
+	return startHeartbeat(localURL, addr, opt, r.Client())
+}
+
+func startHeartbeat(registry, addr string, opt HeartbeatOption, client *http.Client) (*HeartbeatHandle, error) {
+	h := &HeartbeatHandle{
+		registry: registry,
+		addr:     addr,
+		opt:      opt,
+		client:   client,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	reply, err := h.send()
+	if err != nil {
+		return nil, err
+	}
+	go h.run(reply)
+	return h, nil
+}
+
+//Lease 返回注册中心分配的租约
+func (h *HeartbeatHandle) Lease() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lease
+}
+
+func (h *HeartbeatHandle) run(reply heartbeatReply) {
+	defer close(h.done)
+	interval := h.interval(reply)
+	next := interval
+	for {
+		timer := time.NewTimer(h.jitter(next))
+		select {
+		case <-h.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		reply, err := h.send()
+		if err != nil {
+			next = interval / 4
+			continue
+		}
+		interval = h.interval(reply)
+		next = interval
+	}
+}
+
+//interval 计算心跳间隔，优先使用注册中心建议的间隔，
+//注册中心没有返回 TTL（旧版本）或者实例不会过期时以 defaultTimeout 计算
+func (h *HeartbeatHandle) interval(reply heartbeatReply) time.Duration {
+	if h.opt.Interval > 0 {
+		return h.opt.Interval
+	}
+	if reply.interval > 0 {
+		return reply.interval
+	}
+	ttl := reply.ttl
+	if ttl <= 0 {
+		ttl = defaultTimeout
+	}
+	return ttl / 2
+}
+
+//jitter 在 d 的基础上随机浮动 ±Jitter
+func (h *HeartbeatHandle) jitter(d time.Duration) time.Duration {
+	jitter := h.opt.Jitter
+	if jitter == 0 {
+		jitter = DefaultHeartbeatJitter
+	}
+	if jitter < 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+//SetWeight 修改实例的权重并立即发送心跳，weight 为 0 时客户端不再分配新的请求
+func (h *HeartbeatHandle) SetWeight(weight int) error {
+	if weight < 0 {
+		return fmt.Errorf("rpc registry: negative weight %d", weight)
+	}
+	return h.updateMeta(WeightMetaKey, strconv.Itoa(weight))
+}
+
+//Drain 将实例标记为 draining 并立即发送心跳，客户端不再分配新的请求，实例仍然保留在注册中心，
+//等已有的请求处理完后再 Stop。Resume 恢复接收请求
+func (h *HeartbeatHandle) Drain() error {
+	return h.updateMeta(StateMetaKey, StateDraining)
+}
+
+//Resume 取消 Drain 并立即发送心跳
+func (h *HeartbeatHandle) Resume() error {
+	return h.updateMeta(StateMetaKey, "")
+}
+
+//updateMeta 复制元数据后修改 key，value 为空表示删除，注册中心以新的元数据整体替换原来的
+func (h *HeartbeatHandle) updateMeta(key, value string) error {
+	h.mu.Lock()
+	meta := make(map[string]string, len(h.opt.Meta)+1)
+	for k, v := range h.opt.Meta {
+		meta[k] = v
+	}
+	if value == "" {
+		delete(meta, key)
+	} else {
+		meta[key] = value
+	}
+	h.opt.Meta = meta
+	h.mu.Unlock()
+	_, err := h.send()
+	return err
+}
+
+//Stop 停止心跳并从注册中心注销，重复调用直接返回 nil
+func (h *HeartbeatHandle) Stop() error {
+	var err error
+	h.once.Do(func() {
+		close(h.stop)
+		<-h.done
+		err = h.deregister()
+	})
+	return err
+}
+
+func Heartbeat(registry, addr string, duration time.Duration) {
+	HeartbeatWithMeta(registry, addr, duration, nil)
+}
+
+//HeartbeatWithMeta 与 Heartbeat 相同，同时在心跳中携带元数据，客户端可以据此路由，例如按照 shard 分片。
+//duration 为 0 时根据注册中心返回的 TTL 计算间隔，需要停止心跳时使用 StartHeartbeat
+func HeartbeatWithMeta(registry, addr string, duration time.Duration, meta map[string]string) {
+	_, _ = StartHeartbeat(registry, addr, HeartbeatOption{Interval: duration, Meta: meta})
+}
+
+//heartbeatReply 注册中心对心跳的响应，旧版本的注册中心不返回这些字段，对应的值为零值
+type heartbeatReply struct {
+	ttl      time.Duration
+	interval time.Duration
+	lease    string
+}
+
+//newRequest 创建携带地址、租约、命名空间和凭证的请求
+func (h *HeartbeatHandle) newRequest(method string) *http.Request {
+	req, _ := http.NewRequest(method, h.registry, nil)
+	req.Header.Set("X-GPMD-SERVERS", h.addr)
+	if lease := h.Lease(); lease != "" {
+		req.Header.Set("X-GPMD-LEASE", lease)
+	}
+	if h.opt.Namespace != "" {
+		req.Header.Set("X-GPMD-NAMESPACE", h.opt.Namespace)
+	}
+	if h.opt.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.opt.Token)
+	}
+	return req
+}
+
+//send 发送一次心跳，首次注册时租约为空，由注册中心分配
+func (h *HeartbeatHandle) send() (heartbeatReply, error) {
+	log.Println(h.addr, "send heartbeat to registry", h.registry)
+	req := h.newRequest("POST")
+	h.mu.Lock()
+	if h.opt.Meta != nil {
+		req.Header.Set("X-GPMD-META", encodeMeta(h.opt.Meta))
+	}
+	h.mu.Unlock()
+	resp, err := h.client.Do(req)
+	if err == nil {
+		_ = resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusConflict:
+			err = errors.New("address is registered by another lease")
+		default:
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.Println("rpc server:heart beat err:", err)
+		return heartbeatReply{}, err
+	}
+	reply := heartbeatReply{lease: resp.Header.Get("X-GPMD-LEASE")}
+	reply.ttl, _ = time.ParseDuration(resp.Header.Get("X-GPMD-TTL"))
+	reply.interval, _ = time.ParseDuration(resp.Header.Get("X-GPMD-INTERVAL"))
+	if reply.lease != "" {
+		h.mu.Lock()
+		h.lease = reply.lease
+		h.mu.Unlock()
+	}
+	return reply, nil
+}
+
+//deregister 从注册中心注销
+func (h *HeartbeatHandle) deregister() error {
+	resp, err := h.client.Do(h.newRequest("DELETE"))
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("rpc registry: deregister " + h.addr + ": " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartHeartbeat(t *testing.T) {
+	r := New(time.Minute)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	h, err := StartHeartbeat(srv.URL, "tcp@127.0.0.1:9999", HeartbeatOption{})
+	if err != nil {
+		t.Fatal("start heartbeat:", err)
+	}
+	if h.Lease() == "" {
+		t.Fatal("registry should assign a lease")
+	}
+	if got := h.interval(heartbeatReply{ttl: time.Minute}); got != 30*time.Second {
+		t.Fatal("interval should be half of the ttl, got", got)
+	}
+	if got := h.interval(heartbeatReply{ttl: time.Minute, interval: 10 * time.Second}); got != 10*time.Second {
+		t.Fatal("interval should use the hint, got", got)
+	}
+	if got := h.interval(heartbeatReply{}); got != defaultTimeout/2 {
+		t.Fatal("interval without ttl should use defaultTimeout, got", got)
+	}
+	if servers := r.aliveServers(""); len(servers) != 1 {
+		t.Fatal("server should be registered, got", servers)
+	}
+	spoof := &HeartbeatHandle{registry: srv.URL, addr: "tcp@127.0.0.1:9999", lease: "spoofed", client: http.DefaultClient}
+	if _, err := spoof.send(); err == nil {
+		t.Fatal("heartbeat with a wrong lease should be rejected")
+	}
+	if err := spoof.deregister(); err == nil {
+		t.Fatal("deregister with a wrong lease should be rejected")
+	}
+	spoof.lease = ""
+	if _, err := spoof.send(); err == nil {
+		t.Fatal("heartbeat without the lease should be rejected")
+	}
+	if err := h.Stop(); err != nil {
+		t.Fatal("stop heartbeat:", err)
+	}
+	if servers := r.aliveServers(""); len(servers) != 0 {
+		t.Fatal("server should be deregistered, got", servers)
+	}
+	if err := h.Stop(); err != nil {
+		t.Fatal("second stop should be a no-op:", err)
+	}
+}
+
+//注册中心每次返回新的租约，后台心跳更新租约的同时读取它，配合 -race 运行
+func TestHeartbeatConcurrentLease(t *testing.T) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-GPMD-LEASE", fmt.Sprint("lease-", atomic.AddInt32(&n, 1)))
+	}))
+	defer srv.Close()
+	h, err := StartHeartbeat(srv.URL, "tcp@127.0.0.1:9998", HeartbeatOption{Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for atomic.LoadInt32(&n) < 10 {
+		if h.Lease() == "" {
+			t.Fatal("expect a lease")
+		}
+		if err := h.SetWeight(int(atomic.LoadInt32(&n))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeartbeatJitter(t *testing.T) {
+	h := &HeartbeatHandle{opt: HeartbeatOption{Jitter: 0.2}}
+	for i := 0; i < 100; i++ {
+		if d := h.jitter(time.Second); d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatal("jitter out of range:", d)
+		}
+	}
+	h.opt.Jitter = -1
+	if d := h.jitter(time.Second); d != time.Second {
+		t.Fatal("negative jitter should disable jitter, got", d)
+	}
+}
+
+func TestRegistryAuth(t *testing.T) {
+	r := New(time.Minute)
+	r.Auth = TokenAuth(map[string]string{"foo-token": "foo", "ops-token": "ops"})
+	r.ACL = ACL{"foo": {"Foo"}, "ops": {"*"}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	fooMeta := map[string]string{ServicesMetaKey: "Foo"}
+	if _, err := StartHeartbeat(srv.URL, "tcp@127.0.0.1:1", HeartbeatOption{Meta: fooMeta}); err == nil {
+		t.Fatal("registration without a token should be rejected")
+	}
+	if _, err := StartHeartbeat(srv.URL, "tcp@127.0.0.1:1", HeartbeatOption{Meta: fooMeta, Token: "wrong"}); err == nil {
+		t.Fatal("registration with a wrong token should be rejected")
+	}
+	barMeta := map[string]string{ServicesMetaKey: "Foo,Bar"}
+	if _, err := StartHeartbeat(srv.URL, "tcp@127.0.0.1:1", HeartbeatOption{Meta: barMeta, Token: "foo-token"}); err == nil {
+		t.Fatal("foo should not register Bar")
+	}
+	if _, err := StartHeartbeat(srv.URL, "tcp@127.0.0.1:1", HeartbeatOption{Token: "foo-token"}); err == nil {
+		t.Fatal("foo should not register an instance providing all services")
+	}
+	foo, err := StartHeartbeat(srv.URL, "tcp@127.0.0.1:1", HeartbeatOption{Meta: fooMeta, Token: "foo-token"})
+	if err != nil {
+		t.Fatal("foo should register Foo:", err)
+	}
+	defer foo.Stop()
+	ops, err := StartHeartbeat(srv.URL, "tcp@127.0.0.1:2", HeartbeatOption{Token: "ops-token"})
+	if err != nil {
+		t.Fatal("ops should register any service:", err)
+	}
+	defer ops.Stop()
+	if servers := r.aliveServers(""); len(servers) != 2 {
+		t.Fatal("expect 2 servers, got", servers)
+	}
+}
+
+func TestRegistryNamespace(t *testing.T) {
+	r := New(time.Minute)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	staging, err := StartHeartbeat(srv.URL, "tcp@127.0.0.1:1", HeartbeatOption{Namespace: "staging"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer staging.Stop()
+	//同一个地址可以同时注册在不同的命名空间中
+	prod, err := StartHeartbeat(srv.URL+"?namespace=prod", "tcp@127.0.0.1:1", HeartbeatOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servers := r.aliveServers("staging"); len(servers) != 1 {
+		t.Fatal("expect 1 server in staging, got", servers)
+	}
+	if servers := r.aliveServers(""); len(servers) != 0 {
+		t.Fatal("default namespace should not see other namespaces, got", servers)
+	}
+	for _, ns := range []string{"", "prod"} {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Header.Set("X-GPMD-NAMESPACE", ns)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if got, want := resp.Header.Get("X-GPMD-SERVERS"), map[string]string{"": "", "prod": "tcp@127.0.0.1:1"}[ns]; got != want {
+			t.Fatalf("namespace %q: expect %q, got %q", ns, want, got)
+		}
+	}
+	if err := prod.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if servers := r.aliveServers("staging"); len(servers) != 1 {
+		t.Fatal("deregistering prod should not affect staging, got", servers)
+	}
+}
@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//localURL 进程内请求使用的地址，只用于日志，请求直接交给 Registry 处理
+const localURL = "http://local" + defaultPath
+
+//Client 返回直接访问 r 的 HTTP 客户端，请求不经过网络，URL 中的地址和路径被忽略。
+//交给 xclient.GpmdRegistryDiscovery.SetHTTPClient 后，服务发现同样绑定到这个实例
+func (r *Registry) Client() *http.Client {
+	return &http.Client{Transport: localTransport{r}}
+}
+
+//localTransport 将请求交给 handler 处理的 http.RoundTripper
+type localTransport struct {
+	h http.Handler
+}
+
+func (t localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+	w := &localResponse{header: make(http.Header), code: http.StatusOK}
+	t.h.ServeHTTP(w, req)
+	return &http.Response{
+		Status:        strconv.Itoa(w.code) + " " + http.StatusText(w.code),
+		StatusCode:    w.code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          ioutil.NopCloser(strings.NewReader(w.body.String())),
+		ContentLength: int64(w.body.Len()),
+		Request:       req,
+	}, nil
+}
+
+//localResponse 记录 handler 写出的响应
+type localResponse struct {
+	header      http.Header
+	code        int
+	wroteHeader bool
+	body        strings.Builder
+}
+
+func (w *localResponse) Header() http.Header {
+	return w.header
+}
+
+func (w *localResponse) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.code, w.wroteHeader = code, true
+	}
+}
+
+func (w *localResponse) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
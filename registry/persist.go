@@ -0,0 +1,68 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//persistItem 持久化到文件中的服务实例
+type persistItem struct {
+	Namespace string            `json:"namespace,omitempty"`
+	Addr      string            `json:"addr"`
+	Meta      map[string]string `json:"meta,omitempty"`
+	Start     time.Time         `json:"start"`
+	Lease     string            `json:"lease,omitempty"`
+}
+
+//Save 将当前注册的服务实例保存到文件中，先写临时文件再重命名，保证文件内容总是完整的
+func (r *Registry) Save(path string) error {
+	r.mu.Lock()
+	items := make([]persistItem, 0, len(r.servers))
+	for _, s := range r.servers {
+		items = append(items, persistItem{Namespace: s.Namespace, Addr: s.Addr, Meta: s.Meta, Start: s.start, Lease: s.lease})
+	}
+	r.mu.Unlock()
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+//Load 从文件中恢复服务实例，已经过期的实例会在下一次 aliveServers 时被清理
+func (r *Registry) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var items []persistItem
+	if err = json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, item := range items {
+		key := serverKey{item.Namespace, item.Addr}
+		if s := r.servers[key]; s == nil || s.start.Before(item.Start) {
+			r.servers[key] = &ServerItem{Namespace: item.Namespace, Addr: item.Addr, Meta: item.Meta, start: item.Start, lease: item.Lease}
+		}
+	}
+	return nil
+}
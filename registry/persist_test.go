@@ -0,0 +1,75 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRegistrySaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "servers.json")
+
+	r := New(time.Minute)
+	if _, err := r.putServer("", "tcp@127.0.0.1:1", map[string]string{"shard": "1"}, "lease-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.putServer("prod", "tcp@127.0.0.1:1", map[string]string{ServicesMetaKey: "Foo"}, "lease-2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) != 0 {
+		t.Fatal("expect no temporary file left, got", matches)
+	}
+
+	loaded := New(time.Minute)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	want := r.aliveItems()
+	got := loaded.aliveItems()
+	if len(got) != len(want) {
+		t.Fatalf("expect %d servers, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].Namespace != want[i].Namespace || got[i].Addr != want[i].Addr || got[i].lease != want[i].lease ||
+			!got[i].start.Equal(want[i].start) || len(got[i].Meta) != len(want[i].Meta) {
+			t.Fatalf("expect %+v, got %+v", want[i], got[i])
+		}
+		for k, v := range want[i].Meta {
+			if got[i].Meta[k] != v {
+				t.Fatalf("expect meta %s=%s, got %q", k, v, got[i].Meta[k])
+			}
+		}
+	}
+	//恢复的租约同样生效
+	if _, err := loaded.putServer("prod", "tcp@127.0.0.1:1", nil, "lease-1", ""); err == nil {
+		t.Fatal("expect a heartbeat with another namespace's lease rejected")
+	}
+	if lease, err := loaded.putServer("prod", "tcp@127.0.0.1:1", nil, "lease-2", ""); err != nil || lease != "lease-2" {
+		t.Fatalf("expect the restored lease accepted, got %s %v", lease, err)
+	}
+
+	//内存中更新的记录不会被文件中旧的记录覆盖
+	if _, err := loaded.putServer("", "tcp@127.0.0.1:1", map[string]string{"shard": "2"}, "lease-1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if items := loaded.aliveIn(""); len(items) != 1 || items[0].Meta["shard"] != "2" {
+		t.Fatalf("expect the newer record kept, got %+v", items)
+	}
+
+	if err := New(time.Minute).Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatal("expect an error loading a missing file")
+	}
+}
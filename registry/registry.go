@@ -1,25 +1,79 @@
 package registry
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Registry struct {
+	Auth Authenticator //非空时，注册和注销请求必须通过认证，查询不受影响
+	ACL  ACL           //非空时，按照认证的身份限制可以注册的服务，需要同时设置 Auth
+
+	EventHistory int       //内存中保留的事件数，0 表示 DefaultEventHistory，需要在处理请求之前设置
+	EventSink    io.Writer //非空时，每条事件以一行 JSON 写入，例如追加写的审计日志文件
+
+	Clock Clock //判断实例过期和记录事件时间使用的时间来源，为空时使用系统时间，需要在处理请求之前设置
+
 	timeout time.Duration
 	mu      sync.Mutex
-	servers map[string]*ServerItem
+	servers map[serverKey]*ServerItem
+
+	registrations uint64 //新注册的实例数
+	heartbeats    uint64 //已注册的实例刷新心跳的次数
+	expirations   uint64 //超时被删除的实例数
+	evictions     uint64 //通过管理接口强制删除的实例数
+
+	events eventLog
 }
 
 type ServerItem struct {
-	Addr  string
-	start time.Time
+	Namespace string //实例所在的命名空间，例如 staging、prod 或者租户，为空表示默认命名空间
+	Addr      string
+	Meta      map[string]string //服务实例在心跳中携带的元数据，例如分片编号 shard=3
+	start     time.Time
+	lease     string //首次注册时分配的租约，之后的心跳和注销必须携带，防止其他进程冒充这个地址
 }
 
+//serverKey 不同命名空间中的实例互不影响，即使地址相同
+type serverKey struct {
+	namespace string
+	addr      string
+}
+
+//服务实例元数据中有特殊含义的键，客户端据此选择实例
+const (
+	ServicesMetaKey = "services" //以逗号分隔列出提供的服务，没有这一项的实例被认为提供所有服务
+	WeightMetaKey   = "weight"   //实例的权重，没有这一项时为 1，为 0 时不分配新的请求
+	StateMetaKey    = "state"    //实例的状态，StateDraining 表示正在下线
+	StateDraining   = "draining" //不再接收新的请求，但仍然保留在注册中心，处理完已有的请求后再注销
+)
+
+//Clock 注册中心的时间来源，gpmd.Clock 和 gpmdtest.FakeClock 都满足这个接口
+type Clock interface {
+	Now() time.Time
+}
+
+//now 返回 Clock 的当前时间
+func (r *Registry) now() time.Time {
+	if r.Clock != nil {
+		return r.Clock.Now()
+	}
+	return time.Now()
+}
+
+//errLeaseMismatch 心跳或注销携带的租约与注册时分配的不一致
+var errLeaseMismatch = errors.New("rpc registry: lease mismatch")
+
 const (
 	defaultPath    = "/_gpmd_/registry"
 	defaultTimeout = time.Minute * 5
@@ -27,64 +81,245 @@ const (
 
 func New(timeout time.Duration) *Registry {
 	return &Registry{
-		servers: make(map[string]*ServerItem),
+		servers: make(map[serverKey]*ServerItem),
 		timeout: timeout,
 	}
 }
 
 var DefaultRegistry = New(defaultTimeout)
 
-//putServer 添加服务实例，如果服务已经存在，则刷新start时间
-func (r *Registry) putServer(addr string) {
+//putServer 添加服务实例，如果服务已经存在，则刷新start时间，meta 不为 nil 时替换元数据。
+//新注册的实例沿用 lease（注册中心重启后服务实例仍然携带原来的租约），lease 为空时分配新的租约；
+//已经存在且没有过期的实例，lease 必须与注册时的一致。返回实例的租约
+func (r *Registry) putServer(namespace, addr string, meta map[string]string, lease, identity string) (string, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	s := r.servers[addr]
+	key := serverKey{namespace, addr}
+	s := r.servers[key]
+	if s != nil && r.expired(s) {
+		atomic.AddUint64(&r.expirations, 1)
+		r.record(EventExpire, namespace, addr, "", s.Meta)
+		s = nil
+	}
 	if s == nil {
-		r.servers[addr] = &ServerItem{
-			Addr:  addr,
-			start: time.Now(),
+		atomic.AddUint64(&r.registrations, 1)
+		r.record(EventRegister, namespace, addr, identity, meta)
+		if lease == "" {
+			lease = newLease()
+		}
+		r.servers[key] = &ServerItem{
+			Namespace: namespace,
+			Addr:      addr,
+			Meta:      meta,
+			start:     r.now(),
+			lease:     lease,
 		}
-	} else {
-		s.start = time.Now()
+		return lease, nil
+	}
+	if s.lease != "" && s.lease != lease {
+		return "", errLeaseMismatch
+	}
+	atomic.AddUint64(&r.heartbeats, 1)
+	s.start = r.now()
+	if meta != nil {
+		s.Meta = meta
+	}
+	r.record(EventRefresh, namespace, addr, identity, s.Meta)
+	return s.lease, nil
+}
+
+//removeServer 删除服务实例，服务正常退出时主动注销，不用等到超时，lease 必须与注册时的一致
+func (r *Registry) removeServer(namespace, addr string, lease, identity string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := serverKey{namespace, addr}
+	s := r.servers[key]
+	if s == nil {
+		return nil
+	}
+	if s.lease != "" && s.lease != lease {
+		return errLeaseMismatch
 	}
+	delete(r.servers, key)
+	r.record(EventDeregister, namespace, addr, identity, s.Meta)
+	return nil
+}
+
+func (r *Registry) expired(s *ServerItem) bool {
+	return r.timeout != 0 && !s.start.Add(r.timeout).After(r.now())
+}
+
+//newLease 生成随机的租约
+func newLease() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
-// aliveServers 返回可用的服务列表，如果存在超时的服务，则删除
-func (r *Registry) aliveServers() []string {
+// aliveServers 返回命名空间中可用的服务列表，如果存在超时的服务，则删除
+func (r *Registry) aliveServers(namespace string) []string {
+	items := r.aliveIn(namespace)
+	alive := make([]string, 0, len(items))
+	for _, s := range items {
+		alive = append(alive, s.Addr)
+	}
+	return alive
+}
+
+//aliveItems 返回所有命名空间中可用服务实例的副本，按照命名空间和地址排序
+func (r *Registry) aliveItems() []ServerItem {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	var alive []string
-	for addr, s := range r.servers {
-		if r.timeout == 0 || s.start.Add(r.timeout).After(time.Now()) {
-			alive = append(alive, addr)
+	var alive []ServerItem
+	for key, s := range r.servers {
+		if r.expired(s) {
+			atomic.AddUint64(&r.expirations, 1)
+			r.record(EventExpire, s.Namespace, s.Addr, "", s.Meta)
+			delete(r.servers, key)
 		} else {
-			delete(r.servers, addr)
+			alive = append(alive, *s)
+		}
+	}
+	sort.Slice(alive, func(i, j int) bool {
+		if alive[i].Namespace != alive[j].Namespace {
+			return alive[i].Namespace < alive[j].Namespace
+		}
+		return alive[i].Addr < alive[j].Addr
+	})
+	return alive
+}
+
+//aliveIn 返回命名空间中按地址排序的可用服务实例
+func (r *Registry) aliveIn(namespace string) []ServerItem {
+	var alive []ServerItem
+	for _, s := range r.aliveItems() {
+		if s.Namespace == namespace {
+			alive = append(alive, s)
 		}
 	}
-	sort.Strings(alive)
 	return alive
 }
 
+//namespaceOf 请求的命名空间，优先使用 X-GPMD-NAMESPACE，其次是 URL 中的 namespace 参数，
+//例如 http://127.0.0.1:9999/_gpmd_/registry?namespace=staging，都没有时为默认命名空间
+func namespaceOf(req *http.Request) string {
+	if ns := req.Header.Get("X-GPMD-NAMESPACE"); ns != "" {
+		return ns
+	}
+	return req.URL.Query().Get("namespace")
+}
+
+//encodeMeta 元数据编码为 URL 查询字符串的形式，例如 shard=3&zone=a
+func encodeMeta(meta map[string]string) string {
+	values := url.Values{}
+	for k, v := range meta {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+func decodeMeta(s string) map[string]string {
+	values, err := url.ParseQuery(s)
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	meta := make(map[string]string, len(values))
+	for k := range values {
+		meta[k] = values.Get(k)
+	}
+	return meta
+}
+
 //采用 HTTP 协议提供服务，且所有的有用信息都承载在 HTTP Header 中
 func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	namespace := namespaceOf(req)
 	switch req.Method {
 	case "GET":
-		w.Header().Set("X-GPMD-SERVERS", strings.Join(r.aliveServers(), ","))
+		//只返回请求所在命名空间的实例，不同命名空间的服务互相不可见
+		items := r.aliveIn(namespace)
+		addrs := make([]string, 0, len(items))
+		for _, s := range items {
+			addrs = append(addrs, s.Addr)
+			//每个带有元数据的实例对应一个 X-GPMD-META，格式为 "地址 元数据"
+			if len(s.Meta) > 0 {
+				w.Header().Add("X-GPMD-META", s.Addr+" "+encodeMeta(s.Meta))
+			}
+		}
+		w.Header().Set("X-GPMD-SERVERS", strings.Join(addrs, ","))
 	case "POST":
 		addr := req.Header.Get("X-GPMD-SERVERS")
 		if addr == "" {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		r.putServer(addr)
+		identity, ok := r.authenticate(req)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		meta := decodeMeta(req.Header.Get("X-GPMD-META"))
+		if _, ok := req.Header[http.CanonicalHeaderKey("X-GPMD-META")]; ok && meta == nil {
+			//带有空的 X-GPMD-META 表示清空元数据，没有这个头的心跳沿用原来的元数据
+			meta = map[string]string{}
+		}
+		if err := r.authorize(identity, namespace, addr, meta); err != nil {
+			log.Println(err, identity, "to register", addr)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		lease, err := r.putServer(namespace, addr, meta, req.Header.Get("X-GPMD-LEASE"), identity)
+		if err != nil {
+			log.Println(err, "for", addr)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		//服务实例根据 TTL 决定心跳间隔，0 表示实例不会过期；INTERVAL 是建议的心跳间隔
+		w.Header().Set("X-GPMD-TTL", r.timeout.String())
+		w.Header().Set("X-GPMD-INTERVAL", (r.timeout / 2).String())
+		w.Header().Set("X-GPMD-LEASE", lease)
+	case "DELETE":
+		addr := req.Header.Get("X-GPMD-SERVERS")
+		if addr == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		identity, ok := r.authenticate(req)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := r.removeServer(namespace, addr, req.Header.Get("X-GPMD-LEASE"), identity); err != nil {
+			log.Println(err, "for", addr)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-//HandleHTTP 将默认的注册路径注册到HTTP服务中
+//authenticate 没有设置 Auth 时所有请求都通过认证，身份为空
+func (r *Registry) authenticate(req *http.Request) (string, bool) {
+	if r.Auth == nil {
+		return "", true
+	}
+	return r.Auth(req)
+}
+
+//Router 可以按照路径注册 handler 的路由，*http.ServeMux 以及常见的第三方路由都满足
+type Router interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+//HandleHTTP 将注册中心注册到 http.DefaultServeMux 的 registryPath 上
 func (r *Registry) HandleHTTP(registryPath string) {
-	http.Handle(registryPath, r)
+	r.HandleHTTPOn(http.DefaultServeMux, registryPath)
+}
+
+//HandleHTTPOn 将注册中心注册到 mux 的 registryPath 上，嵌入到其他应用时由应用控制自己的路由，
+//同一个进程中的多个 Registry 可以注册到不同的 mux 或者同一个 mux 的不同路径
+func (r *Registry) HandleHTTPOn(mux Router, registryPath string) {
+	mux.Handle(registryPath, r)
 	log.Println("rpc registry path:", registryPath)
 }
 
@@ -92,31 +327,3 @@ func (r *Registry) HandleHTTP(registryPath string) {
 func HandleHTTP() {
 	DefaultRegistry.HandleHTTP(defaultPath)
 }
-
-func Heartbeat(registry, addr string, duration time.Duration) {
-	if duration == 0 {
-		//在超时时间基础上减1分钟，发起心跳。保证有足够的时间发送心跳。
-		duration = defaultTimeout - time.Duration(1)*time.Minute
-	}
-	var err error
-	err = sendHeartbeat(registry, addr)
-	go func() {
-		t := time.NewTicker(duration)
-		for err == nil {
-			<-t.C
-			err = sendHeartbeat(registry, addr)
-		}
-	}()
-}
-
-func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, "send heartbeat to registry", registry)
-	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registry, nil)
-	req.Header.Set("X-GPMD-SERVERS", addr)
-	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server:heart beat err:", err)
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,91 @@
+package registry
+
+import (
+	"gpmd/gpmdtest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistry_HandleHTTPOn(t *testing.T) {
+	mux := http.NewServeMux()
+	a, b := New(time.Minute), New(time.Minute)
+	a.HandleHTTPOn(mux, "/a/registry")
+	b.HandleHTTPOn(mux, "/b/registry")
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("POST", srv.URL+"/a/registry", nil)
+	req.Header.Set("X-GPMD-SERVERS", "tcp@127.0.0.1:9999")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("register:", err)
+	}
+	_ = resp.Body.Close()
+	if servers := a.aliveServers(""); len(servers) != 1 {
+		t.Fatal("server should be registered in the first registry, got", servers)
+	}
+	if servers := b.aliveServers(""); len(servers) != 0 {
+		t.Fatal("registries on one mux should be independent, got", servers)
+	}
+	resp, err = http.Get(srv.URL + "/b/registry")
+	if err != nil {
+		t.Fatal("get:", err)
+	}
+	_ = resp.Body.Close()
+	if got := resp.Header.Get("X-GPMD-SERVERS"); got != "" {
+		t.Fatal("second registry should have no servers, got", got)
+	}
+}
+
+func TestRegistry_StartHeartbeat(t *testing.T) {
+	a, b := New(time.Minute), New(time.Minute)
+	h, err := a.StartHeartbeat("tcp@127.0.0.1:9999", HeartbeatOption{Interval: time.Hour, Meta: map[string]string{"shard": "1"}})
+	if err != nil {
+		t.Fatal("start heartbeat:", err)
+	}
+	if h.Lease() == "" {
+		t.Fatal("registry should assign a lease")
+	}
+	if servers := a.aliveServers(""); len(servers) != 1 {
+		t.Fatal("server should be registered in its own registry, got", servers)
+	}
+	if servers := b.aliveServers(""); len(servers) != 0 {
+		t.Fatal("other registries should not see the server, got", servers)
+	}
+	resp, err := a.Client().Get("http://ignored/")
+	if err != nil {
+		t.Fatal("get:", err)
+	}
+	_ = resp.Body.Close()
+	if got := resp.Header.Get("X-GPMD-SERVERS"); got != "tcp@127.0.0.1:9999" {
+		t.Fatal("in-process client should reach the registry, got", got)
+	}
+	if err := h.Stop(); err != nil {
+		t.Fatal("stop:", err)
+	}
+	if servers := a.aliveServers(""); len(servers) != 0 {
+		t.Fatal("server should be deregistered, got", servers)
+	}
+}
+
+func TestRegistry_Clock(t *testing.T) {
+	clock := gpmdtest.NewFakeClock(time.Time{})
+	r := New(time.Minute)
+	r.Clock = clock
+	if _, err := r.putServer("", "tcp@127.0.0.1:9999", nil, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(59 * time.Second)
+	if servers := r.aliveServers(""); len(servers) != 1 {
+		t.Fatal("server should be alive before the timeout, got", servers)
+	}
+	clock.Advance(time.Second)
+	if servers := r.aliveServers(""); len(servers) != 0 {
+		t.Fatal("server should expire by the registry clock, got", servers)
+	}
+	if events := r.Events("", time.Time{}); len(events) == 0 || !events[0].Time.Equal(clock.Now().Add(-time.Minute)) {
+		t.Fatal("events should be stamped by the registry clock, got", events)
+	}
+}
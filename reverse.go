@@ -0,0 +1,333 @@
+package gpmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//reverseHello 反向连接建立后服务端先发送的一行登记信息：reverseHello + 名称 + "\n"
+const reverseHello = "GPMD-REVERSE "
+
+//maxReverseNameSize 登记名称的最大长度
+const maxReverseNameSize = 255
+
+const (
+	reverseMinBackoff = 100 * time.Millisecond
+	reverseMaxBackoff = 5 * time.Second
+)
+
+var errReverseListenerClosed = errors.New("rpc reverse: listener closed")
+
+//ServeReverse 用于无法接受入站连接的服务端（例如 NAT 后面的边缘节点）：主动连接 address 上的
+//ReverseListener 并以 name 登记，网关在这个连接上作为客户端发起调用。
+//服务端始终保持一个空闲的反向连接，网关开始使用它后立刻建立下一个，连接失败时按指数退避重试。
+//dial 为空时使用 net.Dialer，ctx 取消后关闭所有反向连接并返回
+func (s *Server) ServeReverse(ctx context.Context, network, address, name string, dial DialFunc) error {
+	if name == "" || len(name) > maxReverseNameSize || strings.ContainsAny(name, "\r\n") {
+		return fmt.Errorf("rpc reverse: invalid name %q", name)
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	var mu sync.Mutex
+	conns := make(map[net.Conn]struct{})
+	var wg sync.WaitGroup
+	defer func() {
+		mu.Lock()
+		for conn := range conns {
+			_ = conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	}()
+	backoff := reverseMinBackoff
+	wait := func() error {
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > reverseMaxBackoff {
+			backoff = reverseMaxBackoff
+		}
+		return nil
+	}
+	for {
+		conn, err := dial(ctx, network, address)
+		if err == nil {
+			if _, err = io.WriteString(conn, reverseHello+name+"\n"); err != nil {
+				_ = conn.Close()
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logf(LogWarn, "rpc reverse: dial %s error: %v, retry in %s", address, err, backoff)
+			if err := wait(); err != nil {
+				return err
+			}
+			continue
+		}
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		used := make(chan error, 1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+			}()
+			//网关发送 Option 之前连接可能空闲很久，读到第一个字节后再开始握手，避免握手超时
+			var first [1]byte
+			if _, err := io.ReadFull(conn, first[:]); err != nil {
+				used <- err
+				_ = conn.Close()
+				return
+			}
+			used <- nil
+			s.ServeConn(&prefixConn{Conn: conn, r: io.MultiReader(bytes.NewReader(first[:]), conn)})
+		}()
+		select {
+		case err := <-used:
+			if err == nil {
+				backoff = reverseMinBackoff
+				continue
+			}
+			//网关关闭了空闲的连接，退避之后重新登记
+			if err := wait(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//prefixConn 在读取连接之前先读取已经读出的数据
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+//reverseConn 一个登记过、还没有被网关使用的反向连接
+type reverseConn struct {
+	net.Conn
+	name    string
+	err     error         //watch 读取时遇到的错误
+	stopped chan struct{} //watch 退出后关闭
+}
+
+//ReverseListener 网关一侧接受服务端主动建立的反向连接，按照登记的名称保存，
+//客户端通过 Option{Dialer: l.Dial} 以名称作为地址连接，XClient 也可以这样使用
+type ReverseListener struct {
+	l       net.Listener
+	mu      sync.Mutex
+	idle    map[string][]*reverseConn
+	waiters map[string][]chan *reverseConn
+	closed  bool
+}
+
+//NewReverseListener 在 l 上接受反向连接，直到 Close
+func NewReverseListener(l net.Listener) *ReverseListener {
+	rl := &ReverseListener{
+		l:       l,
+		idle:    make(map[string][]*reverseConn),
+		waiters: make(map[string][]chan *reverseConn),
+	}
+	go rl.accept()
+	return rl
+}
+
+func (rl *ReverseListener) accept() {
+	for {
+		conn, err := rl.l.Accept()
+		if err != nil {
+			rl.mu.Lock()
+			closed := rl.closed
+			rl.mu.Unlock()
+			if !closed {
+				logf(LogError, "rpc reverse: accept error: %v", err)
+			}
+			return
+		}
+		go rl.register(conn)
+	}
+}
+
+//register 读取登记信息后把连接交给等待的 Dial，或者放入空闲列表
+func (rl *ReverseListener) register(conn net.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(DefaultHandshakeTimeout))
+	name, err := readReverseHello(conn)
+	_ = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		logf(LogError, "rpc reverse: register %s error: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	rc := &reverseConn{Conn: conn, name: name, stopped: make(chan struct{})}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.closed {
+		_ = conn.Close()
+		return
+	}
+	if waiters := rl.waiters[name]; len(waiters) > 0 {
+		rl.waiters[name] = waiters[1:]
+		waiters[0] <- rc
+		return
+	}
+	rl.idle[name] = append(rl.idle[name], rc)
+	go rl.watch(rc)
+}
+
+//readReverseHello 逐字节读取登记信息，避免读走之后的数据
+func readReverseHello(r io.Reader) (string, error) {
+	var line []byte
+	var b [1]byte
+	for len(line) <= len(reverseHello)+maxReverseNameSize {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			if !strings.HasPrefix(string(line), reverseHello) || len(line) == len(reverseHello) {
+				return "", fmt.Errorf("invalid hello %q", line)
+			}
+			return string(line[len(reverseHello):]), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("hello too long")
+}
+
+//watch 空闲期间服务端不会发送数据，读到数据或者错误说明连接已经不可用，从空闲列表中移除。
+//连接被 Dial 取走时通过设置读超时让 watch 退出
+func (rl *ReverseListener) watch(rc *reverseConn) {
+	var b [1]byte
+	_, rc.err = rc.Conn.Read(b[:])
+	if rc.err == nil {
+		rc.err = errors.New("unexpected data on idle connection")
+	}
+	rl.mu.Lock()
+	if rl.removeIdleLocked(rc) {
+		_ = rc.Conn.Close()
+	}
+	rl.mu.Unlock()
+	close(rc.stopped)
+}
+
+func (rl *ReverseListener) removeIdleLocked(rc *reverseConn) bool {
+	conns := rl.idle[rc.name]
+	for i, c := range conns {
+		if c == rc {
+			rl.idle[rc.name] = append(conns[:i:i], conns[i+1:]...)
+			if len(rl.idle[rc.name]) == 0 {
+				delete(rl.idle, rc.name)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+//Dial 满足 DialFunc，address 为服务端登记的名称，network 会被忽略。
+//没有空闲的反向连接时等待服务端建立新的连接，直到 ctx 结束
+func (rl *ReverseListener) Dial(ctx context.Context, _, address string) (net.Conn, error) {
+	for {
+		rl.mu.Lock()
+		if rl.closed {
+			rl.mu.Unlock()
+			return nil, errReverseListenerClosed
+		}
+		if conns := rl.idle[address]; len(conns) > 0 {
+			rc := conns[0]
+			rl.removeIdleLocked(rc)
+			rl.mu.Unlock()
+			_ = rc.Conn.SetReadDeadline(time.Now())
+			<-rc.stopped
+			if ne, ok := rc.err.(net.Error); !ok || !ne.Timeout() {
+				_ = rc.Conn.Close()
+				continue
+			}
+			_ = rc.Conn.SetReadDeadline(time.Time{})
+			return rc.Conn, nil
+		}
+		ch := make(chan *reverseConn, 1)
+		rl.waiters[address] = append(rl.waiters[address], ch)
+		rl.mu.Unlock()
+		select {
+		case rc := <-ch:
+			return rc.Conn, nil
+		case <-ctx.Done():
+			rl.mu.Lock()
+			rl.removeWaiterLocked(address, ch)
+			rl.mu.Unlock()
+			//取消等待之前可能已经收到了连接
+			select {
+			case rc := <-ch:
+				return rc.Conn, nil
+			default:
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (rl *ReverseListener) removeWaiterLocked(name string, ch chan *reverseConn) {
+	waiters := rl.waiters[name]
+	for i, w := range waiters {
+		if w == ch {
+			rl.waiters[name] = append(waiters[:i:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(rl.waiters[name]) == 0 {
+		delete(rl.waiters, name)
+	}
+}
+
+//Names 返回当前有空闲反向连接的服务端名称
+func (rl *ReverseListener) Names() []string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	names := make([]string, 0, len(rl.idle))
+	for name := range rl.idle {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Close 停止接受反向连接并关闭所有空闲的反向连接，已经被 Dial 取走的连接不受影响
+func (rl *ReverseListener) Close() error {
+	rl.mu.Lock()
+	rl.closed = true
+	for _, conns := range rl.idle {
+		for _, rc := range conns {
+			_ = rc.Conn.Close()
+		}
+	}
+	rl.idle = make(map[string][]*reverseConn)
+	rl.mu.Unlock()
+	return rl.l.Close()
+}
+
+//Addr 返回接受反向连接的地址
+func (rl *ReverseListener) Addr() net.Addr {
+	return rl.l.Addr()
+}
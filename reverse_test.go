@@ -0,0 +1,69 @@
+package gpmd
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeReverse(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	rl := NewReverseListener(l)
+	defer func() { _ = rl.Close() }()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.ServeReverse(ctx, "tcp", rl.Addr().String(), "agent-1", nil) }()
+
+	opt := &Option{Dialer: rl.Dial, ConnectTimeout: time.Second}
+	clients := make([]*Client, 2)
+	for i := range clients {
+		client, err := Dial("tcp", "agent-1", opt)
+		_assert(err == nil, "dial agent error: %v", err)
+		defer func() { _ = client.Close() }()
+		clients[i] = client
+	}
+	for _, client := range clients {
+		_assert(callSum(client) == nil, "expect call over reverse connection to succeed")
+	}
+	for i := 0; i < 50 && len(rl.Names()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(len(rl.Names()) == 1 && rl.Names()[0] == "agent-1", "expect an idle connection parked, got %v", rl.Names())
+
+	_, err := Dial("tcp", "agent-2", &Option{Dialer: rl.Dial, ConnectTimeout: 50 * time.Millisecond})
+	_assert(err != nil, "expect dial to unknown agent to time out")
+
+	cancel()
+	_assert(<-done == context.Canceled, "expect ServeReverse to return on cancel")
+	_assert(callSum(clients[0]) != nil, "expect reverse connections closed on cancel")
+}
+
+func TestReverseListener_DropsDeadIdle(t *testing.T) {
+	t.Parallel()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	rl := NewReverseListener(l)
+	defer func() { _ = rl.Close() }()
+	conn, err := net.Dial("tcp", rl.Addr().String())
+	_assert(err == nil, "dial error: %v", err)
+	_, _ = conn.Write([]byte(reverseHello + "agent\n"))
+	for i := 0; i < 50 && len(rl.Names()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(len(rl.Names()) == 1, "expect agent registered")
+	_ = conn.Close()
+	for i := 0; i < 50 && len(rl.Names()) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(len(rl.Names()) == 0, "expect closed idle connection removed, got %v", rl.Names())
+
+	_, err = readReverseHello(strings.NewReader("HELLO agent\n"))
+	_assert(err != nil, "expect invalid hello rejected")
+	name, err := readReverseHello(strings.NewReader(reverseHello + "agent\nrest"))
+	_assert(err == nil && name == "agent", "expect name parsed, got %q, %v", name, err)
+}
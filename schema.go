@@ -0,0 +1,227 @@
+package gpmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+//JSONSchema 一个类型按照 encoding/json 编码后的结构，只包含生成文档和客户端代码需要的部分
+type JSONSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Items                *JSONSchema            `json:"items,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+}
+
+//MethodSchema 一个方法的参数和返回值，多个参数的方法的参数是 MultiArgs 的结构体
+type MethodSchema struct {
+	Args  *JSONSchema `json:"args"`
+	Reply *JSONSchema `json:"reply"`
+}
+
+//SchemaDocument 服务端注册的所有方法的 JSON Schema，键为 "Service.Method"，
+//有名字的结构体放在 Defs 中，通过 "#/$defs/包名.类型名" 引用
+type SchemaDocument struct {
+	Methods map[string]MethodSchema `json:"methods"`
+	Defs    map[string]*JSONSchema  `json:"$defs,omitempty"`
+}
+
+var (
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfRawMessage    = reflect.TypeOf(json.RawMessage(nil))
+	typeOfJSONMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+//schemaGenerator 生成 JSONSchema，有名字的结构体只生成一次，可以互相引用和递归
+type schemaGenerator struct {
+	refPrefix string
+	defs      map[string]*JSONSchema
+}
+
+func newSchemaGenerator(refPrefix string) *schemaGenerator {
+	return &schemaGenerator{refPrefix: refPrefix, defs: make(map[string]*JSONSchema)}
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) *JSONSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch {
+	case t == typeOfTime:
+		return &JSONSchema{Type: "string", Format: "date-time"}
+	case t == typeOfRawMessage:
+		return &JSONSchema{}
+	case t.Implements(typeOfJSONMarshaler) || reflect.PtrTo(t).Implements(typeOfJSONMarshaler):
+		//自定义编码的类型无法推断结构
+		return &JSONSchema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return &JSONSchema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &JSONSchema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &JSONSchema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &JSONSchema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &JSONSchema{Type: "string", Format: "byte"} //encoding/json 以 base64 编码 []byte
+		}
+		return &JSONSchema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := t.String()
+		if _, ok := g.defs[name]; !ok {
+			g.defs[name] = nil //先占位，递归引用自身时直接返回引用
+			g.defs[name] = g.object(t)
+		}
+		return &JSONSchema{Ref: g.refPrefix + name}
+	default:
+		//interface{} 可以是任意值，chan、func 等无法编码为 JSON
+		return &JSONSchema{}
+	}
+}
+
+func (g *schemaGenerator) object(t reflect.Type) *JSONSchema {
+	s := &JSONSchema{Type: "object", Properties: make(map[string]*JSONSchema)}
+	g.fields(t, s.Properties)
+	return s
+}
+
+//fields 按照 encoding/json 的规则收集字段：遵循 json tag，没有 tag 的匿名结构体字段展开到外层
+func (g *schemaGenerator) fields(t reflect.Type, props map[string]*JSONSchema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if comma := strings.Index(tag, ","); comma >= 0 {
+			name, opts = tag[:comma], tag[comma+1:]
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			g.fields(ft, props)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue //非导出字段
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(","+opts+",", ",string,") {
+			props[name] = &JSONSchema{Type: "string"}
+			continue
+		}
+		props[name] = g.schema(f.Type)
+	}
+}
+
+//JSONSchema 返回所有已注册方法（不包括内置服务）的参数和返回值的 JSON Schema，
+//描述的是使用 codec.JsonType 或者 Client.CallRaw 时的 JSON 结构
+func (s *Server) JSONSchema() *SchemaDocument {
+	g := newSchemaGenerator("#/$defs/")
+	doc := &SchemaDocument{Methods: make(map[string]MethodSchema)}
+	s.rangeMethods(func(serviceMethod string, m *methodType) {
+		doc.Methods[serviceMethod] = MethodSchema{Args: g.schema(m.ArgType), Reply: g.schema(m.ReplyType)}
+	})
+	if len(g.defs) > 0 {
+		doc.Defs = g.defs
+	}
+	return doc
+}
+
+//OpenAPI 生成 OpenAPI 3.0 文档，每个方法是一个 POST basePath + "Service.Method"，
+//请求体和响应体都是 JSON，用于以 JSON 转发请求的 REST 网关（例如基于 Client.CallRaw 实现的网关）。
+//返回值可以直接用 encoding/json 编码
+func (s *Server) OpenAPI(title, version, basePath string) map[string]interface{} {
+	g := newSchemaGenerator("#/components/schemas/")
+	paths := make(map[string]interface{})
+	s.rangeMethods(func(serviceMethod string, m *methodType) {
+		service := serviceMethod[:strings.LastIndex(serviceMethod, ".")]
+		paths[basePath+serviceMethod] = map[string]interface{}{
+			"post": map[string]interface{}{
+				"operationId": serviceMethod,
+				"tags":        []string{service},
+				"requestBody": map[string]interface{}{
+					"required": true,
+					"content":  jsonContent(g.schema(m.ArgType)),
+				},
+				"responses": map[string]interface{}{
+					"200":     map[string]interface{}{"description": "OK", "content": jsonContent(g.schema(m.ReplyType))},
+					"default": map[string]interface{}{"description": "rpc error"},
+				},
+			},
+		}
+	})
+	return map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       map[string]interface{}{"title": title, "version": version},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": g.defs},
+	}
+}
+
+func jsonContent(schema *JSONSchema) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+//rangeMethods 按照名字顺序遍历除内置服务外的所有方法，保证生成的文档稳定
+func (s *Server) rangeMethods(fn func(serviceMethod string, m *methodType)) {
+	methods := make(map[string]*methodType)
+	s.serviceMap.Range(func(namei, svci interface{}) bool {
+		name := namei.(string)
+		if strings.HasPrefix(name, builtinServicePrefix) {
+			return true
+		}
+		for methodName, m := range svci.(*service).method {
+			methods[name+"."+methodName] = m
+		}
+		return true
+	})
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fn(name, methods[name])
+	}
+}
+
+//openAPIHTTP 以 JSON 返回 Server.OpenAPI，base 参数为方法路径的前缀，默认为 "/"
+type openAPIHTTP struct {
+	*Server
+}
+
+func (server openAPIHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	base := req.URL.Query().Get("base")
+	if base == "" {
+		base = "/"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(server.OpenAPI("gpmd", "1.0", base)); err != nil {
+		_, _ = fmt.Fprintln(w, "rpc: error encoding openapi:", err.Error())
+	}
+}
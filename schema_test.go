@@ -0,0 +1,66 @@
+package gpmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type Tree struct {
+	Value    int     `json:"value"`
+	Children []*Tree `json:"children,omitempty"`
+}
+
+type Audit struct {
+	CreatedAt time.Time
+}
+
+type Document struct {
+	Audit
+	ID     int64             `json:"id,string"`
+	Title  string            `json:"title"`
+	Labels map[string]string `json:"labels"`
+	Body   []byte            `json:"body"`
+	Root   *Tree             `json:"root"`
+	secret string
+	Skip   string `json:"-"`
+}
+
+type Docs int
+
+func (Docs) Get(id int64, reply *Document) error { return nil }
+
+func (Docs) Search(query string, limit int, reply *[]Document) error { return nil }
+
+func TestServer_JSONSchema(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.ExtendedMethods = true
+	_ = server.Register(new(Docs))
+	doc := server.JSONSchema()
+	_assert(len(doc.Methods) == 2, "expect builtin services excluded, got %d methods", len(doc.Methods))
+
+	get := doc.Methods["Docs.Get"]
+	_assert(get.Args.Type == "integer" && get.Reply.Ref == "#/$defs/gpmd.Document", "wrong Docs.Get schema %+v %+v", get.Args, get.Reply)
+	d := doc.Defs["gpmd.Document"]
+	_assert(d != nil && len(d.Properties) == 6, "expect 6 json fields, got %+v", d)
+	_assert(d.Properties["CreatedAt"].Format == "date-time", "expect embedded struct flattened and time as date-time")
+	_assert(d.Properties["id"].Type == "string", "expect ,string option to produce a string")
+	_assert(d.Properties["body"].Format == "byte", "expect []byte as base64 string")
+	_assert(d.Properties["labels"].AdditionalProperties.Type == "string", "expect map as object")
+	tree := doc.Defs["gpmd.Tree"]
+	_assert(tree != nil && tree.Properties["children"].Items.Ref == "#/$defs/gpmd.Tree", "expect recursive types referenced")
+
+	search := doc.Methods["Docs.Search"]
+	_assert(search.Args.Properties["Arg0"].Type == "string" && search.Args.Properties["Arg1"].Type == "integer", "expect multi args as object, got %+v", search.Args)
+	_assert(search.Reply.Type == "array" && search.Reply.Items.Ref == "#/$defs/gpmd.Document", "wrong search reply %+v", search.Reply)
+
+	data, err := json.Marshal(server.OpenAPI("docs", "1.0", "/api/"))
+	_assert(err == nil, "encode openapi error: %v", err)
+	var api struct {
+		Paths      map[string]map[string]interface{}
+		Components struct{ Schemas map[string]*JSONSchema }
+	}
+	_assert(json.Unmarshal(data, &api) == nil, "invalid openapi json")
+	_assert(api.Paths["/api/Docs.Get"]["post"] != nil && api.Components.Schemas["gpmd.Document"] != nil, "wrong openapi document %s", data)
+}
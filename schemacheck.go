@@ -0,0 +1,213 @@
+package gpmd
+
+import (
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//SchemaCheck 开发模式下的 gob 兼容性检查：注册服务时记录每个方法参数和返回值的 gob 结构，
+//与上一次记录的结构比较，同名字段的类型发生了 gob 无法解码的变化时记录日志，Refuse 时拒绝注册。
+//gob 按照字段名匹配，增加或者删除字段、整数和浮点数位宽的变化、指针与值之间的变化都是兼容的。
+//检查需要读写 Path，只应该在开发和测试环境开启，在部署之前发现意外的协议破坏
+type SchemaCheck struct {
+	Path   string //保存记录的 JSON 文件，不存在时创建；为空时只与之前经过这个 SchemaCheck 注册的实现比较
+	Refuse bool   //发现不兼容的变化时注册返回错误，默认只记录日志并更新记录
+
+	mu      sync.Mutex
+	loaded  bool
+	methods map[string]*methodSignature
+}
+
+//methodSignature 一个方法的参数和返回值的 gob 结构
+type methodSignature struct {
+	Args  *gobSignature `json:"args"`
+	Reply *gobSignature `json:"reply"`
+}
+
+//gobSignature 一个类型按照 encoding/gob 编码后的结构
+type gobSignature struct {
+	Kind   string                   `json:"kind"`           //bool、int、uint、float、complex、string、bytes、array、slice、map、struct、interface、opaque
+	Name   string                   `json:"name,omitempty"` //有名字的结构体和自定义编码的类型的名字
+	Key    *gobSignature            `json:"key,omitempty"`
+	Elem   *gobSignature            `json:"elem,omitempty"`
+	Fields map[string]*gobSignature `json:"fields,omitempty"` //为空的有名字的结构体是递归引用，不再展开
+}
+
+var (
+	typeOfGobEncoder      = reflect.TypeOf((*gob.GobEncoder)(nil)).Elem()
+	typeOfBinaryMarshaler = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	typeOfTextMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+//signatureOf 按照 gob 的规则生成 t 的结构，visiting 记录正在展开的结构体，避免无限递归
+func signatureOf(t reflect.Type, visiting map[reflect.Type]bool) *gobSignature {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for _, m := range []reflect.Type{typeOfGobEncoder, typeOfBinaryMarshaler, typeOfTextMarshaler} {
+		if t.Implements(m) || reflect.PtrTo(t).Implements(m) {
+			return &gobSignature{Kind: "opaque", Name: t.String()}
+		}
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Interface:
+		return &gobSignature{Kind: t.Kind().String()}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &gobSignature{Kind: "int"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &gobSignature{Kind: "uint"}
+	case reflect.Float32, reflect.Float64:
+		return &gobSignature{Kind: "float"}
+	case reflect.Complex64, reflect.Complex128:
+		return &gobSignature{Kind: "complex"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &gobSignature{Kind: "bytes"}
+		}
+		return &gobSignature{Kind: "slice", Elem: signatureOf(t.Elem(), visiting)}
+	case reflect.Array:
+		return &gobSignature{Kind: "array", Elem: signatureOf(t.Elem(), visiting)}
+	case reflect.Map:
+		return &gobSignature{Kind: "map", Key: signatureOf(t.Key(), visiting), Elem: signatureOf(t.Elem(), visiting)}
+	case reflect.Struct:
+		sig := &gobSignature{Kind: "struct", Name: t.Name()}
+		if visiting[t] {
+			return sig
+		}
+		visiting[t] = true
+		defer delete(visiting, t)
+		sig.Fields = make(map[string]*gobSignature)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			//gob 忽略非导出字段以及 chan 和 func 类型的字段，匿名字段以类型名作为字段名，不展开
+			if f.PkgPath != "" || f.Type.Kind() == reflect.Chan || f.Type.Kind() == reflect.Func {
+				continue
+			}
+			sig.Fields[f.Name] = signatureOf(f.Type, visiting)
+		}
+		return sig
+	default:
+		return &gobSignature{Kind: t.Kind().String()}
+	}
+}
+
+//incompatible 列出把 old 结构的数据解码到 cur 时 gob 会失败的地方，path 是字段的路径
+func incompatible(path string, old, cur *gobSignature) []string {
+	if old.Kind != cur.Kind {
+		return []string{path + ": " + old.describe() + " -> " + cur.describe()}
+	}
+	switch old.Kind {
+	case "opaque":
+		if old.Name != cur.Name {
+			return []string{path + ": " + old.describe() + " -> " + cur.describe()}
+		}
+	case "array", "slice":
+		return incompatible(path+"[]", old.Elem, cur.Elem)
+	case "map":
+		return append(incompatible(path+"[key]", old.Key, cur.Key), incompatible(path+"[value]", old.Elem, cur.Elem)...)
+	case "struct":
+		if old.Fields == nil || cur.Fields == nil {
+			return nil //递归引用，外层已经比较过
+		}
+		var changes []string
+		common := false
+		for name, o := range old.Fields {
+			if n, ok := cur.Fields[name]; ok {
+				common = true
+				changes = append(changes, incompatible(path+"."+name, o, n)...)
+			}
+		}
+		if !common && len(old.Fields) > 0 && len(cur.Fields) > 0 {
+			changes = append(changes, path+": no fields in common")
+		}
+		sort.Strings(changes)
+		return changes
+	}
+	return nil
+}
+
+func (s *gobSignature) describe() string {
+	if s.Name != "" {
+		return s.Kind + " " + s.Name
+	}
+	return s.Kind
+}
+
+//load 第一次检查时读取 Path 中的记录，调用方持有 mu
+func (c *SchemaCheck) load() error {
+	if c.loaded {
+		return nil
+	}
+	c.methods = make(map[string]*methodSignature)
+	if c.Path != "" {
+		data, err := ioutil.ReadFile(c.Path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return err
+		default:
+			if err = json.Unmarshal(data, &c.methods); err != nil {
+				return errors.New("rpc server: read schema record " + c.Path + ": " + err.Error())
+			}
+		}
+	}
+	c.loaded = true
+	return nil
+}
+
+//check 比较 service 中的方法与记录的结构，兼容或者没有设置 Refuse 时更新记录并写回 Path
+func (c *SchemaCheck) check(serviceName string, methods map[string]*methodType) error {
+	if strings.HasPrefix(serviceName, builtinServicePrefix) {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.load(); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(methods))
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sigs := make(map[string]*methodSignature, len(methods))
+	var changes []string
+	for _, name := range names {
+		m, serviceMethod := methods[name], serviceName+"."+name
+		sig := &methodSignature{
+			Args:  signatureOf(m.ArgType, make(map[reflect.Type]bool)),
+			Reply: signatureOf(m.ReplyType, make(map[reflect.Type]bool)),
+		}
+		sigs[serviceMethod] = sig
+		if old := c.methods[serviceMethod]; old != nil {
+			changes = append(changes, incompatible(serviceMethod+" args", old.Args, sig.Args)...)
+			changes = append(changes, incompatible(serviceMethod+" reply", old.Reply, sig.Reply)...)
+		}
+	}
+	if len(changes) > 0 {
+		msg := "rpc server: incompatible schema change: " + strings.Join(changes, "; ")
+		if c.Refuse {
+			return errors.New(msg)
+		}
+		logf(LogWarn, "%s", msg)
+	}
+	for serviceMethod, sig := range sigs {
+		c.methods[serviceMethod] = sig
+	}
+	if c.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.methods, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.Path, data, 0644)
+}
@@ -0,0 +1,68 @@
+package gpmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type DocV1 struct {
+	Title string
+	Count int
+	Next  *DocV1
+}
+
+type DocV2 struct {
+	Title string
+	Count string
+}
+
+type DocV3 struct {
+	Title string
+	Count int64
+	Next  *DocV3
+	Tags  []string
+}
+
+type DocsV1 int
+
+func (DocsV1) Get(_ int, reply *DocV1) error { return nil }
+
+type DocsV2 int
+
+func (DocsV2) Get(_ int, reply *DocV2) error { return nil }
+
+type DocsV3 int
+
+func (DocsV3) Get(_ int, reply *DocV3) error { return nil }
+
+func TestServer_SchemaCheck(t *testing.T) {
+	t.Parallel()
+	dir, err := ioutil.TempDir("", "gpmd-schema")
+	_assert(err == nil, "temp dir error: %v", err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "schema.json")
+
+	register := func(rcvr interface{}) error {
+		server := NewServer()
+		server.SchemaCheck = &SchemaCheck{Path: path, Refuse: true}
+		return server.RegisterName("Docs", rcvr)
+	}
+	_assert(register(new(DocsV1)) == nil, "expect first registration recorded")
+	_, err = os.Stat(path)
+	_assert(err == nil, "expect schema record written: %v", err)
+	//增加字段、整数位宽变化和递归的结构体都是兼容的
+	_assert(register(new(DocsV3)) == nil, "expect compatible change accepted")
+	err = register(new(DocsV2))
+	_assert(err != nil && strings.Contains(err.Error(), "Docs.Get reply.Count: int -> string"), "expect incompatible change refused, got %v", err)
+
+	//只记录日志时仍然注册成功，并更新记录
+	check := &SchemaCheck{}
+	s1, s2 := NewServer(), NewServer()
+	s1.SchemaCheck, s2.SchemaCheck = check, check
+	_assert(s1.RegisterName("Docs", new(DocsV1)) == nil, "expect registration")
+	_assert(s2.RegisterName("Docs", new(DocsV2)) == nil, "expect warning only without Refuse")
+	_assert(check.methods["Docs.Get"].Reply.Fields["Count"].Kind == "string", "expect record updated, got %+v", check.methods["Docs.Get"].Reply)
+}
@@ -0,0 +1,93 @@
+package gpmd
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//SerialMode 请求的串行执行方式，串行的请求按照到达的顺序逐个处理，
+//前一个请求的 handler 返回之后（包括超过 HandleTimeout 仍在运行的 handler）才开始处理下一个，
+//适合修改会话状态、原本需要自己加锁的 handler。内置服务（健康检查、反射、订阅）总是并发处理
+type SerialMode int
+
+const (
+	SerialNone       SerialMode = iota //并发处理，默认值
+	SerialPerConn                      //同一个连接上的请求串行处理，不同连接之间仍然并发，HTTP/2 的每个请求是单独的连接
+	SerialPerService                   //同一个服务的请求串行处理，包括来自不同连接的请求
+)
+
+//ParseSerialMode 将配置中的字符串转换为串行执行方式
+func ParseSerialMode(s string) (SerialMode, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return SerialNone, nil
+	case "conn", "connection":
+		return SerialPerConn, nil
+	case "service":
+		return SerialPerService, nil
+	default:
+		return 0, fmt.Errorf("rpc server: unknown serial mode %s", s)
+	}
+}
+
+//serialQueue 按照提交的顺序逐个启动，前一个调用 done 之后才启动下一个
+type serialQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+//submit start 不能阻塞，它启动的处理结束时必须调用一次 done
+func (q *serialQueue) submit(start func()) {
+	q.mu.Lock()
+	if q.running {
+		q.pending = append(q.pending, start)
+		q.mu.Unlock()
+		return
+	}
+	q.running = true
+	q.mu.Unlock()
+	start()
+}
+
+func (q *serialQueue) done() {
+	q.mu.Lock()
+	if len(q.pending) == 0 {
+		q.running = false
+		q.mu.Unlock()
+		return
+	}
+	start := q.pending[0]
+	q.pending[0] = nil
+	q.pending = q.pending[1:]
+	q.mu.Unlock()
+	start()
+}
+
+//serialQueue 返回请求所属的串行队列，不需要串行处理时返回空
+func (s *Server) serialQueue(c *Conn, serviceMethod string) *serialQueue {
+	if strings.HasPrefix(serviceMethod, builtinServicePrefix) {
+		return nil
+	}
+	switch s.Serial {
+	case SerialPerConn:
+		return &c.serial
+	case SerialPerService:
+		name := serviceMethod
+		if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+			name = serviceMethod[:dot]
+		}
+		q, _ := s.serialQueues.LoadOrStore(name, &serialQueue{})
+		return q.(*serialQueue)
+	default:
+		return nil
+	}
+}
+
+//serialDone 请求的 handler 已经返回，开始处理同一个队列中的下一个请求
+func (req *request) serialDone() {
+	if req.serial != nil {
+		req.serial.done()
+	}
+}
@@ -0,0 +1,75 @@
+package gpmd
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//Session 没有加锁的会话状态，依赖串行执行保证正确
+type Session struct {
+	active  int32
+	overlap int32
+	order   []int
+}
+
+func (s *Session) Append(n int, reply *int) error {
+	if atomic.AddInt32(&s.active, 1) > 1 {
+		atomic.StoreInt32(&s.overlap, 1)
+	}
+	time.Sleep(time.Millisecond)
+	s.order = append(s.order, n)
+	*reply = len(s.order)
+	atomic.AddInt32(&s.active, -1)
+	return nil
+}
+
+func startSerialServer(mode SerialMode) (string, *Session) {
+	server := NewServer()
+	server.Serial = mode
+	session := &Session{}
+	_ = server.Register(session)
+	return startLimitedServer(server), session
+}
+
+func TestServer_SerialPerConn(t *testing.T) {
+	t.Parallel()
+	addr, session := startSerialServer(SerialPerConn)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	calls := make([]*Call, 20)
+	for i := range calls {
+		calls[i] = client.Go("Session.Append", i, new(int), make(chan *Call, 1))
+	}
+	for _, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil, "append error: %v", call.Error)
+	}
+	_assert(atomic.LoadInt32(&session.overlap) == 0, "expect requests on one connection handled one at a time")
+	for i, n := range session.order {
+		_assert(n == i, "expect requests handled in order, got %v", session.order)
+	}
+	_assert(callSum(client) == nil, "expect other services still usable")
+}
+
+func TestServer_SerialPerService(t *testing.T) {
+	t.Parallel()
+	addr, session := startSerialServer(SerialPerService)
+	var calls []*Call
+	for i := 0; i < 3; i++ {
+		client, err := Dial("tcp", addr)
+		_assert(err == nil, "dial error: %v", err)
+		defer func() { _ = client.Close() }()
+		for j := 0; j < 5; j++ {
+			calls = append(calls, client.Go("Session.Append", j, new(int), make(chan *Call, 1)))
+		}
+	}
+	for _, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil, "append error: %v", call.Error)
+	}
+	_assert(atomic.LoadInt32(&session.overlap) == 0, "expect requests to one service handled one at a time across connections")
+	_assert(len(session.order) == len(calls), "expect %d appends, got %d", len(calls), len(session.order))
+}
@@ -1,25 +1,32 @@
 package gpmd
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"gpmd/codec"
+	"gpmd/metadata"
 	"io"
-	"log"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	MagicNumber      = 0x1234567
-	connected        = "200 Connected to GPMD RPC"
-	defaultRPCPath   = "/_gpmd_"
-	defaultDebugPath = "/debug/gpmd"
+	MagicNumber        = 0x1234567
+	connected          = "200 Connected to GPMD RPC"
+	defaultRPCPath     = "/_gpmd_"
+	defaultDebugPath   = "/debug/gpmd"
+	defaultStatsPath   = "/debug/gpmd/stats"
+	defaultOpenAPIPath = "/debug/gpmd/openapi.json"
 )
 
 type Option struct {
@@ -27,6 +34,29 @@ type Option struct {
 	CodeType       codec.Type    //客户端使用的用来编码body的方式
 	ConnectTimeout time.Duration //Client.Call 链接超时
 	HandleTimeout  time.Duration //server.handleRequest 处理超时
+	//CompressThreshold 编码后不小于这个字节数的消息会被压缩，0 表示不压缩。
+	//客户端和服务端各自决定自己发送的消息是否压缩，服务端回复时沿用客户端协商的值
+	CompressThreshold int
+	//ChunkSize 编码后超过这个字节数的请求和回复拆分为多个帧发送，0 表示不拆分，见 codec.ChunkCodec。
+	//服务端回复时沿用客户端协商的值，客户端没有开启时服务端不会拆分回复
+	ChunkSize int            `json:",omitempty"`
+	Encrypt   bool           //开启后 Option 之后的数据使用 Keyring 中的预共享密钥派生的会话密钥以 AES-GCM 加密
+	Keyring   *codec.Keyring `json:"-"` //客户端加密使用的密钥，不参与握手协商
+	Checksum  bool           //开启后 Option 之后的数据按帧校验 CRC32，数据损坏时返回 codec.DataLossError
+	Dialer    DialFunc       `json:"-"` //客户端建立连接的函数，为空时使用 net.Dialer，不参与握手协商
+	TLSConfig *tls.Config    `json:"-"` //TLSConfig 不为空时，客户端使用 TLS 建立连接，不参与握手协商
+	Metadata  metadata.MD    `json:"-"` //客户端每个请求都携带的元数据，ctx 中同名的元数据优先，不参与握手协商
+	Clock     Clock          `json:"-"` //调用超时和连接超时的计时方式，为空时使用 SystemClock，不参与握手协商
+	Events    ConnEvents     `json:"-"` //连接建立和断开时的回调，不参与握手协商
+	//MaxPendingCalls 客户端等待响应的调用数上限，达到上限时新的调用立即以 ErrTooManyPending 失败，
+	//而不是对卡住的服务端无限地积累等待的调用，0 表示不限制，不参与握手协商
+	MaxPendingCalls int `json:"-"`
+	//MaxCallAge 大于 0 时客户端在后台定期检查等待响应的调用，等待超过这个时间的调用以包装了 ErrDeadlineExceeded 的错误结束，
+	//保护没有使用 ctx 或者 WithCallTimeout 的调用方不会一直等待，不参与握手协商
+	MaxCallAge time.Duration `json:"-"`
+	//OrderedDelivery 开启后服务端按照请求到达的顺序发送这个连接上的回复，先完成的回复等待前面的请求，
+	//用于要求严格顺序的协议。一个慢请求会推迟之后所有的回复
+	OrderedDelivery bool `json:",omitempty"`
 }
 
 //DefaultOption 一般来说，涉及协议协商的这部分信息，需要设计固定的字节来传输的。
@@ -39,30 +69,108 @@ type Option struct {
 //在一次连接中，Option 固定在报文的最开始，Header 和 Body 可以有多个，即报文可能是这样的:
 //| Option | Header1 | Body1 | Header2 | Body2 | ...
 var DefaultOption = &Option{
-	MagicNumber,
-	codec.GobType,
-	10 * time.Second, //ConnectTimeout 默认值为 10s
-	0,                //HandleTimeout 默认值为 0，即不设限
+	MagicNumber:    MagicNumber,
+	CodeType:       codec.GobType,
+	ConnectTimeout: 10 * time.Second, //ConnectTimeout 默认值为 10s
+	HandleTimeout:  0,                //HandleTimeout 默认值为 0，即不设限
 }
 
 type Server struct {
-	serviceMap sync.Map
+	serviceMap            sync.Map
+	HandleTimeout         time.Duration       //客户端没有指定 HandleTimeout 时，服务端使用的默认处理超时，0 表示不设限
+	MaxConns              int                 //最大并发连接数，0 表示不限制
+	MaxPendingConns       int                 //连接数达到上限后允许排队等待的连接数，0 表示直接拒绝
+	AcceptRate            float64             //每秒最多接受的连接数，0 表示不限制
+	AcceptBurst           int                 //AcceptRate 允许的突发连接数，默认为 1
+	OnConnect             func(c *Conn) error //握手成功后调用，返回错误时关闭连接，可以用来做会话跟踪、限额和审计
+	OnDisconnect          func(c *Conn)       //连接上所有请求处理完、连接关闭前调用
+	Keyring               *codec.Keyring      //用来解密 Option.Encrypt 连接的预共享密钥，密钥编号由每一帧携带
+	RequireEncrypt        bool                //拒绝没有开启加密的连接，用于无法终结 TLS 的环境
+	Authorizer            Authorizer          //不为空时，每个请求调用 handler 之前先经过鉴权
+	Middlewares           []Middleware        //每个请求在 Authorizer 之前依次经过的中间件，可以向 handler 的 ctx 中放入值
+	MaxConcurrentRequests int                 //同时处理的请求数，超过的请求按照优先级排队等待，0 表示不限制
+	HandshakeTimeout      time.Duration       //等待客户端发送 Option 的时间，0 表示使用 DefaultHandshakeTimeout，负数表示不限制
+	Overload              *OverloadController //不为空时，过载期间拒绝一部分新请求
+	SlowCallThreshold     time.Duration       //处理时间超过该值的调用会记录详细日志，0 表示不记录
+	Defaults              ListenerOption      //所有连接的默认设置，ServeListener 传入的设置逐项覆盖
+	Serial                SerialMode          //请求的串行执行方式，默认并发处理，见 SerialMode
+	SchemaCheck           *SchemaCheck        //不为空时注册服务前检查方法的 gob 结构是否发生了不兼容的变化，用于开发环境
+	Debug                 *DebugOptions       //不为空时 HandleHTTP 同时挂载 pprof 和 expvar，见 DebugOptions
+	Metrics               Metrics             //这个 Server 的指标钩子，为空时使用 SetMetrics 设置的全局钩子
+	Tracer                Tracer              //这个 Server 的追踪钩子，为空时使用 SetTracer 设置的全局钩子，采样比例同样生效
+	FastPath              bool                //参数和回复是布尔、数字、字符串的方法复用池中的实例，每次调用少两次分配，编码不变，不会明显降低延迟
+	ExtendedMethods       bool                //Register 等还接受多参数、变长参数和返回 (R, error) 的方法，默认只接受 func([ctx,] args, reply *R) error
+	Clock                 Clock               //处理超时和排队时间的计时方式，为空时使用 SystemClock
+
+	unknownHandler atomic.Value //UnknownServiceHandler，通过 SetUnknownServiceHandler 设置
+	handleMu       sync.Mutex   //Handle 替换服务的方法表时加锁
+	methods        methodCache  //"Service.Method" 到方法的快照，serviceMap 变化后重建
+
+	aliases       sync.Map //方法别名，键和值都是 "Service.Method"
+	serialQueues  sync.Map //SerialPerService 时每个服务的串行队列，键为服务名
+	warnedAliases sync.Map //已经记录过弃用日志的别名
+
+	connSeq        uint64 //用来生成连接编号
+	draining       int32  //为 1 时拒绝新的请求，见 Drain
+	bytesRead      uint64 //原生连接握手之后读取的字节数
+	bytesWritten   uint64 //原生连接握手之后写出的字节数
+	activeConns    int64  //握手成功、还没有关闭的连接数
+	activeHandlers int64  //正在执行的 handler 数
+	topicMu        sync.Mutex
+	topics         map[string]map[*Conn]struct{} //topics 记录每个主题的订阅连接
+
+	poolOnce sync.Once
+	pool     *workerPool //MaxConcurrentRequests 大于 0 时处理请求的 worker
+
+	limitOnce     sync.Once
+	connSlots     chan struct{} //connSlots 容量为 MaxConns 的信号量
+	pendingConns  int32         //正在排队等待的连接数
+	acceptLimiter *rateLimiter
 }
 
 var DefaultServer = NewServer()
 
 func NewServer() *Server {
-	return &Server{}
+	s := &Server{topics: make(map[string]map[*Conn]struct{})}
+	_ = s.register(newNamedService(&reflection{server: s}, ReflectionServiceName, false))
+	_ = s.register(newNamedService(&pushService{server: s}, PushServiceName, false))
+	_ = s.register(newNamedService(&healthService{}, HealthServiceName, false))
+	return s
+}
+
+//clock 返回这个 Server 使用的时钟
+func (s *Server) clock() Clock {
+	return clockOr(s.Clock)
+}
+
+//metrics 返回这个 Server 使用的指标钩子
+func (s *Server) metrics() Metrics {
+	if s.Metrics != nil {
+		return s.Metrics
+	}
+	return GetMetrics()
+}
+
+//tracer 返回这个 Server 使用的追踪钩子
+func (s *Server) tracer() Tracer {
+	if s.Tracer != nil {
+		return sampled(s.Tracer)
+	}
+	return GetTracer()
 }
 
 func (s *Server) Accept(lis net.Listener) {
+	s.initLimits()
 	for {
+		if s.acceptLimiter != nil {
+			s.acceptLimiter.wait()
+		}
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
+			logf(LogError, "rpc server: accept error: %v", err)
 			return
 		}
-		go s.ServeConn(conn)
+		go s.serveLimited(conn, nil)
 	}
 }
 
@@ -70,61 +178,247 @@ func Accept(lis net.Listener) {
 	DefaultServer.Accept(lis)
 }
 
+//DefaultHandshakeTimeout 连接建立后客户端必须在这个时间内发送 Option，否则连接被关闭
+const DefaultHandshakeTimeout = 5 * time.Second
+
+//maxOptionSize 握手时 Option 的最大字节数，避免对端发送无穷无尽的 JSON 耗尽内存
+const maxOptionSize = 64 << 10
+
+//readOption 解码握手的 Option，返回 json.Decoder 多读出来的数据，
+//Option 中没有指定 CodeType 时使用 defaultType，Option 不合法时返回 *HandshakeError
+func readOption(conn io.Reader, defaultType codec.Type) (Option, []byte, error) {
+	var opt Option
+	dec := json.NewDecoder(&io.LimitedReader{R: conn, N: maxOptionSize})
+	if err := dec.Decode(&opt); err != nil {
+		return opt, nil, err
+	}
+	if opt.MagicNumber != MagicNumber {
+		return opt, nil, &HandshakeError{Reason: fmt.Sprintf("invalid magic number %x", opt.MagicNumber)}
+	}
+	if opt.CodeType == "" {
+		opt.CodeType = defaultType
+	}
+	if codec.NewCodecFuncMap[opt.CodeType] == nil {
+		return opt, nil, &HandshakeError{Reason: fmt.Sprintf("invalid codec type %s", opt.CodeType)}
+	}
+	buffered, _ := ioutil.ReadAll(dec.Buffered())
+	return opt, buffered, nil
+}
+
+//readOptionTimeout 在握手超时之前读取 Option，超时后连接会被关闭。
+//net.Conn 使用读超时实现，其他类型的连接通过定时关闭连接实现
+func (s *Server) readOptionTimeout(conn io.ReadWriteCloser, lo *ListenerOption) (Option, []byte, error) {
+	timeout := lo.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	if timeout < 0 {
+		return readOption(conn, lo.CodeType)
+	}
+	var expired int32
+	if nc, ok := conn.(net.Conn); ok {
+		_ = nc.SetReadDeadline(time.Now().Add(timeout))
+		defer func() { _ = nc.SetReadDeadline(time.Time{}) }()
+	} else {
+		timer := time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&expired, 1)
+			_ = conn.Close()
+		})
+		defer timer.Stop()
+	}
+	opt, buffered, err := readOption(conn, lo.CodeType)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() || atomic.LoadInt32(&expired) == 1 {
+		s.metrics().Inc("gpmd_server_handshake_timeouts_total")
+		return opt, nil, fmt.Errorf("handshake timeout: expect within %s", timeout)
+	}
+	return opt, buffered, err
+}
+
+//ServeConn 在一个连接上提供服务，连接使用 Server.Defaults 中的设置
 func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	s.serveConn(conn, s.listenerOption(nil))
+}
+
+func (s *Server) serveConn(conn io.ReadWriteCloser, lo *ListenerOption) {
 	defer func() { _ = conn.Close() }()
-	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error:", err)
+	//任何来自网络的数据都不应该让服务端崩溃，自定义的 Codec 出现 panic 时只关闭这个连接
+	defer func() {
+		if r := recover(); r != nil {
+			logf(LogError, "rpc server: panic serving connection: %v", r)
+		}
+	}()
+	opt, buffered, err := s.readOptionTimeout(conn, lo)
+	if herr, ok := err.(*HandshakeError); ok {
+		s.rejectHandshake(conn, remoteAddr(conn), herr)
 		return
 	}
-	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc server:invalid codec type %s\n", opt.CodeType)
+	if err != nil {
+		logf(LogError, "rpc server: options error: %v", err)
 		return
 	}
+	lo.negotiate(&opt)
 	f := codec.NewCodecFuncMap[opt.CodeType]
-	if f == nil {
-		log.Printf("rpc server: invalid codec type %s", opt.CodeType)
+	c := s.newConn(conn, &opt)
+	herr := lo.checkHandshake(&opt)
+	if herr == nil && opt.Encrypt && s.Keyring == nil {
+		herr = &HandshakeError{Reason: "encryption is not supported"}
+	}
+	if herr != nil {
+		s.rejectHandshake(conn, c.RemoteAddr, herr)
 		return
 	}
-	s.serveCodec(f(conn), &opt)
+	var rwc io.ReadWriteCloser = &byteCountingConn{&bufferedConn{r: io.MultiReader(bytes.NewReader(buffered), conn), ReadWriteCloser: conn}, s}
+	if opt.Encrypt {
+		if rwc, err = codec.NewEncryptConn(rwc, s.Keyring); err != nil {
+			logf(LogError, "rpc server: encrypt error: %v", err)
+			return
+		}
+	}
+	if opt.Checksum {
+		rwc = codec.NewChecksumConn(rwc)
+	}
+	c.cc = codec.NewCompressCodec(f(rwc), opt.CodeType, opt.CompressThreshold)
+	if opt.ChunkSize > 0 {
+		c.cc = codec.NewChunkCodec(c.cc, opt.CodeType, opt.ChunkSize)
+	}
+	//服务端在 serveCodec 之前不会写出任何数据，OnConnect 拒绝时同样可以回复错误帧
+	if s.OnConnect != nil {
+		if err := s.OnConnect(c); err != nil {
+			s.rejectHandshake(conn, c.RemoteAddr, &HandshakeError{Reason: err.Error()})
+			return
+		}
+	}
+	if lo.WriteQueue > 0 {
+		c.cc = s.newQueuedCodec(c.cc, conn, lo)
+	}
+	if s.OnDisconnect != nil {
+		defer s.OnDisconnect(c)
+	}
+	defer c.close()
+	defer s.unsubscribeAll(c)
+	atomic.AddInt64(&s.activeConns, 1)
+	defer atomic.AddInt64(&s.activeConns, -1)
+	s.serveCodec(c.cc, c)
+}
+
+//bufferedConn json.Decoder 解码 Option 时可能多读了紧随其后的 Header 和 Body，
+//这里先读出 json.Decoder 中缓存的数据，再继续从连接中读取，避免丢失报文。
+//json.Encoder 会在 Option 之后追加一个换行符，它可能在缓存中，也可能稍后才从连接中读到，需要跳过它
+type bufferedConn struct {
+	r io.Reader
+	io.ReadWriteCloser
+	started bool
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		n, err := c.r.Read(p)
+		if !c.started && n > 0 {
+			c.started = true
+			if p[0] == '\n' {
+				n = copy(p, p[1:n])
+			}
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
 }
 
 // invalidRequest is a placeholder for response argv when error occurs
 var invalidRequest = struct{}{}
 
-func (s *Server) serveCodec(cc codec.Codec, opt *Option) {
-	sending := new(sync.Mutex) //确保发送完整的response
-	wg := new(sync.WaitGroup)  //确保所有的请求都被处理完
+func (s *Server) serveCodec(cc codec.Codec, c *Conn) {
+	sending := &c.sending          //确保发送完整的response，推送消息也使用同一把锁
+	wg := new(sync.WaitGroup)      //确保所有的请求都被处理完
+	timeout := c.Opt.HandleTimeout //握手时已经按照服务端的设置补全和限制
+	if c.Opt.OrderedDelivery {
+		c.order = newReplyOrder()
+	}
 	for {
 		req, err := s.readRequest(cc)
+		if req != nil && c.order != nil {
+			req.order = c.order.next()
+		}
 		if err != nil {
 			if req == nil {
 				break //出错了，关闭连接
 			}
-			req.h.Error = err.Error()
-			s.sendResponse(cc, req.h, invalidRequest, sending)
+			s.respond(cc, c, req, errorResponse(req.h, c.Opt.CodeType, err), sending)
 			continue
 		}
-		wg.Add(1)
-		go s.handleRequest(cc, req, sending, wg, opt.HandleTimeout)
+		s.serveRequest(cc, c, req, sending, wg, timeout)
+		if req.body != nil {
+			//等待 UnknownServiceHandler 读取 body 之后才能读取下一个请求
+			<-req.body.done
+		}
 	}
 	wg.Wait()
 	_ = cc.Close()
 }
 
+//serveRequest 对读取到的请求做过载检查，通过后交给 worker 按照优先级排队处理
+func (s *Server) serveRequest(cc codec.Codec, c *Conn, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+	if s.Draining() && !strings.HasPrefix(req.h.ServiceMethod, builtinServicePrefix) {
+		if req.body != nil {
+			req.body.discard()
+		}
+		s.respond(cc, c, req, errorResponse(req.h, c.Opt.CodeType, errDraining), sending)
+		return
+	}
+	if s.Overload != nil && !strings.HasPrefix(req.h.ServiceMethod, builtinServicePrefix) {
+		if !s.Overload.admit() {
+			if req.body != nil {
+				req.body.discard()
+			}
+			s.metrics().Inc("gpmd_server_overload_rejections_total", "method", req.h.ServiceMethod)
+			s.respond(cc, c, req, errorResponse(req.h, c.Opt.CodeType, s.Overload.unavailable()), sending)
+			return
+		}
+		req.admitted = true
+	}
+	req.queued = s.clock().Now()
+	wg.Add(1)
+	start := func() {
+		if req.body != nil {
+			//读取请求的循环在等待 handler 读取 body，不能排在 worker 的队列中
+			go s.handleRequest(cc, c, req, sending, wg, timeout)
+			return
+		}
+		s.dispatch(requestPriority(req.h), func() { s.handleRequest(cc, c, req, sending, wg, timeout) })
+	}
+	if req.serial = s.serialQueue(c, req.h.ServiceMethod); req.serial != nil {
+		req.serial.submit(start)
+		return
+	}
+	start()
+}
+
 //request 保存一次请求的所有信息
 type request struct {
 	h            *codec.Header //请求中的header信息
 	argv, replyv reflect.Value //argv and replyv of request
 	mType        *methodType
 	svc          *service
+	unknown      UnknownServiceHandler //方法没有注册时处理请求的 handler
+	body         *bodyDecoder          //unknown 不为空时延迟读取 body
+	queued       time.Time             //进入队列的时间
+	admitted     bool                  //是否计入了 Overload 正在处理的请求
+	serial       *serialQueue          //不为空时 handler 返回后需要调用 serialDone
+	pooled       bool                  //argv 和 replyv 可能来自 methodType 的池，见 Server.FastPath
+	order        uint64                //Option.OrderedDelivery 时请求在连接上的序号
+	rawArgs      []byte                //不为空时是反射服务 Invoke 转发的请求，JSON 编码的参数
+	deadline     time.Time             //按照服务端的 Clock 换算的截止时间，零值表示没有截止时间
 }
 
 func (s *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
 	var h codec.Header
 	if err := cc.ReadHeader(&h); err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error:", err)
+			logf(LogError, "rpc server: read header error: %v", err)
 		}
 		return nil, err
 	}
@@ -139,19 +433,35 @@ func (s *Server) readRequest(cc codec.Codec) (*request, error) {
 	if err != nil {
 		return nil, err
 	}
-	req := &request{h: h}
+	req := &request{h: h, deadline: s.requestDeadline(h)}
+	if h.ServiceMethod == reflectionInvoke {
+		return s.readInvokeRequest(cc, req)
+	}
 	req.svc, req.mType, err = s.findService(h.ServiceMethod)
 	if err != nil {
+		if req.unknown = s.unknownServiceHandler(); req.unknown != nil {
+			req.body = newBodyDecoder(cc)
+			return req, nil
+		}
+	}
+	if err != nil {
+		//必须跳过这个请求的 body，否则下一次会把 body 当作 Header 解码，导致连接上的数据错位
+		if discardErr := cc.ReadBody(nil); discardErr != nil {
+			return nil, discardErr
+		}
 		return req, err
 	}
-	req.argv = req.mType.newArgv()
-	req.replyv = req.mType.newReply()
+	if s.FastPath {
+		req.argv, req.replyv, req.pooled = req.mType.fastArgv(), req.mType.fastReply(), true
+	} else {
+		req.argv, req.replyv = req.mType.newArgv(), req.mType.newReply()
+	}
 	argvInterface := req.argv.Interface()
 	if req.argv.Type().Kind() != reflect.Ptr {
 		argvInterface = req.argv.Addr().Interface()
 	}
 	if err = cc.ReadBody(argvInterface); err != nil {
-		log.Println("rpc server: read argv error:", err)
+		logf(LogError, "rpc server: read argv error: %v, request id: %s", err, h.RequestID)
 		return req, err
 	}
 	return req, nil
@@ -160,26 +470,93 @@ func (s *Server) readRequest(cc codec.Codec) (*request, error) {
 func (s *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
 	sending.Lock()
 	defer sending.Unlock()
-	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error:", err)
+	var err error
+	if chunked, ok := cc.(*codec.ChunkCodec); ok {
+		err = chunked.WriteChunks(h, body, -1, sending)
+	} else {
+		err = cc.Write(h, body)
+	}
+	if err != nil {
+		logf(LogError, "rpc server: write response error: %v, request id: %s", err, h.RequestID)
+	}
+}
+
+//respond 发送 req 的回复，连接开启了 Option.OrderedDelivery 时等待前面的请求都已经回复之后才写出
+func (s *Server) respond(cc codec.Codec, c *Conn, req *request, body interface{}, sending *sync.Mutex) {
+	if c.order == nil {
+		s.sendResponse(cc, req.h, body, sending)
+		return
 	}
+	h := *req.h //handler 超时后 req.h 仍会被修改
+	c.order.deliver(req.order, func() { s.sendResponse(cc, &h, body, sending) })
 }
 
-func (s *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
+func (s *Server) handleRequest(cc codec.Codec, c *Conn, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
 	defer wg.Done()
+	if req.body != nil {
+		defer req.body.discard()
+	}
+	if req.admitted {
+		queued := s.clock().Now().Sub(req.queued)
+		defer s.Overload.done(queued)
+	}
+	//排队期间已经注定超时的请求直接返回，不再浪费处理时间
+	if shouldShed(req, s.clock().Now()) {
+		s.metrics().Inc("gpmd_server_shed_requests_total", "method", req.h.ServiceMethod)
+		req.serialDone()
+		req.h.Error = ErrDeadlineExceeded.Error()
+		s.respond(cc, c, req, invalidRequest, sending)
+		return
+	}
+	//handler 通过 ctx 获取请求编号和所在的连接，处理超时或者超过客户端的截止时间后 ctx 会被取消
+	ctx := ContextWithRequestID(context.WithValue(context.Background(), connKey{}, c), req.h.RequestID)
+	if len(req.h.Metadata) > 0 {
+		ctx = metadata.NewIncomingContext(ctx, req.h.Metadata)
+	}
+	if req.h.Priority != 0 {
+		ctx = ContextWithPriority(ctx, Priority(req.h.Priority))
+	}
+	var cancel context.CancelFunc
+	if !req.deadline.IsZero() {
+		//按照 Clock 换算为剩余时间，服务端使用 FakeClock 时截止时间同样以它为准
+		ctx, cancel = context.WithTimeout(ctx, req.deadline.Sub(s.clock().Now()))
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	ctx, span := s.tracer().StartSpan(ctx, req.h.ServiceMethod, SpanServer)
+	if req.h.RequestID != "" {
+		span.SetTag("request_id", req.h.RequestID)
+	}
 	//这里需要确保 sendResponse 仅调用一次，因此将整个过程拆分为 called 和 sent 两个阶段
 	called := make(chan struct{})
 	sent := make(chan struct{})
 	go func() {
-		err := req.svc.call(req.mType, req.argv, req.replyv)
+		start := s.clock().Now()
+		ctx, err := s.intercept(ctx, req.h.ServiceMethod)
+		atomic.AddInt64(&s.activeHandlers, 1)
+		if err == nil && req.unknown != nil {
+			err = s.callUnknown(ctx, req.unknown, req)
+		} else if err == nil {
+			err = req.svc.call(ctx, req.mType, req.argv, req.replyv)
+			req.releaseArgv()
+		}
+		if err == nil && req.rawArgs != nil {
+			err = req.marshalReply()
+		}
+		atomic.AddInt64(&s.activeHandlers, -1)
+		req.serialDone()
+		span.Finish(err)
+		s.logSlowCall(c, req, s.clock().Now().Sub(start), err)
 		called <- struct{}{}
 		if err != nil {
-			req.h.Error = err.Error()
-			s.sendResponse(cc, req.h, invalidRequest, sending)
+			req.releaseReply()
+			s.respond(cc, c, req, errorResponse(req.h, c.Opt.CodeType, err), sending)
 			sent <- struct{}{}
 			return
 		}
-		s.sendResponse(cc, req.h, req.replyv.Interface(), sending)
+		s.respond(cc, c, req, req.replyBody(cc, c), sending)
+		req.releaseReply()
 		sent <- struct{}{}
 	}()
 	if timeout == 0 {
@@ -187,30 +564,90 @@ func (s *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex
 		<-sent
 		return
 	}
+	expired, timer := after(s.clock(), timeout)
+	defer timer.Stop()
 	select {
-	case <-time.After(timeout):
+	case <-expired:
 		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		s.sendResponse(cc, req.h, invalidRequest, sending)
+		logf(LogWarn, "rpc server: %s handle timeout, request id: %s", req.h.ServiceMethod, req.h.RequestID)
+		s.respond(cc, c, req, invalidRequest, sending)
 	case <-called:
 		<-sent
 	}
 }
 
 func (s *Server) Register(rcvr interface{}) error {
-	service := newService(rcvr)
+	return s.register(newService(rcvr, s.ExtendedMethods))
+}
+
+func (s *Server) register(service *service) error {
+	if _, dup := s.serviceMap.Load(service.name); dup {
+		return errors.New("rpc: service already defined:" + service.name)
+	}
+	if s.SchemaCheck != nil {
+		if err := s.SchemaCheck.check(service.name, service.method); err != nil {
+			return err
+		}
+	}
 	if _, dup := s.serviceMap.LoadOrStore(service.name, service); dup {
 		return errors.New("rpc: service already defined:" + service.name)
 	}
+	s.methods.rebuild(&s.serviceMap)
 	return nil
 }
 
 func Register(rcvr interface{}) error { return DefaultServer.Register(rcvr) }
 
+//RegisterInterface 以 name 注册服务，只公开接口 iface（形如 (*Greeter)(nil)）中声明的方法，
+//rcvr 可以是任何实现了该接口的值，包括动态生成的包装类型，接口中的方法都需要符合调用规则
+func (s *Server) RegisterInterface(name string, iface, rcvr interface{}) error {
+	if err := checkServiceName(name); err != nil {
+		return err
+	}
+	svc, err := newInterfaceService(name, iface, rcvr, s.ExtendedMethods)
+	if err != nil {
+		return err
+	}
+	return s.register(svc)
+}
+
+//RegisterFuncs 将一组函数（可以是闭包或者包装过的业务函数）以 name 注册为一个服务，
+//键为方法名，值的签名与方法相同：func([ctx context.Context,] args T, reply *R) error
+func (s *Server) RegisterFuncs(name string, funcs map[string]interface{}) error {
+	if err := checkServiceName(name); err != nil {
+		return err
+	}
+	svc, err := newFuncService(name, funcs, s.ExtendedMethods)
+	if err != nil {
+		return err
+	}
+	return s.register(svc)
+}
+
 //findService 的实现看似比较繁琐，但是逻辑还是非常清晰的。
 //因为 ServiceMethod 的构成是 “Service.Method”，因此先将其分割成 2 部分，
 //第一部分是 Service 的名称，第二部分即方法名。现在 serviceMap 中找到对应的 service 实例，
-//再从 service 实例的 method 中，找到对应的 methodType
+//再从 service 实例的 method 中，找到对应的 methodType。找不到时再查找通过 Alias 设置的别名。
+//已经注册的方法先在 methodCache 的快照中查找，只需要一次 map 查找
 func (s *Server) findService(serviceMethod string) (svc *service, mType *methodType, err error) {
+	svc, mType, err = s.lookupService(serviceMethod)
+	if err != nil {
+		if aliasSvc, aliasType, ok := s.resolveAlias(serviceMethod); ok {
+			return aliasSvc, aliasType, nil
+		}
+	}
+	return
+}
+
+func (s *Server) lookupService(serviceMethod string) (svc *service, mType *methodType, err error) {
+	if e, ok := s.methods.lookup(serviceMethod); ok {
+		return e.svc, e.mType, nil
+	}
+	return s.loadService(serviceMethod)
+}
+
+//loadService 分割 serviceMethod 之后在 serviceMap 中查找，找不到时返回具体的原因
+func (s *Server) loadService(serviceMethod string) (svc *service, mType *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
 		err = errors.New("rpc server: service/method request ill-formed:" + serviceMethod)
@@ -219,13 +656,13 @@ func (s *Server) findService(serviceMethod string) (svc *service, mType *methodT
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	serviceInterface, ok := s.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("rpc server: can't find service" + serviceName)
+		err = &sentinelError{"rpc server: can't find service" + serviceName, ErrNotFound}
 		return
 	}
 	svc = serviceInterface.(*service)
 	mType = svc.method[methodName]
 	if mType == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = &sentinelError{"rpc server: can't find method " + methodName, ErrNotFound}
 	}
 	return
 }
@@ -239,17 +676,35 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Println("rpc hijacking", req.RemoteAddr, ": ", err.Error())
+		logf(LogError, "rpc hijacking %s : %s", req.RemoteAddr, err.Error())
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
-	s.ServeConn(conn)
+	s.initLimits()
+	s.serveLimited(conn, nil)
+}
+
+//Router 可以按照路径注册 handler 的路由，*http.ServeMux 以及常见的第三方路由都满足
+type Router interface {
+	Handle(pattern string, handler http.Handler)
 }
 
+//HandleHTTP 在 http.DefaultServeMux 上注册 CONNECT 入口和调试页面，见 HandleHTTPOn
 func (s *Server) HandleHTTP() {
-	http.Handle(defaultRPCPath, s)
-	http.Handle(defaultDebugPath, debugHTTP{s})
-	log.Println("rpc server debug path:", defaultDebugPath)
+	s.HandleHTTPOn(http.DefaultServeMux)
+}
+
+//HandleHTTPOn 在 mux 上注册 CONNECT 入口和调试页面，嵌入到其他应用时由应用控制自己的路由，
+//同一个进程中的多个 Server 需要注册到不同的 mux，同一个 mux 上重复注册时 http.ServeMux 会 panic
+func (s *Server) HandleHTTPOn(mux Router) {
+	mux.Handle(defaultRPCPath, s)
+	mux.Handle(defaultDebugPath, debugHTTP{s})
+	mux.Handle(defaultStatsPath, statsHTTP{s})
+	mux.Handle(defaultOpenAPIPath, openAPIHTTP{s})
+	if s.Debug != nil {
+		s.handleDebug(s.Debug, mux)
+	}
+	logf(LogInfo, "rpc server debug path: %s", defaultDebugPath)
 }
 
 func HandleHTTP() {
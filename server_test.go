@@ -0,0 +1,568 @@
+package gpmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gpmd/codec"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func startLimitedServer(server *Server) string {
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	return l.Addr().String()
+}
+
+func callSum(client *Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var reply int
+	return client.Call(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+}
+
+func TestServer_MaxConns(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.MaxConns = 1
+	addr := startLimitedServer(server)
+
+	first, _ := Dial("tcp", addr)
+	_assert(callSum(first) == nil, "first connection should be served")
+	second, _ := Dial("tcp", addr)
+	var herr *HandshakeError
+	err := callSum(second)
+	_assert(errors.As(err, &herr) && strings.Contains(herr.Reason, "too many connections"), "second connection should be rejected with a HandshakeError, got %v", err)
+
+	_ = first.Close()
+	time.Sleep(100 * time.Millisecond)
+	third, _ := Dial("tcp", addr)
+	defer func() { _ = third.Close() }()
+	_assert(callSum(third) == nil, "connection should be served after the first one closed")
+}
+
+func TestServer_MaxPendingConns(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.MaxConns = 1
+	server.MaxPendingConns = 1
+	addr := startLimitedServer(server)
+
+	first, _ := Dial("tcp", addr)
+	_assert(callSum(first) == nil, "first connection should be served")
+	second, _ := Dial("tcp", addr)
+	defer func() { _ = second.Close() }()
+	done := make(chan error, 1)
+	go func() {
+		var reply int
+		done <- second.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	}()
+	select {
+	case <-done:
+		t.Fatal("queued connection should wait for a free slot")
+	case <-time.After(200 * time.Millisecond):
+	}
+	third, _ := Dial("tcp", addr)
+	defer func() { _ = third.Close() }()
+	var herr *HandshakeError
+	err := callSum(third)
+	_assert(errors.As(err, &herr) && strings.Contains(herr.Reason, "too many connections"), "connection beyond MaxPendingConns should be rejected with a HandshakeError, got %v", err)
+	_ = first.Close()
+	_assert(<-done == nil, "queued connection should be served after the first one closed")
+}
+
+func TestServer_ListenerMaxConns(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.ServeListener(l, &ListenerOption{MaxConns: 1})
+
+	first, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = first.Close() }()
+	_assert(callSum(first) == nil, "first connection should be served")
+	second, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = second.Close() }()
+	var herr *HandshakeError
+	err := callSum(second)
+	_assert(errors.As(err, &herr) && strings.Contains(herr.Reason, "limit 1"), "second connection should be rejected with a HandshakeError, got %v", err)
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(20, 1)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.wait()
+	}
+	_assert(time.Since(start) >= 90*time.Millisecond, "rate limiter should delay, took %s", time.Since(start))
+}
+
+func TestServer_ConnHooks(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	connected := make(chan *Conn, 2)
+	disconnected := make(chan *Conn, 2)
+	var count int32
+	server.OnConnect = func(c *Conn) error {
+		if atomic.AddInt32(&count, 1) > 1 {
+			return errors.New("only one connection allowed")
+		}
+		c.Set("user", "alice")
+		connected <- c
+		return nil
+	}
+	server.OnDisconnect = func(c *Conn) { disconnected <- c }
+	addr := startLimitedServer(server)
+
+	client, _ := Dial("tcp", addr)
+	_assert(callSum(client) == nil, "connection should be accepted")
+	c := <-connected
+	_assert(c.ID == 1 && c.RemoteAddr != nil && c.TLS == nil, "wrong conn info %+v", c)
+	rejected, _ := Dial("tcp", addr)
+	_assert(callSum(rejected) != nil, "OnConnect error should reject the connection")
+
+	_ = client.Close()
+	c = <-disconnected
+	user, _ := c.Get("user")
+	_assert(user == "alice", "per-connection value should be kept, got %v", user)
+}
+
+func TestServer_UnknownServiceKeepsConn(t *testing.T) {
+	t.Parallel()
+	addr := startLimitedServer(NewServer())
+	client, _ := Dial("tcp", addr)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err := client.Call(context.Background(), "Missing.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil, "expect unknown service error")
+	_assert(callSum(client) == nil, "expect connection still usable after unknown service")
+}
+
+//countingMetrics 记录每个计数器的值
+type countingMetrics struct {
+	mu       sync.Mutex
+	counters map[string]int
+}
+
+func (m *countingMetrics) Inc(name string, _ ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[name]++
+}
+
+func (m *countingMetrics) Observe(string, float64, ...string) {}
+func (m *countingMetrics) Set(string, float64, ...string)     {}
+
+func (m *countingMetrics) get(name string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+func TestServer_HandshakeTimeout(t *testing.T) {
+	metrics := &countingMetrics{counters: make(map[string]int)}
+	SetMetrics(metrics)
+	defer SetMetrics(nil)
+	server := NewServer()
+	server.HandshakeTimeout = 50 * time.Millisecond
+	addr := startLimitedServer(server)
+
+	conn, _ := net.Dial("tcp", addr)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := conn.Read(make([]byte, 1))
+	ne, isNetErr := err.(net.Error)
+	_assert(err != nil && !(isNetErr && ne.Timeout()), "expect connection closed by server, got %v", err)
+	_assert(metrics.get("gpmd_server_handshake_timeouts_total") == 1, "expect handshake timeout counted")
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	time.Sleep(100 * time.Millisecond)
+	_assert(callSum(client) == nil, "expect deadline cleared after handshake")
+}
+
+func TestServer_InstanceHooks(t *testing.T) {
+	t.Parallel()
+	metrics := &countingMetrics{counters: make(map[string]int)}
+	tracer := &countingTracer{}
+	server := NewServer()
+	server.Metrics, server.Tracer = metrics, tracer
+	server.HandshakeTimeout = 50 * time.Millisecond
+	addr := startLimitedServer(server)
+
+	conn, _ := net.Dial("tcp", addr)
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _ = conn.Read(make([]byte, 1))
+	_assert(metrics.get("gpmd_server_handshake_timeouts_total") == 1, "expect handshake timeout counted on the server's own metrics")
+
+	client, _ := Dial("tcp", addr)
+	defer func() { _ = client.Close() }()
+	_assert(callSum(client) == nil, "expect call served")
+	_assert(tracer.spans == 1, "expect span started on the server's own tracer, got %d", tracer.spans)
+}
+
+type Sleeper int
+
+func (s Sleeper) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func (s Sleeper) Deadline(ctx context.Context, _ int, reply *bool) error {
+	_, *reply = ctx.Deadline()
+	return nil
+}
+
+func TestServer_DeadlineShedding(t *testing.T) {
+	metrics := &countingMetrics{counters: make(map[string]int)}
+	SetMetrics(metrics)
+	defer SetMetrics(nil)
+	server := NewServer()
+	server.MaxConcurrentRequests = 1
+	var s Sleeper
+	_ = server.Register(&s)
+	addr := startLimitedServer(server)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	t.Run("deadline propagated", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		var hasDeadline bool
+		_assert(client.Call(ctx, "Sleeper.Deadline", 0, &hasDeadline) == nil && hasDeadline, "expect handler ctx to carry the deadline")
+	})
+	t.Run("expired in queue", func(t *testing.T) {
+		var reply int
+		busy := client.Go("Sleeper.Sleep", 200, &reply, make(chan *Call, 1))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := client.Call(ctx, "Sleeper.Sleep", 0, &reply)
+		_assert(err != nil, "expect queued call to time out")
+		<-busy.Done
+		for i := 0; i < 50 && metrics.get("gpmd_server_shed_requests_total") == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		_assert(metrics.get("gpmd_server_shed_requests_total") == 1, "expect expired request shed")
+	})
+	t.Run("not enough time", func(t *testing.T) {
+		var reply int
+		_assert(client.Call(context.Background(), "Sleeper.Sleep", 100, &reply) == nil, "expect call to succeed")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := client.Call(ctx, "Sleeper.Sleep", 100, &reply)
+		_assert(errors.Is(err, ErrDeadlineExceeded), "expect ErrDeadlineExceeded, got %v", err)
+		_assert(time.Since(start) < 30*time.Millisecond, "expect shed response before the deadline")
+	})
+	t.Run("average decays while shedding", func(t *testing.T) {
+		var reply int
+		var err error
+		for i := 0; i < 50; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+			err = client.Call(ctx, "Sleeper.Sleep", 0, &reply)
+			cancel()
+			if err == nil {
+				break
+			}
+		}
+		_assert(err == nil, "expect shedding to stop once the average decays, got %v", err)
+	})
+}
+
+//skewedClock 比系统时钟慢一个小时
+type skewedClock struct{}
+
+func (skewedClock) Now() time.Time { return time.Now().Add(-time.Hour) }
+
+func (skewedClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+func TestServer_DeadlineClockSkew(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.Clock = skewedClock{}
+	var s Sleeper
+	_ = server.Register(&s)
+	client, err := NewLocalPair(server)
+	_assert(err == nil, "local pair error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var reply int
+	_assert(client.Call(ctx, "Sleeper.Sleep", 20, &reply) == nil, "expect the remaining time not to depend on the server clock")
+	start := time.Now()
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var hasDeadline bool
+	_assert(client.Call(ctx, "Sleeper.Deadline", 0, &hasDeadline) == nil && hasDeadline, "expect the handler deadline")
+	_assert(time.Since(start) < 50*time.Millisecond, "expect the call to finish in time")
+}
+
+func TestServer_SlowCallLog(t *testing.T) {
+	metrics := &countingMetrics{counters: make(map[string]int)}
+	SetMetrics(metrics)
+	defer SetMetrics(nil)
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	log.SetOutput(writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	}))
+	defer log.SetOutput(os.Stderr)
+	server := NewServer()
+	server.SlowCallThreshold = 50 * time.Millisecond
+	var s Sleeper
+	_ = server.Register(&s)
+	addr := startLimitedServer(server)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_assert(client.Call(context.Background(), "Sleeper.Sleep", 0, &reply) == nil, "expect fast call to succeed")
+	_assert(client.Call(context.Background(), "Sleeper.Sleep", 60, &reply) == nil, "expect slow call to succeed")
+	_assert(metrics.get("gpmd_server_slow_calls_total") == 1, "expect one slow call counted")
+	mu.Lock()
+	out := buf.String()
+	mu.Unlock()
+	_assert(strings.Count(out, "slow call") == 1 && strings.Contains(out, "Sleeper.Sleep") && strings.Contains(out, "args size: 2"),
+		"expect slow call logged with details, got %q", out)
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestServer_Priority(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.MaxConcurrentRequests = 1
+	var s Sleeper
+	_ = server.Register(&s)
+	addr := startLimitedServer(server)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	busy := client.Go("Sleeper.Sleep", 100, &reply, make(chan *Call, 1))
+	time.Sleep(20 * time.Millisecond)
+	var mu sync.Mutex
+	var order []Priority
+	var wg sync.WaitGroup
+	call := func(p Priority) {
+		defer wg.Done()
+		var reply int
+		_ = client.Call(ContextWithPriority(context.Background(), p), "Sleeper.Sleep", 20, &reply)
+		mu.Lock()
+		order = append(order, p)
+		mu.Unlock()
+	}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go call(PriorityLow)
+	}
+	time.Sleep(20 * time.Millisecond)
+	wg.Add(1)
+	go call(PriorityHigh)
+	<-busy.Done
+	wg.Wait()
+	_assert(len(order) == 4 && order[0] == PriorityHigh, "expect high priority call served first, got %v", order)
+}
+
+func TestWorkerPool_Next(t *testing.T) {
+	t.Parallel()
+	p := &workerPool{}
+	var got []int
+	for i, priority := range []Priority{PriorityLow, PriorityNormal, PriorityHigh, 5, PriorityNormal} {
+		i := i
+		p.queues[queueIndex(priority)] = append(p.queues[queueIndex(priority)], func() { got = append(got, i) })
+	}
+	for fn := p.next(); fn != nil; fn = p.next() {
+		fn()
+	}
+	_assert(reflect.DeepEqual(got, []int{2, 3, 1, 4, 0}), "expect priority then FIFO order, got %v", got)
+}
+
+func TestServer_ServeListener(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.Defaults.HandleTimeout = time.Second
+	var s Sleeper
+	_ = server.Register(&s)
+	internal := startLimitedServer(server)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.ServeListener(l, &ListenerOption{Codecs: []codec.Type{codec.JsonType}, MaxHandleTimeout: 50 * time.Millisecond})
+	public := l.Addr().String()
+
+	sleep := func(addr string, opt *Option) error {
+		client, err := Dial("tcp", addr, opt)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = client.Close() }()
+		var reply int
+		return client.Call(context.Background(), "Sleeper.Sleep", 200, &reply)
+	}
+	_assert(sleep(internal, &Option{CodeType: codec.GobType}) == nil, "internal listener should use the server defaults")
+	var herr *HandshakeError
+	err := sleep(public, &Option{CodeType: codec.GobType})
+	_assert(errors.As(err, &herr) && strings.Contains(herr.Reason, "not allowed"), "expect gob to be rejected with a handshake error, got %v", err)
+	err = sleep(public, &Option{CodeType: codec.JsonType, HandleTimeout: time.Second})
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect handle timeout capped by the listener, got %v", err)
+}
+
+func TestServer_HandshakeRejected(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.Defaults.RequireChecksum = true
+	addr := startLimitedServer(server)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var herr *HandshakeError
+	err = callSum(client)
+	_assert(errors.As(err, &herr) && herr.Reason == "checksum is required", "expect handshake error, got %v", err)
+	//之后的调用直接返回同一个错误
+	time.Sleep(50 * time.Millisecond)
+	_assert(errors.As(callSum(client), &herr), "expect later calls to return the handshake error")
+
+	client, err = Dial("tcp", addr, &Option{Checksum: true})
+	_assert(err == nil && callSum(client) == nil, "checksum client should be served, got %v", err)
+	_ = client.Close()
+}
+
+func TestServer_HandshakeErrorFrame(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.OnConnect = func(c *Conn) error { return errors.New("unauthorized") }
+	addr := startLimitedServer(server)
+
+	conn, _ := net.Dial("tcp", addr)
+	defer func() { _ = conn.Close() }()
+	_ = json.NewEncoder(conn).Encode(Option{MagicNumber: MagicNumber, CodeType: "application/unknown"})
+	hs := &handshakeConn{ReadWriteCloser: conn}
+	_, err := hs.Read(make([]byte, 1))
+	_assert(hs.err != nil && strings.Contains(hs.err.Reason, "invalid codec type"), "expect invalid codec reported, got %v", err)
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var herr *HandshakeError
+	err = callSum(client)
+	_assert(errors.As(err, &herr) && herr.Reason == "unauthorized", "expect OnConnect error reported, got %v", err)
+}
+
+func TestListenerOption_Merge(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.HandleTimeout = time.Second
+	server.HandshakeTimeout = time.Second
+	server.Defaults = ListenerOption{CodeType: codec.JsonType, HandleTimeout: 2 * time.Second, RequireChecksum: true}
+	lo := server.listenerOption(&ListenerOption{HandleTimeout: 3 * time.Second, CompressThreshold: 1024})
+	_assert(lo.CodeType == codec.JsonType && lo.HandshakeTimeout == time.Second, "expect unset fields to fall back, got %+v", lo)
+	_assert(lo.HandleTimeout == 3*time.Second && lo.CompressThreshold == 1024 && lo.RequireChecksum, "expect listener fields to override, got %+v", lo)
+
+	opt := Option{}
+	lo.negotiate(&opt)
+	_assert(opt.HandleTimeout == 3*time.Second && opt.CompressThreshold == 1024, "expect option filled with listener defaults, got %+v", opt)
+}
+
+func TestServer_Stats(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	client, _ := NewLocalPair(server)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_assert(client.Call(context.Background(), "Sleeper.Sleep", 0, &reply) == nil, "call should succeed")
+	slow := client.Go("Sleeper.Sleep", 200, &reply, nil)
+	time.Sleep(50 * time.Millisecond)
+	stats := server.Stats()
+	_assert(stats.ActiveConns == 1 && stats.ActiveHandlers == 1, "expect one active connection and handler, got %+v", stats)
+	sleep := stats.Services["Sleeper"].Methods["Sleep"]
+	_assert(sleep.Calls == 2 && sleep.Active == 1 && stats.Services["Sleeper"].Calls == 2, "unexpected method stats %+v", sleep)
+	<-slow.Done
+
+	rec := httptest.NewRecorder()
+	statsHTTP{server}.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, defaultStatsPath, nil))
+	var decoded ServerStats
+	err := json.Unmarshal(rec.Body.Bytes(), &decoded)
+	_assert(err == nil && decoded.ActiveHandlers == 0 && decoded.Services["Sleeper"].Methods["Sleep"].Calls == 2, "unexpected stats json %s %v", rec.Body.String(), err)
+}
+
+//Account 演示先登录再调用的会话
+type Account struct {
+	logouts chan string
+}
+
+func (a *Account) Login(ctx context.Context, user string, reply *bool) error {
+	c, _ := ConnFromContext(ctx)
+	c.Set("user", user)
+	c.OnClose(func() { a.logouts <- user })
+	*reply = true
+	return nil
+}
+
+func (a *Account) WhoAmI(ctx context.Context, _ int, reply *string) error {
+	c, _ := ConnFromContext(ctx)
+	user, ok := c.Get("user")
+	if !ok {
+		return errors.New("not logged in")
+	}
+	*reply = user.(string)
+	return nil
+}
+
+func TestServer_Session(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	account := &Account{logouts: make(chan string, 1)}
+	_ = server.Register(account)
+	addr := startLimitedServer(server)
+
+	client, _ := Dial("tcp", addr)
+	var name string
+	err := client.Call(context.Background(), "Account.WhoAmI", 0, &name)
+	_assert(err != nil, "expect an error before login")
+	var ok bool
+	err = client.Call(context.Background(), "Account.Login", "alice", &ok)
+	_assert(err == nil && ok, "login failed: %v", err)
+	err = client.Call(context.Background(), "Account.WhoAmI", 0, &name)
+	_assert(err == nil && name == "alice", "expect the session to keep the user, got %q %v", name, err)
+
+	other, _ := Dial("tcp", addr)
+	defer func() { _ = other.Close() }()
+	err = other.Call(context.Background(), "Account.WhoAmI", 0, &name)
+	_assert(err != nil, "expect sessions not shared between connections")
+
+	_ = client.Close()
+	select {
+	case user := <-account.logouts:
+		_assert(user == "alice", "expect close hook for alice, got %s", user)
+	case <-time.After(time.Second):
+		t.Fatal("expect the close hook to run after the connection closed")
+	}
+}
@@ -1,24 +1,41 @@
 package gpmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
 	"sync/atomic"
+	"time"
 )
 
 //methodType 实例包含了一个方法的完整信息
 type methodType struct {
-	method    reflect.Method //方法本身
-	ArgType   reflect.Type   //第一个参数的类型
-	ReplyType reflect.Type   //第二个参数的类型
-	numCalls  uint64         //统计调用次数
+	fn        reflect.Value //绑定了接收者的方法，或者直接注册的函数
+	hasCtx    bool          //第一个参数是否为 context.Context
+	multiArgs int           //大于 0 时方法有多个参数，ArgType 是按顺序包含它们的 MultiArgs 结构体
+	ArgType   reflect.Type  //第一个参数的类型
+	ReplyType reflect.Type  //第二个参数的类型
+	numCalls  uint64        //统计调用次数
+	numErrors uint64        //handler 返回错误的次数
+	active    int64         //正在执行的 handler 数
+	avgNanos  int64         //处理时间的指数移动平均值，用于判断剩余时间是否足够
+	argPool   *valuePool    //参数是小的值类型时不为空，见 Server.FastPath
+	replyPool *valuePool    //回复是小的值类型时不为空
+	invoke    invoker       //注册时生成的调用函数，见 compileInvoker
 }
 
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+//NumErrors 返回 handler 返回错误的次数
+func (m *methodType) NumErrors() uint64 {
+	return atomic.LoadUint64(&m.numErrors)
+}
+
 func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
 	if m.ArgType.Kind() == reflect.Ptr {
@@ -47,43 +64,160 @@ type service struct {
 	method map[string]*methodType //method 是 map 类型，存储映射的结构体的所有符合条件的方法
 }
 
-func newService(rcvr interface{}) *service {
+//newService 创建 service，extended 为 true 时还注册多参数、变长参数和返回 (R, error) 的方法，见 Server.ExtendedMethods
+func newService(rcvr interface{}, extended bool) *service {
+	name := reflect.Indirect(reflect.ValueOf(rcvr)).Type().Name()
+	if !ast.IsExported(name) {
+		log.Fatalf("rpc server:%s is not a valid service name", name)
+	}
+	return newNamedService(rcvr, name, extended)
+}
+
+//newNamedService 使用指定的名字而不是结构体名字创建 service，用于注册内置服务
+func newNamedService(rcvr interface{}, name string, extended bool) *service {
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
+	s.name = name
 	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) {
-		log.Fatalf("rpc server:%s is not a valid service name", s.name)
-	}
-	s.registerMethod()
+	s.registerMethod(extended)
 	return s
 }
 
-//registerMethod 过滤出复合RPC调用规则的方法
-//两个导出或内置类型的入参（反射时为 3 个，第 0 个是自身，类似于 python 的 self，java 中的 this）
-//返回值有且只有 1 个，类型为 error
-func (s *service) registerMethod() {
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+//registerMethod 过滤出复合RPC调用规则的方法，默认只支持
+//
+//	func([ctx context.Context,] args T, reply *R) error
+//
+//extended 为 true 时（见 Server.ExtendedMethods）还支持
+//
+//	func([ctx context.Context,] args T, reply *R, opts ...O) error
+//	func([ctx context.Context,] a A, b B, ..., reply *R[, opts ...O]) error
+//	func([ctx context.Context,] args T) (R, error)
+//
+//参数和返回值都需要是导出或内置类型（反射时第 0 个参数是自身，类似于 python 的 self，java 中的 this）；
+//第一个参数可以是 context.Context，用来获取请求编号、感知超时等；
+//有多个参数时，客户端通过 MultiArgs(a, b, ...) 按顺序传递；
+//最后可以有一个变长参数，例如供进程内调用使用的选项，远程调用时它总是为空；
+//返回值只有 error 时通过 reply 返回结果，返回 (R, error) 时结果作为返回值，R 可以是值或者指针，
+//返回的空指针被当作 R 的零值，与 Server.Handle 的规则相同。不符合规则的导出方法不会被注册，并在日志中记录原因
+func (s *service) registerMethod(extended bool) {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
-		method := s.typ.Method(i)
-		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
+		name := s.typ.Method(i).Name
+		m, err := newMethodType(s.rcvr.Method(i), extended)
+		if err != nil {
+			logf(LogWarn, "rpc service: skip %s.%s: %v", s.name, name, err)
 			continue
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
+		s.method[name] = m
+		logf(LogInfo, "rpc service: register %s.%s", s.name, name)
+	}
+}
+
+//newMethodType 检查 fn（方法需要已经绑定接收者）是否符合上面的调用规则，不符合时返回具体的原因
+func newMethodType(fn reflect.Value, extended bool) (*methodType, error) {
+	fType := fn.Type()
+	if fType.NumOut() == 2 && fType.Out(1) == typeOfError {
+		if !extended {
+			return nil, fmt.Errorf("%s returns (reply, error), register it with Server.Handle or set Server.ExtendedMethods", fType)
 		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
-			continue
+		return adaptHandler(fn)
+	}
+	if fType.NumOut() != 1 || fType.Out(0) != typeOfError {
+		return nil, fmt.Errorf("%s must return error or (reply, error)", fType)
+	}
+	in := make([]reflect.Type, fType.NumIn())
+	for i := range in {
+		in[i] = fType.In(i)
+	}
+	hasCtx := len(in) > 0 && in[0] == typeOfContext
+	if hasCtx {
+		in = in[1:]
+	}
+	if fType.IsVariadic() {
+		if !extended {
+			return nil, fmt.Errorf("%s is variadic, set Server.ExtendedMethods to register it", fType)
+		}
+		in = in[:len(in)-1]
+	}
+	if len(in) < 2 {
+		return nil, fmt.Errorf("%s has no args and reply, expect func([ctx,] args, reply) error", fType)
+	}
+	args, replyType := in[:len(in)-1], in[len(in)-1]
+	if len(args) > 1 && !extended {
+		return nil, fmt.Errorf("%s has more than one args, set Server.ExtendedMethods to register it", fType)
+	}
+	if replyType.Kind() != reflect.Ptr {
+		return nil, fmt.Errorf("reply type %s is not a pointer", replyType)
+	}
+	if !isExportedOrBuiltinType(replyType) {
+		return nil, fmt.Errorf("reply type %s is not exported", replyType)
+	}
+	for _, t := range args {
+		if t == typeOfContext {
+			return nil, errors.New("context.Context must be the first param")
+		}
+		if !isExportedOrBuiltinType(t) {
+			return nil, fmt.Errorf("args type %s is not exported", t)
+		}
+	}
+	m := &methodType{fn: fn, hasCtx: hasCtx, ArgType: args[0], ReplyType: replyType}
+	if len(args) > 1 {
+		m.multiArgs = len(args)
+		m.ArgType = multiArgsType(args)
+	}
+	m.argPool, m.replyPool = newValuePool(m.ArgType), newValuePool(replyType.Elem())
+	m.invoke = compileInvoker(fn, hasCtx, m.multiArgs)
+	return m, nil
+}
+
+//newInterfaceService 只公开接口 iface（形如 (*Greeter)(nil)）中的方法，rcvr 可以是任意实现了该接口的值
+func newInterfaceService(name string, iface, rcvr interface{}, extended bool) (*service, error) {
+	ifaceType := reflect.TypeOf(iface)
+	if ifaceType == nil || ifaceType.Kind() != reflect.Ptr || ifaceType.Elem().Kind() != reflect.Interface {
+		return nil, errors.New("rpc server: iface must be a pointer to an interface, e.g. (*Greeter)(nil)")
+	}
+	ifaceType = ifaceType.Elem()
+	v := reflect.ValueOf(rcvr)
+	if !v.IsValid() || !v.Type().Implements(ifaceType) {
+		return nil, fmt.Errorf("rpc server: %T does not implement %s", rcvr, ifaceType)
+	}
+	s := &service{name: name, typ: v.Type(), rcvr: v, method: make(map[string]*methodType)}
+	for i := 0; i < ifaceType.NumMethod(); i++ {
+		methodName := ifaceType.Method(i).Name
+		m, err := newMethodType(v.MethodByName(methodName), extended)
+		if err != nil {
+			return nil, fmt.Errorf("rpc server: %s.%s: %v", ifaceType, methodName, err)
+		}
+		s.method[methodName] = m
+		logf(LogInfo, "rpc service: register %s.%s", name, methodName)
+	}
+	return s, nil
+}
+
+//newFuncService 将一组函数注册为一个服务，键为方法名，值的签名与方法相同：func([ctx,] args, reply) error
+func newFuncService(name string, funcs map[string]interface{}, extended bool) (*service, error) {
+	s := &service{name: name, method: make(map[string]*methodType)}
+	for methodName, f := range funcs {
+		if !ast.IsExported(methodName) {
+			return nil, errors.New("rpc server: method name must be exported: " + methodName)
+		}
+		fn := reflect.ValueOf(f)
+		if fn.Kind() != reflect.Func || fn.IsNil() {
+			return nil, fmt.Errorf("rpc server: %s.%s is %T, expect func([ctx,] args, reply) error", name, methodName, f)
 		}
-		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
+		m, err := newMethodType(fn, extended)
+		if err != nil {
+			return nil, fmt.Errorf("rpc server: %s.%s: %v", name, methodName, err)
 		}
-		log.Printf("rpc service: register %s.%s", s.name, method.Name)
+		s.method[methodName] = m
+		logf(LogInfo, "rpc service: register %s.%s", name, methodName)
 	}
+	return s, nil
 }
 
 func isExportedOrBuiltinType(t reflect.Type) bool {
@@ -91,12 +225,17 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 }
 
 //call 方法，即能够通过反射值调用方法
-func (s *service) call(m *methodType, argv, replayValue reflect.Value) error {
+func (s *service) call(ctx context.Context, m *methodType, argv, replayValue reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
-	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replayValue})
-	if errInter := returnValues[0].Interface(); errInter != nil {
-		return errInter.(error)
+	atomic.AddInt64(&m.active, 1)
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&m.active, -1)
+		m.observe(time.Since(start))
+	}()
+	if err := m.invoke(ctx, argv, replayValue); err != nil {
+		atomic.AddUint64(&m.numErrors, 1)
+		return err
 	}
 	return nil
 }
@@ -1,7 +1,9 @@
 package gpmd
 
 import (
+	"context"
 	"fmt"
+	"gpmd/codec"
 	"reflect"
 	"testing"
 )
@@ -31,7 +33,7 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 func TestNewService(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s := newService(&foo, false)
 	_assert(len(s.method) == 1, "wrong service method, expect 1, but got %d", len(s.method))
 	mType := s.method["Sum"]
 	_assert(mType != nil, "wrong method, Sum should not nil")
@@ -39,11 +41,164 @@ func TestNewService(t *testing.T) {
 
 func TestMethodType_Call(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s := newService(&foo, false)
 	mType := s.method["Sum"]
 	argv := mType.newArgv()
 	replyValue := mType.newReply()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyValue)
+	err := s.call(context.Background(), mType, argv, replyValue)
 	_assert(err == nil && *replyValue.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
+
+type Greeter interface {
+	Hello(name string, reply *string) error
+}
+
+type politeGreeter struct{ prefix string }
+
+func (g *politeGreeter) Hello(name string, reply *string) error {
+	*reply = g.prefix + name
+	return nil
+}
+
+//Internal 不在 Greeter 接口中，不应该被公开
+func (g *politeGreeter) Internal(_ int, reply *int) error {
+	*reply = 1
+	return nil
+}
+
+func TestServer_RegisterInterfaceAndFuncs(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_assert(server.RegisterInterface("Greeter", (*Greeter)(nil), &politeGreeter{prefix: "hello, "}) == nil, "expect interface registered")
+	_assert(server.RegisterInterface("Bad", Greeter(nil), &politeGreeter{}) != nil, "expect non-pointer iface rejected")
+	_assert(server.RegisterInterface("Bad", (*Greeter)(nil), new(Foo)) != nil, "expect non-implementing rcvr rejected")
+	factor := 10
+	err := server.RegisterFuncs("Calc", map[string]interface{}{
+		"Scale": func(n int, reply *int) error {
+			*reply = n * factor
+			return nil
+		},
+		"Echo": func(ctx context.Context, s string, reply *string) error {
+			requestID, _ := RequestIDFromContext(ctx)
+			*reply = s + "@" + requestID
+			return nil
+		},
+	})
+	_assert(err == nil, "expect funcs registered: %v", err)
+	_assert(server.RegisterFuncs("Bad", map[string]interface{}{"Scale": func(n int) error { return nil }}) != nil, "expect bad signature rejected")
+	_assert(server.RegisterFuncs("Bad", map[string]interface{}{"scale": func(n int, reply *int) error { return nil }}) != nil, "expect unexported name rejected")
+	client, err := NewLocalPair(server)
+	_assert(err == nil, "local pair error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var greeting string
+	err = client.Call(context.Background(), "Greeter.Hello", "gpmd", &greeting)
+	_assert(err == nil && greeting == "hello, gpmd", "expect greeting, got %q, err: %v", greeting, err)
+	var n int
+	_assert(client.Call(context.Background(), "Greeter.Internal", 0, &n) != nil, "expect method outside the interface hidden")
+	err = client.Call(context.Background(), "Calc.Scale", 4, &n)
+	_assert(err == nil && n == 40, "expect 40, got %d, err: %v", n, err)
+	var echo string
+	err = client.Call(ContextWithRequestID(context.Background(), "r1"), "Calc.Echo", "hi", &echo)
+	_assert(err == nil && echo == "hi@r1", "expect hi@r1, got %q, err: %v", echo, err)
+}
+
+type Shapes int
+
+func (Shapes) Add3(ctx context.Context, a, b, c int, reply *int) error {
+	*reply = a + b + c
+	return nil
+}
+
+func (Shapes) Join(sep string, parts []string, reply *string) error {
+	for i, p := range parts {
+		if i > 0 {
+			*reply += sep
+		}
+		*reply += p
+	}
+	return nil
+}
+
+func (Shapes) Double(n int, reply *int, opts ...string) error {
+	*reply = 2*n + len(opts)
+	return nil
+}
+
+//NoReply 返回值不是指针，不应该被注册
+func (Shapes) NoReply(n int, reply int) error { return nil }
+
+func TestService_MethodShapes(t *testing.T) {
+	t.Parallel()
+	s := newService(new(Shapes), true)
+	_assert(len(s.method) == 3 && s.method["NoReply"] == nil, "expect 3 methods registered, got %d", len(s.method))
+	s = newService(new(Shapes), false)
+	_assert(len(s.method) == 0, "expect extended shapes skipped by default, got %d", len(s.method))
+	_, err := newMethodType(reflect.ValueOf(func(a int, ctx context.Context, reply *int) error { return nil }), true)
+	_assert(err != nil && err.Error() == "context.Context must be the first param", "expect precise error, got %v", err)
+	_, err = newMethodType(reflect.ValueOf(func(reply *int) error { return nil }), true)
+	_assert(err != nil, "expect a method without args rejected")
+
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		server := NewServer()
+		server.ExtendedMethods = true
+		_ = server.Register(new(Shapes))
+		opt := *DefaultOption
+		opt.CodeType = typ
+		client, err := NewLocalPair(server, &opt)
+		_assert(err == nil, "local pair error: %v", err)
+		var n int
+		err = client.Call(context.Background(), "Shapes.Add3", MultiArgs(1, 2, 3), &n)
+		_assert(err == nil && n == 6, "%s: expect 6, got %d %v", typ, n, err)
+		var joined string
+		err = client.Call(context.Background(), "Shapes.Join", MultiArgs("-", []string{"a", "b"}), &joined)
+		_assert(err == nil && joined == "a-b", "%s: expect a-b, got %q %v", typ, joined, err)
+		err = client.Call(context.Background(), "Shapes.Double", 4, &n)
+		_assert(err == nil && n == 8, "%s: expect variadic opts empty, got %d %v", typ, n, err)
+		_ = client.Close()
+	}
+}
+
+type Profile struct {
+	Name string
+	Age  int
+}
+
+type Profiles map[string]*Profile
+
+func (p Profiles) Get(ctx context.Context, name string) (*Profile, error) {
+	return p[name], nil
+}
+
+func (p Profiles) Count(_ int) (int, error) {
+	return len(p), nil
+}
+
+func TestService_ReturnValueReply(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(Profiles{})
+	client, err := NewLocalPair(server)
+	_assert(err == nil, "local pair error: %v", err)
+	var n int
+	err = client.Call(context.Background(), "Profiles.Count", 0, &n)
+	_assert(err != nil, "expect (reply, error) methods skipped by default")
+	_ = client.Close()
+
+	server = NewServer()
+	server.ExtendedMethods = true
+	_ = server.Register(Profiles{"alice": {Name: "alice", Age: 30}})
+	client, err = NewLocalPair(server)
+	_assert(err == nil, "local pair error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var profile Profile
+	err = client.Call(context.Background(), "Profiles.Get", "alice", &profile)
+	_assert(err == nil && profile.Age == 30, "expect alice's profile, got %+v %v", profile, err)
+	profile = Profile{}
+	err = client.Call(context.Background(), "Profiles.Get", "bob", &profile)
+	_assert(err == nil && profile == Profile{}, "expect a nil reply decoded as zero value, got %+v %v", profile, err)
+	err = client.Call(context.Background(), "Profiles.Count", 0, &n)
+	_assert(err == nil && n == 1, "expect 1, got %d %v", n, err)
+}
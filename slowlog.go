@@ -0,0 +1,29 @@
+package gpmd
+
+import (
+	"encoding/json"
+	"time"
+)
+
+//logSlowCall 处理时间超过 Server.SlowCallThreshold 的调用记录一条详细日志并计数，
+//用来找出造成 p99 抖动的方法。参数大小为 JSON 编码后的字节数，只在慢调用时计算
+func (s *Server) logSlowCall(c *Conn, req *request, d time.Duration, err error) {
+	if s.SlowCallThreshold <= 0 || d < s.SlowCallThreshold {
+		return
+	}
+	s.metrics().Inc("gpmd_server_slow_calls_total", "method", req.h.ServiceMethod)
+	s.metrics().Observe("gpmd_server_slow_call_seconds", d.Seconds(), "method", req.h.ServiceMethod)
+	//UnknownServiceHandler 自己解码参数，这时 argv 为空，大小记为 -1
+	argsSize := -1
+	if req.argv.IsValid() {
+		if data, err := json.Marshal(req.argv.Interface()); err == nil {
+			argsSize = len(data)
+		}
+	}
+	peer := "unknown"
+	if c.RemoteAddr != nil {
+		peer = c.RemoteAddr.String()
+	}
+	logf(LogWarn, "rpc server: slow call %s took %s (threshold %s), args size: %d, peer: %s, connection: %d, request id: %s, error: %v",
+		req.h.ServiceMethod, d, s.SlowCallThreshold, argsSize, peer, c.ID, req.h.RequestID, err)
+}
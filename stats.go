@@ -0,0 +1,173 @@
+package gpmd
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+//ClientStats Client 的调用统计，可以导出为监控指标，OldestPending 持续增长说明有调用卡住了
+type ClientStats struct {
+	Sent          uint64                 //成功写出的请求数
+	Received      uint64                 //收到响应的请求数，包括服务端返回错误的请求
+	Failed        uint64                 //以错误结束的请求数，包括服务端返回的错误、发送失败、超时、取消和连接断开
+	InFlight      int                    //正在等待响应的请求数
+	OldestPending time.Duration          //等待最久的请求已经等待的时间，没有等待的请求时为 0
+	Methods       map[string]MethodStats //按方法的统计，只有调用过 EnableMethodStats 才有
+}
+
+//MethodStats 一个方法的调用统计
+type MethodStats struct {
+	Sent     uint64
+	Received uint64
+	Failed   uint64
+}
+
+//PendingCall 一个正在等待响应的请求
+type PendingCall struct {
+	Seq           uint64
+	ServiceMethod string
+	RequestID     string
+	Age           time.Duration //请求发出后已经等待的时间
+}
+
+//EnableMethodStats 开启按方法的统计，开启之前的调用不会计入
+func (client *Client) EnableMethodStats() {
+	client.statsMu.Lock()
+	defer client.statsMu.Unlock()
+	if client.methods == nil {
+		client.methods = make(map[string]*MethodStats)
+	}
+}
+
+//Stats 返回当前的调用统计
+func (client *Client) Stats() ClientStats {
+	stats := ClientStats{
+		Sent:     atomic.LoadUint64(&client.sent),
+		Received: atomic.LoadUint64(&client.received),
+		Failed:   atomic.LoadUint64(&client.failed),
+	}
+	now := client.clock().Now()
+	client.mu.Lock()
+	stats.InFlight = len(client.pending)
+	for _, call := range client.pending {
+		if age := now.Sub(call.sentAt); age > stats.OldestPending {
+			stats.OldestPending = age
+		}
+	}
+	client.mu.Unlock()
+	client.statsMu.Lock()
+	if client.methods != nil {
+		stats.Methods = make(map[string]MethodStats, len(client.methods))
+		for method, m := range client.methods {
+			stats.Methods[method] = *m
+		}
+	}
+	client.statsMu.Unlock()
+	return stats
+}
+
+//Pending 返回正在等待响应的请求，等待最久的在前
+func (client *Client) Pending() []PendingCall {
+	now := client.clock().Now()
+	client.mu.Lock()
+	calls := make([]PendingCall, 0, len(client.pending))
+	for _, call := range client.pending {
+		calls = append(calls, PendingCall{
+			Seq:           call.Seq,
+			ServiceMethod: call.ServerMethod,
+			RequestID:     call.RequestID,
+			Age:           now.Sub(call.sentAt),
+		})
+	}
+	client.mu.Unlock()
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Age > calls[j].Age })
+	return calls
+}
+
+//count 更新 call 所属方法的统计
+func (client *Client) count(call *Call, update func(m *MethodStats)) {
+	client.statsMu.Lock()
+	defer client.statsMu.Unlock()
+	if client.methods == nil {
+		return
+	}
+	m := client.methods[call.ServerMethod]
+	if m == nil {
+		m = &MethodStats{}
+		client.methods[call.ServerMethod] = m
+	}
+	update(m)
+}
+
+func (client *Client) countSent(call *Call) {
+	atomic.AddUint64(&client.sent, 1)
+	client.count(call, func(m *MethodStats) { m.Sent++ })
+}
+
+func (client *Client) countReceived(call *Call) {
+	atomic.AddUint64(&client.received, 1)
+	client.count(call, func(m *MethodStats) { m.Received++ })
+}
+
+func (client *Client) countFailed(call *Call) {
+	atomic.AddUint64(&client.failed, 1)
+	client.count(call, func(m *MethodStats) { m.Failed++ })
+}
+
+//complete 结束 call，计入统计后通知调用方
+func (client *Client) complete(call *Call) {
+	if call.Error != nil {
+		client.countFailed(call)
+	}
+	call.done()
+}
+
+//ServerStats Server 的调用统计，HandleHTTP 之后也可以通过 /debug/gpmd/stats 以 JSON 获取
+type ServerStats struct {
+	ActiveConns    int64                   `json:"active_conns"`    //握手成功、还没有关闭的连接数
+	ActiveHandlers int64                   `json:"active_handlers"` //正在执行的 handler 数，包括 UnknownServiceHandler
+	Services       map[string]ServiceStats `json:"services"`
+}
+
+//ServiceStats 一个服务的调用统计，Calls 和 Errors 是各个方法之和
+type ServiceStats struct {
+	Calls   uint64                       `json:"calls"`
+	Errors  uint64                       `json:"errors"`
+	Methods map[string]ServerMethodStats `json:"methods"`
+}
+
+//ServerMethodStats 服务端一个方法的调用统计
+type ServerMethodStats struct {
+	Calls       uint64        `json:"calls"`        //调用次数
+	Errors      uint64        `json:"errors"`       //handler 返回错误的次数
+	Active      int64         `json:"active"`       //正在执行的 handler 数
+	AvgDuration time.Duration `json:"avg_duration"` //处理时间的指数移动平均值
+}
+
+//Stats 返回当前的调用统计
+func (s *Server) Stats() ServerStats {
+	stats := ServerStats{
+		ActiveConns:    atomic.LoadInt64(&s.activeConns),
+		ActiveHandlers: atomic.LoadInt64(&s.activeHandlers),
+		Services:       make(map[string]ServiceStats),
+	}
+	s.serviceMap.Range(func(namei, svci interface{}) bool {
+		svc := svci.(*service)
+		ss := ServiceStats{Methods: make(map[string]ServerMethodStats, len(svc.method))}
+		for name, m := range svc.method {
+			ms := ServerMethodStats{
+				Calls:       m.NumCalls(),
+				Errors:      m.NumErrors(),
+				Active:      atomic.LoadInt64(&m.active),
+				AvgDuration: m.AvgDuration(),
+			}
+			ss.Calls += ms.Calls
+			ss.Errors += ms.Errors
+			ss.Methods[name] = ms
+		}
+		stats.Services[namei.(string)] = ss
+		return true
+	})
+	return stats
+}
@@ -0,0 +1,239 @@
+package gpmd
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"gpmd/codec"
+	"io/ioutil"
+	"reflect"
+	"time"
+)
+
+//预定义的错误码。handler 返回的错误包装了对应的哨兵错误时，服务端以这些错误码发送 Status，
+//客户端用 errors.Is 判断错误的种类，不依赖可能被本地化或者改写的错误信息
+const (
+	CodeNotFound          = "not_found"
+	CodeUnavailable       = "unavailable"
+	CodeResourceExhausted = "resource_exhausted"
+	CodeDeadlineExceeded  = "deadline_exceeded"
+)
+
+//ErrNotFound 请求的服务、方法或者 handler 查询的资源不存在
+var ErrNotFound = errors.New("rpc: not found")
+
+//ErrUnavailable 服务端暂时不可用（例如过载），稍后可以重试
+var ErrUnavailable = errors.New("rpc: unavailable")
+
+//ErrResourceExhausted 超过了配额或者限流，稍后可以重试
+var ErrResourceExhausted = errors.New("rpc: resource exhausted")
+
+//sentinelCodes 哨兵错误与错误码的对应关系
+var sentinelCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrNotFound, CodeNotFound},
+	{ErrUnavailable, CodeUnavailable},
+	{ErrResourceExhausted, CodeResourceExhausted},
+	{ErrDeadlineExceeded, CodeDeadlineExceeded},
+}
+
+//sentinelCode 返回 err 包装的哨兵错误对应的错误码，没有时返回空字符串
+func sentinelCode(err error) string {
+	for _, c := range sentinelCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+//sentinelError 信息保持不变，errors.Is 时与 sentinel 匹配
+type sentinelError struct {
+	msg      string
+	sentinel error
+}
+
+func (e *sentinelError) Error() string { return e.msg }
+
+func (e *sentinelError) Unwrap() error { return e.sentinel }
+
+//Status 带有结构化详情的错误。handler 返回 *Status 或者包装了 *Status 的错误时，Code 和 Details
+//随错误响应发送给客户端，编码方式与连接的 codec 相同，客户端通过 errors.As 取得 *Status。
+//客户端收到的 Message 是服务端完整的错误信息（包括外层包装的内容），与没有详情的错误一致
+type Status struct {
+	Code    string        //业务定义的错误码，例如 "invalid_argument"
+	Message string        //错误信息
+	Details []interface{} //错误详情，例如 *BadRequest、*RetryInfo，gob 编码时自定义的类型需要先调用 RegisterType
+}
+
+//NewStatus 创建带有详情的错误
+func NewStatus(code, message string, details ...interface{}) *Status {
+	return &Status{Code: code, Message: message, Details: details}
+}
+
+func (s *Status) Error() string {
+	return s.Message
+}
+
+//Is 错误码是预定义的错误码时与对应的哨兵错误匹配，例如 errors.Is(err, ErrNotFound)
+func (s *Status) Is(target error) bool {
+	for _, c := range sentinelCodes {
+		if target == c.err {
+			return s.Code == c.code
+		}
+	}
+	return false
+}
+
+//As 带有 RetryInfo 的 CodeUnavailable 可以取得 *UnavailableError，与只有错误信息的过载错误兼容
+func (s *Status) As(target interface{}) bool {
+	p, ok := target.(**UnavailableError)
+	if !ok || s.Code != CodeUnavailable {
+		return false
+	}
+	var ri RetryInfo
+	if !s.Detail(&ri) {
+		return false
+	}
+	*p = &UnavailableError{RetryAfter: ri.RetryAfter}
+	return true
+}
+
+//RetryAfter 返回服务端拒绝请求时建议的重试间隔，即错误码为 CodeUnavailable 或者 CodeResourceExhausted 的
+//Status 中的 RetryInfo，或者 UnavailableError.RetryAfter。这时请求没有被处理，等待之后重试是安全的
+func RetryAfter(err error) (time.Duration, bool) {
+	var st *Status
+	if errors.As(err, &st) && (st.Code == CodeUnavailable || st.Code == CodeResourceExhausted) {
+		var ri RetryInfo
+		if st.Detail(&ri) {
+			return ri.RetryAfter, true
+		}
+	}
+	var ue *UnavailableError
+	if errors.As(err, &ue) {
+		return ue.RetryAfter, true
+	}
+	return 0, false
+}
+
+//Detail 把第一个类型匹配的详情赋值给 target，target 是指向详情类型的指针，例如 **BadRequest 或者 *BadRequest。
+//以 JSON 编码的详情在客户端是 map，字段与 target 的类型一致时按照 JSON 解码，返回是否找到
+func (s *Status) Detail(target interface{}) bool {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return false
+	}
+	elem := v.Elem()
+	for _, d := range s.Details {
+		dv := reflect.ValueOf(d)
+		switch {
+		case !dv.IsValid():
+			continue
+		case dv.Type().AssignableTo(elem.Type()):
+			elem.Set(dv)
+			return true
+		case dv.Kind() == reflect.Ptr && dv.Elem().Type().AssignableTo(elem.Type()):
+			elem.Set(dv.Elem())
+			return true
+		case elem.Kind() == reflect.Ptr && dv.Type().AssignableTo(elem.Type().Elem()):
+			//gob 解码出来的详情是值，target 是 **BadRequest 时取地址
+			p := reflect.New(dv.Type())
+			p.Elem().Set(dv)
+			elem.Set(p)
+			return true
+		case dv.Kind() == reflect.Map:
+			if jsonDetail(d, target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//jsonDetail 字段完全匹配时才认为是 target 的类型，避免把其他类型的详情解码成零值
+func jsonDetail(d, target interface{}) bool {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return false
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(target) == nil
+}
+
+//BadRequest 参数校验失败的详情
+type BadRequest struct {
+	Violations []FieldViolation
+}
+
+//FieldViolation 一个字段的校验错误
+type FieldViolation struct {
+	Field       string //字段的路径，例如 "User.Email"
+	Description string
+}
+
+//RetryInfo 建议客户端等待多久之后重试
+type RetryInfo struct {
+	RetryAfter time.Duration
+}
+
+func init() {
+	_ = RegisterType(BadRequest{}, RetryInfo{}) //gob 按照基础类型注册，指针形式的详情同样可以编码
+}
+
+//statusBody 错误响应中 Status 的编码，Message 即 Header.Error，不重复发送
+type statusBody struct {
+	Code    string
+	Details []interface{}
+}
+
+//errorResponse 设置错误响应的 Header，返回需要发送的 body。UnavailableError 以 CodeUnavailable 和 RetryInfo 发送，
+//包装了哨兵错误的普通错误以对应的错误码发送。
+//详情不能以连接的编码方式编码时（例如 gob 类型没有注册）丢弃详情，避免编码失败关闭整个连接
+func errorResponse(h *codec.Header, typ codec.Type, err error) interface{} {
+	h.Error = err.Error()
+	var st *Status
+	var ue *UnavailableError
+	switch {
+	case errors.As(err, &st):
+	case errors.As(err, &ue):
+		st = &Status{Code: CodeUnavailable, Details: []interface{}{&RetryInfo{RetryAfter: ue.RetryAfter}}}
+	default:
+		code := sentinelCode(err)
+		if code == "" {
+			return invalidRequest
+		}
+		st = &Status{Code: code}
+	}
+	body := &statusBody{Code: st.Code, Details: st.Details}
+	if encodeErr := checkEncode(typ, body); encodeErr != nil {
+		logf(LogWarn, "rpc server: drop status details of %s: %v", h.ServiceMethod, encodeErr)
+		body.Details = nil
+	}
+	h.Status = true
+	return body
+}
+
+func checkEncode(typ codec.Type, body interface{}) error {
+	if typ == codec.JsonType {
+		_, err := json.Marshal(body)
+		return err
+	}
+	return gob.NewEncoder(ioutil.Discard).Encode(body)
+}
+
+//readStatus 客户端读取错误响应的 body，返回调用的错误 callErr，没有详情的错误和之前一样丢弃 body，
+//读取 body 失败时返回 err
+func readStatus(cc codec.Codec, h *codec.Header) (callErr, err error) {
+	if !h.Status {
+		return serverError(h.Error), cc.ReadBody(nil)
+	}
+	var body statusBody
+	if err = cc.ReadBody(&body); err != nil {
+		return serverError(h.Error), err
+	}
+	return &Status{Code: body.Code, Message: h.Error, Details: body.Details}, nil
+}
@@ -0,0 +1,91 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gpmd/codec"
+	"testing"
+	"time"
+)
+
+type unregisteredDetail struct{ Reason string }
+
+type Validator int
+
+func (v Validator) Check(n int, reply *int) error {
+	switch n {
+	case 0:
+		return fmt.Errorf("validate: %w", NewStatus("invalid_argument", "bad request",
+			&BadRequest{Violations: []FieldViolation{{Field: "N", Description: "must not be zero"}}},
+			&RetryInfo{RetryAfter: time.Second}))
+	case 1:
+		return NewStatus("internal", "unencodable details", unregisteredDetail{"x"})
+	}
+	*reply = n
+	return nil
+}
+
+func TestStatus_Details(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Validator))
+	addr := startLimitedServer(server)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, CodeType: typ})
+		_assert(err == nil, "dial error: %v", err)
+		var reply int
+		err = client.Call(context.Background(), "Validator.Check", 0, &reply)
+		var st *Status
+		_assert(errors.As(err, &st), "%s: expect *Status, got %T %v", typ, err, err)
+		_assert(st.Code == "invalid_argument" && st.Message == "validate: bad request", "%s: unexpected status %+v", typ, st)
+		var br *BadRequest
+		_assert(st.Detail(&br) && len(br.Violations) == 1 && br.Violations[0].Field == "N", "%s: expect bad request detail, got %+v", typ, st.Details)
+		var ri RetryInfo
+		_assert(st.Detail(&ri) && ri.RetryAfter == time.Second, "%s: expect retry info detail, got %+v", typ, st.Details)
+
+		err = client.Call(context.Background(), "Validator.Check", 1, &reply)
+		_assert(errors.As(err, &st) && st.Code == "internal", "%s: expect status, got %v", typ, err)
+		if typ == codec.GobType {
+			_assert(len(st.Details) == 0, "expect unregistered gob details dropped, got %+v", st.Details)
+		}
+		err = client.Call(context.Background(), "Validator.Check", 2, &reply)
+		_assert(err == nil && reply == 2, "%s: expect connection still usable, got %v", typ, err)
+		_ = client.Close()
+	}
+}
+
+func (v Validator) Lookup(n int, reply *int) error {
+	switch n {
+	case 0:
+		return fmt.Errorf("用户 %d 不存在: %w", n, ErrNotFound)
+	case 1:
+		return fmt.Errorf("lookup: %w", ErrDeadlineExceeded)
+	}
+	return &UnavailableError{RetryAfter: time.Second}
+}
+
+func TestStatus_Sentinels(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Validator))
+	addr := startLimitedServer(server)
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType} {
+		client, err := Dial("tcp", addr, &Option{MagicNumber: MagicNumber, CodeType: typ})
+		_assert(err == nil, "dial error: %v", err)
+		var reply int
+		err = client.Call(context.Background(), "Validator.Lookup", 0, &reply)
+		_assert(errors.Is(err, ErrNotFound) && !errors.Is(err, ErrUnavailable), "%s: expect not found, got %v", typ, err)
+		_assert(err.Error() == "用户 0 不存在: rpc: not found", "%s: expect message kept, got %q", typ, err)
+		err = client.Call(context.Background(), "Validator.Lookup", 1, &reply)
+		_assert(errors.Is(err, ErrDeadlineExceeded), "%s: expect deadline exceeded, got %v", typ, err)
+		err = client.Call(context.Background(), "Validator.Lookup", 2, &reply)
+		_assert(errors.Is(err, ErrUnavailable), "%s: expect unavailable, got %v", typ, err)
+		err = client.Call(context.Background(), "Validator.Missing", 0, &reply)
+		_assert(errors.Is(err, ErrNotFound), "%s: expect missing method not found, got %v", typ, err)
+		err = client.Call(context.Background(), "Missing.Lookup", 0, &reply)
+		_assert(errors.Is(err, ErrNotFound), "%s: expect missing service not found, got %v", typ, err)
+		_ = client.Close()
+	}
+	_assert(errors.Is(&UnavailableError{}, ErrUnavailable), "expect overload rejection to match ErrUnavailable")
+}
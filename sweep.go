@@ -0,0 +1,48 @@
+package gpmd
+
+import "time"
+
+//errCallExpired 调用等待响应的时间超过了 Option.MaxCallAge
+var errCallExpired = &sentinelError{"rpc client: call exceeded max call age", ErrDeadlineExceeded}
+
+//startSweeper 每隔 age 的一半检查一次等待响应的调用，超过 age 的调用被移除并以 errCallExpired 结束，
+//因此调用最晚在等待 1.5 倍 age 之后结束。连接断开之后停止检查
+func (client *Client) startSweeper(age time.Duration) {
+	interval := age / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	var sweep func()
+	sweep = func() {
+		if !client.sweep(age) {
+			return
+		}
+		client.clock().AfterFunc(interval, sweep)
+	}
+	client.clock().AfterFunc(interval, sweep)
+}
+
+//sweep 结束等待超过 age 的调用，连接已经断开时返回 false
+func (client *Client) sweep(age time.Duration) bool {
+	now := client.clock().Now()
+	client.mu.Lock()
+	if client.shutdown {
+		client.mu.Unlock()
+		return false
+	}
+	var expired []*Call
+	for seq, call := range client.pending {
+		if now.Sub(call.sentAt) >= age {
+			delete(client.pending, seq)
+			expired = append(expired, call)
+		}
+	}
+	client.checkDrained()
+	client.mu.Unlock()
+	for _, call := range expired {
+		GetMetrics().Inc("gpmd_client_expired_calls_total")
+		call.Error = errCallExpired
+		client.complete(call)
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClient_MaxCallAge(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	_ = server.Register(new(Sleeper))
+	addr := startLimitedServer(server)
+	opt, _ := NewOption(WithMaxCallAge(50 * time.Millisecond))
+	client, err := Dial("tcp", addr, opt)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	//没有 ctx 截止时间的调用在等待超过 MaxCallAge 之后结束，不会一直等待
+	start := time.Now()
+	err = client.Call(context.Background(), "Sleeper.Sleep", 1000, new(int))
+	_assert(errors.Is(err, ErrDeadlineExceeded), "expect ErrDeadlineExceeded, got %v", err)
+	_assert(time.Since(start) < 500*time.Millisecond, "expect the sweeper to fail the call, took %s", time.Since(start))
+	_assert(client.Stats().InFlight == 0, "expect the expired call removed from pending")
+
+	//没有超过 MaxCallAge 的调用不受影响，连接仍然可用
+	var reply int
+	err = client.Call(context.Background(), "Sleeper.Sleep", 5, &reply)
+	_assert(err == nil && reply == 5, "expect short calls unaffected, got %v", err)
+
+	_, err = NewOption(WithMaxCallAge(-time.Second))
+	_assert(err != nil, "expect negative age rejected")
+}
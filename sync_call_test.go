@@ -2,7 +2,6 @@ package gpmd
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net"
 	"sync"
@@ -11,6 +10,8 @@ import (
 )
 
 func startServer(addr chan string) {
+	var foo Foo
+	_ = Register(&foo)
 	l, err := net.Listen("tcp", ":0")
 	if err != nil {
 		log.Fatalln("network error:", err)
@@ -36,12 +37,12 @@ func TestSyncCall(t *testing.T) {
 				wg.Done()
 				cancel()
 			}()
-			args := fmt.Sprintf("gpmd req %d", i)
-			var reply string
+			args := &Args{Num1: i, Num2: i * i}
+			var reply int
 			if err := client.Call(ctx, "Foo.Sum", args, &reply); err != nil {
 				log.Fatalln("call Foo.Sum error:", err)
 			}
-			log.Println("reply:", reply)
+			log.Printf("%d + %d = %d", args.Num1, args.Num2, reply)
 		}(i)
 	}
 	wg.Wait()
@@ -0,0 +1,114 @@
+package gpmd
+
+import (
+	"context"
+	"math/rand"
+	"sync/atomic"
+)
+
+//SpanKind span 在调用中的角色
+type SpanKind int
+
+const (
+	SpanClient   SpanKind = iota //客户端发出的一次调用，重试时每次尝试各有一个
+	SpanServer                   //服务端处理一个请求
+	SpanInternal                 //进程内的操作，例如 XClient 的广播，本身不发送请求
+)
+
+//Tracer 分布式追踪的钩子，通过 SetTracer 接入 OpenTelemetry、Zipkin 等系统。
+//StartSpan 返回的 ctx 会被用于后续的调用，客户端 span 可以通过 metadata.AppendToOutgoingContext
+//把追踪上下文写入请求；服务端 span 收到的 ctx 带有请求的元数据，通过 metadata.FromIncomingContext 取出
+type Tracer interface {
+	StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, Span)
+}
+
+//Span 一次被追踪的操作，Finish 只调用一次，err 为操作的结果
+type Span interface {
+	SetTag(key, value string)
+	Finish(err error)
+}
+
+type nopTracer struct{}
+
+type nopSpan struct{}
+
+func (nopTracer) StartSpan(ctx context.Context, _ string, _ SpanKind) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+func (nopSpan) SetTag(string, string) {}
+func (nopSpan) Finish(error)          {}
+
+type tracerHolder struct {
+	t Tracer
+}
+
+var globalTracer atomic.Value
+
+//traceSampling 采样比例乘以 1e6，默认全部采样
+var traceSampling int64 = 1e6
+
+func init() {
+	globalTracer.Store(tracerHolder{nopTracer{}})
+}
+
+//SetTracer 设置全局的追踪钩子，t 为 nil 时恢复为不追踪
+func SetTracer(t Tracer) {
+	if t == nil {
+		t = nopTracer{}
+	}
+	globalTracer.Store(tracerHolder{t})
+}
+
+//GetTracer 返回当前的追踪钩子，供其他包创建 span。采样比例小于 1 时按照比例跳过根 span，
+//被跳过的 span 的 ctx 会记住这个决定，之后在这个 ctx 上创建的 span 同样被跳过
+func GetTracer() Tracer {
+	return sampled(globalTracer.Load().(tracerHolder).t)
+}
+
+//sampled 采样比例小于 1 时按照比例跳过 t 的根 span
+func sampled(t Tracer) Tracer {
+	if rate := atomic.LoadInt64(&traceSampling); rate < 1e6 {
+		if _, nop := t.(nopTracer); !nop {
+			return sampledTracer{t: t, rate: rate}
+		}
+	}
+	return t
+}
+
+//SetTraceSampling 设置追踪的采样比例，rate 在 0 到 1 之间，1 表示全部追踪，0 表示不追踪。
+//采样在每个进程中独立决定，服务端不知道客户端的决定，需要一致的采样时由 Tracer 自己实现
+func SetTraceSampling(rate float64) {
+	if rate < 0 {
+		rate = 0
+	}
+	if rate > 1 {
+		rate = 1
+	}
+	atomic.StoreInt64(&traceSampling, int64(rate*1e6))
+}
+
+//TraceSampling 返回当前的采样比例
+func TraceSampling() float64 {
+	return float64(atomic.LoadInt64(&traceSampling)) / 1e6
+}
+
+//sampledKey ctx 中保存的采样决定
+type sampledKey struct{}
+
+type sampledTracer struct {
+	t    Tracer
+	rate int64
+}
+
+func (s sampledTracer) StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, Span) {
+	sampled, decided := ctx.Value(sampledKey{}).(bool)
+	if !decided {
+		sampled = rand.Int63n(1e6) < s.rate
+		ctx = context.WithValue(ctx, sampledKey{}, sampled)
+	}
+	if !sampled {
+		return ctx, nopSpan{}
+	}
+	return s.t.StartSpan(ctx, name, kind)
+}
@@ -0,0 +1,74 @@
+package gpmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gpmd/codec"
+	"reflect"
+	"sync"
+)
+
+//UnknownServiceHandler 处理服务端没有注册的方法，dec 将请求的 body 解码到传入的指针，
+//返回值作为响应发送给客户端，可以用来实现通用的代理、动态分发，或者在版本不一致时返回友好的错误。
+//读取请求的循环会等待 dec 被调用，handler 应该先调用 dec 再做其他处理，
+//dec 只能调用一次，handler 返回后仍然没有调用时 body 会被丢弃
+type UnknownServiceHandler func(ctx context.Context, serviceMethod string, dec func(interface{}) error) (interface{}, error)
+
+var errBodyConsumed = errors.New("rpc server: request body already consumed")
+
+//SetUnknownServiceHandler 设置处理未注册方法的 handler，传入 nil 时恢复为直接返回错误
+func (s *Server) SetUnknownServiceHandler(h UnknownServiceHandler) {
+	if h == nil {
+		s.unknownHandler.Store(UnknownServiceHandler(nil))
+		return
+	}
+	s.unknownHandler.Store(h)
+}
+
+func (s *Server) unknownServiceHandler() UnknownServiceHandler {
+	h, _ := s.unknownHandler.Load().(UnknownServiceHandler)
+	return h
+}
+
+//bodyDecoder 延迟读取一个请求的 body，读取完成（或者丢弃）后关闭 done，读取请求的循环才能继续
+type bodyDecoder struct {
+	cc   codec.Codec
+	once sync.Once
+	done chan struct{}
+}
+
+func newBodyDecoder(cc codec.Codec) *bodyDecoder {
+	return &bodyDecoder{cc: cc, done: make(chan struct{})}
+}
+
+func (d *bodyDecoder) decode(body interface{}) error {
+	err := errBodyConsumed
+	d.once.Do(func() {
+		err = d.cc.ReadBody(body)
+		close(d.done)
+	})
+	return err
+}
+
+//discard 丢弃还没有读取的 body
+func (d *bodyDecoder) discard() {
+	_ = d.decode(nil)
+}
+
+//callUnknown 调用 UnknownServiceHandler，返回值作为响应
+func (s *Server) callUnknown(ctx context.Context, h UnknownServiceHandler, req *request) error {
+	decode := func(v interface{}) error { return json.Unmarshal(req.rawArgs, v) }
+	if req.body != nil {
+		decode = req.body.decode
+	}
+	reply, err := h(ctx, req.h.ServiceMethod, decode)
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		reply = invalidRequest
+	}
+	req.replyv = reflect.ValueOf(reply)
+	return nil
+}
@@ -0,0 +1,42 @@
+package gpmd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestServer_UnknownServiceHandler(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.MaxConcurrentRequests = 1
+	var foo Foo
+	_ = server.Register(&foo)
+	server.SetUnknownServiceHandler(func(ctx context.Context, serviceMethod string, dec func(interface{}) error) (interface{}, error) {
+		if !strings.HasPrefix(serviceMethod, "Dynamic.") {
+			return nil, errors.New("version skew: " + serviceMethod + " is not supported yet")
+		}
+		var args Args
+		if err := dec(&args); err != nil {
+			return nil, err
+		}
+		_assert(dec(&args) == errBodyConsumed, "expect dec to be usable once")
+		return args.Num1 * args.Num2, nil
+	})
+	client, err := NewLocalPair(server)
+	_assert(err == nil, "local pair error: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Dynamic.Mul", &Args{Num1: 3, Num2: 4}, &reply)
+	_assert(err == nil && reply == 12, "expect 12, got %d, err: %v", reply, err)
+	err = client.Call(context.Background(), "Foo.v9.Sum", &Args{Num1: 3, Num2: 4}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "version skew"), "expect handler error, got %v", err)
+	err = client.Call(context.Background(), "Foo.Sum", &Args{Num1: 3, Num2: 4}, &reply)
+	_assert(err == nil && reply == 7, "expect registered methods unaffected after skipped body, got %d, err: %v", reply, err)
+
+	server.SetUnknownServiceHandler(nil)
+	err = client.Call(context.Background(), "Dynamic.Mul", &Args{Num1: 3, Num2: 4}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect hard error after reset, got %v", err)
+}
@@ -0,0 +1,276 @@
+//Package wire 定义 gpmd 的线上协议，供其他语言实现客户端时参考，它不依赖 gob，也不依赖 gpmd 包本身。
+//一个连接上的数据依次是：
+//
+//	| 握手 | 帧 | 帧 | ...
+//
+//握手：客户端连接建立后发送一行 JSON 编码的 Handshake（以 '\n' 结尾），MagicNumber 必须为 MagicNumber，
+//CodeType 为 header 和 body 的编码方式，其他语言的客户端应该使用 CodecJSON。服务端接受握手时不回复任何数据，
+//客户端可以紧接着发送请求；拒绝握手时服务端在任何帧之前写出 HandshakeErrorMagic，
+//之后是一行 JSON {"error":"原因"}，然后关闭连接。
+//
+//帧：以 CodecJSON 编码时，一帧是两个连续的 JSON 值，先是 Header，然后是 body，各自以 '\n' 结尾。
+//读取方应该接受任意合法的 JSON（字段顺序、空白、未知字段），写出方按照 Encoder 的格式写出。
+//
+//	请求  客户端 -> 服务端  Seq 从 1 开始递增，Error 为空，body 为方法的参数
+//	响应  服务端 -> 客户端  Seq 与请求相同，Error 为空时 body 为方法的返回值，
+//	                        Error 不为空时调用失败，body 为 {}，需要读取并丢弃；
+//	                        Status 为 true 时 body 为 {"Code":"错误码","Details":[详情...]}
+//	                        预定义的错误码有 "not_found"、"unavailable"、"resource_exhausted"、
+//	                        "deadline_exceeded"，服务端过载时 Details 中有 {"RetryAfter":纳秒}
+//	推送  服务端 -> 客户端  Seq 不小于 PushSeqBase，ServiceMethod 为主题名，body 为消息，
+//	                        客户端没有订阅时丢弃
+//
+//响应的顺序不一定和请求相同，客户端按照 Seq 匹配请求。Header.Timeout 是调用剩余的时间（纳秒），
+//服务端从读到请求时开始计时；旧版本的客户端发送的 Header.Deadline 是截止时间（Unix 纳秒）。
+//Header.Priority 是服务端排队时的优先级，Header.Metadata 是调用的元数据，键为小写。
+//
+//压缩：Header.Compressed 为 true 时 body 是一个 JSON 字符串，内容是 base64 编码的 gzip 数据，
+//解压后是原本的 body JSON。握手时 Handshake.CompressThreshold 为 0 的客户端不会收到压缩的帧。
+//
+//分块：握手时 Handshake.ChunkSize 大于 0 的客户端会收到拆分的响应，一条消息拆分为 Seq 相同的多帧，
+//Header.Chunk 从 1 开始递增，最后一帧的 MoreChunks 为 false。第一帧的 Header 是消息原本的 Header，之后的帧只有 Seq；
+//每一帧的 body（压缩的帧通过 Payload 解压之后）是一个 JSON 字符串，内容是 base64 编码的一段数据，
+//把所有的段按顺序拼接之后是原本的 body JSON。帧之间可能插入其他 Seq 的帧。
+//
+//校验：握手时 Handshake.Checksum 为 true 时，握手之后两个方向上的数据都被分成校验帧：
+//| 长度 uint32 | CRC32-C uint32 | 数据 |，整数为大端序，长度不超过 1 MiB，帧之间的边界与 JSON 值无关。
+//加密（Handshake.Encrypt）依赖 Go 实现的密钥管理，不属于跨语言协议的范围。
+//
+//testdata/vectors.json 是这个格式的一致性测试用例，../interop/python 中的参考客户端在测试中与服务端互通
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	MagicNumber                = 0x1234567              //与 gpmd.MagicNumber 相同
+	CodecJSON                  = "application/json"     //跨语言使用的编码方式
+	HandshakeErrorMagic        = "\x00\x00\x00\x00GPMD" //服务端拒绝握手时的前缀
+	PushSeqBase         uint64 = 1 << 63                //推送消息的 Seq 的下限
+	MaxChecksumFrame           = 1 << 20                //校验帧的最大长度
+)
+
+//Handshake 握手时发送的 JSON，时间的单位是纳秒
+type Handshake struct {
+	MagicNumber       int
+	CodeType          string
+	ConnectTimeout    int64 //客户端的连接超时，服务端不使用
+	HandleTimeout     int64 //服务端处理请求的超时，0 表示使用服务端的设置
+	CompressThreshold int   //不小于这个字节数的 body 会被压缩，0 表示不压缩
+	ChunkSize         int   `json:",omitempty"` //超过这个字节数的 body 拆分为多帧，0 表示不拆分
+	Encrypt           bool
+	Checksum          bool
+}
+
+//Header 每一帧的头部，字段的顺序与 gpmd 写出的一致
+type Header struct {
+	ServiceMethod string
+	Seq           uint64
+	Error         string
+	RequestID     string
+	Compressed    bool
+	Metadata      map[string][]string
+	Deadline      int64
+	Priority      int8
+	Status        bool `json:",omitempty"` //错误响应的 body 是错误码和详情，为 false 时不写出
+	Chunk         int  `json:",omitempty"` //拆分的消息中这一帧的序号，从 1 开始，没有拆分时不写出
+	MoreChunks    bool `json:",omitempty"` //同一个 Seq 之后还有帧
+}
+
+//Frame 一帧数据，Body 是线上的原始 JSON，压缩的帧需要通过 Payload 解压
+type Frame struct {
+	Header Header
+	Body   json.RawMessage
+}
+
+//IsPush 是否是服务端主动推送的消息
+func (f *Frame) IsPush() bool {
+	return f.Header.Seq >= PushSeqBase
+}
+
+//Payload 返回解压之后的 body
+func (f *Frame) Payload() (json.RawMessage, error) {
+	if !f.Header.Compressed {
+		return f.Body, nil
+	}
+	var data []byte
+	if err := json.Unmarshal(f.Body, &data); err != nil {
+		return nil, fmt.Errorf("wire: compressed body: %w", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("wire: compressed body: %w", err)
+	}
+	payload, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("wire: compressed body: %w", err)
+	}
+	return payload, nil
+}
+
+//HandshakeError 服务端拒绝了握手
+type HandshakeError struct {
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "wire: handshake rejected: " + e.Reason
+}
+
+//ErrNotJSON 帧不是以 CodecJSON 编码的
+var ErrNotJSON = errors.New("wire: frame is not json")
+
+//Encoder 按照协议写出握手和帧
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+//WriteHandshake 写出握手，MagicNumber 和 CodeType 为空时使用 MagicNumber 和 CodecJSON
+func (e *Encoder) WriteHandshake(h Handshake) error {
+	if h.MagicNumber == 0 {
+		h.MagicNumber = MagicNumber
+	}
+	if h.CodeType == "" {
+		h.CodeType = CodecJSON
+	}
+	return e.writeLines(h)
+}
+
+//WriteHandshakeError 写出拒绝握手的错误
+func (e *Encoder) WriteHandshakeError(reason string) error {
+	if _, err := io.WriteString(e.w, HandshakeErrorMagic); err != nil {
+		return err
+	}
+	return e.writeLines(struct {
+		Error string `json:"error"`
+	}{reason})
+}
+
+//WriteFrame 写出一帧，body 为空时写出 null
+func (e *Encoder) WriteFrame(h *Header, body json.RawMessage) error {
+	if body == nil {
+		body = json.RawMessage("null")
+	}
+	return e.writeLines(h, body)
+}
+
+//writeLines 一次写出所有的 JSON 值，每个值以 '\n' 结尾，与 encoding/json.Encoder 的格式相同
+func (e *Encoder) writeLines(values ...interface{}) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+//Decoder 按照协议读取握手和帧
+type Decoder struct {
+	r       *bufio.Reader
+	dec     *json.Decoder
+	checked bool //是否已经检查过握手的错误
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	br := bufio.NewReader(r)
+	return &Decoder{r: br, dec: json.NewDecoder(br)}
+}
+
+//ReadHandshake 服务端一侧读取握手
+func (d *Decoder) ReadHandshake() (Handshake, error) {
+	var h Handshake
+	d.checked = true
+	if err := d.dec.Decode(&h); err != nil {
+		return h, err
+	}
+	if h.MagicNumber != MagicNumber {
+		return h, fmt.Errorf("wire: invalid magic number %x", h.MagicNumber)
+	}
+	return h, nil
+}
+
+//ReadFrame 读取一帧，客户端读取的第一帧之前可能是服务端拒绝握手的错误，这时返回 *HandshakeError
+func (d *Decoder) ReadFrame() (*Frame, error) {
+	if !d.checked {
+		d.checked = true
+		if err := d.readHandshakeError(); err != nil {
+			return nil, err
+		}
+	}
+	f := new(Frame)
+	if err := d.dec.Decode(&f.Header); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return nil, ErrNotJSON
+		}
+		return nil, err
+	}
+	if err := d.dec.Decode(&f.Body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d *Decoder) readHandshakeError() error {
+	prefix, err := d.r.Peek(len(HandshakeErrorMagic))
+	if err != nil || string(prefix) != HandshakeErrorMagic {
+		return nil //交给 json.Decoder 报告错误
+	}
+	_, _ = d.r.Discard(len(HandshakeErrorMagic))
+	var reply struct {
+		Error string `json:"error"`
+	}
+	if err := d.dec.Decode(&reply); err != nil {
+		return &HandshakeError{Reason: "unreadable error: " + err.Error()}
+	}
+	return &HandshakeError{Reason: reply.Error}
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//AppendChecksumFrame 把 data 封装为一个校验帧追加到 dst，data 不能超过 MaxChecksumFrame
+func AppendChecksumFrame(dst, data []byte) []byte {
+	var head [8]byte
+	binary.BigEndian.PutUint32(head[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(head[4:], crc32.Checksum(data, crcTable))
+	return append(append(dst, head[:]...), data...)
+}
+
+//ReadChecksumFrame 读取并校验一个校验帧，返回其中的数据
+func ReadChecksumFrame(r io.Reader) ([]byte, error) {
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(head[:4])
+	if size > MaxChecksumFrame {
+		return nil, fmt.Errorf("wire: checksum frame size %d exceeds %d", size, MaxChecksumFrame)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	if want, got := binary.BigEndian.Uint32(head[4:]), crc32.Checksum(data, crcTable); want != got {
+		return nil, fmt.Errorf("wire: checksum mismatch: expect %08x, got %08x", want, got)
+	}
+	return data, nil
+}
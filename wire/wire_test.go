@@ -0,0 +1,293 @@
+package wire_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"gpmd"
+	"gpmd/codec"
+	"gpmd/wire"
+	"io"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type vector struct {
+	Name           string
+	Kind           string
+	Wire           string
+	WireHex        string
+	Handshake      *wire.Handshake
+	HandshakeError string
+	Header         *wire.Header
+	Body           json.RawMessage
+	Payload        json.RawMessage
+	Data           string
+	DecodeOnly     bool
+}
+
+func (v *vector) bytes(t *testing.T) []byte {
+	if v.WireHex == "" {
+		return []byte(v.Wire)
+	}
+	b, err := hex.DecodeString(v.WireHex)
+	if err != nil {
+		t.Fatalf("%s: invalid hex: %v", v.Name, err)
+	}
+	return b
+}
+
+func loadVectors(t *testing.T) []vector {
+	data, err := ioutil.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []vector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	return vectors
+}
+
+func jsonEqual(a, b []byte) bool {
+	var x, y interface{}
+	return json.Unmarshal(a, &x) == nil && json.Unmarshal(b, &y) == nil && reflect.DeepEqual(x, y)
+}
+
+func TestVectors(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		data := v.bytes(t)
+		var out bytes.Buffer
+		enc := wire.NewEncoder(&out)
+		dec := wire.NewDecoder(bytes.NewReader(data))
+		switch v.Kind {
+		case "handshake":
+			h, err := dec.ReadHandshake()
+			if err != nil || h != *v.Handshake {
+				t.Fatalf("%s: decode %+v %v", v.Name, h, err)
+			}
+			_ = enc.WriteHandshake(h)
+		case "handshakeError":
+			_, err := dec.ReadFrame()
+			var herr *wire.HandshakeError
+			if !errors.As(err, &herr) || herr.Reason != v.HandshakeError {
+				t.Fatalf("%s: expect handshake error, got %v", v.Name, err)
+			}
+			_ = enc.WriteHandshakeError(herr.Reason)
+		case "frame":
+			f, err := dec.ReadFrame()
+			if err != nil || !reflect.DeepEqual(f.Header, *v.Header) || !jsonEqual(f.Body, v.Body) {
+				t.Fatalf("%s: decode %+v %s %v", v.Name, f, f.Body, err)
+			}
+			want := v.Payload
+			if want == nil {
+				want = v.Body
+			}
+			if payload, err := f.Payload(); err != nil || !jsonEqual(payload, want) {
+				t.Fatalf("%s: payload %s %v", v.Name, payload, err)
+			}
+			_ = enc.WriteFrame(&f.Header, f.Body)
+		case "checksum":
+			got, err := wire.ReadChecksumFrame(bytes.NewReader(data))
+			if err != nil || string(got) != v.Data {
+				t.Fatalf("%s: decode %q %v", v.Name, got, err)
+			}
+			out.Write(wire.AppendChecksumFrame(nil, got))
+		default:
+			t.Fatalf("%s: unknown kind %s", v.Name, v.Kind)
+		}
+		if !v.DecodeOnly && !bytes.Equal(out.Bytes(), data) {
+			t.Fatalf("%s: encode\n got %q\nwant %q", v.Name, out.Bytes(), data)
+		}
+	}
+}
+
+//bufferConn 在内存中读写编码后的数据
+type bufferConn struct {
+	io.Reader
+	bytes.Buffer
+}
+
+func (c *bufferConn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+func (c *bufferConn) Close() error               { return nil }
+
+//TestVectors_MatchGpmd 用例与 gpmd 自身的 Option 和 JSON 编码逐字节一致
+func TestVectors_MatchGpmd(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		if v.DecodeOnly {
+			continue
+		}
+		data := v.bytes(t)
+		switch v.Kind {
+		case "handshake":
+			h := v.Handshake
+			opt := gpmd.Option{MagicNumber: h.MagicNumber, CodeType: codec.Type(h.CodeType), ConnectTimeout: time.Duration(h.ConnectTimeout),
+				HandleTimeout: time.Duration(h.HandleTimeout), CompressThreshold: h.CompressThreshold, Encrypt: h.Encrypt, Checksum: h.Checksum}
+			got, _ := json.Marshal(opt)
+			if string(got)+"\n" != string(data) {
+				t.Fatalf("%s: gpmd encodes option as %s", v.Name, got)
+			}
+		case "frame":
+			conn := &bufferConn{Reader: bytes.NewReader(data)}
+			cc := codec.NewCompressCodec(codec.NewJsonCodec(conn), codec.JsonType, 0)
+			var h codec.Header
+			var body json.RawMessage
+			if err := cc.ReadHeader(&h); err != nil {
+				t.Fatalf("%s: gpmd read header: %v", v.Name, err)
+			}
+			if err := cc.ReadBody(&body); err != nil {
+				t.Fatalf("%s: gpmd read body: %v", v.Name, err)
+			}
+			want := v.Payload
+			if want == nil {
+				want = v.Body
+			}
+			if h.Seq != v.Header.Seq || h.ServiceMethod != v.Header.ServiceMethod || !jsonEqual(body, want) {
+				t.Fatalf("%s: gpmd decodes %+v %s", v.Name, h, body)
+			}
+			if h.Compressed {
+				continue //gzip 的输出与实现有关，只要求能够解码
+			}
+			if err := codec.NewJsonCodec(conn).Write(&h, body); err != nil || conn.String() != string(data) {
+				t.Fatalf("%s: gpmd encodes %q %v", v.Name, conn.String(), err)
+			}
+		}
+	}
+}
+
+type Args struct{ A, B int }
+
+type Arith int
+
+func (a Arith) Multiply(args Args, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (a Arith) Divide(args Args, reply *int) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	*reply = args.A / args.B
+	return nil
+}
+
+func startServer(t *testing.T) string {
+	server := gpmd.NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return l.Addr().String()
+}
+
+//checksumReader 读取连续的校验帧
+type checksumReader struct {
+	r    io.Reader
+	data []byte
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	for len(c.data) == 0 {
+		data, err := wire.ReadChecksumFrame(c.r)
+		if err != nil {
+			return 0, err
+		}
+		c.data = data
+	}
+	n := copy(p, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func TestServer(t *testing.T) {
+	addr := startServer(t)
+	for _, checksum := range []bool{false, true} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if err := wire.NewEncoder(conn).WriteHandshake(wire.Handshake{Checksum: checksum}); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		enc := wire.NewEncoder(&out)
+		_ = enc.WriteFrame(&wire.Header{ServiceMethod: "Arith.Multiply", Seq: 1, RequestID: "req-1"}, json.RawMessage(`{"A":3,"B":4}`))
+		_ = enc.WriteFrame(&wire.Header{ServiceMethod: "Arith.Divide", Seq: 2}, json.RawMessage(`{"A":1,"B":0}`))
+		var r io.Reader = conn
+		if checksum {
+			_, err = conn.Write(wire.AppendChecksumFrame(nil, out.Bytes()))
+			r = &checksumReader{r: conn}
+		} else {
+			_, err = conn.Write(out.Bytes())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec := wire.NewDecoder(r)
+		replies := make(map[uint64]*wire.Frame)
+		for len(replies) < 2 {
+			f, err := dec.ReadFrame()
+			if err != nil {
+				t.Fatalf("checksum=%v: read frame: %v", checksum, err)
+			}
+			replies[f.Header.Seq] = f
+		}
+		if f := replies[1]; f.Header.Error != "" || f.Header.RequestID != "req-1" || string(f.Body) != "12" {
+			t.Fatalf("checksum=%v: unexpected reply %+v %s", checksum, f.Header, f.Body)
+		}
+		if f := replies[2]; f.Header.Error != "divide by zero" {
+			t.Fatalf("checksum=%v: expect error reply, got %+v", checksum, f.Header)
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestServer_HandshakeRejected(t *testing.T) {
+	conn, err := net.Dial("tcp", startServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_ = wire.NewEncoder(conn).WriteHandshake(wire.Handshake{MagicNumber: 1})
+	_, err = wire.NewDecoder(conn).ReadFrame()
+	var herr *wire.HandshakeError
+	if !errors.As(err, &herr) || !strings.Contains(herr.Reason, "magic number") {
+		t.Fatalf("expect handshake error, got %v", err)
+	}
+}
+
+//TestPythonInterop 运行 interop/python 中的参考客户端
+func TestPythonInterop(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not found")
+	}
+	const client = "../interop/python/gpmd_client.py"
+	out, err := exec.Command(python, client, "vectors", "testdata/vectors.json").CombinedOutput()
+	if err != nil || !strings.HasPrefix(string(out), "ok ") {
+		t.Fatalf("python vectors: %s %v", out, err)
+	}
+	addr := startServer(t)
+	out, err = exec.Command(python, client, "call", addr, "Arith.Multiply", `{"A": 6, "B": 7}`).CombinedOutput()
+	if err != nil || strings.TrimSpace(string(out)) != "42" {
+		t.Fatalf("python call: %s %v", out, err)
+	}
+	out, err = exec.Command(python, client, "call", addr, "Arith.Divide", `{"A": 1, "B": 0}`).CombinedOutput()
+	if err == nil || strings.TrimSpace(string(out)) != "divide by zero" {
+		t.Fatalf("python call: expect error, got %s %v", out, err)
+	}
+}
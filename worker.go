@@ -0,0 +1,154 @@
+package gpmd
+
+import (
+	"errors"
+	"gpmd/codec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//ErrDeadlineExceeded 请求在开始处理之前已经超过了客户端传递的截止时间，
+//或者剩余的时间不足以完成这个方法的平均处理时间，服务端直接放弃处理
+var ErrDeadlineExceeded = errors.New("rpc server: deadline exceeded")
+
+//workerPool 固定数量的 worker 处理排队的请求，请求按照优先级分为高、普通、低三个队列，
+//worker 总是先处理高优先级队列，同一个队列内先进先出
+type workerPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues [3][]func() //依次为高、普通、低优先级
+}
+
+func newWorkerPool(workers int) *workerPool {
+	p := &workerPool{}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+//queueIndex 返回优先级对应的队列，超出范围的值归入最近的队列
+func queueIndex(priority Priority) int {
+	switch {
+	case priority > PriorityNormal:
+		return 0
+	case priority < PriorityNormal:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (p *workerPool) submit(priority Priority, fn func()) {
+	i := queueIndex(priority)
+	p.mu.Lock()
+	p.queues[i] = append(p.queues[i], fn)
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+//next 取出优先级最高的请求，没有排队的请求时返回空
+func (p *workerPool) next() func() {
+	for i, queue := range p.queues {
+		if len(queue) > 0 {
+			fn := queue[0]
+			queue[0] = nil
+			p.queues[i] = queue[1:]
+			return fn
+		}
+	}
+	return nil
+}
+
+func (p *workerPool) work() {
+	for {
+		p.mu.Lock()
+		fn := p.next()
+		for fn == nil {
+			p.cond.Wait()
+			fn = p.next()
+		}
+		p.mu.Unlock()
+		fn()
+	}
+}
+
+//dispatch 设置了 MaxConcurrentRequests 时交给 worker 按照 priority 排队处理，否则直接启动 goroutine
+func (s *Server) dispatch(priority Priority, fn func()) {
+	s.poolOnce.Do(func() {
+		if s.MaxConcurrentRequests > 0 {
+			s.pool = newWorkerPool(s.MaxConcurrentRequests)
+		}
+	})
+	if s.pool == nil {
+		go fn()
+		return
+	}
+	s.pool.submit(priority, fn)
+}
+
+//requestPriority 内置服务（健康检查、反射、订阅）总是使用高优先级，其余使用客户端在 Header 中指定的优先级
+func requestPriority(h *codec.Header) Priority {
+	if strings.HasPrefix(h.ServiceMethod, builtinServicePrefix) {
+		return PriorityHigh
+	}
+	return Priority(h.Priority)
+}
+
+//shouldShed 请求已经过了截止时间，或者剩余时间少于这个方法的平均处理时间时返回 true。
+//被拒绝的请求不会更新平均值，因此每次因为平均值拒绝时衰减一次，避免几次慢调用之后长期拒绝所有请求
+func shouldShed(req *request, now time.Time) bool {
+	if req.deadline.IsZero() {
+		return false
+	}
+	remaining := req.deadline.Sub(now)
+	if remaining <= 0 {
+		return true
+	}
+	if req.mType == nil || remaining >= req.mType.AvgDuration() {
+		return false
+	}
+	req.mType.observe(0)
+	return true
+}
+
+//requestDeadline 换算请求的截止时间，Header.Timeout 从读到请求时开始计时，旧版本的客户端只发送 Header.Deadline
+func (s *Server) requestDeadline(h *codec.Header) time.Time {
+	if h.Timeout > 0 {
+		return s.clock().Now().Add(time.Duration(h.Timeout))
+	}
+	if h.Deadline != 0 {
+		return time.Unix(0, h.Deadline)
+	}
+	return time.Time{}
+}
+
+//headerTimeout 返回随 Header 发送的剩余时间，已经过了截止时间时发送 1 纳秒，服务端会直接拒绝
+func headerTimeout(remaining time.Duration) int64 {
+	if remaining <= 0 {
+		return 1
+	}
+	return int64(remaining)
+}
+
+//AvgDuration 返回方法处理时间的指数移动平均值
+func (m *methodType) AvgDuration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&m.avgNanos))
+}
+
+//observe 记录一次处理时间，新的样本占 1/8 的权重
+func (m *methodType) observe(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&m.avgNanos)
+		avg := int64(d)
+		if old != 0 {
+			avg = old - old/8 + int64(d)/8
+		}
+		if atomic.CompareAndSwapInt64(&m.avgNanos, old, avg) {
+			return
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package gpmd
+
+import (
+	"errors"
+	"fmt"
+	"gpmd/codec"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+//QueueFullPolicy 连接的发送队列满时的处理方式，见 ListenerOption.WriteQueue
+type QueueFullPolicy int
+
+const (
+	QueueBlock QueueFullPolicy = iota //等待队列中有空位，慢的客户端只拖慢自己连接上的 handler，默认
+	QueueDrop                         //丢弃这条消息，对应的调用在客户端超时
+	QueueClose                        //关闭连接，客户端所有进行中的调用立即失败
+)
+
+func (p QueueFullPolicy) String() string {
+	switch p {
+	case QueueBlock:
+		return "block"
+	case QueueDrop:
+		return "drop"
+	case QueueClose:
+		return "close"
+	}
+	return "unknown"
+}
+
+//ParseQueueFullPolicy 将 block、drop、close 转换为 QueueFullPolicy，空字符串为 QueueBlock
+func ParseQueueFullPolicy(s string) (QueueFullPolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "block":
+		return QueueBlock, nil
+	case "drop":
+		return QueueDrop, nil
+	case "close":
+		return QueueClose, nil
+	default:
+		return 0, fmt.Errorf("rpc server: unknown write queue policy %s", s)
+	}
+}
+
+var (
+	errWriteQueueFull   = errors.New("rpc server: write queue full")
+	errWriteQueueClosed = errors.New("rpc server: connection closed")
+)
+
+//outbound 队列中的一条消息，Header 在入队时复制，之后请求的 Header 可能被修改
+type outbound struct {
+	h    codec.Header
+	body interface{}
+}
+
+//queuedCodec 把 Write 变成入队，由一个 goroutine 依次写出，慢的客户端不会让 handler 阻塞在写连接上。
+//写出超过 timeout 或者失败时关闭连接，之后的消息直接丢弃
+type queuedCodec struct {
+	codec.Codec
+	s       *Server
+	conn    io.Closer
+	timeout time.Duration
+	policy  QueueFullPolicy
+	queue   chan outbound
+	finish  chan struct{} //Close 时关闭，写完队列中剩余的消息后退出
+	stopped chan struct{} //写 goroutine 退出后关闭
+	once    sync.Once
+	failed  bool //写出失败过，只由写 goroutine 访问
+}
+
+func (s *Server) newQueuedCodec(cc codec.Codec, conn io.Closer, lo *ListenerOption) *queuedCodec {
+	q := &queuedCodec{
+		Codec:   cc,
+		s:       s,
+		conn:    conn,
+		timeout: lo.WriteTimeout,
+		policy:  lo.WriteQueueFull,
+		queue:   make(chan outbound, lo.WriteQueue),
+		finish:  make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+//Write 按照 policy 入队，不等待写出
+func (q *queuedCodec) Write(h *codec.Header, body interface{}) error {
+	m := outbound{h: *h, body: body}
+	select {
+	case <-q.stopped:
+		return errWriteQueueClosed
+	case q.queue <- m:
+		return nil
+	default:
+	}
+	if q.policy == QueueBlock {
+		select {
+		case q.queue <- m:
+			return nil
+		case <-q.stopped:
+			return errWriteQueueClosed
+		}
+	}
+	q.s.metrics().Inc("gpmd_server_write_queue_full_total", "policy", q.policy.String())
+	if q.policy == QueueClose {
+		_ = q.conn.Close()
+	}
+	return errWriteQueueFull
+}
+
+func (q *queuedCodec) run() {
+	defer close(q.stopped)
+	for {
+		select {
+		case m := <-q.queue:
+			q.write(m, queueYield{q})
+		case <-q.finish:
+			q.drain()
+			return
+		}
+	}
+}
+
+//drain 写出队列中已有的消息，不等待新的消息
+func (q *queuedCodec) drain() {
+	for n := len(q.queue); n > 0; n-- {
+		q.write(<-q.queue, noYield{})
+	}
+}
+
+//write 写出一条消息，分块的消息在帧之间通过 yield 先写出队列中的其他消息
+func (q *queuedCodec) write(m outbound, yield sync.Locker) {
+	if q.failed {
+		return
+	}
+	if q.timeout > 0 {
+		if wd, ok := q.conn.(interface{ SetWriteDeadline(time.Time) error }); ok {
+			_ = wd.SetWriteDeadline(time.Now().Add(q.timeout))
+		}
+	}
+	var err error
+	if chunked, ok := q.Codec.(*codec.ChunkCodec); ok {
+		err = chunked.WriteChunks(&m.h, m.body, -1, yield)
+	} else {
+		err = q.Codec.Write(&m.h, m.body)
+	}
+	if err != nil {
+		logf(LogError, "rpc server: write response error: %v, request id: %s", err, m.h.RequestID)
+		q.failed = true
+		_ = q.conn.Close()
+	}
+}
+
+//Close 写完已经入队的消息后关闭连接
+func (q *queuedCodec) Close() error {
+	q.once.Do(func() { close(q.finish) })
+	<-q.stopped
+	return q.Codec.Close()
+}
+
+//queueYield 分块之间让出连接：写 goroutine 是唯一的写出方，不需要加锁，Lock 时先写出队列中已有的消息
+type queueYield struct {
+	q *queuedCodec
+}
+
+func (y queueYield) Lock()   { y.q.drain() }
+func (y queueYield) Unlock() {}
+
+//noYield 写出队列中的消息时不再嵌套让出
+type noYield struct{}
+
+func (noYield) Lock()   {}
+func (noYield) Unlock() {}
@@ -0,0 +1,76 @@
+package gpmd
+
+import (
+	"encoding/json"
+	"gpmd/codec"
+	"net"
+	"testing"
+	"time"
+)
+
+//dialPipe 通过 net.Pipe 连接 server，返回的 Codec 不读取时服务端的写出会一直阻塞
+func dialPipe(server *Server) codec.Codec {
+	client, conn := net.Pipe()
+	go server.ServeConn(conn)
+	_ = json.NewEncoder(client).Encode(&Option{MagicNumber: MagicNumber, CodeType: codec.JsonType})
+	return codec.NewJsonCodec(client)
+}
+
+func TestServer_WriteQueueDrop(t *testing.T) {
+	t.Parallel()
+	metrics := &countingMetrics{counters: make(map[string]int)}
+	server := NewServer()
+	server.Metrics = metrics
+	server.Defaults = ListenerOption{WriteQueue: 1, WriteQueueFull: QueueDrop}
+	var foo Foo
+	_ = server.Register(&foo)
+	cc := dialPipe(server)
+	defer func() { _ = cc.Close() }()
+	for i := 1; i <= 5; i++ {
+		_assert(cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: uint64(i)}, &Args{Num1: i, Num2: i}) == nil, "write request %d", i)
+	}
+	//一条卡在连接上，一条在队列中，其余的被丢弃
+	deadline := time.Now().Add(time.Second)
+	for metrics.get("gpmd_server_write_queue_full_total") < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_assert(metrics.get("gpmd_server_write_queue_full_total") == 3, "expect 3 dropped replies, got %d", metrics.get("gpmd_server_write_queue_full_total"))
+	for i := 0; i < 2; i++ {
+		var h codec.Header
+		var reply int
+		_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(&reply) == nil, "expect queued reply")
+		_assert(reply == 2*int(h.Seq), "expect reply of seq %d, got %d", h.Seq, reply)
+	}
+}
+
+func TestServer_WriteTimeout(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.Defaults = ListenerOption{WriteQueue: 4, WriteTimeout: 50 * time.Millisecond}
+	var foo Foo
+	_ = server.Register(&foo)
+	cc := dialPipe(server)
+	defer func() { _ = cc.Close() }()
+	_assert(cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, &Args{Num1: 1, Num2: 2}) == nil, "write request")
+	time.Sleep(200 * time.Millisecond)
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) != nil, "expect connection closed after the write timeout")
+}
+
+func TestServer_WriteQueue(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.Defaults = ListenerOption{WriteQueue: 8, WriteQueueFull: QueueClose, WriteTimeout: time.Second}
+	addr := startLimitedServer(server)
+	opt, _ := NewOption(WithChunking(1024))
+	client, err := Dial("tcp", addr, opt)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	for i := 0; i < 10; i++ {
+		_assert(callSum(client) == nil, "expect calls served through the write queue")
+	}
+	policy, err := ParseQueueFullPolicy("Drop")
+	_assert(err == nil && policy == QueueDrop, "expect drop policy, got %v", policy)
+	_, err = ParseQueueFullPolicy("spill")
+	_assert(err != nil, "expect unknown policy rejected")
+}
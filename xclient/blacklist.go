@@ -0,0 +1,145 @@
+package xclient
+
+import (
+	"errors"
+	. "gpmd"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+//BlacklistPolicy 连接失败的实例暂时不再被选中，不依赖注册中心的状态，零值表示使用默认值
+type BlacklistPolicy struct {
+	BaseDuration time.Duration //第一次失败后移出的时间，默认 1s，连续失败时每次翻倍
+	MaxDuration  time.Duration //最长移出的时间，默认 30s
+	DecayAfter   time.Duration //每经过这么久没有失败，累计的失败次数减半，默认 10s
+}
+
+//blacklistEntry 一个实例的失败记录
+type blacklistEntry struct {
+	failures int       //经过衰减的累计失败次数
+	last     time.Time //最近一次失败的时间
+	until    time.Time //在这之前不会被选中
+}
+
+//blacklist 记录连接失败的实例，所有方法可以并发调用
+type blacklist struct {
+	policy  BlacklistPolicy
+	mu      sync.Mutex
+	entries map[string]*blacklistEntry
+}
+
+func newBlacklist(p BlacklistPolicy) *blacklist {
+	if p.BaseDuration <= 0 {
+		p.BaseDuration = time.Second
+	}
+	if p.MaxDuration <= 0 {
+		p.MaxDuration = 30 * time.Second
+	}
+	if p.DecayAfter <= 0 {
+		p.DecayAfter = 10 * time.Second
+	}
+	return &blacklist{policy: p, entries: make(map[string]*blacklistEntry)}
+}
+
+//decay 按照距离上次失败的时间衰减失败次数
+func (b *blacklist) decay(e *blacklistEntry, now time.Time) {
+	for n := now.Sub(e.last) / b.policy.DecayAfter; n > 0 && e.failures > 0; n-- {
+		e.failures /= 2
+	}
+}
+
+//fail 记录一次连接失败，移出的时间随着累计失败次数指数增长
+func (b *blacklist) fail(addr string) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.entries[addr]
+	if e == nil {
+		e = &blacklistEntry{}
+		b.entries[addr] = e
+	}
+	b.decay(e, now)
+	e.failures++
+	penalty := b.policy.BaseDuration
+	for i := 1; i < e.failures && penalty < b.policy.MaxDuration; i++ {
+		penalty *= 2
+	}
+	if penalty > b.policy.MaxDuration {
+		penalty = b.policy.MaxDuration
+	}
+	e.last, e.until = now, now.Add(penalty)
+}
+
+//succeed 调用成功后实例立即恢复，累计的失败次数仍然保留到衰减为 0，反复失败的实例下次会被移出更久
+func (b *blacklist) succeed(addr string) {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.entries[addr]
+	if e == nil {
+		return
+	}
+	b.decay(e, now)
+	if e.failures == 0 {
+		delete(b.entries, addr)
+		return
+	}
+	e.until = time.Time{}
+}
+
+//active 是否有实例正在被移出
+func (b *blacklist) active() bool {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range b.entries {
+		if now.Before(e.until) {
+			return true
+		}
+	}
+	return false
+}
+
+//banned addr 是否正在被移出
+func (b *blacklist) banned(addr string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e := b.entries[addr]
+	return e != nil && time.Now().Before(e.until)
+}
+
+//filter 去掉正在被移出的实例，所有实例都被移出时原样返回，避免完全没有可用的实例
+func (b *blacklist) filter(servers []Instance) []Instance {
+	now := time.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	alive := make([]Instance, 0, len(servers))
+	for _, s := range servers {
+		if e := b.entries[s.Addr]; e == nil || !now.Before(e.until) {
+			alive = append(alive, s)
+		}
+	}
+	if len(alive) == 0 {
+		return servers
+	}
+	return alive
+}
+
+//isConnError 判断是否是连接层面的错误，服务端返回的业务错误不会导致实例被移出
+func isConnError(err error) bool {
+	if errors.Is(err, ErrShutdown) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+//SetBlacklist 开启自动移出：调用某个实例时出现连接错误后，在一段时间内不再选择它，
+//这样崩溃的实例在注册中心过期之前也不会继续接收请求
+func (xc *XClient) SetBlacklist(p BlacklistPolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.blacklist = newBlacklist(p)
+}
@@ -0,0 +1,113 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	. "gpmd"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//ServerReply 一个服务实例的调用结果
+type ServerReply struct {
+	Addr  string
+	Reply interface{} //与 BroadcastAll 的 reply 类型相同，调用失败时为空
+	Err   error
+}
+
+//BroadcastResult BroadcastAll 的结果，按照地址排序
+type BroadcastResult struct {
+	Replies []ServerReply
+}
+
+//Succeeded 返回调用成功的实例数
+func (r *BroadcastResult) Succeeded() int {
+	n := 0
+	for _, s := range r.Replies {
+		if s.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+//Failed 返回调用失败的实例
+func (r *BroadcastResult) Failed() []ServerReply {
+	var failed []ServerReply
+	for _, s := range r.Replies {
+		if s.Err != nil {
+			failed = append(failed, s)
+		}
+	}
+	return failed
+}
+
+//BroadcastError 成功的实例数没有达到 quorum
+type BroadcastError struct {
+	Quorum int
+	Result *BroadcastResult
+}
+
+func (e *BroadcastError) Error() string {
+	failed := e.Result.Failed()
+	msgs := make([]string, 0, len(failed))
+	for _, s := range failed {
+		msgs = append(msgs, s.Addr+": "+s.Err.Error())
+	}
+	return fmt.Sprintf("rpc xclient: broadcast quorum not reached: %d of %d succeeded, need %d (%s)",
+		e.Result.Succeeded(), len(e.Result.Replies), e.Quorum, strings.Join(msgs, "; "))
+}
+
+//BroadcastAll 与 Broadcast 一样调用所有实例，但是不会因为一个实例失败而取消其他调用，
+//返回每个实例的结果。quorum 为需要成功的实例数，0 表示全部实例，成功数不足时返回 *BroadcastError，
+//reply 不为空时设置为第一个成功的结果
+func (xc *XClient) BroadcastAll(ctx context.Context, serviceMethod string, args, reply interface{}, quorum int) (result *BroadcastResult, err error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(servers)
+	ctx, span := startFanout(ctx, "BroadcastAll", serviceMethod, len(servers))
+	defer func() { span.Finish(err) }()
+	result = &BroadcastResult{Replies: make([]ServerReply, len(servers))}
+	var wg sync.WaitGroup
+	for i, rpcAddr := range servers {
+		wg.Add(1)
+		go func(i int, rpcAddr string) {
+			defer wg.Done()
+			var cloneReply interface{}
+			if reply != nil {
+				cloneReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+			}
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, cloneReply)
+			result.Replies[i] = ServerReply{Addr: rpcAddr, Err: err}
+			if err == nil {
+				result.Replies[i].Reply = cloneReply
+			}
+		}(i, rpcAddr)
+	}
+	wg.Wait()
+	for _, s := range result.Replies {
+		if s.Err == nil && reply != nil {
+			reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(s.Reply).Elem())
+			break
+		}
+	}
+	if quorum <= 0 {
+		quorum = len(servers)
+	}
+	if result.Succeeded() < quorum {
+		return result, &BroadcastError{Quorum: quorum, Result: result}
+	}
+	return result, nil
+}
+
+//startFanout 为一次扇出调用创建 span，每个实例的调用都是它的子 span
+func startFanout(ctx context.Context, op, serviceMethod string, servers int) (context.Context, Span) {
+	ctx, span := GetTracer().StartSpan(ctx, op+" "+serviceMethod, SpanInternal)
+	span.SetTag("servers", strconv.Itoa(servers))
+	return ctx, span
+}
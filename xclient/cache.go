@@ -0,0 +1,169 @@
+package xclient
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	. "gpmd"
+	"gpmd/metadata"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//responseCache 以 (serviceMethod, args、路由提示和元数据的哈希) 为键缓存调用结果，按照 LRU 淘汰，
+//值保存为 gob 编码后的字节，命中时解码到调用方的 reply 中，避免多个调用方共享同一个对象
+type responseCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	methods    map[string]bool //可以缓存的方法
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	data    []byte
+	expires time.Time
+}
+
+func newResponseCache(ttl time.Duration, maxEntries int) *responseCache {
+	return &responseCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		methods:    make(map[string]bool),
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *responseCache) setCacheable(serviceMethod string, cacheable bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cacheable {
+		c.methods[serviceMethod] = true
+		return
+	}
+	delete(c.methods, serviceMethod)
+	for key, e := range c.items {
+		if len(key) > len(serviceMethod) && key[:len(serviceMethod)+1] == serviceMethod+"|" {
+			c.ll.Remove(e)
+			delete(c.items, key)
+		}
+	}
+}
+
+//key 返回缓存键，方法不可缓存或者参数无法编码时返回 false
+func (c *responseCache) key(ctx context.Context, serviceMethod string, args interface{}, hint RouteHint, opts []CallOption) (string, bool) {
+	c.mu.Lock()
+	cacheable := c.methods[serviceMethod]
+	c.mu.Unlock()
+	if !cacheable {
+		return "", false
+	}
+	return requestKey(ctx, serviceMethod, args, hint, opts)
+}
+
+//callKey 由方法名和参数的哈希组成，参数无法编码时返回 false。
+//参数使用 json 编码，map 的键是有序的，保证相同参数得到相同的键
+func callKey(serviceMethod string, args interface{}) (string, bool) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return "", false
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return serviceMethod + "|" + strconv.FormatUint(h.Sum64(), 16), true
+}
+
+//requestKey 由 callKey、路由提示和元数据组成，响应缓存和合并调用都使用它，不同租户或者不同路由的调用不会共用结果。
+//无法编码时返回 false
+func requestKey(ctx context.Context, serviceMethod string, args interface{}, hint RouteHint, opts []CallOption) (string, bool) {
+	key, ok := callKey(serviceMethod, args)
+	if !ok {
+		return "", false
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	callMD := ApplyCallOptions(opts...).Metadata
+	if hint.isZero() && len(md) == 0 && len(callMD) == 0 {
+		return key, true
+	}
+	data, err := json.Marshal(struct {
+		Hint     RouteHint
+		Metadata metadata.MD
+		CallMD   metadata.MD
+	}{hint, md, callMD})
+	if err != nil {
+		return "", false
+	}
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	return key + "|" + strconv.FormatUint(h.Sum64(), 16), true
+}
+
+//get 命中时将缓存的结果解码到 reply 中
+func (c *responseCache) get(key string, reply interface{}) bool {
+	c.mu.Lock()
+	e, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return false
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.ll.Remove(e)
+		delete(c.items, key)
+		c.mu.Unlock()
+		return false
+	}
+	c.ll.MoveToFront(e)
+	data := entry.data
+	c.mu.Unlock()
+	return reply == nil || gob.NewDecoder(bytes.NewReader(data)).Decode(reply) == nil
+}
+
+func (c *responseCache) put(key string, reply interface{}) {
+	var buf bytes.Buffer
+	if reply != nil {
+		if err := gob.NewEncoder(&buf).Encode(reply); err != nil {
+			return
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, data: buf.Bytes(), expires: time.Now().Add(c.ttl)}
+	if e, ok := c.items[key]; ok {
+		e.Value = entry
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(entry)
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+//EnableCache 开启响应缓存，ttl 为缓存有效期，maxEntries 为最多缓存的条目数（0 表示不限制）。
+//只有通过 SetCacheable 标记过的方法才会被缓存，适合配置查询、功能开关这类只读的热点调用。
+//参数、路由提示和元数据（ctx 和 WithCallMetadata）都相同的调用才会命中同一个条目
+func (xc *XClient) EnableCache(ttl time.Duration, maxEntries int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.cache = newResponseCache(ttl, maxEntries)
+}
+
+//SetCacheable 标记方法是否可以缓存，需要先调用 EnableCache。取消标记时会清除该方法已缓存的结果
+func (xc *XClient) SetCacheable(serviceMethod string, cacheable bool) {
+	xc.mu.Lock()
+	cache := xc.cache
+	xc.mu.Unlock()
+	if cache != nil {
+		cache.setCacheable(serviceMethod, cacheable)
+	}
+}
@@ -2,10 +2,9 @@ package xclient
 
 import (
 	"errors"
-	"math"
-	"math/rand"
+	"fmt"
+	"strings"
 	"sync"
-	"time"
 )
 
 type SelectMode int
@@ -15,6 +14,18 @@ const (
 	RoundRobinSelect
 )
 
+//ParseSelectMode 将配置中的字符串转换为负载均衡策略
+func ParseSelectMode(s string) (SelectMode, error) {
+	switch strings.ToLower(s) {
+	case "", "random":
+		return RandomSelect, nil
+	case "roundrobin", "round_robin", "round-robin":
+		return RoundRobinSelect, nil
+	default:
+		return 0, fmt.Errorf("rpc discovery: unknown select mode %s", s)
+	}
+}
+
 type Discovery interface {
 	Refresh() error                      //从注册中心更新服务列表
 	Update(servers []string) error       //手动更新服务列表
@@ -23,23 +34,30 @@ type Discovery interface {
 }
 
 type MultiServerDiscovery struct {
-	r       *rand.Rand //r 是一个产生随机数的实例，初始化时使用时间戳设定随机数种子，避免每次产生相同的随机数序列
-	mu      sync.Mutex
-	servers []string
-	index   int //index 记录 Round Robin 算法已经轮询到的位置，为了避免每次从 0 开始，初始化时随机设定一个值
+	mu        sync.Mutex
+	servers   []string
+	meta      map[string]map[string]string //meta 记录服务实例的元数据，键是实例地址
+	selectors map[SelectMode]Selector      //每种 SelectMode 对应的内置策略
+}
+
+//MetaDiscovery 可以提供服务实例元数据的 Discovery，分片路由等功能依赖它
+type MetaDiscovery interface {
+	Discovery
+	GetMeta() (map[string]map[string]string, error) //返回每个服务实例的元数据，键是实例地址
 }
 
 func NewMultiServerDiscovery(servers []string) *MultiServerDiscovery {
-	d := &MultiServerDiscovery{
+	return &MultiServerDiscovery{
 		servers: servers,
-		r:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		selectors: map[SelectMode]Selector{
+			RandomSelect:     NewRandomSelector(),
+			RoundRobinSelect: NewRoundRobinSelector(),
+		},
 	}
-	d.index = d.r.Intn(math.MaxInt32 - 1)
-	return d
 }
 
 //以下方法，判断是否实现了所有接口
-var _ Discovery = (*MultiServerDiscovery)(nil)
+var _ MetaDiscovery = (*MultiServerDiscovery)(nil)
 
 func (d *MultiServerDiscovery) Refresh() error {
 	return nil
@@ -55,20 +73,35 @@ func (d *MultiServerDiscovery) Update(servers []string) error {
 func (d *MultiServerDiscovery) Get(mode SelectMode) (string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	n := len(d.servers)
-	if n == 0 {
-		return "", errors.New("rpc discovery: no available servers")
+	if len(d.servers) == 0 {
+		return "", errNoAvailableServers
 	}
-	switch mode {
-	case RandomSelect:
-		return d.servers[d.r.Intn(n)], nil
-	case RoundRobinSelect:
-		s := d.servers[d.index%n]
-		d.index = (d.index + 1) % n
-		return s, nil
-	default:
+	selector, ok := d.selectors[mode]
+	if !ok {
 		return "", errors.New("rpc discovery: not supported select mode")
 	}
+	s := selector.Pick(instances(d.servers, d.meta), CallInfo{})
+	if s.Addr == "" {
+		return "", errNoAvailableServers
+	}
+	return s.Addr, nil
+}
+
+//UpdateMeta 手动设置服务实例的元数据，用于没有注册中心的静态服务列表
+func (d *MultiServerDiscovery) UpdateMeta(meta map[string]map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.meta = meta
+}
+
+func (d *MultiServerDiscovery) GetMeta() (map[string]map[string]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	meta := make(map[string]map[string]string, len(d.meta))
+	for addr, m := range d.meta {
+		meta[addr] = m
+	}
+	return meta, nil
 }
 
 func (d *MultiServerDiscovery) GetAll() ([]string, error) {
@@ -1,8 +1,12 @@
 package xclient
 
 import (
+	"context"
+	"errors"
+	. "gpmd"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -10,11 +14,20 @@ import (
 type GpmdRegistryDiscovery struct {
 	*MultiServerDiscovery
 	registry   string        //registry 即注册中心地址
+	namespace  string        //只发现这个命名空间中的实例，为空表示默认命名空间
 	timeout    time.Duration //服务列表过期时间
 	lastUpdate time.Time     //代表从注册中心更新服务列表的时间，默认10s过期。即10秒后需要从注册中心更新新的列表
+	maxStale   time.Duration //服务列表过期后，在后台更新的同时最多继续使用的时间，负数表示不限制
+	refreshing bool          //正在后台更新服务列表
+	flights    flightGroup   //合并并发的更新
+	client     *http.Client  //访问注册中心的客户端，为空时使用 http.DefaultClient
+	clock      Clock         //判断服务列表是否过期使用的时钟，为空时使用 SystemClock
 }
 
-const defaultUpdateDuration = time.Second * 10
+const (
+	defaultUpdateDuration = time.Second * 10
+	defaultMaxStaleness   = time.Minute * 5
+)
 
 func NewGpmdRegistryDiscovery(registerAddr string, timeout time.Duration) *GpmdRegistryDiscovery {
 	if timeout == 0 {
@@ -24,50 +37,194 @@ func NewGpmdRegistryDiscovery(registerAddr string, timeout time.Duration) *GpmdR
 		MultiServerDiscovery: NewMultiServerDiscovery(make([]string, 0)),
 		registry:             registerAddr,
 		timeout:              timeout,
+		maxStale:             defaultMaxStaleness,
 	}
 	return d
 }
 
+//SetMaxStaleness 服务列表过期后，Get 等方法在后台更新的同时最多继续使用缓存的列表 maxStale，
+//注册中心暂时不可用时不影响调用。超过之后在调用中同步更新，更新失败时返回错误。
+//0 表示默认的 5 分钟，负数表示不限制
+func (d *GpmdRegistryDiscovery) SetMaxStaleness(maxStale time.Duration) {
+	if maxStale == 0 {
+		maxStale = defaultMaxStaleness
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxStale = maxStale
+}
+
+//SetNamespace 只发现命名空间 ns 中的实例，例如 staging 的客户端不会发现 prod 的实例。
+//也可以在注册中心地址中加上 namespace 参数，例如 http://127.0.0.1:9999/_gpmd_/registry?namespace=staging
+func (d *GpmdRegistryDiscovery) SetNamespace(ns string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.namespace = ns
+	d.lastUpdate = time.Time{}
+}
+
+//SetHTTPClient 使用 c 访问注册中心，例如配置了证书的客户端，或者 registry.Registry.Client 返回的进程内客户端
+func (d *GpmdRegistryDiscovery) SetHTTPClient(c *http.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.client = c
+}
+
+//SetClock 使用 c 判断服务列表是否过期，测试中通常传入 gpmdtest.FakeClock
+func (d *GpmdRegistryDiscovery) SetClock(c Clock) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clock = c
+}
+
+//now 返回 clock 的当前时间，调用方需要持有 mu
+func (d *GpmdRegistryDiscovery) now() time.Time {
+	if d.clock != nil {
+		return d.clock.Now()
+	}
+	return SystemClock.Now()
+}
+
 func (d *GpmdRegistryDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.servers = servers
-	d.lastUpdate = time.Now()
+	d.lastUpdate = d.now()
 	return nil
 }
 
+//Refresh 服务列表过期时从注册中心同步更新
 func (d *GpmdRegistryDiscovery) Refresh() error {
+	return d.RefreshContext(context.Background())
+}
+
+//RefreshContext 与 Refresh 相同，ctx 被取消时立即返回。
+//请求注册中心时不持有锁，并发的 Get 继续使用原来的列表，同一时刻只有一个请求发往注册中心
+func (d *GpmdRegistryDiscovery) RefreshContext(ctx context.Context) error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
+	fresh := d.lastUpdate.Add(d.timeout).After(d.now())
+	d.mu.Unlock()
+	if fresh {
+		return nil
+	}
+	return d.refresh(ctx)
+}
+
+//revalidate 供 Get 等方法使用：没有列表或者列表过期超过 maxStale 时同步更新，
+//刚过期时在后台更新，先使用缓存的列表
+func (d *GpmdRegistryDiscovery) revalidate() error {
+	d.mu.Lock()
+	age := d.now().Sub(d.lastUpdate)
+	inline := d.lastUpdate.IsZero() || (d.maxStale >= 0 && age > d.timeout+d.maxStale)
+	background := !inline && age > d.timeout && !d.refreshing
+	if background {
+		d.refreshing = true
+	}
+	d.mu.Unlock()
+	switch {
+	case age <= d.timeout:
 		return nil
+	case inline:
+		return d.refresh(context.Background())
+	case background:
+		go func() {
+			_ = d.refresh(context.Background())
+			d.mu.Lock()
+			d.refreshing = false
+			d.mu.Unlock()
+		}()
+	}
+	return nil
+}
+
+//refresh 合并并发的更新，只有一个请求发往注册中心，成功后整体替换服务列表和元数据，失败时保留原来的列表
+func (d *GpmdRegistryDiscovery) refresh(ctx context.Context) error {
+	return d.flights.do(ctx, "refresh", nil, func(interface{}) error {
+		d.mu.Lock()
+		registry, namespace, client := d.registry, d.namespace, d.client
+		d.mu.Unlock()
+		servers, meta, err := fetchServers(ctx, client, registry, namespace)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		//更新期间修改了命名空间时丢弃这次的结果，下次重新获取
+		if d.registry == registry && d.namespace == namespace {
+			d.servers, d.meta = servers, meta
+			d.lastUpdate = d.now()
+		}
+		return nil
+	})
+}
+
+//fetchServers 从注册中心获取命名空间中的服务列表和元数据
+func fetchServers(ctx context.Context, client *http.Client, registry, namespace string) ([]string, map[string]map[string]string, error) {
+	log.Println("rpc registry: refresh servers from registry", registry)
+	req, err := http.NewRequest("GET", registry, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	if namespace != "" {
+		req.Header.Set("X-GPMD-NAMESPACE", namespace)
 	}
-	log.Println("rpc registry: refresh servers from registry", d.registry)
-	resp, err := http.Get(d.registry)
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		log.Println("rpc registry refresh err:", err)
-		return err
+		return nil, nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Println("rpc registry refresh err:", resp.Status)
+		return nil, nil, errors.New("rpc registry: refresh from " + registry + ": " + resp.Status)
 	}
-	servers := strings.Split(resp.Header.Get("X-GPMD-SERVERS"), ",")
-	d.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
+	list := strings.Split(resp.Header.Get("X-GPMD-SERVERS"), ",")
+	servers := make([]string, 0, len(list))
+	for _, server := range list {
 		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
+			servers = append(servers, strings.TrimSpace(server))
 		}
 	}
-	d.lastUpdate = time.Now()
-	return nil
+	//每个 X-GPMD-META 的格式为 "地址 元数据"，元数据为 URL 查询字符串
+	metas := make(map[string]map[string]string)
+	for _, v := range resp.Header.Values("X-GPMD-META") {
+		parts := strings.SplitN(v, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values, err := url.ParseQuery(parts[1])
+		if err != nil {
+			continue
+		}
+		meta := make(map[string]string, len(values))
+		for k := range values {
+			meta[k] = values.Get(k)
+		}
+		metas[parts[0]] = meta
+	}
+	return servers, metas, nil
 }
 
 func (d *GpmdRegistryDiscovery) Get(mode SelectMode) (string, error) {
-	if err := d.Refresh(); err != nil {
+	if err := d.revalidate(); err != nil {
 		return "", err
 	}
 	return d.MultiServerDiscovery.Get(mode)
 }
 
+func (d *GpmdRegistryDiscovery) GetMeta() (map[string]map[string]string, error) {
+	if err := d.revalidate(); err != nil {
+		return nil, err
+	}
+	return d.MultiServerDiscovery.GetMeta()
+}
+
 func (d *GpmdRegistryDiscovery) GetAll() ([]string, error) {
-	if err := d.Refresh(); err != nil {
+	if err := d.revalidate(); err != nil {
 		return nil, err
 	}
 	return d.MultiServerDiscovery.GetAll()
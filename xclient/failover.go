@@ -0,0 +1,104 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	. "gpmd"
+	"time"
+)
+
+//FailoverPolicy 与选中的实例建立连接失败、缓存的连接已经关闭（ErrShutdown）并且重新连接失败时，
+//换一个实例重试。这两种情况请求还没有发出，换实例总是安全的，WithNoRetry 的调用也会换实例。
+//请求发出之后连接断开时请求可能已经被处理，只有设置了 WithIdempotent 并且没有设置 WithNoRetry 的调用才会换实例重新发送。
+//注册中心可能还没有让崩溃的实例过期，没有这个策略时调用直接返回连接错误。
+//服务端因为过载或者限流拒绝请求并给出重试间隔（见 gpmd.RetryAfter）时请求没有被处理，同样会重试，
+//等待的时间不少于服务端给出的间隔，也不受 MaxBackoff 的限制；被拒绝的实例仍然可以再次被选中，
+//ctx 的截止时间早于等待结束时直接返回服务端的错误，不再增加服务端的压力
+type FailoverPolicy struct {
+	MaxAttempts int           //最多尝试的实例数，包括第一次，0 表示默认的 3，1 表示不换实例
+	Backoff     time.Duration //换实例之前等待的时间，之后每次翻倍，0 表示默认的 10ms
+	MaxBackoff  time.Duration //最长的等待时间，0 表示默认的 200ms
+}
+
+//dialError 与实例建立连接失败，错误信息与原来的错误相同
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
+
+//brokenError 幂等的请求发出之后连接断开，错误信息与原来的错误相同
+type brokenError struct {
+	err error
+}
+
+func (e *brokenError) Error() string { return e.err.Error() }
+func (e *brokenError) Unwrap() error { return e.err }
+
+//canFailover 是否可以换一个实例重试
+func canFailover(err error) bool {
+	var de *dialError
+	var be *brokenError
+	if errors.As(err, &de) || errors.As(err, &be) {
+		return true
+	}
+	_, ok := RetryAfter(err)
+	return ok
+}
+
+func (p FailoverPolicy) normalize() FailoverPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = 10 * time.Millisecond
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 200 * time.Millisecond
+	}
+	return p
+}
+
+//wait 第 attempt 次失败后等待，至少等待 retryAfter，ctx 结束或者等不到重试时返回错误
+func (p FailoverPolicy) wait(ctx context.Context, attempt int, retryAfter time.Duration) error {
+	backoff := p.Backoff
+	for i := 1; i < attempt && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff < retryAfter {
+		backoff = retryAfter
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+		return context.DeadlineExceeded
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+//SetFailover 设置建立连接失败时换实例重试的策略，见 FailoverPolicy
+func (xc *XClient) SetFailover(p FailoverPolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.failover = p
+}
+
+//without 去掉已经尝试过的实例
+func without(servers []Instance, tried map[string]bool) []Instance {
+	left := make([]Instance, 0, len(servers))
+	for _, s := range servers {
+		if !tried[s.Addr] {
+			left = append(left, s)
+		}
+	}
+	return left
+}
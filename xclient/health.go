@@ -0,0 +1,141 @@
+package xclient
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//startHealthCheckLocked 按照 xc.policy 重新启动健康检查，需要持有 xc.mu
+func (xc *XClient) startHealthCheckLocked() {
+	xc.stopHealthCheckLocked()
+	if xc.policy.HealthCheck <= 0 || xc.closed {
+		return
+	}
+	xc.healthStop = make(chan struct{})
+	go xc.runHealthCheck(xc.healthStop, xc.policy.HealthCheck)
+}
+
+//stopHealthCheckLocked 停止健康检查，正在进行的一轮检查会继续完成
+func (xc *XClient) stopHealthCheckLocked() {
+	if xc.healthStop != nil {
+		close(xc.healthStop)
+		xc.healthStop = nil
+	}
+}
+
+func (xc *XClient) runHealthCheck(stop chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		xc.checkHealth()
+	}
+}
+
+//checkHealth 对每个缓存的连接 Ping 一次，失败的连接被关闭，实例被标记为不健康，不再被选中；
+//不健康的实例重新建立连接后 Ping 成功才恢复，新的连接留在缓存中代替原来的连接
+func (xc *XClient) checkHealth() {
+	xc.mu.Lock()
+	timeout := xc.policy.PingTimeout
+	conns := make([]*cachedClient, 0, len(xc.clients))
+	for _, cc := range xc.clients {
+		//检查期间登记一次使用，避免连接被淘汰
+		cc.inflight++
+		conns = append(conns, cc)
+	}
+	var sick []string
+	for addr := range xc.unhealthy {
+		if _, ok := xc.clients[addr]; !ok {
+			sick = append(sick, addr)
+		}
+	}
+	xc.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, cc := range conns {
+		wg.Add(1)
+		go func(cc *cachedClient) {
+			defer wg.Done()
+			xc.setHealthy(cc.addr, xc.ping(cc, timeout))
+		}(cc)
+	}
+	for _, addr := range sick {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			cc, err := xc.acquire(ctx, addr)
+			cancel()
+			if err != nil {
+				return
+			}
+			xc.setHealthy(addr, xc.ping(cc, timeout))
+		}(addr)
+	}
+	wg.Wait()
+	xc.pruneUnhealthy()
+}
+
+//ping 检查 cc 并结束登记的使用，失败时关闭连接
+func (xc *XClient) ping(cc *cachedClient, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	err := cc.client.Ping(ctx)
+	cancel()
+	xc.release(cc)
+	if err != nil {
+		xc.evict(cc)
+		return false
+	}
+	return true
+}
+
+func (xc *XClient) setHealthy(addr string, healthy bool) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if healthy {
+		delete(xc.unhealthy, addr)
+		return
+	}
+	if xc.unhealthy == nil {
+		xc.unhealthy = make(map[string]bool)
+	}
+	xc.unhealthy[addr] = true
+}
+
+//pruneUnhealthy 忘记已经不在服务列表中的实例，不再尝试重新连接
+func (xc *XClient) pruneUnhealthy() {
+	xc.mu.Lock()
+	n := len(xc.unhealthy)
+	xc.mu.Unlock()
+	if n == 0 {
+		return
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return
+	}
+	alive := make(map[string]bool, len(servers))
+	for _, addr := range servers {
+		alive[addr] = true
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	for addr := range xc.unhealthy {
+		if !alive[addr] {
+			delete(xc.unhealthy, addr)
+		}
+	}
+}
+
+//withoutUnhealthy 去掉不健康的实例，全部不健康时原样返回，避免完全没有可用的实例
+func withoutUnhealthy(servers []Instance, unhealthy map[string]bool) []Instance {
+	if left := without(servers, unhealthy); len(left) > 0 {
+		return left
+	}
+	return servers
+}
@@ -0,0 +1,84 @@
+package xclient
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+//MapOption 控制 Map 的并发和超时
+type MapOption struct {
+	Concurrency int           //同时进行的调用数，0 表示不限制
+	Timeout     time.Duration //每个实例的调用超时，0 表示只受 ctx 限制
+}
+
+//Reducer 汇总一个实例的结果，reply 是指向 replyType 的指针，调用失败时为空。
+//Reducer 按照调用完成的顺序依次执行，不会被并发调用，返回错误时取消剩余的调用，该错误作为 Map 的返回值
+type Reducer func(addr string, reply interface{}, err error) error
+
+//Map 向每个实例发送各自的参数（argsPerServer 的键为实例地址），并把结果交给 reduce 汇总，
+//用于在注册为同一个服务的多个分片上做扇出查询。replyType 是返回值类型的示例，比如 0 或者 Result{}
+func (xc *XClient) Map(ctx context.Context, serviceMethod string, argsPerServer map[string]interface{}, replyType interface{}, reduce Reducer, opt MapOption) (err error) {
+	addrs := make([]string, 0, len(argsPerServer))
+	for addr := range argsPerServer {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	ctx, span := startFanout(ctx, "Map", serviceMethod, len(addrs))
+	defer func() { span.Finish(err) }()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var sem chan struct{}
+	if opt.Concurrency > 0 {
+		sem = make(chan struct{}, opt.Concurrency)
+	}
+	typ := reflect.TypeOf(replyType)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var reduceErr error
+	for _, addr := range addrs {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+			}
+		}
+		//reduce 返回错误或者 ctx 结束后不再发起新的调用
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			callCtx := ctx
+			if opt.Timeout > 0 {
+				var callCancel context.CancelFunc
+				callCtx, callCancel = context.WithTimeout(ctx, opt.Timeout)
+				defer callCancel()
+			}
+			reply := reflect.New(typ).Interface()
+			err := xc.call(addr, callCtx, serviceMethod, argsPerServer[addr], reply)
+			if err != nil {
+				reply = nil
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if reduceErr != nil {
+				return
+			}
+			if reduceErr = reduce(addr, reply, err); reduceErr != nil {
+				cancel()
+			}
+		}(addr)
+	}
+	wg.Wait()
+	if reduceErr != nil {
+		return reduceErr
+	}
+	return ctx.Err()
+}
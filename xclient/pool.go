@@ -0,0 +1,209 @@
+package xclient
+
+import (
+	"context"
+	. "gpmd"
+	"time"
+)
+
+//ConnPolicy 控制 XClient 缓存的连接，零值表示不限制，与之前的行为一致
+type ConnPolicy struct {
+	IdleTimeout time.Duration //连接空闲超过这个时间后被关闭
+	MaxClients  int           //最多缓存的连接数，超过时关闭最久没有使用的空闲连接
+	PingIdle    time.Duration //复用空闲超过这个时间的连接之前先 Ping，失败时重新建立连接
+	PingTimeout time.Duration //Ping 的超时时间，默认为 1s
+	HealthCheck time.Duration //定期对缓存的连接调用健康检查服务的间隔，失败的实例在重新连接并检查成功之前不会被选中，0 表示不检查
+}
+
+//cachedClient 缓存的连接以及它的使用情况
+type cachedClient struct {
+	addr     string
+	client   *Client
+	lastUsed time.Time
+	inflight int //正在进行的调用数，大于 0 时不会被淘汰
+}
+
+//SetConnPolicy 设置连接缓存的淘汰和健康检查策略，避免长期运行的进程中积累失效的连接
+func (xc *XClient) SetConnPolicy(p ConnPolicy) {
+	if p.PingTimeout <= 0 {
+		p.PingTimeout = time.Second
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.policy = p
+	xc.startHealthCheckLocked()
+}
+
+//pendingDial 一次正在进行的连接，完成后 done 被关闭，成功时连接已经放入 xc.clients
+type pendingDial struct {
+	done      chan struct{}
+	err       error
+	abandoned bool //发起连接的调用方 ctx 已经结束，失败不代表实例不可用，等待的调用方自己重新连接
+}
+
+//acquire 返回 rpcAddr 的连接并登记一次使用，调用结束后需要 release。需要建立连接时 ctx 限制建立连接和握手的时间，
+//连接期间不持有 xc.mu，一个无响应的实例不会阻塞其他实例的调用，同一个地址的并发调用共用一次连接
+func (xc *XClient) acquire(ctx context.Context, rpcAddr string) (*cachedClient, error) {
+	xc.mu.Lock()
+	if xc.closed {
+		xc.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	now := time.Now()
+	xc.evictIdleLocked(now)
+	cc, ok := xc.clients[rpcAddr]
+	if ok && !cc.client.IsAvailable() {
+		xc.removeLocked(cc)
+		cc = nil
+	}
+	if cc != nil {
+		needPing := xc.policy.PingIdle > 0 && cc.inflight == 0 && now.Sub(cc.lastUsed) > xc.policy.PingIdle
+		cc.inflight++
+		cc.lastUsed = now
+		timeout := xc.policy.PingTimeout
+		xc.mu.Unlock()
+		if !needPing {
+			return cc, nil
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := cc.client.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			return cc, nil
+		}
+		xc.release(cc)
+		xc.evict(cc)
+		xc.mu.Lock()
+	}
+	for {
+		if xc.closed {
+			xc.mu.Unlock()
+			return nil, ErrShutdown
+		}
+		//等待 Ping 或者等待其他调用连接的期间，连接可能已经建立好了
+		if cc, ok = xc.clients[rpcAddr]; ok && cc.client.IsAvailable() {
+			cc.inflight++
+			cc.lastUsed = time.Now()
+			xc.mu.Unlock()
+			return cc, nil
+		}
+		d, dialing := xc.dials[rpcAddr]
+		if !dialing {
+			break
+		}
+		xc.mu.Unlock()
+		select {
+		case <-d.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if d.err != nil && !d.abandoned {
+			return nil, d.err
+		}
+		xc.mu.Lock()
+	}
+	d := &pendingDial{done: make(chan struct{})}
+	if xc.dials == nil {
+		xc.dials = make(map[string]*pendingDial)
+	}
+	xc.dials[rpcAddr] = d
+	xc.mu.Unlock()
+
+	client, err := XDialContext(ctx, rpcAddr, xc.opt)
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	delete(xc.dials, rpcAddr)
+	d.err, d.abandoned = err, ctx.Err() != nil
+	close(d.done) //等待的调用方在 xc.mu 释放之后才能看到 xc.clients
+	if err != nil {
+		return nil, err
+	}
+	if xc.closed {
+		//连接期间 XClient 已经关闭，不再缓存这个连接
+		_ = client.Close()
+		return nil, ErrShutdown
+	}
+	if cc, ok = xc.clients[rpcAddr]; ok {
+		if cc.client.IsAvailable() {
+			//连接期间已经有了可用的连接，关闭这次建立的连接
+			_ = client.Close()
+			cc.inflight++
+			cc.lastUsed = time.Now()
+			return cc, nil
+		}
+		xc.removeLocked(cc)
+	}
+	xc.evictLRULocked()
+	cc = &cachedClient{addr: rpcAddr, client: client, lastUsed: time.Now(), inflight: 1}
+	xc.clients[rpcAddr] = cc
+	if xc.lost[rpcAddr] {
+		delete(xc.lost, rpcAddr)
+		if xc.opt != nil && xc.opt.Events.OnReconnect != nil {
+			//在释放 xc.mu 之后调用，回调中可以继续使用 XClient
+			go xc.opt.Events.OnReconnect(client.RemoteAddr())
+		}
+	}
+	return cc, nil
+}
+
+func (xc *XClient) release(cc *cachedClient) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	cc.inflight--
+	cc.lastUsed = time.Now()
+}
+
+//evict 调用失败或者检查失败时关闭并移除 cc，cc 已经被替换时什么也不做
+func (xc *XClient) evict(cc *cachedClient) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.clients[cc.addr] == cc {
+		xc.markLostLocked(cc.addr)
+	}
+	xc.removeLocked(cc)
+}
+
+//removeLocked 关闭并移除 cc，已经断开的连接记录下来，之后重新连接时调用 OnReconnect
+func (xc *XClient) removeLocked(cc *cachedClient) {
+	if xc.clients[cc.addr] == cc {
+		if !cc.client.IsAvailable() {
+			xc.markLostLocked(cc.addr)
+		}
+		delete(xc.clients, cc.addr)
+	}
+	_ = cc.client.Close()
+}
+
+func (xc *XClient) markLostLocked(addr string) {
+	if xc.lost == nil {
+		xc.lost = make(map[string]bool)
+	}
+	xc.lost[addr] = true
+}
+
+func (xc *XClient) evictIdleLocked(now time.Time) {
+	if xc.policy.IdleTimeout <= 0 {
+		return
+	}
+	for _, cc := range xc.clients {
+		if cc.inflight == 0 && now.Sub(cc.lastUsed) > xc.policy.IdleTimeout {
+			xc.removeLocked(cc)
+		}
+	}
+}
+
+//evictLRULocked 为新连接腾出位置，正在使用的连接不会被关闭，因此连接数可能暂时超过 MaxClients
+func (xc *XClient) evictLRULocked() {
+	for xc.policy.MaxClients > 0 && len(xc.clients) >= xc.policy.MaxClients {
+		var oldest *cachedClient
+		for _, cc := range xc.clients {
+			if cc.inflight == 0 && (oldest == nil || cc.lastUsed.Before(oldest.lastUsed)) {
+				oldest = cc
+			}
+		}
+		if oldest == nil {
+			return
+		}
+		xc.removeLocked(oldest)
+	}
+}
@@ -0,0 +1,74 @@
+package xclient
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+//ZoneMetaKey 服务实例元数据中表示所在可用区的键，RouteHint.Zone 优先选择同一可用区的实例
+const ZoneMetaKey = "zone"
+
+//RouteHint 单次调用的路由提示，零值表示不限制
+type RouteHint struct {
+	Key  string            //路由键，相同的键在实例不变时总是选择同一个实例，例如用户 ID，需要就近缓存时使用
+	Zone string            //优先选择元数据中 zone 相同的实例，没有时使用其他可用区的实例
+	Tags map[string]string //实例的元数据必须包含这些键值，例如 version=v2，没有满足的实例时调用返回错误
+}
+
+func (h RouteHint) isZero() bool {
+	return h.Key == "" && h.Zone == "" && len(h.Tags) == 0
+}
+
+//filter 按照 Tags 过滤实例，再优先保留 Zone 相同的实例
+func (h RouteHint) filter(servers []Instance) []Instance {
+	matched := make([]Instance, 0, len(servers))
+	for _, s := range servers {
+		if hasTags(s.Meta, h.Tags) {
+			matched = append(matched, s)
+		}
+	}
+	if h.Zone == "" {
+		return matched
+	}
+	local := make([]Instance, 0, len(matched))
+	for _, s := range matched {
+		if s.Meta[ZoneMetaKey] == h.Zone {
+			local = append(local, s)
+		}
+	}
+	if len(local) == 0 {
+		return matched
+	}
+	return local
+}
+
+func hasTags(meta, tags map[string]string) bool {
+	for k, v := range tags {
+		if meta[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+//hashPick 使用 rendezvous 哈希按照 key 选择实例，实例增减时只有原本落在该实例上的键会改变，
+//权重为 0 的实例不会被选中
+func hashPick(servers []Instance, key string) Instance {
+	var best Instance
+	var bestScore uint64
+	for _, s := range servers {
+		if Weight(s.Meta) == 0 {
+			continue
+		}
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(s.Addr))
+		if score := h.Sum64(); best.Addr == "" || score > bestScore {
+			best, bestScore = s, score
+		}
+	}
+	return best
+}
+
+var errNoMatchingServers = errors.New("rpc xclient: no servers match the route hint")
@@ -0,0 +1,152 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//Instance 一个可供选择的服务实例
+type Instance struct {
+	Addr string
+	Meta map[string]string //实例在注册中心的元数据，静态服务列表通过 UpdateMeta 设置，可能为空
+}
+
+//CallInfo 本次调用的信息，Selector 可以据此选择实例，例如按照元数据中的租户固定到某些实例。
+//通过 Discovery.Get 选择时没有调用信息，各项为零值
+type CallInfo struct {
+	Ctx           context.Context
+	ServiceMethod string
+	Args          interface{}
+	Hint          RouteHint //CallWith 传入的路由提示，Call 时为零值
+}
+
+//Selector 负载均衡策略，从 servers 中选择一个实例，servers 不为空。
+//返回零值表示没有合适的实例，调用返回错误。Pick 可能被并发调用
+type Selector interface {
+	Pick(servers []Instance, info CallInfo) Instance
+}
+
+//SelectorFunc 以函数实现 Selector
+type SelectorFunc func(servers []Instance, info CallInfo) Instance
+
+func (f SelectorFunc) Pick(servers []Instance, info CallInfo) Instance {
+	return f(servers, info)
+}
+
+//NewSelector 返回 mode 对应的内置策略，内置策略都会按照 Weight 分配请求，不会选择 draining 的实例
+func NewSelector(mode SelectMode) (Selector, error) {
+	switch mode {
+	case RandomSelect:
+		return NewRandomSelector(), nil
+	case RoundRobinSelect:
+		return NewRoundRobinSelector(), nil
+	default:
+		return nil, fmt.Errorf("rpc discovery: not supported select mode %d", mode)
+	}
+}
+
+//instances 组合地址和元数据
+func instances(servers []string, meta map[string]map[string]string) []Instance {
+	list := make([]Instance, len(servers))
+	for i, addr := range servers {
+		list[i] = Instance{Addr: addr, Meta: meta[addr]}
+	}
+	return list
+}
+
+//weights 返回每个实例的权重和权重之和，weighted 表示有实例的权重不是 1
+func weights(servers []Instance) (ws []int, total int, weighted bool) {
+	ws = make([]int, len(servers))
+	for i, s := range servers {
+		ws[i] = Weight(s.Meta)
+		total += ws[i]
+		weighted = weighted || ws[i] != 1
+	}
+	return ws, total, weighted
+}
+
+type randomSelector struct {
+	mu sync.Mutex
+	r  *rand.Rand //r 是一个产生随机数的实例，初始化时使用时间戳设定随机数种子，避免每次产生相同的随机数序列
+}
+
+//NewRandomSelector 按照权重随机选择
+func NewRandomSelector() Selector {
+	return &randomSelector{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *randomSelector) Pick(servers []Instance, _ CallInfo) Instance {
+	ws, total, _ := weights(servers)
+	if total == 0 {
+		return Instance{}
+	}
+	s.mu.Lock()
+	n := s.r.Intn(total)
+	s.mu.Unlock()
+	for i, w := range ws {
+		if n < w {
+			return servers[i]
+		}
+		n -= w
+	}
+	return Instance{}
+}
+
+type roundRobinSelector struct {
+	mu      sync.Mutex
+	index   int            //index 记录 Round Robin 算法已经轮询到的位置，为了避免每次从 0 开始，初始化时随机设定一个值
+	current map[string]int //平滑加权轮询中每个实例的当前权重
+}
+
+//NewRoundRobinSelector 轮询选择，有实例设置了权重或者 draining 时使用平滑加权轮询，权重大的实例不会连续被选中
+func NewRoundRobinSelector() Selector {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &roundRobinSelector{index: r.Intn(math.MaxInt32 - 1), current: make(map[string]int)}
+}
+
+func (s *roundRobinSelector) Pick(servers []Instance, _ CallInfo) Instance {
+	ws, total, weighted := weights(servers)
+	if total == 0 {
+		return Instance{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !weighted {
+		n := len(servers)
+		picked := servers[s.index%n]
+		s.index = (s.index + 1) % n
+		return picked
+	}
+	best := -1
+	for i, inst := range servers {
+		if ws[i] == 0 {
+			continue
+		}
+		s.current[inst.Addr] += ws[i]
+		if best < 0 || s.current[inst.Addr] > s.current[servers[best].Addr] {
+			best = i
+		}
+	}
+	s.current[servers[best].Addr] -= total
+	if len(s.current) > len(servers) {
+		s.prune(servers)
+	}
+	return servers[best]
+}
+
+//prune 删除已经不在服务列表中的实例
+func (s *roundRobinSelector) prune(servers []Instance) {
+	alive := make(map[string]bool, len(servers))
+	for _, inst := range servers {
+		alive[inst.Addr] = true
+	}
+	for addr := range s.current {
+		if !alive[addr] {
+			delete(s.current, addr)
+		}
+	}
+}
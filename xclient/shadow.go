@@ -0,0 +1,142 @@
+package xclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	. "gpmd"
+	"log"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+//ShadowPolicy 影子流量：按照 Percent 复制一部分调用，在原调用返回之后异步发送到影子实例（例如正在测试的新版本），
+//影子调用的结果被丢弃，不影响原调用的返回值和耗时。Compare 为 true 时比较两边的返回值，不一致时记录日志。
+//影子调用使用独立的 ctx，不会随着原调用的 ctx 取消
+type ShadowPolicy struct {
+	Discovery   Discovery     //影子实例，为空表示关闭影子流量
+	Mode        SelectMode    //选择影子实例的方式
+	Percent     float64       //复制的比例，0 到 100
+	Timeout     time.Duration //影子调用的超时，0 表示默认的 1s
+	MaxInFlight int           //同时进行的影子调用数上限，超过时丢弃新的影子调用，0 表示默认的 100
+	Compare     bool          //比较影子调用和原调用的返回值，不一致时记录日志
+}
+
+//shadow 把调用复制到影子实例
+type shadow struct {
+	policy ShadowPolicy
+	xc     *XClient
+	slots  chan struct{} //容量为 MaxInFlight 的信号量
+}
+
+//SetShadow 开启影子流量，见 ShadowPolicy，p.Discovery 为空时关闭。影子实例的连接使用与 XClient 相同的 Option
+func (xc *XClient) SetShadow(p ShadowPolicy) {
+	if p.Timeout <= 0 {
+		p.Timeout = time.Second
+	}
+	if p.MaxInFlight <= 0 {
+		p.MaxInFlight = 100
+	}
+	var sh *shadow
+	if p.Discovery != nil {
+		sh = &shadow{policy: p, xc: NewXClient(p.Discovery, p.Mode, xc.opt), slots: make(chan struct{}, p.MaxInFlight)}
+	}
+	xc.mu.Lock()
+	old := xc.shadow
+	xc.shadow = sh
+	xc.mu.Unlock()
+	if old != nil {
+		_ = old.xc.Close()
+	}
+}
+
+//mirror 按照比例复制一次调用，callErr 和 reply 是原调用的结果。
+//args 和 reply 在调用返回之后可能被调用方修改，需要在返回之前复制
+func (s *shadow) mirror(serviceMethod string, args, reply interface{}, callErr error, opts []CallOption) {
+	if rand.Float64()*100 >= s.policy.Percent {
+		return
+	}
+	m := GetMetrics()
+	select {
+	case s.slots <- struct{}{}:
+	default:
+		m.Inc("gpmd_xclient_shadow_calls_total", "method", serviceMethod, "result", "dropped")
+		return
+	}
+	shadowArgs, err := cloneArgs(args)
+	if err != nil {
+		<-s.slots
+		m.Inc("gpmd_xclient_shadow_calls_total", "method", serviceMethod, "result", "dropped")
+		return
+	}
+	var want []byte
+	if s.policy.Compare && callErr == nil {
+		want, _ = json.Marshal(reply)
+	}
+	//reply 为空时影子调用同样丢弃回复，只比较错误
+	var replyType reflect.Type
+	if t := reflect.TypeOf(reply); t != nil && t.Kind() == reflect.Ptr {
+		replyType = t.Elem()
+	}
+	go func() {
+		defer func() { <-s.slots }()
+		ctx, cancel := context.WithTimeout(context.Background(), s.policy.Timeout)
+		defer cancel()
+		var shadowReply interface{}
+		if replyType != nil {
+			shadowReply = reflect.New(replyType).Interface()
+		}
+		err := s.xc.Call(ctx, serviceMethod, shadowArgs, shadowReply, opts...)
+		m.Inc("gpmd_xclient_shadow_calls_total", "method", serviceMethod, "result", s.result(serviceMethod, callErr, err, want, shadowReply))
+	}()
+}
+
+//result 影子调用的结果：没有开启 Compare 时为 ok 或者 error，开启时为 match 或者 diverged
+func (s *shadow) result(serviceMethod string, callErr, err error, want []byte, shadowReply interface{}) string {
+	if !s.policy.Compare {
+		if err != nil {
+			return "error"
+		}
+		return "ok"
+	}
+	switch {
+	case callErr != nil && err != nil:
+		return "match"
+	case callErr != nil || err != nil:
+		log.Printf("rpc xclient: shadow %s diverged: error %v, shadow error %v", serviceMethod, callErr, err)
+		return "diverged"
+	case shadowReply == nil:
+		return "match"
+	}
+	got, _ := json.Marshal(shadowReply)
+	if bytes.Equal(want, got) {
+		return "match"
+	}
+	log.Printf("rpc xclient: shadow %s diverged: reply %s, shadow reply %s", serviceMethod, want, got)
+	return "diverged"
+}
+
+//cloneArgs 复制 args 中可能被调用方修改的部分，值类型的参数在装箱时已经复制
+func cloneArgs(args interface{}) (interface{}, error) {
+	v := reflect.ValueOf(args)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+	case reflect.Struct:
+		if v.NumField() == 0 {
+			return args, nil //gob 不能编码没有字段的结构体，例如 struct{}{}
+		}
+	default:
+		return args, nil
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).EncodeValue(v); err != nil {
+		return nil, err
+	}
+	clone := reflect.New(v.Type())
+	if err := gob.NewDecoder(&buf).DecodeValue(clone); err != nil {
+		return nil, err
+	}
+	return clone.Elem().Interface(), nil
+}
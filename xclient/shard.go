@@ -0,0 +1,84 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+//ShardMetaKey 服务实例元数据中表示分片编号的键，例如 registry.HeartbeatWithMeta(reg, addr, 0, map[string]string{"shard": "3"})
+const ShardMetaKey = "shard"
+
+//ShardFunc 将路由键映射到 [0, shards) 范围内的分片编号
+type ShardFunc func(key string, shards int) int
+
+//HashShard 使用 FNV 哈希取模，适合均匀分布的键，例如用户 ID
+func HashShard(key string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shards))
+}
+
+//RangeShard 按照有序的上界划分分片：key < bounds[0] 属于分片 0，bounds[i-1] <= key < bounds[i] 属于分片 i，
+//其余的键属于最后一个分片，因此分片数为 len(bounds)+1
+func RangeShard(bounds []string) ShardFunc {
+	return func(key string, shards int) int {
+		i := sort.SearchStrings(bounds, key)
+		if i < len(bounds) && bounds[i] == key {
+			i++
+		}
+		if i >= shards {
+			i = shards - 1
+		}
+		return i
+	}
+}
+
+//SetSharding 开启分片路由，shards 为分片总数，之后通过 CallShard 按照路由键调用
+func (xc *XClient) SetSharding(fn ShardFunc, shards int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.shardFunc, xc.shards = fn, shards
+}
+
+//CallShard 根据 key 计算分片编号，调用元数据中 shard 等于该编号的实例，与选择器一样跳过权重为 0 和 draining 的副本
+func (xc *XClient) CallShard(ctx context.Context, key, serviceMethod string, args, reply interface{}) error {
+	xc.mu.Lock()
+	fn, shards := xc.shardFunc, xc.shards
+	xc.mu.Unlock()
+	if fn == nil || shards <= 0 {
+		return errors.New("rpc xclient: sharding is not configured")
+	}
+	md, ok := xc.d.(MetaDiscovery)
+	if !ok {
+		return errors.New("rpc xclient: discovery does not provide server metadata")
+	}
+	meta, err := md.GetMeta()
+	if err != nil {
+		return err
+	}
+	shard := strconv.Itoa(fn(key, shards))
+	var candidates []string
+	replicas := 0
+	for addr, m := range meta {
+		if m[ShardMetaKey] != shard {
+			continue
+		}
+		replicas++
+		if Weight(m) > 0 {
+			candidates = append(candidates, addr)
+		}
+	}
+	if replicas == 0 {
+		return fmt.Errorf("rpc xclient: no server for shard %s", shard)
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("rpc xclient: all %d servers for shard %s are draining or have weight 0", replicas, shard)
+	}
+	//同一个分片的多个副本之间随机选择
+	return xc.call(candidates[rand.Intn(len(candidates))], ctx, serviceMethod, args, reply)
+}
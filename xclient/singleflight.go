@@ -0,0 +1,68 @@
+package xclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"sync"
+)
+
+//flightCall 一次正在进行中的调用，完成前把回复编码为字节，其他相同的调用各自解码，
+//不与调用方共享 reply，调用方在 do 返回之后可以随意修改它
+type flightCall struct {
+	done  chan struct{}
+	reply []byte //gob 编码的回复，reply 为空时为空
+	err   error
+}
+
+//flightGroup 合并并发的相同调用，避免缓存过期后大量相同请求同时打到后端
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+//do 同一时刻相同 key 的调用只有第一个会真正执行 fn，其余调用等待并复制它的结果。
+//等待的调用方 ctx 被取消时立即返回，不影响正在执行的调用
+func (g *flightGroup) do(ctx context.Context, key string, reply interface{}, fn func(reply interface{}) error) error {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			if c.err != nil || reply == nil || c.reply == nil {
+				return c.err
+			}
+			return gob.NewDecoder(bytes.NewReader(c.reply)).Decode(reply)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	err := fn(reply)
+	c.err = err
+	if err == nil && reply != nil {
+		var buf bytes.Buffer
+		if c.err = gob.NewEncoder(&buf).Encode(reply); c.err == nil {
+			c.reply = buf.Bytes()
+		}
+	}
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+	return err
+}
+
+//SetSingleFlight 开启后，同一进程内并发的相同调用（方法名、参数、路由提示和元数据都相同）共用一次远程调用，
+//结果复制给每个调用方。适合与 EnableCache 配合，避免缓存过期瞬间的惊群
+func (xc *XClient) SetSingleFlight(enabled bool) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.singleFlight = enabled
+}
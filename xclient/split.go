@@ -0,0 +1,91 @@
+package xclient
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//SplitGroup 元数据 Key 的值为 Value 的一组实例，以及分给这一组的流量比例
+type SplitGroup struct {
+	Value   string  //元数据的值，例如 "v2"
+	Percent float64 //分到的流量比例，所有组之和通常为 100
+}
+
+//splitSelector 按照比例把流量分给不同版本的实例，见 NewSplitSelector
+type splitSelector struct {
+	key    string
+	groups []SplitGroup
+	next   Selector
+	mu     sync.Mutex
+	r      *rand.Rand
+}
+
+//NewSplitSelector 按照实例元数据中 key 的值把实例分组，按照 groups 中的比例分配流量，用于 A/B 测试和金丝雀发布，
+//例如 NewSplitSelector("version", []SplitGroup{{"v1", 90}, {"v2", 10}}, nil)。
+//RouteHint.Key 不为空时按照它的哈希分组，同一个键总是落在同一组，组内固定到同一个实例；否则按照比例随机分组。
+//没有实例的组的流量按照比例分给其他组，不属于任何组的实例不会被选中，所有的组都没有实例时从全部实例中选择。
+//没有路由键时组内使用 next 选择实例，next 为空时按照权重随机选择
+func NewSplitSelector(key string, groups []SplitGroup, next Selector) Selector {
+	if next == nil {
+		next = NewRandomSelector()
+	}
+	return &splitSelector{key: key, groups: groups, next: next, r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *splitSelector) Pick(servers []Instance, info CallInfo) Instance {
+	members := make([][]Instance, len(s.groups))
+	var total float64
+	for i, g := range s.groups {
+		for _, inst := range servers {
+			if inst.Meta[s.key] == g.Value {
+				members[i] = append(members[i], inst)
+			}
+		}
+		if len(members[i]) > 0 && g.Percent > 0 {
+			total += g.Percent
+		}
+	}
+	if total == 0 {
+		return s.pickIn(servers, info)
+	}
+	point := s.point(info.Hint.Key) * total
+	for i, g := range s.groups {
+		if len(members[i]) == 0 || g.Percent <= 0 {
+			continue
+		}
+		if point < g.Percent {
+			return s.pickIn(members[i], info)
+		}
+		point -= g.Percent
+	}
+	//浮点误差落在最后，交给最后一个有实例的组
+	for i := len(s.groups) - 1; i >= 0; i-- {
+		if len(members[i]) > 0 && s.groups[i].Percent > 0 {
+			return s.pickIn(members[i], info)
+		}
+	}
+	return Instance{}
+}
+
+//point 返回 [0, 1) 之间的位置，key 不为空时由 key 决定
+func (s *splitSelector) point(key string) float64 {
+	if key == "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.r.Float64()
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("split\x00"))
+	_, _ = h.Write([]byte(key))
+	return float64(h.Sum64()%10000) / 10000
+}
+
+//pickIn 在一组实例中选择，有路由键时固定到同一个实例
+func (s *splitSelector) pickIn(servers []Instance, info CallInfo) Instance {
+	if info.Hint.Key != "" {
+		return hashPick(servers, info.Hint.Key)
+	}
+	return s.next.Pick(servers, info)
+}
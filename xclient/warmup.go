@@ -0,0 +1,88 @@
+package xclient
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+//warmupScale 预热期间权重按照这个倍数放大后再按比例缩小，避免整数权重的精度不够
+const warmupScale = 100
+
+//warmup 记录每个实例第一次出现在服务列表中的时间，预热期间按比例降低它的权重。
+//第一次获取到的服务列表被认为已经预热完成，客户端启动时不会降低所有实例的权重
+type warmup struct {
+	window  time.Duration
+	mu      sync.Mutex
+	started bool
+	seen    map[string]time.Time
+}
+
+func newWarmup(window time.Duration) *warmup {
+	return &warmup{window: window, seen: make(map[string]time.Time)}
+}
+
+//apply 返回按照预热进度调整权重之后的实例，没有实例在预热时原样返回。
+//从服务列表中消失的实例会被忘记，重新出现（例如重启）时再次预热
+func (w *warmup) apply(servers []Instance) []Instance {
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	alive := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		alive[s.Addr] = true
+		if _, ok := w.seen[s.Addr]; !ok {
+			start := now
+			if !w.started {
+				start = now.Add(-w.window)
+			}
+			w.seen[s.Addr] = start
+		}
+	}
+	w.started = true
+	for addr := range w.seen {
+		if !alive[addr] {
+			delete(w.seen, addr)
+		}
+	}
+	warming := false
+	for _, s := range servers {
+		if now.Sub(w.seen[s.Addr]) < w.window {
+			warming = true
+			break
+		}
+	}
+	if !warming {
+		return servers
+	}
+	scaled := make([]Instance, len(servers))
+	for i, s := range servers {
+		weight := Weight(s.Meta) * warmupScale
+		if elapsed := now.Sub(w.seen[s.Addr]); elapsed < w.window && weight > 0 {
+			weight = int(float64(weight) * float64(elapsed) / float64(w.window))
+			if weight < 1 {
+				weight = 1
+			}
+		}
+		meta := make(map[string]string, len(s.Meta)+1)
+		for k, v := range s.Meta {
+			meta[k] = v
+		}
+		meta[WeightMetaKey] = strconv.Itoa(weight)
+		scaled[i] = Instance{Addr: s.Addr, Meta: meta}
+	}
+	return scaled
+}
+
+//SetWarmup 开启预热：新出现在服务列表中的实例在 window 内权重从接近 0 线性增长到设置的权重，
+//避免刚启动的实例（缓存为空、依赖还没有预热）立即承担全部的流量。window 为 0 表示关闭。
+//预热按照权重分配请求，设置了 Selector 时 Selector 看到的是调整之后的权重
+func (xc *XClient) SetWarmup(window time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if window <= 0 {
+		xc.warmup = nil
+		return
+	}
+	xc.warmup = newWarmup(window)
+}
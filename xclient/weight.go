@@ -0,0 +1,32 @@
+package xclient
+
+import (
+	"errors"
+	"gpmd/registry"
+	"strconv"
+)
+
+//服务实例元数据中的权重和状态，由服务端通过 registry.HeartbeatHandle 的 SetWeight、Drain 修改
+const (
+	WeightMetaKey = registry.WeightMetaKey
+	StateMetaKey  = registry.StateMetaKey
+	StateDraining = registry.StateDraining
+)
+
+var errNoAvailableServers = errors.New("rpc discovery: no available servers")
+
+//Weight 实例元数据中的权重，没有设置或者不合法时为 1，draining 的实例为 0，不再分配新的请求
+func Weight(meta map[string]string) int {
+	if meta[StateMetaKey] == StateDraining {
+		return 0
+	}
+	v, ok := meta[WeightMetaKey]
+	if !ok {
+		return 1
+	}
+	w, err := strconv.Atoi(v)
+	if err != nil || w < 0 {
+		return 1
+	}
+	return w
+}
@@ -2,10 +2,13 @@ package xclient
 
 import (
 	"context"
+	"errors"
 	. "gpmd"
 	"io"
 	"reflect"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type XClient struct {
@@ -13,7 +16,29 @@ type XClient struct {
 	mode    SelectMode
 	opt     *Option
 	mu      sync.Mutex
-	clients map[string]*Client
+	clients map[string]*cachedClient
+	policy  ConnPolicy
+	closed  bool //Close 之后新的调用返回 ErrShutdown，之后才建立好的连接直接关闭
+
+	lost  map[string]bool         //连接断开或者检查失败后被移除、还没有重新连接的实例，重新连接时调用 OnReconnect
+	dials map[string]*pendingDial //正在建立的连接，同一个地址的并发调用等待同一次连接
+
+	healthStop chan struct{}   //不为空表示正在定期检查连接的健康状态
+	unhealthy  map[string]bool //健康检查失败的实例
+
+	selector     Selector       //不为空时代替 mode 选择实例
+	modeSelector Selector       //需要过滤实例或者调整权重后再按照 mode 选择时使用
+	blacklist    *blacklist     //为空表示没有开启自动移出
+	warmup       *warmup        //为空表示没有开启预热
+	failover     FailoverPolicy //建立连接失败时换实例重试，零值表示使用默认值
+	cache        *responseCache //cache 为空表示没有开启响应缓存
+	shadow       *shadow        //为空表示没有开启影子流量
+
+	singleFlight bool //是否合并并发的相同调用
+	flights      flightGroup
+
+	shardFunc ShardFunc //不为空时 CallShard 按照路由键选择分片
+	shards    int
 }
 
 var _ io.Closer = (*XClient)(nil)
@@ -21,51 +46,275 @@ var _ io.Closer = (*XClient)(nil)
 func (xc *XClient) Close() error {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	for key, client := range xc.clients {
-		_ = client.Close()
+	xc.closed = true
+	xc.stopHealthCheckLocked()
+	for key, cc := range xc.clients {
+		_ = cc.client.Close()
 		delete(xc.clients, key)
 	}
+	xc.lost = nil
+	if xc.shadow != nil {
+		_ = xc.shadow.xc.Close()
+		xc.shadow = nil
+	}
 	return nil
 }
 
 func NewXClient(d Discovery, mode SelectMode, opt *Option) *XClient {
-	return &XClient{d: d, mode: mode, opt: opt, clients: make(map[string]*Client)}
+	return &XClient{d: d, mode: mode, opt: opt, clients: make(map[string]*cachedClient)}
 }
 
-func (xc *XClient) dial(rpcAddr string) (*Client, error) {
+//NewXClientFromConfig 根据配置创建 XClient，配置了注册中心时从注册中心发现服务，否则使用静态服务列表
+func NewXClientFromConfig(cfg *Config) (*XClient, error) {
+	mode, err := ParseSelectMode(cfg.SelectMode)
+	if err != nil {
+		return nil, err
+	}
+	opt, err := cfg.Option()
+	if err != nil {
+		return nil, err
+	}
+	var d Discovery
+	switch {
+	case cfg.Registry != "":
+		rd := NewGpmdRegistryDiscovery(cfg.Registry, cfg.RegistryRefresh)
+		rd.SetNamespace(cfg.Namespace)
+		rd.SetMaxStaleness(cfg.RegistryMaxStale)
+		d = rd
+	case len(cfg.Servers) > 0:
+		d = NewMultiServerDiscovery(cfg.Servers)
+	default:
+		return nil, errors.New("rpc xclient: neither registry nor servers is configured")
+	}
+	return NewXClient(d, mode, opt), nil
+}
+
+//call 在 rpcAddr 上调用，并按照后端上报调用次数、结果和耗时，见 reportCall
+func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
 	xc.mu.Lock()
-	defer xc.mu.Unlock()
-	client, ok := xc.clients[rpcAddr]
-	if ok && !client.IsAvailable() {
-		_ = client.Close()
-		delete(xc.clients, rpcAddr)
-		client = nil
-	}
-	if client == nil {
-		var err error
-		client, err = XDial(rpcAddr, xc.opt)
+	bl := xc.blacklist
+	xc.mu.Unlock()
+	start := time.Now()
+	err := xc.callServer(rpcAddr, bl, ctx, serviceMethod, args, reply, opts...)
+	reportCall(rpcAddr, bl, time.Since(start), err)
+	return err
+}
+
+//callServer 缓存的连接已经关闭（ErrShutdown）时请求还没有发出，重新建立连接再试一次，
+//仍然失败时返回 dialError 交给 FailoverPolicy 换实例。请求发出之后连接断开时，
+//设置了 WithIdempotent 并且没有设置 WithNoRetry 的调用返回 brokenError，同样可以换实例
+func (xc *XClient) callServer(rpcAddr string, bl *blacklist, ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	o := ApplyCallOptions(opts...)
+	resend := o.Idempotent && !o.NoRetry
+	for attempt := 0; ; attempt++ {
+		//每次尝试一个客户端 span，可以看出每次尝试由哪个实例处理
+		attemptCtx, span := GetTracer().StartSpan(ctx, serviceMethod, SpanClient)
+		span.SetTag("server", rpcAddr)
+		span.SetTag("attempt", strconv.Itoa(attempt))
+		if attempt > 0 {
+			span.SetTag("retry", "true")
+		}
+		cc, err := xc.acquire(attemptCtx, rpcAddr)
+		if err == ErrShutdown {
+			//XClient 已经关闭，换实例也没有意义
+			span.Finish(err)
+			return err
+		}
 		if err != nil {
-			return nil, err
+			span.Finish(err)
+			if bl != nil {
+				bl.fail(rpcAddr)
+			}
+			return &dialError{err}
 		}
-		xc.clients[rpcAddr] = client
+		err = cc.client.Call(attemptCtx, serviceMethod, args, reply, opts...)
+		span.Finish(err)
+		xc.release(cc)
+		if err == ErrShutdown {
+			xc.evict(cc)
+			if attempt == 0 {
+				continue
+			}
+			err = &dialError{err}
+		} else if isConnError(err) && resend {
+			err = &brokenError{err}
+		}
+		if bl != nil {
+			if isConnError(err) {
+				bl.fail(rpcAddr)
+			} else {
+				bl.succeed(rpcAddr)
+			}
+		}
+		return err
 	}
-	return client, nil
 }
 
-func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcAddr)
+//reportCall 按照后端上报指标，server 标签为实例地址：
+//gpmd_xclient_selected_total 被选中的次数，gpmd_xclient_successes_total 和 gpmd_xclient_failures_total 调用结果，
+//gpmd_xclient_call_seconds 调用耗时，开启自动移出时 gpmd_xclient_server_blacklisted 为 1 表示实例正在被移出
+func reportCall(rpcAddr string, bl *blacklist, d time.Duration, err error) {
+	m := GetMetrics()
+	m.Inc("gpmd_xclient_selected_total", "server", rpcAddr)
 	if err != nil {
-		return err
+		m.Inc("gpmd_xclient_failures_total", "server", rpcAddr)
+	} else {
+		m.Inc("gpmd_xclient_successes_total", "server", rpcAddr)
+	}
+	m.Observe("gpmd_xclient_call_seconds", d.Seconds(), "server", rpcAddr)
+	if bl != nil {
+		banned := 0.0
+		if bl.banned(rpcAddr) {
+			banned = 1
+		}
+		m.Set("gpmd_xclient_server_blacklisted", banned, "server", rpcAddr)
+	}
+}
+
+//Call 选择一个服务端调用，opts 原样用于这次调用，见 gpmd.CallOption
+func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	return xc.CallWith(ctx, serviceMethod, args, reply, RouteHint{}, opts...)
+}
+
+//CallWith 与 Call 相同，按照 hint 选择实例。设置了 SetSelector 时，按照 Tags 和 Zone 过滤后的实例
+//和 hint 一起交给 Selector，由它决定如何使用 Key
+func (xc *XClient) CallWith(ctx context.Context, serviceMethod string, args, reply interface{}, hint RouteHint, opts ...CallOption) error {
+	xc.mu.Lock()
+	cache, singleFlight, failover, sh := xc.cache, xc.singleFlight, xc.failover.normalize(), xc.shadow
+	xc.mu.Unlock()
+	var key string
+	var cacheable bool
+	if cache != nil {
+		if key, cacheable = cache.key(ctx, serviceMethod, args, hint, opts); cacheable && cache.get(key, reply) {
+			return nil
+		}
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	info := CallInfo{Ctx: ctx, ServiceMethod: serviceMethod, Args: args, Hint: hint}
+	invoke := func(reply interface{}) error {
+		var tried map[string]bool
+		var lastErr error
+		for attempt := 1; ; attempt++ {
+			rpcAddr, err := xc.pick(info, tried)
+			if err != nil {
+				//剩下的实例都已经尝试过，返回最后一次的连接错误
+				if lastErr != nil {
+					return lastErr
+				}
+				return err
+			}
+			err = xc.call(rpcAddr, ctx, serviceMethod, args, reply, opts...)
+			if err == nil && cacheable {
+				cache.put(key, reply)
+			}
+			if !canFailover(err) || attempt >= failover.MaxAttempts {
+				return err
+			}
+			//服务端给出重试间隔时实例本身是好的，等待之后仍然可以选中它
+			retryAfter, throttled := RetryAfter(err)
+			if !throttled {
+				if tried == nil {
+					tried = make(map[string]bool)
+				}
+				tried[rpcAddr] = true
+			}
+			lastErr = err
+			if failover.wait(ctx, attempt, retryAfter) != nil {
+				return err
+			}
+		}
+	}
+	var flightKey string
+	if singleFlight {
+		flightKey, singleFlight = requestKey(ctx, serviceMethod, args, hint, opts)
+	}
+	var err error
+	if singleFlight {
+		err = xc.flights.do(ctx, flightKey, reply, invoke)
+	} else {
+		err = invoke(reply)
+	}
+	if sh != nil {
+		sh.mirror(serviceMethod, args, reply, err, opts)
+	}
+	return err
+}
+
+//SetSelector 使用自定义的负载均衡策略代替 NewXClient 的 mode，例如按照租户固定实例、按照成本路由，
+//s 为 nil 时恢复使用 mode。实例的元数据来自 MetaDiscovery，其他 Discovery 的元数据为空
+func (xc *XClient) SetSelector(s Selector) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.selector = s
 }
 
-func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
+//pick 为一次调用选择实例，先按照 info.Hint 过滤，再交给 Selector；没有 Selector 时，
+//设置了 Hint.Key 的调用使用哈希选择，其余按照 mode 选择。tried 中的实例已经连接失败，不再选择
+func (xc *XClient) pick(info CallInfo, tried map[string]bool) (string, error) {
+	xc.mu.Lock()
+	selector, bl, wu := xc.selector, xc.blacklist, xc.warmup
+	banned := bl != nil && bl.active()
+	var unhealthy map[string]bool
+	if len(xc.unhealthy) > 0 {
+		unhealthy = make(map[string]bool, len(xc.unhealthy))
+		for addr := range xc.unhealthy {
+			unhealthy[addr] = true
+		}
+	}
+	filtered := banned || wu != nil || len(tried) > 0 || unhealthy != nil
+	if selector == nil && (filtered || !info.Hint.isZero()) && info.Hint.Key == "" {
+		if xc.modeSelector == nil {
+			xc.modeSelector, _ = NewSelector(xc.mode)
+		}
+		selector = xc.modeSelector
+	}
+	xc.mu.Unlock()
+	if selector == nil && info.Hint.isZero() && !filtered {
+		return xc.d.Get(xc.mode)
+	}
+	servers, err := xc.d.GetAll()
 	if err != nil {
-		return err
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", errNoAvailableServers
+	}
+	var meta map[string]map[string]string
+	if md, ok := xc.d.(MetaDiscovery); ok {
+		if meta, err = md.GetMeta(); err != nil {
+			return "", err
+		}
+	}
+	candidates := instances(servers, meta)
+	if wu != nil {
+		candidates = wu.apply(candidates)
+	}
+	if !info.Hint.isZero() {
+		if candidates = info.Hint.filter(candidates); len(candidates) == 0 {
+			return "", errNoMatchingServers
+		}
+	}
+	if unhealthy != nil {
+		candidates = withoutUnhealthy(candidates, unhealthy)
+	}
+	if banned {
+		candidates = bl.filter(candidates)
+	}
+	if len(tried) > 0 {
+		if candidates = without(candidates, tried); len(candidates) == 0 {
+			return "", errNoAvailableServers
+		}
+	}
+	var s Instance
+	switch {
+	case selector != nil:
+		s = selector.Pick(candidates, info)
+	case info.Hint.Key != "":
+		s = hashPick(candidates, info.Hint.Key)
+	}
+	if s.Addr == "" {
+		return "", errNoAvailableServers
 	}
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	return s.Addr, nil
 }
 
 func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
@@ -77,6 +326,8 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 	var mu sync.Mutex
 	var e error
 	replyDone := reply == nil
+	ctx, span := startFanout(ctx, "Broadcast", serviceMethod, len(servers))
+	defer func() { span.Finish(e) }()
 	ctx, cancel := context.WithCancel(ctx)
 	for _, rpcAddr := range servers {
 		wg.Add(1)
@@ -0,0 +1,1367 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"gpmd"
+	"gpmd/gpmdtest"
+	"gpmd/metadata"
+	"gpmd/registry"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Counter struct {
+	calls int32
+}
+
+func (c *Counter) Get(key string, reply *int) error {
+	*reply = int(atomic.AddInt32(&c.calls, 1))
+	return nil
+}
+
+//Slow 模拟一个慢调用，用于测试并发调用的合并
+func (c *Counter) Slow(key string, reply *int) error {
+	time.Sleep(100 * time.Millisecond)
+	*reply = int(atomic.AddInt32(&c.calls, 1))
+	return nil
+}
+
+func startServer(t *testing.T, rcvr interface{}) string {
+	server := gpmd.NewServer()
+	if err := server.Register(rcvr); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_Cache(t *testing.T) {
+	counter := &Counter{}
+	addr := startServer(t, counter)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.EnableCache(100*time.Millisecond, 1)
+	xc.SetCacheable("Counter.Get", true)
+
+	get := func(key string) int {
+		var reply int
+		if err := xc.Call(context.Background(), "Counter.Get", key, &reply); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	if a, b := get("a"), get("a"); a != 1 || b != 1 {
+		t.Fatalf("expect cached reply 1, got %d %d", a, b)
+	}
+	//maxEntries 为 1，缓存 b 会淘汰 a
+	if b := get("b"); b != 2 {
+		t.Fatalf("expect 2, got %d", b)
+	}
+	if a := get("a"); a != 3 {
+		t.Fatalf("expect a evicted, got %d", a)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if a := get("a"); a != 4 {
+		t.Fatalf("expect a expired, got %d", a)
+	}
+	xc.SetCacheable("Counter.Get", false)
+	if a := get("a"); a != 5 {
+		t.Fatalf("expect uncached call, got %d", a)
+	}
+
+	//路由提示和元数据不同的调用不共用缓存
+	xc.SetCacheable("Counter.Get", true)
+	call := func(ctx context.Context, hint RouteHint, opts ...gpmd.CallOption) int {
+		var reply int
+		if err := xc.CallWith(ctx, "Counter.Get", "a", &reply, hint, opts...); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+	tenant := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("tenant", "acme"))
+	if a := call(context.Background(), RouteHint{}); a != 6 {
+		t.Fatalf("expect 6, got %d", a)
+	}
+	if a := call(tenant, RouteHint{}); a != 7 {
+		t.Fatalf("expect outgoing metadata in the cache key, got %d", a)
+	}
+	if a := call(context.Background(), RouteHint{}, gpmd.WithCallMetadata("tenant", "acme")); a != 8 {
+		t.Fatalf("expect call metadata in the cache key, got %d", a)
+	}
+	if a := call(context.Background(), RouteHint{Key: "user-42"}); a != 9 {
+		t.Fatalf("expect the route hint in the cache key, got %d", a)
+	}
+	if a := call(context.Background(), RouteHint{Key: "user-42"}); a != 9 {
+		t.Fatalf("expect the same hint cached, got %d", a)
+	}
+}
+
+func TestXClient_SingleFlight(t *testing.T) {
+	counter := &Counter{}
+	addr := startServer(t, counter)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetSingleFlight(true)
+
+	var wg sync.WaitGroup
+	replies := make([]int, 10)
+	for i := range replies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			//0 的参数不同，1 的元数据不同，2 的路由提示不同，都不与其他调用合并
+			key, hint := "a", RouteHint{}
+			var opts []gpmd.CallOption
+			switch i {
+			case 0:
+				key = "b"
+			case 1:
+				opts = append(opts, gpmd.WithCallMetadata("tenant", "acme"))
+			case 2:
+				hint.Zone = "z1"
+			}
+			var reply int
+			if err := xc.CallWith(context.Background(), "Counter.Slow", key, &reply, hint, opts...); err != nil {
+				t.Error(err)
+			}
+			replies[i] = reply
+			reply = -1 //返回之后调用方可以修改 reply，不影响其他等待的调用
+		}(i)
+	}
+	wg.Wait()
+	if calls := atomic.LoadInt32(&counter.calls); calls != 4 {
+		t.Fatalf("expect 4 backend calls, got %d", calls)
+	}
+	for i := 4; i < len(replies); i++ {
+		if replies[i] != replies[3] {
+			t.Fatalf("expect shared reply %d, got %d", replies[3], replies[i])
+		}
+	}
+}
+
+//blackholeProxy 转发到 backend，freeze 之后已有的连接不再转发任何数据，模拟半开的连接
+type blackholeProxy struct {
+	mu     sync.Mutex
+	frozen []*int32
+}
+
+func startProxy(t *testing.T, backend string) (string, *blackholeProxy) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	p := &blackholeProxy{}
+	pipe := func(dst, src net.Conn, frozen *int32) {
+		buf := make([]byte, 4096)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			if atomic.LoadInt32(frozen) == 0 {
+				_, _ = dst.Write(buf[:n])
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", strings.TrimPrefix(backend, "tcp@"))
+			if err != nil {
+				_ = conn.Close()
+				continue
+			}
+			frozen := new(int32)
+			p.mu.Lock()
+			p.frozen = append(p.frozen, frozen)
+			p.mu.Unlock()
+			go pipe(upstream, conn, frozen)
+			go pipe(conn, upstream, frozen)
+		}
+	}()
+	return "tcp@" + l.Addr().String(), p
+}
+
+func (p *blackholeProxy) freeze() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, frozen := range p.frozen {
+		atomic.StoreInt32(frozen, 1)
+	}
+}
+
+func TestXClient_ConnPolicy(t *testing.T) {
+	addr1, addr2 := startServer(t, &Counter{}), startServer(t, &Counter{})
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr1}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetConnPolicy(ConnPolicy{IdleTimeout: 50 * time.Millisecond, MaxClients: 1})
+	var reply int
+	call := func(addr string) *gpmd.Client {
+		if err := xc.call(addr, context.Background(), "Counter.Get", "a", &reply); err != nil {
+			t.Fatal(err)
+		}
+		return xc.clients[addr].client
+	}
+	first := call(addr1)
+	if call(addr1) != first {
+		t.Fatal("expect connection reused")
+	}
+	call(addr2)
+	if len(xc.clients) != 1 || xc.clients[addr1] != nil {
+		t.Fatalf("expect addr1 evicted by MaxClients, got %d clients", len(xc.clients))
+	}
+	time.Sleep(100 * time.Millisecond)
+	second := xc.clients[addr2].client
+	if call(addr2) == second {
+		t.Fatal("expect idle connection replaced")
+	}
+	if second.IsAvailable() {
+		t.Fatal("expect idle connection closed")
+	}
+}
+
+func TestXClient_PingBeforeReuse(t *testing.T) {
+	addr, proxy := startProxy(t, startServer(t, &Counter{}))
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetConnPolicy(ConnPolicy{PingIdle: 10 * time.Millisecond, PingTimeout: 100 * time.Millisecond})
+	var reply int
+	if err := xc.Call(context.Background(), "Counter.Get", "a", &reply); err != nil {
+		t.Fatal(err)
+	}
+	proxy.freeze()
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := xc.Call(ctx, "Counter.Get", "a", &reply); err != nil {
+		t.Fatalf("expect half-open connection replaced after failed ping, got %v", err)
+	}
+}
+
+func TestXClient_BroadcastAll(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead := "tcp@" + l.Addr().String()
+	_ = l.Close()
+	servers := []string{startServer(t, &Counter{}), startServer(t, &Counter{}), dead}
+	xc := NewXClient(NewMultiServerDiscovery(servers), RandomSelect, &gpmd.Option{ConnectTimeout: time.Second})
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	result, err := xc.BroadcastAll(context.Background(), "Counter.Get", "a", &reply, 2)
+	if err != nil || reply != 1 || result.Succeeded() != 2 {
+		t.Fatalf("expect quorum of 2 reached, got %v %v", result, err)
+	}
+	if failed := result.Failed(); len(failed) != 1 || failed[0].Addr != dead {
+		t.Fatalf("expect %s failed, got %v", dead, failed)
+	}
+	_, err = xc.BroadcastAll(context.Background(), "Counter.Get", "a", &reply, 0)
+	if be, ok := err.(*BroadcastError); !ok || be.Quorum != 3 || !strings.Contains(be.Error(), dead) {
+		t.Fatalf("expect BroadcastError naming %s, got %v", dead, err)
+	}
+}
+
+type Shard int
+
+func (s Shard) Sum(nums []int, reply *int) error {
+	for _, n := range nums {
+		*reply += n
+	}
+	return nil
+}
+
+func TestXClient_Map(t *testing.T) {
+	var shard Shard
+	a, b, c := startServer(t, &shard), startServer(t, &shard), startServer(t, &shard)
+	xc := NewXClient(NewMultiServerDiscovery([]string{a, b, c}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	args := map[string]interface{}{a: []int{1, 2}, b: []int{3}, c: []int{4, 5, 6}}
+	total, calls := 0, 0
+	err := xc.Map(context.Background(), "Shard.Sum", args, 0, func(addr string, reply interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		total += *reply.(*int)
+		calls++
+		return nil
+	}, MapOption{Concurrency: 2, Timeout: time.Second})
+	if err != nil || total != 21 || calls != 3 {
+		t.Fatalf("expect total 21 from 3 shards, got %d from %d: %v", total, calls, err)
+	}
+
+	stop := errors.New("stop")
+	calls = 0
+	err = xc.Map(context.Background(), "Shard.Sum", args, 0, func(string, interface{}, error) error {
+		calls++
+		return stop
+	}, MapOption{Concurrency: 1})
+	if err != stop || calls != 1 {
+		t.Fatalf("expect reduce error to stop remaining calls, got %v after %d calls", err, calls)
+	}
+}
+
+type Named string
+
+func (n *Named) Name(_ int, reply *string) error {
+	*reply = string(*n)
+	return nil
+}
+
+func TestXClient_CallShard(t *testing.T) {
+	reg := httptest.NewServer(registry.New(0))
+	defer reg.Close()
+	for i := 0; i < 3; i++ {
+		name := Named("shard-" + strconv.Itoa(i))
+		registry.HeartbeatWithMeta(reg.URL, startServer(t, &name), time.Hour, map[string]string{ShardMetaKey: strconv.Itoa(i)})
+	}
+	//正在下线和权重为 0 的副本不应该收到新的请求
+	drained, idle := Named("drained"), Named("idle")
+	registry.HeartbeatWithMeta(reg.URL, startServer(t, &drained), time.Hour, map[string]string{ShardMetaKey: "0", StateMetaKey: StateDraining})
+	registry.HeartbeatWithMeta(reg.URL, startServer(t, &idle), time.Hour, map[string]string{ShardMetaKey: "1", WeightMetaKey: "0"})
+	registry.HeartbeatWithMeta(reg.URL, startServer(t, &drained), time.Hour, map[string]string{ShardMetaKey: "3", StateMetaKey: StateDraining})
+	xc := NewXClient(NewGpmdRegistryDiscovery(reg.URL, 0), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetSharding(RangeShard([]string{"h", "p"}), 3)
+
+	for key, want := range map[string]string{"alice": "shard-0", "hank": "shard-1", "zoe": "shard-2"} {
+		for i := 0; i < 10; i++ {
+			var reply string
+			if err := xc.CallShard(context.Background(), key, "Named.Name", 0, &reply); err != nil || reply != want {
+				t.Fatalf("expect %s routed to %s, got %q %v", key, want, reply, err)
+			}
+		}
+	}
+	if shard := HashShard("user-42", 3); shard != HashShard("user-42", 3) || shard < 0 || shard >= 3 {
+		t.Fatalf("expect stable hash shard in range, got %d", shard)
+	}
+
+	//分片只剩下正在下线的副本时返回错误
+	xc.SetSharding(func(string, int) int { return 3 }, 4)
+	var reply string
+	if err := xc.CallShard(context.Background(), "any", "Named.Name", 0, &reply); err == nil || !strings.Contains(err.Error(), "draining") {
+		t.Fatalf("expect no eligible replica for a drained shard, got %q %v", reply, err)
+	}
+}
+
+func TestXClient_WeightAndDrain(t *testing.T) {
+	reg := httptest.NewServer(registry.New(0))
+	defer reg.Close()
+	handles := make(map[string]*registry.HeartbeatHandle)
+	for _, n := range []string{"a", "b"} {
+		name := Named(n)
+		h, err := registry.StartHeartbeat(reg.URL, startServer(t, &name), registry.HeartbeatOption{Interval: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.Stop()
+		handles[n] = h
+	}
+	count := func(mode SelectMode) map[string]int {
+		d := NewGpmdRegistryDiscovery(reg.URL, 0)
+		xc := NewXClient(d, mode, nil)
+		defer func() { _ = xc.Close() }()
+		counts := make(map[string]int)
+		for i := 0; i < 40; i++ {
+			var reply string
+			if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil {
+				t.Fatal(err)
+			}
+			counts[reply]++
+		}
+		return counts
+	}
+
+	if err := handles["a"].SetWeight(3); err != nil {
+		t.Fatal(err)
+	}
+	if counts := count(RoundRobinSelect); counts["a"] != 30 || counts["b"] != 10 {
+		t.Fatalf("expect 3:1 round robin, got %v", counts)
+	}
+	if err := handles["a"].Drain(); err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []SelectMode{RandomSelect, RoundRobinSelect} {
+		if counts := count(mode); counts["a"] != 0 {
+			t.Fatalf("draining server should receive no new calls, got %v", counts)
+		}
+	}
+	if err := handles["a"].Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if counts := count(RoundRobinSelect); counts["a"] != 30 {
+		t.Fatalf("resumed server should keep its weight, got %v", counts)
+	}
+}
+
+func TestGpmdRegistryDiscovery_Stale(t *testing.T) {
+	var down int32
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("X-GPMD-SERVERS", "tcp@127.0.0.1:1")
+	}))
+	defer reg.Close()
+	clock := gpmdtest.NewFakeClock(time.Time{})
+	d := NewGpmdRegistryDiscovery(reg.URL, 10*time.Second)
+	d.SetClock(clock)
+	if addr, err := d.Get(RandomSelect); err != nil || addr != "tcp@127.0.0.1:1" {
+		t.Fatal("first Get should fetch from registry:", addr, err)
+	}
+
+	atomic.StoreInt32(&down, 1)
+	clock.Advance(20 * time.Second)
+	if addr, err := d.Get(RandomSelect); err != nil || addr != "tcp@127.0.0.1:1" {
+		t.Fatal("stale list should be used while the registry is down:", addr, err)
+	}
+	if err := d.Refresh(); err == nil {
+		t.Fatal("Refresh should report the registry error")
+	}
+	if servers, _ := d.GetAll(); len(servers) != 1 {
+		t.Fatal("failed refresh should keep the cached list, got", servers)
+	}
+
+	d.SetMaxStaleness(time.Second)
+	clock.Advance(20 * time.Second)
+	if _, err := d.Get(RandomSelect); err == nil {
+		t.Fatal("list older than max staleness should not be used")
+	}
+	atomic.StoreInt32(&down, 0)
+	if _, err := d.Get(RandomSelect); err != nil {
+		t.Fatal("Get should recover with the registry:", err)
+	}
+}
+
+func TestGpmdRegistryDiscovery_LocalRegistry(t *testing.T) {
+	reg := registry.New(0)
+	name := Named("local")
+	h, err := reg.StartHeartbeat(startServer(t, &name), registry.HeartbeatOption{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Stop()
+	d := NewGpmdRegistryDiscovery("http://local/_gpmd_/registry", 0)
+	d.SetHTTPClient(reg.Client())
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply string
+	if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil || reply != "local" {
+		t.Fatalf("expect call through the in-process registry, got %q, %v", reply, err)
+	}
+}
+
+func TestGpmdRegistryDiscovery_ConcurrentRefresh(t *testing.T) {
+	var hits int32
+	release := make(chan struct{})
+	reg := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&hits, 1) > 1 {
+			<-release
+		}
+		w.Header().Set("X-GPMD-SERVERS", "tcp@127.0.0.1:1")
+	}))
+	defer reg.Close()
+	defer close(release)
+	d := NewGpmdRegistryDiscovery(reg.URL, 10*time.Millisecond)
+	if err := d.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := d.RefreshContext(ctx); err == nil {
+				t.Error("refresh should be cancelled while the registry is blocked")
+			}
+		}()
+	}
+	//注册中心阻塞时，Get 不等待更新，使用原来的列表
+	start := time.Now()
+	if addr, err := d.Get(RandomSelect); err != nil || addr != "tcp@127.0.0.1:1" || time.Since(start) > 20*time.Millisecond {
+		t.Fatal("Get should not block on a slow registry:", addr, err, time.Since(start))
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatal("concurrent refreshes should be merged into one request, got", n)
+	}
+}
+
+func TestXClient_SetSelector(t *testing.T) {
+	a, b := Named("a"), Named("b")
+	addrA, addrB := startServer(t, &a), startServer(t, &b)
+	d := NewMultiServerDiscovery([]string{addrA, addrB})
+	d.UpdateMeta(map[string]map[string]string{addrA: {"tenant": "1"}, addrB: {"tenant": "2"}})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	//按照参数中的租户固定到对应的实例
+	xc.SetSelector(SelectorFunc(func(servers []Instance, info CallInfo) Instance {
+		for _, s := range servers {
+			if s.Meta["tenant"] == strconv.Itoa(info.Args.(int)) {
+				return s
+			}
+		}
+		return Instance{}
+	}))
+	for tenant, want := range map[int]string{1: "a", 2: "b"} {
+		for i := 0; i < 5; i++ {
+			var reply string
+			if err := xc.Call(context.Background(), "Named.Name", tenant, &reply); err != nil || reply != want {
+				t.Fatalf("expect tenant %d pinned to %s, got %q %v", tenant, want, reply, err)
+			}
+		}
+	}
+	var reply string
+	if err := xc.Call(context.Background(), "Named.Name", 3, &reply); err == nil {
+		t.Fatal("expect an error when the selector picks nothing")
+	}
+	xc.SetSelector(nil)
+	if err := xc.Call(context.Background(), "Named.Name", 3, &reply); err != nil {
+		t.Fatal("expect mode to be used after clearing the selector:", err)
+	}
+}
+
+func TestXClient_CallWith(t *testing.T) {
+	names := []Named{"a", "b", "c"}
+	addrs := make([]string, len(names))
+	for i := range names {
+		addrs[i] = startServer(t, &names[i])
+	}
+	d := NewMultiServerDiscovery(addrs)
+	d.UpdateMeta(map[string]map[string]string{
+		addrs[0]: {ZoneMetaKey: "east", "version": "v1"},
+		addrs[1]: {ZoneMetaKey: "west", "version": "v2"},
+		addrs[2]: {ZoneMetaKey: "west", "version": "v1"},
+	})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	call := func(hint RouteHint) (string, error) {
+		var reply string
+		err := xc.CallWith(context.Background(), "Named.Name", 0, &reply, hint)
+		return reply, err
+	}
+
+	for i := 0; i < 6; i++ {
+		if got, err := call(RouteHint{Zone: "east"}); err != nil || got != "a" {
+			t.Fatalf("expect the east instance, got %q %v", got, err)
+		}
+		if got, err := call(RouteHint{Zone: "west", Tags: map[string]string{"version": "v1"}}); err != nil || got != "c" {
+			t.Fatalf("expect the west v1 instance, got %q %v", got, err)
+		}
+		if got, err := call(RouteHint{Zone: "north", Tags: map[string]string{"version": "v2"}}); err != nil || got != "b" {
+			t.Fatalf("unknown zone should fall back to other zones, got %q %v", got, err)
+		}
+	}
+	if _, err := call(RouteHint{Tags: map[string]string{"version": "v3"}}); err == nil {
+		t.Fatal("expect an error when no server matches the tags")
+	}
+	first, err := call(RouteHint{Key: "user-42"})
+	for i := 0; i < 5; i++ {
+		if got, err2 := call(RouteHint{Key: "user-42"}); err != nil || err2 != nil || got != first {
+			t.Fatalf("same key should pick the same server, got %q and %q", first, got)
+		}
+	}
+}
+
+func TestXClient_Blacklist(t *testing.T) {
+	live := Named("live")
+	addr := startServer(t, &live)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead := "tcp@" + l.Addr().String()
+	_ = l.Close()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{dead, addr}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBlacklist(BlacklistPolicy{BaseDuration: time.Minute})
+	failures := 0
+	for i := 0; i < 10; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil {
+			failures++
+		} else if reply != "live" {
+			t.Fatalf("expect the live server, got %q", reply)
+		}
+	}
+	if failures > 1 {
+		t.Fatalf("dead server should be blacklisted after the first failure, got %d failures", failures)
+	}
+}
+
+func TestXClient_Warmup(t *testing.T) {
+	names := []Named{"a", "b", "new"}
+	addrs := make([]string, len(names))
+	for i := range names {
+		addrs[i] = startServer(t, &names[i])
+	}
+	d := NewMultiServerDiscovery(addrs[:2])
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetWarmup(300 * time.Millisecond)
+	count := func(n int) map[string]int {
+		got := make(map[string]int)
+		for i := 0; i < n; i++ {
+			var reply string
+			if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil {
+				t.Fatal(err)
+			}
+			got[reply]++
+		}
+		return got
+	}
+	//第一次获取到的实例不需要预热
+	if got := count(10); got["a"] != 5 || got["b"] != 5 {
+		t.Fatalf("expect the initial servers to share traffic evenly, got %v", got)
+	}
+	_ = d.Update(addrs)
+	if got := count(50); got["new"] > 2 {
+		t.Fatalf("expect the new server to receive few calls while warming up, got %v", got)
+	}
+	time.Sleep(350 * time.Millisecond)
+	if got := count(30); got["new"] != 10 {
+		t.Fatalf("expect the new server to receive a full share after warming up, got %v", got)
+	}
+}
+
+//serverMetrics 按照指标名和 server 标签记录计数器和瞬时值
+type serverMetrics struct {
+	mu       sync.Mutex
+	counters map[string]int
+	gauges   map[string]float64
+	observed int
+}
+
+func (m *serverMetrics) key(name string, labels []string) string {
+	for i := 0; i+1 < len(labels); i += 2 {
+		if labels[i] == "server" {
+			return name + " " + labels[i+1]
+		}
+	}
+	return name
+}
+
+func (m *serverMetrics) Inc(name string, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[m.key(name, labels)]++
+}
+
+func (m *serverMetrics) Observe(name string, _ float64, _ ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if name == "gpmd_xclient_call_seconds" {
+		m.observed++
+	}
+}
+
+func (m *serverMetrics) Set(name string, value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.gauges[m.key(name, labels)] = value
+}
+
+func TestXClient_Metrics(t *testing.T) {
+	metrics := &serverMetrics{counters: make(map[string]int), gauges: make(map[string]float64)}
+	gpmd.SetMetrics(metrics)
+	defer gpmd.SetMetrics(nil)
+	live := Named("live")
+	addr := startServer(t, &live)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	dead := "tcp@" + l.Addr().String()
+	_ = l.Close()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBlacklist(BlacklistPolicy{BaseDuration: time.Minute})
+	var reply string
+	for i := 0; i < 3; i++ {
+		if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := xc.call(dead, context.Background(), "Named.Name", 0, &reply); err == nil {
+		t.Fatal("expect an error calling a closed address")
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	for key, want := range map[string]int{
+		"gpmd_xclient_selected_total " + addr:  3,
+		"gpmd_xclient_successes_total " + addr: 3,
+		"gpmd_xclient_selected_total " + dead:  1,
+		"gpmd_xclient_failures_total " + dead:  1,
+	} {
+		if got := metrics.counters[key]; got != want {
+			t.Fatalf("expect %s = %d, got %d", key, want, got)
+		}
+	}
+	if metrics.observed != 4 {
+		t.Fatalf("expect 4 latency observations, got %d", metrics.observed)
+	}
+	if metrics.gauges["gpmd_xclient_server_blacklisted "+dead] != 1 || metrics.gauges["gpmd_xclient_server_blacklisted "+addr] != 0 {
+		t.Fatalf("unexpected blacklist state %v", metrics.gauges)
+	}
+}
+
+type recordedSpan struct {
+	name   string
+	kind   gpmd.SpanKind
+	parent *recordedSpan
+	tags   map[string]string
+	err    error
+}
+
+func (s *recordedSpan) SetTag(key, value string) { s.tags[key] = value }
+func (s *recordedSpan) Finish(err error)         { s.err = err }
+
+type spanKey struct{}
+
+//recordingTracer 记录所有 span，父 span 通过 ctx 传递
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) StartSpan(ctx context.Context, name string, kind gpmd.SpanKind) (context.Context, gpmd.Span) {
+	s := &recordedSpan{name: name, kind: kind, tags: make(map[string]string)}
+	s.parent, _ = ctx.Value(spanKey{}).(*recordedSpan)
+	r.mu.Lock()
+	r.spans = append(r.spans, s)
+	r.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func TestXClient_Tracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	gpmd.SetTracer(tracer)
+	defer gpmd.SetTracer(nil)
+	a, b := Named("a"), Named("b")
+	addrs := []string{startServer(t, &a), startServer(t, &b)}
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply string
+	if err := xc.Broadcast(context.Background(), "Named.Name", 0, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	var fanout *recordedSpan
+	servers := make(map[string]bool)
+	for _, s := range tracer.spans {
+		switch s.kind {
+		case gpmd.SpanInternal:
+			fanout = s
+		case gpmd.SpanClient:
+			if s.parent == nil || s.parent.kind != gpmd.SpanInternal || s.tags["attempt"] != "0" || s.err != nil {
+				t.Fatalf("unexpected client span %+v", s)
+			}
+			servers[s.tags["server"]] = true
+		}
+	}
+	if fanout == nil || fanout.name != "Broadcast Named.Name" || fanout.tags["servers"] != "2" {
+		t.Fatalf("expect a broadcast span, got %+v", fanout)
+	}
+	if !servers[addrs[0]] || !servers[addrs[1]] {
+		t.Fatalf("expect one client span per server, got %v", servers)
+	}
+}
+
+func TestXClient_Failover(t *testing.T) {
+	live := Named("live")
+	addr := startServer(t, &live)
+	deadAddr := func() string {
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		_ = l.Close()
+		return "tcp@" + l.Addr().String()
+	}
+	dead1, dead2 := deadAddr(), deadAddr()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{dead1, addr, dead2}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 6; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Named.Name", 0, &reply, gpmd.WithNoRetry()); err != nil || reply != "live" {
+			t.Fatalf("expect dial failures to fail over to the live server, got %q %v", reply, err)
+		}
+	}
+
+	xc.SetFailover(FailoverPolicy{MaxAttempts: 1})
+	failures := 0
+	for i := 0; i < 6; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil {
+			failures++
+		}
+	}
+	if failures == 0 {
+		t.Fatal("expect dial errors to surface when failover is disabled")
+	}
+
+	all := NewXClient(NewMultiServerDiscovery([]string{dead1, dead2}), RandomSelect, nil)
+	defer func() { _ = all.Close() }()
+	var reply string
+	if err := all.Call(context.Background(), "Named.Name", 0, &reply); err == nil || err == errNoAvailableServers {
+		t.Fatalf("expect the last dial error when every server fails, got %v", err)
+	}
+}
+
+func TestXClient_HealthCheck(t *testing.T) {
+	a, b := Named("a"), Named("b")
+	addrA, proxy := startProxy(t, startServer(t, &a))
+	addrB := startServer(t, &b)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addrA, addrB}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	//检查由测试直接触发
+	xc.SetConnPolicy(ConnPolicy{HealthCheck: time.Hour, PingTimeout: 100 * time.Millisecond})
+	count := func(n int) map[string]int {
+		got := make(map[string]int)
+		for i := 0; i < n; i++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			var reply string
+			err := xc.Call(ctx, "Named.Name", 0, &reply)
+			cancel()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got[reply]++
+		}
+		return got
+	}
+	count(2)
+
+	proxy.freeze()
+	xc.checkHealth()
+	xc.mu.Lock()
+	unhealthy, cached := xc.unhealthy[addrA], xc.clients[addrA] != nil
+	xc.mu.Unlock()
+	if !unhealthy || cached {
+		t.Fatal("expect the frozen connection dropped and the server marked unhealthy")
+	}
+	if got := count(6); got["b"] != 6 {
+		t.Fatalf("expect unhealthy server skipped, got %v", got)
+	}
+	//代理只冻结已有的连接，重新连接后检查成功
+	xc.checkHealth()
+	if got := count(6); got["a"] == 0 {
+		t.Fatalf("expect the server back after a successful check, got %v", got)
+	}
+}
+
+//Wait 等待 ms 毫秒后返回名字，用来制造正在处理中的调用
+func (n *Named) Wait(ms int, reply *string) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = string(*n)
+	return nil
+}
+
+//killableListener 记录接受的连接，kill 时关闭 listener 和所有连接，模拟实例崩溃
+type killableListener struct {
+	net.Listener
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *killableListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+	return conn, err
+}
+
+func (l *killableListener) kill() {
+	_ = l.Close()
+	l.drop()
+}
+
+//drop 断开已经建立的连接，继续接受新的连接
+func (l *killableListener) drop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.conns = nil
+}
+
+func TestXClient_ReconnectFailover(t *testing.T) {
+	a, b := Named("a"), Named("b")
+	server := gpmd.NewServer()
+	_ = server.Register(&a)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	killable := &killableListener{Listener: l}
+	go server.Accept(killable)
+	addrA, addrB := "tcp@"+l.Addr().String(), startServer(t, &b)
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{addrA, addrB}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var calls int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				var reply string
+				if err := xc.Call(context.Background(), "Named.Wait", 5, &reply, gpmd.WithIdempotent()); err != nil {
+					errs <- err
+				}
+				atomic.AddInt32(&calls, 1)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&calls) < 20 {
+		time.Sleep(time.Millisecond)
+	}
+	killable.kill()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("expect calls to fail over seamlessly after the backend is killed, got %v", err)
+	}
+
+	//连接已经关闭（ErrShutdown）时请求还没有发出，WithNoRetry 的调用也会重新连接并换实例
+	for i := 0; i < 4; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Named.Name", 0, &reply, gpmd.WithNoRetry()); err != nil || reply != "b" {
+			t.Fatalf("expect shut down client evicted and the call failed over, got %q %v", reply, err)
+		}
+	}
+	xc.mu.Lock()
+	_, cached := xc.clients[addrA]
+	xc.mu.Unlock()
+	if cached {
+		t.Fatal("expect the dead backend's client evicted")
+	}
+}
+
+func TestXClient_BrokenFailoverIdempotent(t *testing.T) {
+	b := Named("b")
+	addrB := startServer(t, &b)
+	for _, idempotent := range []bool{false, true} {
+		a := Named("a")
+		server := gpmd.NewServer()
+		_ = server.Register(&a)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		killable := &killableListener{Listener: l}
+		go server.Accept(killable)
+		addrA := "tcp@" + l.Addr().String()
+
+		d := NewMultiServerDiscovery([]string{addrA})
+		xc := NewXClient(d, RoundRobinSelect, nil)
+		var opts []gpmd.CallOption
+		if idempotent {
+			opts = append(opts, gpmd.WithIdempotent())
+		}
+		done := make(chan error, 1)
+		var reply string
+		go func() { done <- xc.Call(context.Background(), "Named.Wait", 300, &reply, opts...) }()
+		time.Sleep(50 * time.Millisecond)
+		_ = d.Update([]string{addrA, addrB})
+		killable.kill()
+		err = <-done
+		if idempotent && (err != nil || reply != "b") {
+			t.Fatalf("expect the idempotent call resent to b, got %q %v", reply, err)
+		}
+		if !idempotent && err == nil {
+			t.Fatalf("expect the sent call not resent by default, got %q", reply)
+		}
+		_ = xc.Close()
+	}
+}
+
+func TestXClient_ConnEvents(t *testing.T) {
+	a := Named("a")
+	server := gpmd.NewServer()
+	_ = server.Register(&a)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	killable := &killableListener{Listener: l}
+	t.Cleanup(killable.kill)
+	go server.Accept(killable)
+
+	events := make(chan string, 10)
+	opt, _ := gpmd.NewOption(gpmd.WithConnEvents(gpmd.ConnEvents{
+		OnConnect:    func(addr string) { events <- "connect " + addr },
+		OnDisconnect: func(addr string, err error) { events <- "disconnect " + addr },
+		OnReconnect:  func(addr string) { events <- "reconnect " + addr },
+	}))
+	xc := NewXClient(NewMultiServerDiscovery([]string{"tcp@" + l.Addr().String()}), RandomSelect, opt)
+	defer func() { _ = xc.Close() }()
+	call := func() {
+		var reply string
+		if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want+" "+l.Addr().String() {
+				t.Fatalf("expect %s event, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expect %s event", want)
+		}
+	}
+	call()
+	expect("connect")
+	call()
+
+	//连接断开之后下一次调用重新连接，依次收到 OnDisconnect、OnConnect 和 OnReconnect
+	killable.drop()
+	expect("disconnect")
+	call()
+	expect("connect")
+	expect("reconnect")
+
+	//主动关闭的连接不算断开，之后不会有 OnReconnect
+	_ = xc.Close()
+	expect("disconnect")
+	select {
+	case got := <-events:
+		t.Fatal("unexpected event", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestXClient_DialContext(t *testing.T) {
+	//接受连接但是从不回复 CONNECT 的实例，建立连接的时间只受调用的 ctx 限制
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	killable := &killableListener{Listener: l}
+	t.Cleanup(killable.kill)
+	go func() {
+		for {
+			if _, err := killable.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	xc := NewXClient(NewMultiServerDiscovery([]string{"http@" + l.Addr().String()}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var reply string
+	err = xc.Call(ctx, "Named.Name", 0, &reply)
+	if err == nil || time.Since(start) > time.Second {
+		t.Fatalf("expect the call ctx to bound the dial, got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestXClient_DialWithoutLock(t *testing.T) {
+	//blackhole 的连接一直没有结果，直到 release 或者 ctx 结束
+	const blackhole = "127.0.0.1:1"
+	release := make(chan struct{})
+	var dials int32
+	opt, _ := gpmd.NewOption(gpmd.WithDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+		if address != blackhole {
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}
+		atomic.AddInt32(&dials, 1)
+		select {
+		case <-release:
+			return nil, errors.New("refused")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}))
+	v1 := Named("v1")
+	xc := NewXClient(NewMultiServerDiscovery([]string{startServer(t, &v1)}), RandomSelect, opt)
+	defer func() { _ = xc.Close() }()
+	waitDials := func(want int32) {
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&dials) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("expect %d dials, got %d", want, atomic.LoadInt32(&dials))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	errs := make(chan error, 3)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := xc.acquire(context.Background(), "tcp@"+blackhole)
+			errs <- err
+		}()
+	}
+	waitDials(1)
+	//连接 blackhole 期间其他实例的调用不受影响
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var reply string
+	if err := xc.Call(ctx, "Named.Name", 0, &reply); err != nil || reply != "v1" {
+		t.Fatalf("expect other instances usable while dialing, got %q %v", reply, err)
+	}
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err == nil {
+			t.Fatal("expect the shared dial error")
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("expect concurrent dials to one address merged, got %d", n)
+	}
+
+	//发起连接的调用方放弃之后，等待的调用方自己重新连接
+	release = make(chan struct{})
+	short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+	go func() {
+		_, err := xc.acquire(short, "tcp@"+blackhole)
+		errs <- err
+	}()
+	waitDials(2)
+	go func() {
+		_, err := xc.acquire(context.Background(), "tcp@"+blackhole)
+		errs <- err
+	}()
+	if err := <-errs; err == nil {
+		t.Fatalf("expect the short ctx to end its dial, got %v", err)
+	}
+	waitDials(3)
+	close(release)
+	if err := <-errs; err == nil {
+		t.Fatal("expect the redial error")
+	}
+}
+
+func TestXClient_CloseDuringDial(t *testing.T) {
+	v1 := Named("v1")
+	addr := startServer(t, &v1)
+	release := make(chan struct{})
+	dialing := make(chan struct{}, 1)
+	var dialed net.Conn
+	opt, _ := gpmd.NewOption(gpmd.WithDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialing <- struct{}{}
+		<-release
+		conn, err := (&net.Dialer{}).DialContext(ctx, network, address)
+		dialed = conn
+		return conn, err
+	}))
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, opt)
+	errs := make(chan error, 1)
+	go func() {
+		var reply string
+		errs <- xc.Call(context.Background(), "Named.Name", 0, &reply)
+	}()
+	<-dialing
+	_ = xc.Close()
+	close(release)
+	if err := <-errs; err != gpmd.ErrShutdown {
+		t.Fatalf("expect ErrShutdown for a dial finished after Close, got %v", err)
+	}
+	xc.mu.Lock()
+	cached := len(xc.clients)
+	xc.mu.Unlock()
+	if cached != 0 {
+		t.Fatalf("expect the late client not cached, got %d", cached)
+	}
+	if _, err := dialed.Write([]byte("x")); err == nil {
+		t.Fatal("expect the late connection closed")
+	}
+	var reply string
+	if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != gpmd.ErrShutdown {
+		t.Fatalf("expect ErrShutdown after Close, got %v", err)
+	}
+}
+
+//Quota 前 reject 次调用以 CodeResourceExhausted 拒绝，并建议 300ms 之后重试
+type Quota struct {
+	calls  int32
+	reject int32
+}
+
+func (q *Quota) Take(_ int, reply *int) error {
+	n := atomic.AddInt32(&q.calls, 1)
+	if n <= atomic.LoadInt32(&q.reject) {
+		return gpmd.NewStatus(gpmd.CodeResourceExhausted, "quota exceeded", &gpmd.RetryInfo{RetryAfter: 300 * time.Millisecond})
+	}
+	*reply = int(n)
+	return nil
+}
+
+func TestXClient_RetryAfter(t *testing.T) {
+	q := &Quota{reject: 1}
+	addr := startServer(t, q)
+	xc := NewXClient(NewMultiServerDiscovery([]string{addr}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	//被拒绝的请求没有被处理，WithNoRetry 的调用也会在服务端给出的间隔之后重试同一个实例
+	start := time.Now()
+	var reply int
+	if err := xc.Call(context.Background(), "Quota.Take", 0, &reply, gpmd.WithNoRetry()); err != nil || reply != 2 {
+		t.Fatalf("expect retry after the hint, got %d %v", reply, err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("expect retry-after honored beyond MaxBackoff, retried after %v", elapsed)
+	}
+
+	//截止时间早于重试间隔时直接返回服务端的错误
+	atomic.StoreInt32(&q.calls, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := xc.Call(ctx, "Quota.Take", 0, &reply)
+	if d, ok := gpmd.RetryAfter(err); !errors.Is(err, gpmd.ErrResourceExhausted) || !ok || d != 300*time.Millisecond {
+		t.Fatalf("expect resource exhausted with retry-after, got %v", err)
+	}
+	if calls := atomic.LoadInt32(&q.calls); calls != 1 {
+		t.Fatalf("expect no retry before the deadline, got %d calls", calls)
+	}
+}
+
+//shadowMetrics 按照 result 标签记录影子调用的结果
+type shadowMetrics struct {
+	mu      sync.Mutex
+	results map[string]int
+}
+
+func (m *shadowMetrics) Inc(name string, labels ...string) {
+	if name != "gpmd_xclient_shadow_calls_total" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[labels[len(labels)-1]]++
+}
+
+func (m *shadowMetrics) Observe(string, float64, ...string) {}
+func (m *shadowMetrics) Set(string, float64, ...string)     {}
+
+func (m *shadowMetrics) wait(t *testing.T, result string, want int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		m.mu.Lock()
+		got := m.results[result]
+		m.mu.Unlock()
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d %s shadow calls, got %d", want, result, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestXClient_Shadow(t *testing.T) {
+	metrics := &shadowMetrics{results: make(map[string]int)}
+	gpmd.SetMetrics(metrics)
+	defer gpmd.SetMetrics(nil)
+	v1, same, v2 := Named("v1"), Named("v1"), Named("v2")
+	xc := NewXClient(NewMultiServerDiscovery([]string{startServer(t, &v1)}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	xc.SetShadow(ShadowPolicy{Discovery: NewMultiServerDiscovery([]string{startServer(t, &same)}), Percent: 100, Compare: true})
+	for i := 0; i < 5; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil || reply != "v1" {
+			t.Fatalf("expect the primary reply, got %q %v", reply, err)
+		}
+	}
+	metrics.wait(t, "match", 5)
+
+	//不需要回复的调用只比较错误
+	if err := xc.Call(context.Background(), "Named.Name", 0, nil); err != nil {
+		t.Fatalf("expect a nil reply accepted, got %v", err)
+	}
+	metrics.wait(t, "match", 6)
+
+	//影子实例的返回值不同时记录为 diverged，原调用仍然得到主实例的结果
+	xc.SetShadow(ShadowPolicy{Discovery: NewMultiServerDiscovery([]string{startServer(t, &v2)}), Percent: 100, Compare: true})
+	var reply string
+	if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil || reply != "v1" {
+		t.Fatalf("expect the primary reply, got %q %v", reply, err)
+	}
+	metrics.wait(t, "diverged", 1)
+
+	xc.SetShadow(ShadowPolicy{Discovery: NewMultiServerDiscovery([]string{startServer(t, &v2)}), Percent: 0})
+	for i := 0; i < 5; i++ {
+		_ = xc.Call(context.Background(), "Named.Name", 0, &reply)
+	}
+	xc.SetShadow(ShadowPolicy{})
+	if err := xc.Call(context.Background(), "Named.Name", 0, &reply); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if n := metrics.results["ok"] + metrics.results["error"]; n != 0 {
+		t.Fatalf("expect no shadow calls at 0%% or after disabling, got %d", n)
+	}
+}
+
+func TestSplitSelector(t *testing.T) {
+	servers := []Instance{
+		{Addr: "v1-a", Meta: map[string]string{"version": "v1"}},
+		{Addr: "v1-b", Meta: map[string]string{"version": "v1"}},
+		{Addr: "v2-a", Meta: map[string]string{"version": "v2"}},
+		{Addr: "other", Meta: map[string]string{"version": "v3"}},
+	}
+	s := NewSplitSelector("version", []SplitGroup{{"v1", 90}, {"v2", 10}}, nil)
+	count := func(key func(i int) string) map[string]int {
+		got := make(map[string]int)
+		for i := 0; i < 10000; i++ {
+			got[s.Pick(servers, CallInfo{Hint: RouteHint{Key: key(i)}}).Addr]++
+		}
+		return got
+	}
+	for name, key := range map[string]func(int) string{
+		"random": func(int) string { return "" },
+		"keyed":  strconv.Itoa,
+	} {
+		got := count(key)
+		if got["other"] != 0 || got["v2-a"] < 800 || got["v2-a"] > 1200 || got["v1-a"]+got["v1-b"] != 10000-got["v2-a"] {
+			t.Fatalf("%s: expect a 90/10 split across versions, got %v", name, got)
+		}
+	}
+	//同一个路由键总是选择同一个实例
+	first := s.Pick(servers, CallInfo{Hint: RouteHint{Key: "user-42"}})
+	for i := 0; i < 10; i++ {
+		if got := s.Pick(servers, CallInfo{Hint: RouteHint{Key: "user-42"}}); got.Addr != first.Addr {
+			t.Fatalf("expect deterministic assignment, got %s and %s", first.Addr, got.Addr)
+		}
+	}
+	//没有实例的组的流量分给其他组
+	if got := s.Pick(servers[:2], CallInfo{}); got.Addr != "v1-a" && got.Addr != "v1-b" {
+		t.Fatalf("expect the v1 group to take all traffic, got %s", got.Addr)
+	}
+}